@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// staleActiveUserAge is how long after its lease expired an index entry is
+// removed, if it was not removed by the revocation of the lease. This happens
+// when a lease is force revoked, which skips the backend.
+const staleActiveUserAge = 24 * time.Hour
+
+// activeUser is the index entry of a user created for a dynamic role. Entries
+// are stored under databaseActiveUserPath, then the name of the role and then the
+// username, from issuance until the lease is revoked.
+type activeUser struct {
+	Username   string    `json:"username"`
+	IssueTime  time.Time `json:"issue_time"`
+	Expiration time.Time `json:"expiration"`
+
+	// NotAfter is when the user is revoked by the periodic function if it
+	// still exists, for roles with a max_lifetime. RequestIP, UserSchema and
+	// SkipRevocation are kept from the lease to revoke the user as its lease
+	// would when the backend revokes the user itself.
+	NotAfter       time.Time `json:"not_after,omitempty"`
+	RequestIP      string    `json:"request_ip,omitempty"`
+	UserSchema     bool      `json:"user_schema,omitempty"`
+	SkipRevocation bool      `json:"skip_revocation,omitempty"`
+
+	// DBName, RoleVersion and StatementsHash record the connection and the
+	// version and statements of the role that the user was created with.
+	DBName         string `json:"db_name,omitempty"`
+	RoleVersion    int    `json:"role_version,omitempty"`
+	StatementsHash string `json:"statements_hash,omitempty"`
+}
+
+// issuanceMetadata returns what the user was created with, for the users
+// indexed by versions of the backend that recorded it.
+func (u *activeUser) issuanceMetadata() map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if u.DBName != "" {
+		metadata["db_name"] = u.DBName
+	}
+	if u.RoleVersion != 0 {
+		metadata["role_version"] = u.RoleVersion
+	}
+	if u.StatementsHash != "" {
+		metadata["statements_hash"] = u.StatementsHash
+	}
+	return metadata
+}
+
+// stale returns whether the entry outlived its lease long enough that the
+// lease's revocation was skipped. Entries with a NotAfter are kept until the
+// user is revoked.
+func (u *activeUser) stale(now time.Time) bool {
+	return u.NotAfter.IsZero() && now.After(u.Expiration.Add(staleActiveUserAge))
+}
+
+func activeUserKey(role, username string) string {
+	return databaseActiveUserPath + role + "/" + username
+}
+
+func putActiveUser(ctx context.Context, s logical.Storage, role string, user *activeUser) error {
+	entry, err := logical.StorageEntryJSON(activeUserKey(role, user.Username), user)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getActiveUser(ctx context.Context, s logical.Storage, role, username string) (*activeUser, error) {
+	entry, err := s.Get(ctx, activeUserKey(role, username))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	var user activeUser
+	if err := entry.DecodeJSON(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func deleteActiveUser(ctx context.Context, s logical.Storage, role, username string) error {
+	return s.Delete(ctx, activeUserKey(role, username))
+}
+
+// activeUsers returns the index entries of a role whose leases have not
+// expired, removing entries that are stale.
+func activeUsers(ctx context.Context, s logical.Storage, role string, now time.Time) ([]*activeUser, error) {
+	usernames, err := s.List(ctx, databaseActiveUserPath+role+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*activeUser
+	for _, username := range usernames {
+		user, err := getActiveUser(ctx, s, role, username)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			continue
+		}
+		if user.stale(now) {
+			if err := deleteActiveUser(ctx, s, role, username); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if now.After(user.Expiration) {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// countRoleUsers counts the users with unexpired leases of the stored role
+// called entryName, including those of the virtual roles of Kubernetes service
+// accounts based on it, which are indexed under their own names.
+func (b *databaseBackend) countRoleUsers(ctx context.Context, s logical.Storage, entryName string, now time.Time) (int, error) {
+	roles, err := s.List(ctx, databaseActiveUserPath)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, role := range roles {
+		role = strings.TrimSuffix(role, "/")
+		if role != entryName {
+			if !strings.HasPrefix(role, "k8s_"+entryName+"_") {
+				continue
+			}
+			if resolved, err := b.roleEntryName(ctx, s, role); err != nil {
+				return 0, err
+			} else if resolved != entryName {
+				continue
+			}
+		}
+		users, err := activeUsers(ctx, s, role, now)
+		if err != nil {
+			return 0, err
+		}
+		count += len(users)
+	}
+	return count, nil
+}
+
+// moveActiveUsers moves the index entries of a renamed role to its new name,
+// along with those of the Kubernetes roles based on it.
+func moveActiveUsers(ctx context.Context, s logical.Storage, from, to string) error {
+	roles, err := s.List(ctx, databaseActiveUserPath)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		role = strings.TrimSuffix(role, "/")
+
+		var renamed string
+		switch {
+		case role == from:
+			renamed = to
+		case strings.HasPrefix(role, "k8s_"+from+"_"):
+			renamed = "k8s_" + to + "_" + strings.TrimPrefix(role, "k8s_"+from+"_")
+		default:
+			continue
+		}
+
+		usernames, err := s.List(ctx, databaseActiveUserPath+role+"/")
+		if err != nil {
+			return err
+		}
+		for _, username := range usernames {
+			user, err := getActiveUser(ctx, s, role, username)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				continue
+			}
+			if err := putActiveUser(ctx, s, renamed, user); err != nil {
+				return err
+			}
+			if err := deleteActiveUser(ctx, s, role, username); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// revokeIndexedUser revokes an indexed user of a role in the database,
+// without its lease, and removes it from the index. A user that is already
+// gone is treated as revoked, so that its lease revokes cleanly. The user is
+// revoked on the connection it was created on, which is the role's for users
+// indexed before it was recorded.
+func (b *databaseBackend) revokeIndexedUser(ctx context.Context, s logical.Storage, roleName string, role *roleEntry, user *activeUser) error {
+	if user.SkipRevocation {
+		return deleteActiveUser(ctx, s, roleName, user.Username)
+	}
+
+	dbName := user.DBName
+	if dbName == "" {
+		dbName = role.DBName
+	}
+
+	statements := withRequestIP(role.Statements, user.RequestIP)
+	if user.UserSchema {
+		config, err := b.DatabaseConfig(ctx, s, dbName)
+		if err != nil {
+			return err
+		}
+		if statements, err = withUserSchema(config.PluginName, statements); err != nil {
+			return err
+		}
+	}
+
+	db, err := b.GetConnection(ctx, s, dbName)
+	if err != nil {
+		return err
+	}
+
+	db.RLock()
+	err = db.RevokeUser(ctx, statements, user.Username)
+	db.RUnlock()
+	if err != nil {
+		b.CloseIfShutdown(db, err)
+		if classifyPluginError(err) != pluginErrorNotFound {
+			return err
+		}
+	}
+
+	return deleteActiveUser(ctx, s, roleName, user.Username)
+}
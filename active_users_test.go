@@ -0,0 +1,326 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestActiveUsers(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	now := time.Now()
+
+	for username, expiration := range map[string]time.Time{
+		"current": now.Add(time.Hour),
+		"expired": now.Add(-time.Hour),
+		"stale":   now.Add(-staleActiveUserAge - time.Hour),
+	} {
+		if err := putActiveUser(ctx, s, "app", &activeUser{Username: username, Expiration: expiration}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users, err := activeUsers(ctx, s, "app", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "current" {
+		t.Fatalf("expected only the unexpired user, got %#v", users)
+	}
+
+	// Expired users are kept until revoked, but stale ones are removed
+	for username, exists := range map[string]bool{"expired": true, "stale": false} {
+		user, err := getActiveUser(ctx, s, "app", username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (user != nil) != exists {
+			t.Fatalf("expected %s to exist: %t", username, exists)
+		}
+	}
+
+	if err := putActiveUser(ctx, s, "k8s_app_sa_default", &activeUser{Username: "pod", Expiration: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveActiveUsers(ctx, s, "app", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	for role, count := range map[string]int{"app": 0, "renamed": 1, "k8s_app_sa_default": 0, "k8s_renamed_sa_default": 1} {
+		users, err := activeUsers(ctx, s, role, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != count {
+			t.Fatalf("expected %d users for %s, got %d", count, role, len(users))
+		}
+	}
+}
+
+func TestBackend_maxConcurrentUsers(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+
+	resp, err := request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	fake := &fakeIssuingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	resp, err = request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":              "plugin-test",
+			"creation_statements":  `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			"max_concurrent_users": 2,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	var secrets []*logical.Secret
+	for i := 0; i < 2; i++ {
+		resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		secrets = append(secrets, resp.Secret)
+	}
+	_, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	codedErr, ok := err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+
+	resp, err = request(&logical.Request{Operation: logical.RevokeOperation, Secret: secrets[0]})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	if len(fake.revoked) != 1 {
+		t.Fatalf("expected the user to be revoked, got %v", fake.revoked)
+	}
+
+	// Revoking a lease frees its place in the quota, including after the
+	// role is renamed
+	resp, err = request(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/app/rename",
+		Data:      map[string]interface{}{"new_name": "renamed"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/renamed"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	_, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/renamed"})
+	if codedErr, ok := err.(logical.HTTPCodedError); !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+
+	resp, err = request(&logical.Request{Operation: logical.RevokeOperation, Secret: secrets[1]})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/renamed"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	// The virtual roles of service accounts count towards the quota of the
+	// role they are based on, and a request refused by the quota doesn't
+	// take a token from the role's bucket
+	entry, err := logical.StorageEntryJSON("serviceaccount/default/s-ledger", saCacheObject{Keyspace: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = request(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/renamed",
+		Data:      map[string]interface{}{"max_concurrent_users": 3, "max_creds_per_minute": 2},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/k8s_renamed_s-ledger_default"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	saSecret := resp.Secret
+	for _, path := range []string{"creds/renamed", "creds/k8s_renamed_s-ledger_default"} {
+		_, err = request(&logical.Request{Operation: logical.ReadOperation, Path: path})
+		if codedErr, ok := err.(logical.HTTPCodedError); !ok || codedErr.Code() != http.StatusTooManyRequests || !strings.Contains(err.Error(), "concurrent users") {
+			t.Fatalf("expected the quota to refuse %q, got %v", path, err)
+		}
+	}
+
+	resp, err = request(&logical.Request{Operation: logical.RevokeOperation, Secret: saSecret})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/renamed"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+}
+
+func TestBackend_roleCredentials(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			"default_ttl":         "1h",
+		},
+	})
+
+	first := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+
+	resp := request(&logical.Request{Operation: logical.ListOperation, Path: "roles/app/credentials/"})
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 2 || keys[0] != "user-1" || keys[1] != "user-2" {
+		t.Fatalf("expected both users to be listed, got %v", keys)
+	}
+	info := resp.Data["key_info"].(map[string]interface{})["user-1"].(map[string]interface{})
+	issued, err := time.Parse(time.RFC3339, info["issue_time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration, err := time.Parse(time.RFC3339, info["expiration"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := expiration.Sub(issued); ttl < 59*time.Minute || ttl > time.Hour || info["expired"] != false {
+		t.Fatalf("expected a one hour lease, got %#v", info)
+	}
+
+	request(&logical.Request{Operation: logical.RevokeOperation, Secret: first.Secret})
+	resp = request(&logical.Request{Operation: logical.ListOperation, Path: "roles/app/credentials/"})
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "user-2" {
+		t.Fatalf("expected the revoked user not to be listed, got %v", keys)
+	}
+}
+
+func TestBackend_roleCredentialIssuanceMetadata(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	var leases []*logical.Response
+	for _, statements := range []string{
+		`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}'; GRANT ALL ON DATABASE app TO "{{name}}";`,
+	} {
+		request(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/app",
+			Data: map[string]interface{}{
+				"db_name":             "plugin-test",
+				"creation_statements": statements,
+			},
+		})
+		leases = append(leases, request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"}))
+	}
+
+	first := request(&logical.Request{Operation: logical.ReadOperation, Path: "roles/app/credentials/user-1"})
+	second := request(&logical.Request{Operation: logical.ReadOperation, Path: "roles/app/credentials/user-2"})
+	if first.Data["db_name"] != "plugin-test" || first.Data["role_version"] != 1 || second.Data["role_version"] != 2 {
+		t.Fatalf("expected the connection and role versions to be recorded, got %#v and %#v", first.Data, second.Data)
+	}
+	if first.Data["statements_hash"] == "" || first.Data["statements_hash"] == second.Data["statements_hash"] {
+		t.Fatalf("expected the statements of each version to be hashed, got %#v and %#v", first.Data, second.Data)
+	}
+	if leases[0].Secret.InternalData["statements_hash"] != first.Data["statements_hash"] || leases[1].Secret.InternalData["role_version"] != 2 {
+		t.Fatalf("expected the lease to record the same, got %#v", leases[0].Secret.InternalData)
+	}
+
+	if resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{Operation: logical.ReadOperation, Path: "roles/app/credentials/missing", Storage: s}); err != nil || resp != nil {
+		t.Fatalf("expected no user, got %#v, %v", resp, err)
+	}
+}
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// hostnameRegex matches the hostnames accepted in connection details: dot
+// separated labels of letters, digits, hyphens and underscores.
+var hostnameRegex = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*\.?$`)
+
+// lookupSRV resolves the SRV records of Cassandra contact points. Tests
+// replace it to avoid depending on DNS.
+var lookupSRV = net.LookupSRV
+
+// normalizeAddresses validates the database addresses in the connection
+// details of a plugin, so that a malformed host or port is reported when the
+// connection is written rather than as a driver failure when it is first
+// used. Unbracketed IPv6 addresses are bracketed where the plugin joins the
+// host and port itself, and the SRV records among the hosts of a Cassandra
+// connection are resolved into contact points.
+func normalizeAddresses(pluginName string, details map[string]interface{}) error {
+	connURL, _ := details["connection_url"].(string)
+
+	switch pluginName {
+	case "mysql-database-plugin", "mysql-aurora-database-plugin", "mysql-rds-database-plugin", "mysql-legacy-database-plugin", "mariadb-database-plugin", "vitess-database-plugin":
+		slash := strings.LastIndex(connURL, "/")
+		if slash < 0 {
+			return nil
+		}
+		protocol := connURL[strings.LastIndex(connURL[:slash], "@")+1 : slash]
+		if !strings.HasPrefix(protocol, "tcp(") || !strings.HasSuffix(protocol, ")") {
+			return nil
+		}
+		address, err := normalizeAddress("connection_url", protocol[len("tcp("):len(protocol)-1], "3306")
+		if err != nil {
+			return err
+		}
+		dsn, err := setMySQLAddress(connURL, "tcp", address)
+		if err != nil {
+			return err
+		}
+		details["connection_url"] = dsn
+
+	case "postgresql-database-plugin":
+		if isURL(connURL, "postgres", "postgresql") {
+			return validateURLHosts(connURL, false)
+		}
+		for _, field := range strings.Fields(connURL) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch v := strings.Trim(kv[1], "'"); kv[0] {
+			case "host":
+				if !strings.HasPrefix(v, "/") && !isValidHost(v) {
+					return fmt.Errorf("connection_url has an invalid host %q", v)
+				}
+			case "port":
+				if err := validatePort("connection_url", v); err != nil {
+					return err
+				}
+			}
+		}
+
+	case "mssql-database-plugin":
+		if isURL(connURL, "sqlserver") {
+			return validateURLHosts(connURL, false)
+		}
+
+	case "hana-database-plugin":
+		if isURL(connURL, "hdb") {
+			return validateURLHosts(connURL, false)
+		}
+
+	case "mongodb-database-plugin":
+		if isURL(connURL, "mongodb") {
+			return validateURLHosts(connURL, true)
+		}
+
+	case "cassandra-database-plugin":
+		return normalizeCassandraHosts(details)
+
+	case "influxdb-database-plugin":
+		host, _ := details["host"].(string)
+		if host == "" {
+			return nil
+		}
+		// The plugin joins the host and port with a colon itself
+		trimmed := strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if ip := net.ParseIP(trimmed); ip != nil && strings.Contains(trimmed, ":") {
+			details["host"] = "[" + trimmed + "]"
+		} else if !isValidHost(host) {
+			return fmt.Errorf("host %q is not a valid hostname or IP address, and must not include a port; set port instead", host)
+		}
+		if port, ok := details["port"]; ok {
+			if err := validatePort("port", fmt.Sprint(port)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeCassandraHosts validates the comma separated contact points in the
+// hosts of a Cassandra connection, replacing each RFC 2782 service name, such
+// as _cql._tcp.cassandra.example.com, with the targets of its SRV records.
+func normalizeCassandraHosts(details map[string]interface{}) error {
+	if port, ok := details["port"]; ok {
+		var value int
+		if err := mapstructure.WeakDecode(port, &value); err != nil {
+			return fmt.Errorf("port must be a number: %s", err)
+		}
+		if value != 0 {
+			if err := validatePort("port", strconv.Itoa(value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	hosts, _ := details["hosts"].(string)
+	if hosts == "" {
+		return nil
+	}
+
+	var normalized []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			return errors.New("hosts cannot contain an empty host")
+		}
+
+		if isSRVName(host) {
+			_, records, err := lookupSRV("", "", host)
+			if err != nil {
+				return fmt.Errorf("failed to look up the SRV records of %s in hosts: %s", host, err)
+			}
+			if len(records) == 0 {
+				return fmt.Errorf("%s in hosts has no SRV records", host)
+			}
+			for _, record := range records {
+				normalized = append(normalized, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+			}
+			continue
+		}
+
+		address, err := normalizeAddress("hosts", host, "")
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, address)
+	}
+
+	details["hosts"] = strings.Join(normalized, ",")
+	return nil
+}
+
+// isSRVName reports whether a host is an RFC 2782 service name, which
+// starts with the underscored labels of a service and its protocol.
+func isSRVName(host string) bool {
+	labels := strings.SplitN(host, ".", 3)
+	return len(labels) == 3 && strings.HasPrefix(labels[0], "_") && labels[1] == "_tcp"
+}
+
+// validateURLHosts validates the host and port of a URL, or its comma
+// separated hosts if multiple is set. Unbracketed IPv6 addresses are
+// rejected, as they can't be told apart from a port.
+func validateURLHosts(connURL string, multiple bool) error {
+	hosts, _ := urlHost(connURL)
+	list := []string{hosts}
+	if multiple {
+		list = strings.Split(hosts, ",")
+	}
+	for _, host := range list {
+		if host == "" {
+			if multiple {
+				return errors.New("connection_url cannot contain an empty host")
+			}
+			continue
+		}
+		if strings.Count(host, ":") > 1 && !strings.HasPrefix(host, "[") {
+			return fmt.Errorf("connection_url has an unbracketed IPv6 address %q; write it as [%s] or [%s]:port", host, host, host)
+		}
+		// Instance names such as host\instance are allowed by sqlserver URLs
+		if i := strings.Index(host, `\`); i >= 0 {
+			host = host[:i]
+		}
+		if _, err := normalizeAddress("connection_url", host, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeAddress validates a host with an optional port, which may be an
+// IPv6 address either bracketed or, without a port, bare. It returns the
+// address with IPv6 addresses bracketed, and with defaultPort unless the
+// address has a port or defaultPort is empty.
+func normalizeAddress(field, address, defaultPort string) (string, error) {
+	if strings.Contains(address, "{{") {
+		// A templated address is filled in by the plugin
+		return address, nil
+	}
+
+	host, port := address, ""
+	switch {
+	case strings.HasPrefix(address, "["):
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return "", fmt.Errorf("%s has an unterminated IPv6 address %q", field, address)
+		}
+		host = address[1:end]
+		if ip := net.ParseIP(host); ip == nil || !strings.Contains(host, ":") {
+			return "", fmt.Errorf("%s has an invalid IPv6 address %q", field, address)
+		}
+		switch rest := address[end+1:]; {
+		case rest == "":
+		case strings.HasPrefix(rest, ":"):
+			port = rest[1:]
+		default:
+			return "", fmt.Errorf("%s has unexpected characters after IPv6 address %q", field, address)
+		}
+
+	case strings.Count(address, ":") > 1:
+		if ip := net.ParseIP(address); ip == nil {
+			return "", fmt.Errorf("%s has an invalid address %q; IPv6 addresses with a port must be bracketed, as in [::1]:5432", field, address)
+		}
+
+	case strings.Contains(address, ":"):
+		i := strings.Index(address, ":")
+		host, port = address[:i], address[i+1:]
+	}
+
+	if !isValidHost(host) {
+		return "", fmt.Errorf("%s has an invalid host %q", field, host)
+	}
+	if port != "" || strings.HasSuffix(address, ":") {
+		if err := validatePort(field, port); err != nil {
+			return "", err
+		}
+	} else {
+		port = defaultPort
+	}
+
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]", nil
+		}
+		return host, nil
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func isValidHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	return len(host) <= 253 && hostnameRegex.MatchString(host)
+}
+
+func validatePort(field, port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s has an invalid port %q; ports must be between 1 and 65535", field, port)
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	approvalPath = "approval/"
+
+	// defaultApprovalWindow is how long a credential request for a role that
+	// requires approval waits to be approved, and then to be picked up, if
+	// the role doesn't set approval_window.
+	defaultApprovalWindow = 15 * time.Minute
+)
+
+// approvalGrant is a pending credential request for a role that requires
+// approval. Credentials are only issued once another caller approved it, to
+// the caller that requested them.
+type approvalGrant struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+
+	// RequestedBy and ApprovedBy are the requesterIdentity of the requester
+	// and the approver.
+	RequestedBy          string    `json:"requested_by"`
+	RequesterDisplayName string    `json:"requester_display_name"`
+	RequestTime          time.Time `json:"request_time"`
+
+	ApprovedBy          string    `json:"approved_by,omitempty"`
+	ApproverDisplayName string    `json:"approver_display_name,omitempty"`
+	ApprovalTime        time.Time `json:"approval_time,omitempty"`
+
+	// Window is the role's approval window when the grant was requested, and
+	// Expiration the time by which it must be approved, or once approved
+	// picked up.
+	Window     time.Duration `json:"window"`
+	Expiration time.Time     `json:"expiration"`
+}
+
+func (g *approvalGrant) approved() bool {
+	return !g.ApprovalTime.IsZero()
+}
+
+func (g *approvalGrant) status() string {
+	if g.approved() {
+		return "approved"
+	}
+	return "pending"
+}
+
+// requesterIdentity identifies the caller of a request for approvals: by its
+// entity, as the tokens of an entity must not approve each other's requests,
+// or by its token for tokens without one.
+func requesterIdentity(req *logical.Request) string {
+	if req.EntityID != "" {
+		return "entity:" + req.EntityID
+	}
+	if req.ClientTokenAccessor != "" {
+		return "accessor:" + req.ClientTokenAccessor
+	}
+	return ""
+}
+
+func putApprovalGrant(ctx context.Context, s logical.Storage, grant *approvalGrant) error {
+	entry, err := logical.StorageEntryJSON(approvalPath+grant.ID, grant)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// approvalGrant returns the grant with the given ID, or nil if it doesn't
+// exist or has expired, in which case it is removed.
+func (b *databaseBackend) approvalGrant(ctx context.Context, s logical.Storage, id string) (*approvalGrant, error) {
+	entry, err := s.Get(ctx, approvalPath+id)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	var grant approvalGrant
+	if err := entry.DecodeJSON(&grant); err != nil {
+		return nil, err
+	}
+	if b.clock.Now().After(grant.Expiration) {
+		return nil, s.Delete(ctx, approvalPath+id)
+	}
+	return &grant, nil
+}
+
+// createApprovalGrant records a credential request for a role that requires
+// approval, returning its ID to the requester.
+func (b *databaseBackend) createApprovalGrant(ctx context.Context, req *logical.Request, name string, role *roleEntry) (*logical.Response, error) {
+	identity := requesterIdentity(req)
+	if identity == "" {
+		return logical.ErrorResponse(fmt.Sprintf("role %q requires approval, which can only be requested by a token with an entity or accessor", name)), nil
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	window := role.ApprovalWindow
+	if window == 0 {
+		window = defaultApprovalWindow
+	}
+	now := b.clock.Now()
+	grant := &approvalGrant{
+		ID:                   id,
+		Role:                 name,
+		RequestedBy:          identity,
+		RequesterDisplayName: req.DisplayName,
+		RequestTime:          now,
+		Window:               window,
+		Expiration:           now.Add(window),
+	}
+	if err := putApprovalGrant(ctx, req.Storage, grant); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("credentials requested for approval", "role", name, "approval_id", id, "requested_by", identity)
+
+	resp := &logical.Response{Data: grant.responseData()}
+	resp.AddWarning(fmt.Sprintf("Role %q requires approval. Once another caller approved the request at approvals/%s/approve, read the credentials from approvals/%s/creds.", name, id, id))
+	return resp, nil
+}
+
+func (g *approvalGrant) responseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"approval_id":  g.ID,
+		"role":         g.Role,
+		"status":       g.status(),
+		"requested_by": g.RequesterDisplayName,
+		"request_time": g.RequestTime.Format(time.RFC3339),
+		"expiration":   g.Expiration.Format(time.RFC3339),
+	}
+	if g.approved() {
+		data["approved_by"] = g.ApproverDisplayName
+		data["approval_time"] = g.ApprovalTime.Format(time.RFC3339)
+	}
+	return data
+}
+
+func pathApprovals(b *databaseBackend) []*framework.Path {
+	idField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "ID of the approval, returned by the credential request.",
+	}
+
+	return []*framework.Path{
+		{
+			Pattern: "approvals/?$",
+			Fields:  listFields(),
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathApprovalsList,
+					Summary:  "List the credential requests waiting for approval or to be picked up.",
+				},
+			},
+
+			HelpSynopsis:    pathApprovalsHelpSyn,
+			HelpDescription: pathApprovalsHelpDesc,
+		},
+		{
+			Pattern: "approvals/" + framework.GenericNameRegex("id") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"id": idField,
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathApprovalRead,
+					Summary:  "Read the status of a credential request.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"approval_id":  "c3b3a9e1-5d19-3c1f-1e2d-acb0bcbc3d8e",
+									"role":         "production-admin",
+									"status":       "pending",
+									"requested_by": "oidc-alice",
+									"request_time": "2020-01-01T12:00:00Z",
+									"expiration":   "2020-01-01T12:15:00Z",
+								},
+							},
+						}},
+					},
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathApprovalDelete,
+					Summary:  "Cancel or deny a credential request.",
+				},
+			},
+
+			HelpSynopsis:    pathApprovalsHelpSyn,
+			HelpDescription: pathApprovalsHelpDesc,
+		},
+		{
+			Pattern: "approvals/" + framework.GenericNameRegex("id") + "/approve$",
+			Fields: map[string]*framework.FieldSchema{
+				"id": idField,
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathApprovalApprove,
+					Summary:  "Approve another caller's credential request.",
+				},
+			},
+
+			HelpSynopsis:    pathApprovalsHelpSyn,
+			HelpDescription: pathApprovalsHelpDesc,
+		},
+		{
+			Pattern: "approvals/" + framework.GenericNameRegex("id") + "/creds$",
+			Fields: map[string]*framework.FieldSchema{
+				"id": idField,
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathApprovalCreds,
+					Summary:  "Generate the credentials of an approved request.",
+				},
+			},
+
+			HelpSynopsis:    pathApprovalsHelpSyn,
+			HelpDescription: pathApprovalsHelpDesc,
+		},
+	}
+}
+
+func (b *databaseBackend) pathApprovalsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ids, err := req.Storage.List(ctx, approvalPath)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = paginate(ids, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, id := range ids {
+		grant, err := b.approvalGrant(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if grant == nil {
+			continue
+		}
+		keys = append(keys, id)
+		keyInfo[id] = grant.responseData()
+	}
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *databaseBackend) pathApprovalRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	grant, err := b.approvalGrant(ctx, req.Storage, data.Get("id").(string))
+	if err != nil || grant == nil {
+		return nil, err
+	}
+	return &logical.Response{Data: grant.responseData()}, nil
+}
+
+func (b *databaseBackend) pathApprovalDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	lock := locksutil.LockForKey(b.approvalLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := req.Storage.Delete(ctx, approvalPath+id); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("credential request cancelled", "approval_id", id, "cancelled_by", requesterIdentity(req))
+	return nil, nil
+}
+
+func (b *databaseBackend) pathApprovalApprove(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	lock := locksutil.LockForKey(b.approvalLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	grant, err := b.approvalGrant(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no pending credential request %q; it may have expired", id)), nil
+	}
+	if grant.approved() {
+		return logical.ErrorResponse(fmt.Sprintf("credential request %q has already been approved", id)), nil
+	}
+
+	// A token without an entity can create child tokens with accessors of
+	// their own, so only an entity tells the approver apart from the requester
+	if req.EntityID == "" {
+		return logical.ErrorResponse("credential requests can only be approved by a token with an entity"), nil
+	}
+	identity := requesterIdentity(req)
+	if identity == grant.RequestedBy {
+		return nil, logical.CodedError(http.StatusForbidden, "credential requests must be approved by a caller other than their requester")
+	}
+
+	now := b.clock.Now()
+	grant.ApprovedBy = identity
+	grant.ApproverDisplayName = req.DisplayName
+	grant.ApprovalTime = now
+	grant.Expiration = now.Add(grant.Window)
+	if err := putApprovalGrant(ctx, req.Storage, grant); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("credential request approved", "role", grant.Role, "approval_id", id, "requested_by", grant.RequestedBy, "approved_by", identity)
+
+	return &logical.Response{Data: grant.responseData()}, nil
+}
+
+func (b *databaseBackend) pathApprovalCreds(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	lock := locksutil.LockForKey(b.approvalLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	grant, err := b.approvalGrant(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no credential request %q; it may have expired", id)), nil
+	}
+	if requesterIdentity(req) != grant.RequestedBy {
+		return nil, logical.CodedError(http.StatusForbidden, "the credentials of a request can only be read by its requester")
+	}
+	if !grant.approved() {
+		return logical.ErrorResponse(fmt.Sprintf("credential request %q has not been approved yet", id)), nil
+	}
+
+	// The role's other restrictions are checked again, as the requester
+	// picks up the credentials.
+	resp, err := b.issueCredentials(ctx, req, grant.Role, grant)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return resp, err
+	}
+
+	// Each approval issues a single credential
+	if err := req.Storage.Delete(ctx, approvalPath+id); err != nil {
+		b.Logger().Error("failed to remove an approval after issuing its credentials", "approval_id", id, "error", err)
+	}
+	return resp, nil
+}
+
+const pathApprovalsHelpSyn = `
+Approve credential requests for roles that require approval.
+`
+
+const pathApprovalsHelpDesc = `
+Reading "creds/<role>" for a role with "require_approval" doesn't return
+credentials. It records a pending request and returns its "approval_id"
+instead, for break-glass access to databases where a single person must not be
+able to obtain credentials on their own.
+
+Another caller approves the request by writing to "approvals/<id>/approve".
+Callers are identified by their entity, or by their token if it has none, so
+the tokens of one entity can't approve each other's requests. Approvers must
+have an entity, since a token without one could approve the requests of the
+token that created it. Who may approve
+is controlled by the ACL policies granting "approvals/+/approve". Once
+approved, the requester reads the credentials from "approvals/<id>/creds",
+which issues them with the role's TTLs and checks the role's other
+restrictions again. Each approval issues a single credential.
+
+Requests must be approved within the role's "approval_window", and once
+approved picked up within it again, after which they expire. Reading
+"approvals/<id>" returns the status of a request, listing "approvals/" the
+requests that haven't expired, and deleting "approvals/<id>" cancels or denies
+a request.
+`
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_approvals(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(entity string, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation:   op,
+			Path:        path,
+			Storage:     s,
+			Data:        data,
+			EntityID:    entity,
+			DisplayName: "oidc-" + entity,
+		})
+	}
+	mustRequest := func(entity string, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(entity, op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+	mustFail := func(entity string, op logical.Operation, path string, code int) {
+		t.Helper()
+		resp, err := request(entity, op, path, nil)
+		if code != 0 {
+			if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != code {
+				t.Fatalf("expected a %d error, got err:%v resp:%#v", code, err, resp)
+			}
+			return
+		}
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error response, got err:%v resp:%#v", err, resp)
+		}
+	}
+
+	mustRequest("", logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	mustRequest("", logical.CreateOperation, "roles/breakglass", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"default_ttl":         "15m",
+		"require_approval":    true,
+		"approval_window":     "10m",
+	})
+
+	// Requests without an identity can't be approved
+	mustFail("", logical.ReadOperation, "creds/breakglass", 0)
+
+	resp := mustRequest("alice", logical.ReadOperation, "creds/breakglass", nil)
+	if resp.Secret != nil || resp.Data["username"] != nil || resp.Data["status"] != "pending" {
+		t.Fatalf("expected a pending request, got %#v", resp)
+	}
+	id := resp.Data["approval_id"].(string)
+
+	mustFail("alice", logical.ReadOperation, "approvals/"+id+"/creds", 0)
+	mustFail("alice", logical.UpdateOperation, "approvals/"+id+"/approve", http.StatusForbidden)
+
+	// Tokens without an entity can't approve, as they might be children of
+	// the requester's token
+	resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation:           logical.UpdateOperation,
+		Path:                "approvals/" + id + "/approve",
+		Storage:             s,
+		ClientTokenAccessor: "child",
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an approval without an entity to be refused, got err:%v resp:%#v", err, resp)
+	}
+
+	clock.advance(5 * time.Minute)
+	resp = mustRequest("bob", logical.UpdateOperation, "approvals/"+id+"/approve", nil)
+	if resp.Data["status"] != "approved" || resp.Data["approved_by"] != "oidc-bob" || resp.Data["expiration"] != "2020-01-01T12:15:00Z" {
+		t.Fatalf("unexpected approval %#v", resp.Data)
+	}
+	mustFail("bob", logical.UpdateOperation, "approvals/"+id+"/approve", 0)
+
+	// Only the requester picks up the credentials, once
+	mustFail("bob", logical.ReadOperation, "approvals/"+id+"/creds", http.StatusForbidden)
+	resp = mustRequest("alice", logical.ReadOperation, "approvals/"+id+"/creds", nil)
+	if resp.Data["username"] == nil || resp.Secret == nil || resp.Secret.InternalData["approved_by"] != "entity:bob" {
+		t.Fatalf("expected credentials, got %#v", resp)
+	}
+	mustFail("alice", logical.ReadOperation, "approvals/"+id+"/creds", 0)
+
+	// Requests expire if they aren't approved in time
+	resp = mustRequest("alice", logical.ReadOperation, "creds/breakglass", nil)
+	expiring := resp.Data["approval_id"].(string)
+	resp = mustRequest("alice", logical.ReadOperation, "creds/breakglass", nil)
+	cancelled := resp.Data["approval_id"].(string)
+
+	resp = mustRequest("", logical.ListOperation, "approvals/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 2 {
+		t.Fatalf("expected two pending requests, got %v", keys)
+	}
+	mustRequest("bob", logical.DeleteOperation, "approvals/"+cancelled, nil)
+	mustFail("bob", logical.UpdateOperation, "approvals/"+cancelled+"/approve", 0)
+
+	clock.advance(11 * time.Minute)
+	mustFail("bob", logical.UpdateOperation, "approvals/"+expiring+"/approve", 0)
+	if resp := mustRequest("", logical.ReadOperation, "approvals/"+expiring, nil); resp != nil {
+		t.Fatalf("expected the request to have expired, got %#v", resp.Data)
+	}
+	resp = mustRequest("", logical.ListOperation, "approvals/", nil)
+	if keys := resp.Data["keys"]; keys != nil {
+		t.Fatalf("expected no pending requests, got %v", keys)
+	}
+}
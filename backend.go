@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"net/rpc"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/errwrap"
 	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/database/dbplugin"
 	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
@@ -28,6 +32,8 @@ const (
 	databaseConfigPath     = "database/config/"
 	databaseRolePath       = "role/"
 	databaseStaticRolePath = "static-role/"
+	databaseRoleRenamePath = "role-rename/"
+	databaseActiveUserPath = "active-user/"
 )
 
 type dbPluginInstance struct {
@@ -37,18 +43,79 @@ type dbPluginInstance struct {
 	id     string
 	name   string
 	closed bool
+
+	// closeOnce closes the plugin and its tunnel once, whether Close drained
+	// the calls in flight on it or gave up waiting for them.
+	closeOnce sync.Once
+	closeErr  error
+
+	// tunnel is the SSH bastion or proxy tunnel the plugin connects
+	// through, if any.
+	tunnel *tunnel
+
+	// srvHosts are the contact points the SRV records in the hosts of a
+	// Cassandra connection resolved to when the plugin was initialized, and
+	// srvResolvedAt is when they were last resolved.
+	srvHosts      string
+	srvResolvedAt time.Time
 }
 
-func (dbi *dbPluginInstance) Close() error {
-	dbi.Lock()
-	defer dbi.Unlock()
+// closeDrainTimeout bounds how long closing a plugin instance waits for the
+// calls in flight on it to finish before the plugin is killed regardless.
+var closeDrainTimeout = 30 * time.Second
 
-	if dbi.closed {
-		return nil
+// Close closes the plugin instance once the calls in flight on it have
+// finished, so that a user being created when a connection is reset or
+// reloaded gets its lease. Taking the instance's lock stops new calls from
+// starting, and the calls that were waiting for it fail once it is closed
+// rather than reaching the closed plugin. If the calls in flight haven't
+// finished within closeDrainTimeout, the plugin is closed anyway.
+func (dbi *dbPluginInstance) Close() error {
+	locked := make(chan struct{})
+	go func() {
+		dbi.Lock()
+		close(locked)
+	}()
+
+	timer := time.NewTimer(closeDrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-locked:
+		defer dbi.Unlock()
+		if dbi.closed {
+			return nil
+		}
+		err := dbi.close()
+		dbi.retire()
+		return err
+	case <-timer.C:
+		err := dbi.close()
+		go func() {
+			<-locked
+			defer dbi.Unlock()
+			dbi.retire()
+		}()
+		return err
 	}
-	dbi.closed = true
+}
 
-	return dbi.Database.Close()
+func (dbi *dbPluginInstance) close() error {
+	dbi.closeOnce.Do(func() {
+		dbi.closeErr = dbi.Database.Close()
+		if dbi.tunnel != nil {
+			dbi.tunnel.Close()
+		}
+	})
+	return dbi.closeErr
+}
+
+// retire marks the instance closed, failing the calls made on it from now
+// on. It is called holding the instance's lock.
+func (dbi *dbPluginInstance) retire() {
+	if !dbi.closed {
+		dbi.closed = true
+		dbi.Database = closedDatabase{Database: dbi.Database}
+	}
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -56,6 +123,30 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
+	b.mountLabels = mountMetricLabels(ctx, conf)
+
+	deny, err := parseDenyWildcardAllowedRolesOption(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	b.denyWildcardAllowedRoles = deny
+	if deny {
+		if _, err := b.auditWildcardAllowedRoles(ctx, conf.StorageView); err != nil {
+			conf.Logger.Error("error auditing the allowed_roles of the connections", "error", err)
+		}
+	}
+	bridgeDriverLogs(b.Logger())
+
+	if b.pluginDirectory, err = parsePluginDirectoryOption(conf.Config); err != nil {
+		return nil, err
+	}
+
+	prewarm, timeout, err := parsePrewarmOptions(conf.Config)
+	if err != nil {
+		conf.Logger.Error("error reading the connections to pre-warm", "error", err)
+	} else if len(prewarm) > 0 {
+		go b.prewarmConnections(context.Background(), conf.StorageView, prewarm, timeout)
+	}
 
 	b.credRotationQueue = queue.New()
 	// Create a context with a cancel method for processing any WAL entries and
@@ -103,13 +194,20 @@ func Backend(conf *logical.BackendConfig) *databaseBackend {
 		Help: strings.TrimSpace(backendHelp),
 
 		PathsSpecial: &logical.Paths{
+			Root: []string{
+				breakGlassPath + "*",
+			},
 			LocalStorage: []string{
 				framework.WALPrefix,
 			},
 			SealWrapStorage: []string{
 				"config/*",
 				"static-role/*",
+				secretSinkPath + "*",
 				kubeconfigPath,
+				tokenLookupPath,
+				breakGlassPath + "*",
+				deferredPasswordPath + "*",
 			},
 		},
 		Paths: framework.PathAppend(
@@ -117,11 +215,28 @@ func Backend(conf *logical.BackendConfig) *databaseBackend {
 				pathListPluginConnection(&b),
 				pathConfigurePluginConnection(&b),
 				pathResetConnection(&b),
+				pathConnectionMaintenance(&b),
+				pathListSinks(&b),
+				pathSinks(&b),
+				pathTokenLookup(&b),
+				pathTestRole(&b),
+				pathDrift(&b),
+				pathRevocationPlan(&b),
+				pathDeferredPassword(&b),
 			},
 			pathListRoles(&b),
 			pathRoles(&b),
+			pathRoleRename(&b),
+			pathRoleCopy(&b),
+			pathRoleCredentials(&b),
+			pathReloadConnection(&b),
 			pathCredsCreate(&b),
+			pathApprovals(&b),
+			pathBreakGlass(&b),
 			pathRotateCredentials(&b),
+			pathIssuance(&b),
+			pathBundle(&b),
+			pathChangeHistory(&b),
 			pathKubeconfig(&b),
 		),
 
@@ -132,22 +247,73 @@ func Backend(conf *logical.BackendConfig) *databaseBackend {
 		Invalidate:  b.invalidate,
 		BackendType: logical.TypeLogical,
 
-		PeriodicFunc: b.syncServiceAccounts,
+		PeriodicFunc: b.periodicFunc,
 	}
 
 	b.logger = conf.Logger
 	b.connections = make(map[string]*dbPluginInstance)
 
 	b.roleLocks = locksutil.CreateLocks()
+	b.connectionLocks = locksutil.CreateLocks()
+	b.approvalLocks = locksutil.CreateLocks()
+	b.deferredPasswordLocks = locksutil.CreateLocks()
 	b.saCache = cache.NewStore(keyFunc)
+	b.clock = systemClock{}
+	b.connectionFiles = newConnectionFiles(conf.BackendUUID)
 
 	return &b
 }
 
+// periodicFunc syncs the service account cache to storage, rotates any
+// root credentials that are due for scheduled rotation, reconnects
+// connections whose SRV records have changed, rotates released break-glass
+// credentials, revokes users past their role's max_lifetime and the expired
+// users of connections with host-managed expiration, checks connections for
+// drift between their users and leases and removes expired username
+// reservations and deferred passwords.
+func (b *databaseBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	var result *multierror.Error
+	if err := b.syncServiceAccounts(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.rotateScheduledRootCredentials(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.renewClientCertificates(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.refreshSRVHosts(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.rotateReleasedBreakGlassCredentials(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.revokeUsersPastMaxLifetime(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.revokeExpiredUsers(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.checkConnectionsForDrift(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.removeExpiredUsernameReservations(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.removeExpiredDeferredPasswords(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
+
 type databaseBackend struct {
 	connections map[string]*dbPluginInstance
 	logger      log.Logger
 
+	// connectionCalls are the plugin instances being created for
+	// connections, guarded by the backend's lock.
+	connectionCalls map[string]*connectionCall
+
 	*framework.Backend
 	sync.RWMutex
 	// CredRotationQueue is an in-memory priority queue used to track Static Roles
@@ -165,13 +331,66 @@ type databaseBackend struct {
 	// issues with the priority queue.
 	roleLocks []*locksutil.LockEntry
 
+	// connectionLocks serialize the writes of each connection, so that a
+	// check-and-set write can't interleave with another write.
+	connectionLocks []*locksutil.LockEntry
+
+	// approvalLocks serialize the updates of each approval grant. They are
+	// apart from the roleLocks, which are taken while credentials are issued
+	// for a grant.
+	approvalLocks []*locksutil.LockEntry
+
+	// deferredPasswordLocks serialize the pickups of each deferred password,
+	// so that it is returned once.
+	deferredPasswordLocks []*locksutil.LockEntry
+
+	// historyLock serializes the updates of change histories, which are
+	// read, appended to and written back.
+	historyLock sync.Mutex
+
+	// clock is the source of the current time for lease expirations and
+	// rotation schedules, so that tests can control it.
+	clock clock
+
+	// credLimiters rate limits credential issuance for roles that set
+	// max_creds_per_minute.
+	credLimiters credLimiters
+
+	// connectionFiles holds the certificates and keys of the connections
+	// whose drivers read them from files.
+	connectionFiles *connectionFiles
+
+	// storageCache keeps the connection and role entries read by credential
+	// requests in memory.
+	storageCache storageCache
+
+	// pauseCache keeps the issuance pauses in memory.
+	pauseCache issuancePauseCache
+
+	// statementTemplates keeps the parsed statements of roles in memory.
+	statementTemplates statementTemplateCache
+
+	// mountLabels identify the mount in the backend's metrics.
+	mountLabels []metrics.Label
+
+	// pluginDirectory is the mount's plugin_directory option, holding the
+	// binaries that connections can run with plugin_command.
+	pluginDirectory string
+
+	// denyWildcardAllowedRoles is set by the mount's
+	// deny_wildcard_allowed_roles option, rejecting allowed_roles "*".
+	denyWildcardAllowedRoles bool
+
+	// saChanges holds back the changes to flapping service accounts.
+	saChanges serviceAccountChangeLimiter
+
 	saCache   cache.Store
 	stopWatch func()
 	stopMtx   sync.Mutex
 }
 
 func (b *databaseBackend) DatabaseConfig(ctx context.Context, s logical.Storage, name string) (*DatabaseConfig, error) {
-	entry, err := s.Get(ctx, fmt.Sprintf("config/%s", name))
+	entry, err := b.storageCache.get(ctx, s, fmt.Sprintf("config/%s", name))
 	if err != nil {
 		return nil, errwrap.Wrapf("failed to read connection configuration: {{err}}", err)
 	}
@@ -187,6 +406,19 @@ func (b *databaseBackend) DatabaseConfig(ctx context.Context, s logical.Storage,
 	return &config, nil
 }
 
+// updateDatabaseConfig stores a connection's configuration as changed by the
+// backend itself, at the next version so that check-and-set writes made
+// against the previous version fail. The caller must hold the connection's
+// lock.
+func (b *databaseBackend) updateDatabaseConfig(ctx context.Context, s logical.Storage, name string, config *DatabaseConfig) error {
+	config.Version = entryVersion(config.Version) + 1
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("config/%s", name), config)
+	if err != nil {
+		return err
+	}
+	return b.putEntry(ctx, s, entry)
+}
+
 type upgradeStatements struct {
 	// This json tag has a typo in it, the new version does not. This
 	// necessitates this upgrade logic.
@@ -238,17 +470,8 @@ func (b *databaseBackend) getKubernetesRoleEntry(ctx context.Context, s logical.
 		role.DBName = dbName
 	}
 
-	transformation := map[string]string{
-		"annotation": annotation,
-	}
-
-	var transformedStatements []string
-
-	for _, statement := range role.Statements.Creation {
-		transformedStatements = append(transformedStatements, dbutil.QueryHelper(statement, transformation))
-	}
-
-	role.Statements.Creation = transformedStatements
+	role.Statements.Creation = b.annotatedStatements(role.Statements.Creation, annotation)
+	role.CreationStatementsNext = b.annotatedStatements(role.CreationStatementsNext, annotation)
 
 	// For backwards compatibility, copy the transformed value back into the string form
 	// of the field
@@ -257,16 +480,43 @@ func (b *databaseBackend) getKubernetesRoleEntry(ctx context.Context, s logical.
 	return role, nil
 }
 
+// annotatedStatements interpolates a service account's annotation into the
+// creation statements of a concrete role.
+func (b *databaseBackend) annotatedStatements(statements []string, annotation string) []string {
+	if len(statements) == 0 {
+		return nil
+	}
+	return b.statementTemplates.render(statements, map[string]string{
+		"annotation": annotation,
+	})
+}
+
 func (b *databaseBackend) Role(ctx context.Context, s logical.Storage, roleName string) (*roleEntry, error) {
 	return b.roleAtPath(ctx, s, roleName, databaseRolePath)
 }
 
+// roleEntryName returns the name of the stored role that the role called name
+// resolves to: the role itself, or for the virtual roles of Kubernetes service
+// accounts the role they are based on.
+func (b *databaseBackend) roleEntryName(ctx context.Context, s logical.Storage, name string) (string, error) {
+	entry, err := b.storageCache.get(ctx, s, databaseRolePath+name)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil && strings.HasPrefix(name, "k8s_") {
+		if subs := strings.SplitN(name, "_", 4); len(subs) == 4 {
+			return subs[1], nil
+		}
+	}
+	return name, nil
+}
+
 func (b *databaseBackend) StaticRole(ctx context.Context, s logical.Storage, roleName string) (*roleEntry, error) {
 	return b.roleAtPath(ctx, s, roleName, databaseStaticRolePath)
 }
 
 func (b *databaseBackend) roleAtPath(ctx context.Context, s logical.Storage, roleName string, pathPrefix string) (*roleEntry, error) {
-	entry, err := s.Get(ctx, pathPrefix+roleName)
+	entry, err := b.storageCache.get(ctx, s, pathPrefix+roleName)
 	if err != nil {
 		return nil, err
 	}
@@ -314,67 +564,180 @@ func (b *databaseBackend) roleAtPath(ctx context.Context, s logical.Storage, rol
 	return &result, nil
 }
 
+// listFields returns the paging fields accepted by the list endpoints.
+func listFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"after": {
+			Type:        framework.TypeString,
+			Description: "Only return keys that sort after this key.",
+			Query:       true,
+		},
+		"limit": {
+			Type:        framework.TypeInt,
+			Description: "Maximum number of keys to return. If zero or unset, all keys are returned.",
+			Query:       true,
+		},
+	}
+}
+
+// paginate returns a page of the sorted keys, starting after the "after"
+// field and containing at most "limit" keys.
+func paginate(keys []string, data *framework.FieldData) ([]string, error) {
+	after := data.Get("after").(string)
+	limit := data.Get("limit").(int)
+	if limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+
+	sort.Strings(keys)
+	if after != "" {
+		keys = keys[sort.Search(len(keys), func(i int) bool { return keys[i] > after }):]
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	return keys, nil
+}
+
 func (b *databaseBackend) invalidate(ctx context.Context, key string) {
+	if isCachedStorageKey(key) {
+		b.storageCache.invalidate(key)
+	}
+	if isRoleStorageKey(key) {
+		b.statementTemplates.purge()
+	}
+
 	switch {
+	case key == issuancePausePath:
+		b.pauseCache.invalidate()
 	case strings.HasPrefix(key, databaseConfigPath):
 		name := strings.TrimPrefix(key, databaseConfigPath)
 		b.ClearConnection(name)
 	}
 }
 
+// connectionCall is the creation of a connection's plugin instance by the
+// first request to use it, which the concurrent requests for the connection
+// wait for rather than each starting a plugin.
+type connectionCall struct {
+	done chan struct{}
+	db   *dbPluginInstance
+	err  error
+
+	// cleared is set if the connection is cleared while the instance is
+	// created, as it may have been created from the old configuration.
+	cleared bool
+}
+
 func (b *databaseBackend) GetConnection(ctx context.Context, s logical.Storage, name string) (*dbPluginInstance, error) {
 	b.RLock()
-	unlockFunc := b.RUnlock
-	defer func() { unlockFunc() }()
-
 	db, ok := b.connections[name]
+	b.RUnlock()
 	if ok {
 		return db, nil
 	}
 
-	// Upgrade lock
-	b.RUnlock()
 	b.Lock()
-	unlockFunc = b.Unlock
-
 	db, ok = b.connections[name]
 	if ok {
+		b.Unlock()
 		return db, nil
 	}
+	if call, ok := b.connectionCalls[name]; ok {
+		b.Unlock()
+		select {
+		case <-call.done:
+			return call.db, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if b.connectionCalls == nil {
+		b.connectionCalls = make(map[string]*connectionCall)
+	}
+	call := &connectionCall{done: make(chan struct{})}
+	b.connectionCalls[name] = call
+	b.Unlock()
+
+	// The plugin is started and initialized without holding the backend's
+	// lock, so that the requests for other connections aren't held up
+	db, err := b.createConnection(ctx, s, name)
 
+	b.Lock()
+	delete(b.connectionCalls, name)
+	existing, ok := b.connections[name]
+	switch {
+	case err != nil:
+	case ok:
+		// The connection was written or reloaded in the meantime
+		db.Close()
+		db = existing
+	case call.cleared:
+		db.Close()
+		db, err = nil, fmt.Errorf("connection %q was changed while its plugin was initialized; retry the request", name)
+	default:
+		b.connections[name] = db
+	}
+	call.db, call.err = db, err
+	b.Unlock()
+	close(call.done)
+
+	return db, err
+}
+
+func (b *databaseBackend) createConnection(ctx context.Context, s logical.Storage, name string) (*dbPluginInstance, error) {
 	config, err := b.DatabaseConfig(ctx, s, name)
 	if err != nil {
 		return nil, err
 	}
+	return b.newConnection(ctx, name, config)
+}
 
-	// We have to create a custom plugin lookup mock, as plugins can't look up other plugins
-	// We instead just manually pack all the builtin database plugins into this binary
-	looker := &mockPluginLooker{}
+// newConnection creates and initializes a plugin instance for a connection,
+// without adding it to the connections cache.
+func (b *databaseBackend) newConnection(ctx context.Context, name string, config *DatabaseConfig) (*dbPluginInstance, error) {
+	logger := b.pluginLogger(name, config)
+	dbp, err := b.newPluginClient(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	dbp, err := dbplugin.PluginFactory(ctx, config.PluginName, looker, b.logger)
+	details, err := pluginConnectionDetails(b.connectionFiles, name, config)
 	if err != nil {
+		dbp.Close()
 		return nil, err
 	}
 
-	_, err = dbp.Init(ctx, config.ConnectionDetails, true)
+	tunnel, err := openTunnel(name, config, details, b.logger, b.mountLabels)
 	if err != nil {
 		dbp.Close()
 		return nil, err
 	}
+	srvHosts := resolvedSRVHosts(config, details)
 
-	id, err := uuid.GenerateUUID()
+	_, err = dbp.Init(ctx, details, true)
 	if err != nil {
+		dbp.Close()
+		if tunnel != nil {
+			tunnel.Close()
+		}
 		return nil, err
 	}
 
-	db = &dbPluginInstance{
-		Database: dbp,
-		name:     name,
-		id:       id,
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
 	}
 
-	b.connections[name] = db
-	return db, nil
+	return &dbPluginInstance{
+		Database:      newOperationLimiter(newStatementLogger(newHostCredentials(dbp), logger, config.PluginName), config.MaxConcurrentOperations),
+		name:          name,
+		id:            id,
+		tunnel:        tunnel,
+		srvHosts:      srvHosts,
+		srvResolvedAt: b.clock.Now(),
+	}, nil
 }
 
 // invalidateQueue cancels any background queue loading and destroys the queue.
@@ -388,72 +751,126 @@ func (b *databaseBackend) invalidateQueue() {
 	b.credRotationQueue = nil
 }
 
-// ClearConnection closes the database connection and
-// removes it from the b.connections map.
+// ClearConnection removes the database connection from the b.connections
+// map and closes it. Closing drains the calls in flight on it after the
+// backend's lock is released, so that the requests for other connections
+// aren't held up while it waits for them.
 func (b *databaseBackend) ClearConnection(name string) error {
 	b.Lock()
-	defer b.Unlock()
-	return b.clearConnection(name)
-}
+	db := b.clearConnection(name)
+	b.Unlock()
 
-func (b *databaseBackend) clearConnection(name string) error {
-	db, ok := b.connections[name]
-	if ok {
+	if db != nil {
 		// Ignore error here since the database client is always killed
 		db.Close()
-		delete(b.connections, name)
 	}
 	return nil
 }
 
+// clearConnection removes the connection's plugin instance from the
+// b.connections map and returns it, if any, for the caller to close once it
+// has released the backend's lock. It is called holding the backend's lock.
+func (b *databaseBackend) clearConnection(name string) *dbPluginInstance {
+	if call, ok := b.connectionCalls[name]; ok {
+		call.cleared = true
+	}
+	db, ok := b.connections[name]
+	if !ok {
+		return nil
+	}
+	delete(b.connections, name)
+	return db
+}
+
 func (b *databaseBackend) CloseIfShutdown(db *dbPluginInstance, err error) {
-	// Plugin has shutdown, close it so next call can reconnect.
-	switch err {
-	case rpc.ErrShutdown, dbplugin.ErrPluginShutdown:
+	// Plugin has shutdown, close it so next call can reconnect. The errors
+	// may have been wrapped on their way back from the plugin.
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, dbplugin.ErrPluginShutdown) {
 		// Put this in a goroutine so that requests can run with the read or write lock
 		// and simply defer the unlock.  Since we are attaching the instance and matching
-		// the id in the connection map, we can safely do this.
+		// the id in the connection map, we can safely do this. As with
+		// ClearConnection, the instance is closed once the backend's lock
+		// is released, since closing drains the calls in flight on it.
 		go func() {
 			b.Lock()
-			defer b.Unlock()
-			db.Close()
-
 			// Ensure we are deleting the correct connection
 			mapDB, ok := b.connections[db.name]
 			if ok && db.id == mapDB.id {
-				delete(b.connections, db.name)
+				b.clearConnection(db.name)
 			}
+			b.Unlock()
+
+			db.Close()
 		}()
 	}
 }
 
-// clean closes all connections from all database types
-// and cancels any rotation queue loading operation.
+// clean closes all connections from all database types, along with their
+// tunnels and the files and TLS configurations written for them, and cancels
+// any rotation queue loading operation. It is called when the mount is
+// disabled or Vault is sealed.
 func (b *databaseBackend) clean(ctx context.Context) {
 	// invalidateQueue acquires it's own lock on the backend, removes queue, and
 	// terminates the background ticker
 	b.invalidateQueue()
 
+	// The connections are detached under the backend's lock and closed after
+	// it is released, since closing drains the calls in flight on them
 	b.Lock()
-	defer b.Unlock()
+	connections := b.connections
+	b.connections = make(map[string]*dbPluginInstance)
+	for _, call := range b.connectionCalls {
+		call.cleared = true
+	}
+	b.storageCache.purge()
+	b.statementTemplates.purge()
+	b.Unlock()
 
-	for _, db := range b.connections {
-		db.Close()
+	for name, db := range connections {
+		if err := db.Close(); err != nil {
+			b.Logger().Error("error closing the connection", "connection", name, "error", err)
+		}
+		if err := releaseConnectionDetails(b.connectionFiles, name); err != nil {
+			b.Logger().Error("error removing the files of the connection", "connection", name, "error", err)
+		}
+	}
+	if err := b.connectionFiles.removeAll(); err != nil {
+		b.Logger().Error("error removing the connection files", "error", err)
 	}
-	b.connections = make(map[string]*dbPluginInstance)
 
 	b.stopMtx.Lock()
 	defer b.stopMtx.Unlock()
 	if b.stopWatch != nil {
 		b.stopWatch()
+		b.stopWatch = nil
 	}
 }
 
 const backendHelp = `
 The database backend supports using many different databases
 as secret backends, including but not limited to:
-cassandra, mssql, mysql, postgres
+cassandra, mariadb, mssql, mysql, postgres, vitess
 
 After mounting this backend, configure it using the endpoints within
 the "database/config/" path.
+
+The backend's metrics are labelled with the "mount_uuid" of the mount and,
+when Vault passes it to the backend, the path of its "namespace", so that
+mounts in different namespaces can use the same connection and role names.
+
+To initialize connections when the mount is set up, such as after an
+unseal, rather than on their first request, list them in the mount's
+"prewarm_connections" option, separated by commas. They are initialized
+concurrently; "prewarm_timeout" (30s by default) bounds how long the backend
+waits for them before leaving the rest to finish in the background.
+
+Setting the mount's "deny_wildcard_allowed_roles" option to true requires
+every connection to list its roles in "allowed_roles": writing a connection
+with "*" fails, and connections already stored with it are logged when the
+mount is set up and allow no roles until they are rewritten. Globs such as
+"k8s_*" are still allowed.
+
+Connections can run external plugins with "plugin_command" only if the mount
+is given a "plugin_directory" option, the absolute path of the directory
+holding the plugin binaries. "plugin_command" names a binary in it.
 `
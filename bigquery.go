@@ -0,0 +1,406 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/mitchellh/mapstructure"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	iam "google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	bigQueryTypeName = "bigquery"
+
+	// bigQueryAccountPrefix starts the IDs of the service accounts created
+	// by the bigquery-database-plugin.
+	bigQueryAccountPrefix = "vault-"
+
+	// bigQueryDescriptionPrefix starts the description of the service
+	// accounts created by the bigquery-database-plugin, which is followed by
+	// the datasets they were granted access to, so that revocation can
+	// remove the access without the role.
+	bigQueryDescriptionPrefix = "Created by Vault with access to "
+
+	// maxServiceAccountDescription is the longest description of a service
+	// account that IAM accepts.
+	maxServiceAccountDescription = 256
+
+	// datasetUpdateAttempts bounds how often updating the access of a
+	// dataset is retried when it is changed concurrently.
+	datasetUpdateAttempts = 5
+)
+
+// bigQueryRoles are the dataset roles that the creation statements of a role
+// may grant, besides the predefined roles/bigquery.* IAM roles.
+var bigQueryRoles = []string{"READER", "WRITER", "OWNER"}
+
+// bigQueryStatement is the schema of the creation statement of a role using
+// the bigquery-database-plugin. Datasets without a project are in the
+// project of the connection.
+type bigQueryStatement struct {
+	Datasets []bigQueryDataset `json:"datasets"`
+}
+
+type bigQueryDataset struct {
+	Project string `json:"project"`
+	Dataset string `json:"dataset"`
+	Role    string `json:"role"`
+}
+
+func (d bigQueryDataset) String() string {
+	return d.Project + ":" + d.Dataset
+}
+
+// validateBigQueryStatement checks the creation statement of a role using
+// the bigquery-database-plugin.
+func validateBigQueryStatement(stmt string, requireRoles bool) error {
+	_, err := parseBigQueryStatement(stmt)
+	return err
+}
+
+func parseBigQueryStatement(stmt string) (*bigQueryStatement, error) {
+	dec := json.NewDecoder(strings.NewReader(stmt))
+	dec.DisallowUnknownFields()
+
+	var parsed bigQueryStatement
+	if err := dec.Decode(&parsed); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("statement looks like a query rather than JSON (%s)", err)
+		}
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+
+	if len(parsed.Datasets) == 0 {
+		return nil, errors.New(`"datasets" must contain at least one dataset`)
+	}
+	for i, dataset := range parsed.Datasets {
+		if dataset.Dataset == "" {
+			return nil, fmt.Errorf(`datasets[%d] is missing "dataset"`, i)
+		}
+		if !strings.HasPrefix(dataset.Role, "roles/bigquery.") && !strutil.StrListContains(bigQueryRoles, dataset.Role) {
+			return nil, fmt.Errorf(`datasets[%d] must have a "role" of %s or a roles/bigquery.* role`, i, strings.Join(bigQueryRoles, ", "))
+		}
+	}
+
+	return &parsed, nil
+}
+
+// bigQueryAPI is the part of the IAM and BigQuery APIs used by the
+// bigquery-database-plugin.
+type bigQueryAPI interface {
+	createServiceAccount(ctx context.Context, project, accountID string, account *iam.ServiceAccount) (*iam.ServiceAccount, error)
+	getServiceAccount(ctx context.Context, email string) (*iam.ServiceAccount, error)
+	deleteServiceAccount(ctx context.Context, email string) error
+	createServiceAccountKey(ctx context.Context, email string) (*iam.ServiceAccountKey, error)
+	getDataset(ctx context.Context, project, dataset string) (*bigquery.Dataset, error)
+	updateDatasetAccess(ctx context.Context, project, dataset, etag string, access []*bigquery.DatasetAccess) error
+}
+
+// googleBigQueryAPI calls the IAM and BigQuery APIs of Google Cloud.
+type googleBigQueryAPI struct {
+	iam      *iam.Service
+	bigquery *bigquery.Service
+}
+
+// newBigQueryAPI creates the API clients of a connection, authenticating
+// with its credentials, or otherwise the application default credentials of
+// the Vault server. Tests replace it with a fake.
+var newBigQueryAPI = func(ctx context.Context, config bigQueryConfig) (bigQueryAPI, error) {
+	opts := []option.ClientOption{option.WithScopes(iam.CloudPlatformScope)}
+	if config.Credentials != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(config.Credentials)))
+	}
+	iamService, err := iam.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	bigQueryService, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &googleBigQueryAPI{iam: iamService, bigquery: bigQueryService}, nil
+}
+
+func (g *googleBigQueryAPI) createServiceAccount(ctx context.Context, project, accountID string, account *iam.ServiceAccount) (*iam.ServiceAccount, error) {
+	return g.iam.Projects.ServiceAccounts.Create("projects/"+project, &iam.CreateServiceAccountRequest{
+		AccountId:      accountID,
+		ServiceAccount: account,
+	}).Context(ctx).Do()
+}
+
+func (g *googleBigQueryAPI) getServiceAccount(ctx context.Context, email string) (*iam.ServiceAccount, error) {
+	return g.iam.Projects.ServiceAccounts.Get("projects/-/serviceAccounts/" + email).Context(ctx).Do()
+}
+
+func (g *googleBigQueryAPI) deleteServiceAccount(ctx context.Context, email string) error {
+	_, err := g.iam.Projects.ServiceAccounts.Delete("projects/-/serviceAccounts/" + email).Context(ctx).Do()
+	return err
+}
+
+func (g *googleBigQueryAPI) createServiceAccountKey(ctx context.Context, email string) (*iam.ServiceAccountKey, error) {
+	return g.iam.Projects.ServiceAccounts.Keys.Create("projects/-/serviceAccounts/"+email, &iam.CreateServiceAccountKeyRequest{}).Context(ctx).Do()
+}
+
+func (g *googleBigQueryAPI) getDataset(ctx context.Context, project, dataset string) (*bigquery.Dataset, error) {
+	return g.bigquery.Datasets.Get(project, dataset).Context(ctx).Do()
+}
+
+func (g *googleBigQueryAPI) updateDatasetAccess(ctx context.Context, project, dataset, etag string, access []*bigquery.DatasetAccess) error {
+	call := g.bigquery.Datasets.Patch(project, dataset, &bigquery.Dataset{Access: access, ForceSendFields: []string{"Access"}})
+	call.Header().Set("If-Match", etag)
+	_, err := call.Context(ctx).Do()
+	return err
+}
+
+// bigQueryConfig is the connection configuration of the
+// bigquery-database-plugin.
+type bigQueryConfig struct {
+	Credentials string `mapstructure:"credentials"`
+	Project     string `mapstructure:"project"`
+}
+
+// bigQuery issues credentials for Google BigQuery. Each credential is the key
+// of a new service account in the connection's project, which is granted
+// access to the datasets listed by the creation statement of the role.
+// Revocation removes the access and deletes the service account, along with
+// its key.
+type bigQuery struct {
+	sync.RWMutex
+
+	config bigQueryConfig
+	api    bigQueryAPI
+}
+
+func newBigQuery() (interface{}, error) {
+	return &bigQuery{}, nil
+}
+
+func (b *bigQuery) Type() (string, error) {
+	return bigQueryTypeName, nil
+}
+
+func (b *bigQuery) Init(ctx context.Context, conf map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	var config bigQueryConfig
+	if err := mapstructure.WeakDecode(conf, &config); err != nil {
+		return nil, err
+	}
+	if config.Project == "" {
+		return nil, errors.New("project cannot be empty")
+	}
+	if config.Credentials != "" && !json.Valid([]byte(config.Credentials)) {
+		return nil, errors.New("credentials must be the JSON key of a service account")
+	}
+
+	api, err := newBigQueryAPI(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Lock()
+	b.config, b.api = config, api
+	b.Unlock()
+
+	return conf, nil
+}
+
+func (b *bigQuery) Initialize(ctx context.Context, conf map[string]interface{}, verifyConnection bool) error {
+	_, err := b.Init(ctx, conf, verifyConnection)
+	return err
+}
+
+func (b *bigQuery) client() (bigQueryConfig, bigQueryAPI) {
+	b.RLock()
+	defer b.RUnlock()
+	return b.config, b.api
+}
+
+// CreateUser returns the email of the new service account as the username,
+// and its JSON key as the password.
+func (b *bigQuery) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Creation) == 0 {
+		return "", "", dbutil.ErrEmptyCreationStatement
+	}
+	stmt, err := parseBigQueryStatement(statements.Creation[0])
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	config, api := b.client()
+	for i := range stmt.Datasets {
+		if stmt.Datasets[i].Project == "" {
+			stmt.Datasets[i].Project = config.Project
+		}
+	}
+
+	datasets := make([]string, 0, len(stmt.Datasets))
+	for _, dataset := range stmt.Datasets {
+		datasets = append(datasets, dataset.String())
+	}
+	description := bigQueryDescriptionPrefix + strings.Join(datasets, ",")
+	if len(description) > maxServiceAccountDescription {
+		return "", "", status.Error(codes.InvalidArgument, "the role grants access to too many datasets to be recorded on a service account")
+	}
+
+	suffix, err := credsutil.RandomAlphaNumeric(12, false)
+	if err != nil {
+		return "", "", err
+	}
+	displayName := fmt.Sprintf("vault %s %s", usernameConfig.DisplayName, usernameConfig.RoleName)
+	if len(displayName) > 100 {
+		displayName = displayName[:100]
+	}
+	account, err := api.createServiceAccount(ctx, config.Project, bigQueryAccountPrefix+strings.ToLower(suffix), &iam.ServiceAccount{
+		DisplayName: displayName,
+		Description: description,
+	})
+	if err != nil {
+		return "", "", googleStatusError(err)
+	}
+
+	key, err := b.grantAccess(ctx, api, account.Email, stmt.Datasets)
+	if err != nil {
+		// Don't leave a service account behind that no lease will revoke
+		if revokeErr := b.revoke(ctx, api, account.Email, stmt.Datasets); revokeErr != nil {
+			err = fmt.Errorf("%s; the service account %s could not be deleted: %s", err, account.Email, revokeErr)
+		}
+		return "", "", googleStatusError(err)
+	}
+	return account.Email, key, nil
+}
+
+// grantAccess grants the service account access to the datasets, and returns
+// its new JSON key.
+func (b *bigQuery) grantAccess(ctx context.Context, api bigQueryAPI, email string, datasets []bigQueryDataset) (string, error) {
+	for _, dataset := range datasets {
+		role := dataset.Role
+		err := updateDatasetAccess(ctx, api, dataset, func(access []*bigquery.DatasetAccess) []*bigquery.DatasetAccess {
+			return append(access, &bigquery.DatasetAccess{Role: role, UserByEmail: email})
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to grant access to %s: %s", dataset, err)
+		}
+	}
+
+	key, err := api.createServiceAccountKey(ctx, email)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(key.PrivateKeyData)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode the service account key: %s", err)
+	}
+	return string(data), nil
+}
+
+// updateDatasetAccess changes the access list of a dataset, retrying if it
+// is changed concurrently.
+func updateDatasetAccess(ctx context.Context, api bigQueryAPI, dataset bigQueryDataset, update func([]*bigquery.DatasetAccess) []*bigquery.DatasetAccess) error {
+	var err error
+	for attempt := 0; attempt < datasetUpdateAttempts; attempt++ {
+		var current *bigquery.Dataset
+		current, err = api.getDataset(ctx, dataset.Project, dataset.Dataset)
+		if err != nil {
+			return err
+		}
+		err = api.updateDatasetAccess(ctx, dataset.Project, dataset.Dataset, current.Etag, update(current.Access))
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusPreconditionFailed {
+			return err
+		}
+	}
+	return err
+}
+
+// RenewUser does nothing, as service account keys don't expire.
+func (b *bigQuery) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	return nil
+}
+
+// RevokeUser removes the access of the service account to the datasets
+// recorded in its description, and deletes it.
+func (b *bigQuery) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	_, api := b.client()
+
+	account, err := api.getServiceAccount(ctx, username)
+	if err != nil {
+		return googleStatusError(err)
+	}
+	if !strings.HasPrefix(account.Description, bigQueryDescriptionPrefix) {
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf("%s was not created by the bigquery-database-plugin", username))
+	}
+
+	var datasets []bigQueryDataset
+	for _, ref := range strings.Split(strings.TrimPrefix(account.Description, bigQueryDescriptionPrefix), ",") {
+		parts := strings.SplitN(ref, ":", 2)
+		if len(parts) == 2 {
+			datasets = append(datasets, bigQueryDataset{Project: parts[0], Dataset: parts[1]})
+		}
+	}
+	return googleStatusError(b.revoke(ctx, api, username, datasets))
+}
+
+func (b *bigQuery) revoke(ctx context.Context, api bigQueryAPI, email string, datasets []bigQueryDataset) error {
+	for _, dataset := range datasets {
+		err := updateDatasetAccess(ctx, api, dataset, func(access []*bigquery.DatasetAccess) []*bigquery.DatasetAccess {
+			kept := make([]*bigquery.DatasetAccess, 0, len(access))
+			for _, entry := range access {
+				if entry.UserByEmail != email && entry.IamMember != "serviceAccount:"+email {
+					kept = append(kept, entry)
+				}
+			}
+			return kept
+		})
+		// A dataset that was deleted has no access left to remove
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove access to %s: %s", dataset, err)
+		}
+	}
+	return api.deleteServiceAccount(ctx, email)
+}
+
+func (b *bigQuery) GenerateCredentials(ctx context.Context) (string, error) {
+	return "", errors.New("static roles are not supported by the bigquery-database-plugin")
+}
+
+func (b *bigQuery) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticUser dbplugin.StaticUserConfig) (string, string, error) {
+	return "", "", errors.New("static roles are not supported by the bigquery-database-plugin")
+}
+
+func (b *bigQuery) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	return nil, errors.New("root credential rotation is not supported by the bigquery-database-plugin")
+}
+
+func (b *bigQuery) Close() error {
+	return nil
+}
+
+// googleStatusError returns an error of the Google Cloud APIs with the gRPC
+// code of its HTTP status.
+func googleStatusError(err error) error {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return httpStatusError(apiErr.Code, apiErr.Error())
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	iam "google.golang.org/api/iam/v1"
+)
+
+type fakeDataset struct {
+	etag   int
+	access []*bigquery.DatasetAccess
+}
+
+// fakeBigQueryAPI implements the IAM and BigQuery calls made by the
+// bigquery-database-plugin. The first update of each dataset fails as if it
+// had been changed concurrently.
+type fakeBigQueryAPI struct {
+	l        sync.Mutex
+	accounts map[string]*iam.ServiceAccount
+	keys     map[string]int
+	datasets map[string]*fakeDataset
+	conflict map[string]bool
+}
+
+func newFakeBigQueryAPI(datasets ...string) *fakeBigQueryAPI {
+	f := &fakeBigQueryAPI{
+		accounts: map[string]*iam.ServiceAccount{},
+		keys:     map[string]int{},
+		datasets: map[string]*fakeDataset{},
+		conflict: map[string]bool{},
+	}
+	for _, dataset := range datasets {
+		f.datasets[dataset] = &fakeDataset{}
+	}
+	return f
+}
+
+func (f *fakeBigQueryAPI) createServiceAccount(ctx context.Context, project, accountID string, account *iam.ServiceAccount) (*iam.ServiceAccount, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	account.Email = fmt.Sprintf("%s@%s.iam.gserviceaccount.com", accountID, project)
+	f.accounts[account.Email] = account
+	return account, nil
+}
+
+func (f *fakeBigQueryAPI) getServiceAccount(ctx context.Context, email string) (*iam.ServiceAccount, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	account, ok := f.accounts[email]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "Unknown service account"}
+	}
+	return account, nil
+}
+
+func (f *fakeBigQueryAPI) deleteServiceAccount(ctx context.Context, email string) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	delete(f.accounts, email)
+	delete(f.keys, email)
+	return nil
+}
+
+func (f *fakeBigQueryAPI) createServiceAccountKey(ctx context.Context, email string) (*iam.ServiceAccountKey, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.keys[email]++
+	data := fmt.Sprintf(`{"type": "service_account", "client_email": %q}`, email)
+	return &iam.ServiceAccountKey{PrivateKeyData: base64.StdEncoding.EncodeToString([]byte(data))}, nil
+}
+
+func (f *fakeBigQueryAPI) getDataset(ctx context.Context, project, dataset string) (*bigquery.Dataset, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	d, ok := f.datasets[project+":"+dataset]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "Not found: Dataset " + project + ":" + dataset}
+	}
+	return &bigquery.Dataset{Etag: fmt.Sprint(d.etag), Access: d.access}, nil
+}
+
+func (f *fakeBigQueryAPI) updateDatasetAccess(ctx context.Context, project, dataset, etag string, access []*bigquery.DatasetAccess) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	d := f.datasets[project+":"+dataset]
+	if !f.conflict[project+":"+dataset] {
+		f.conflict[project+":"+dataset] = true
+		d.etag++
+	}
+	if etag != fmt.Sprint(d.etag) {
+		return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "Precondition check failed."}
+	}
+	d.etag++
+	d.access = access
+	return nil
+}
+
+func (f *fakeBigQueryAPI) access(dataset string) []*bigquery.DatasetAccess {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.datasets[dataset].access
+}
+
+func TestBackend_bigQuery(t *testing.T) {
+	api := newFakeBigQueryAPI("vault-project:analytics", "shared:events")
+	defer func(previous func(context.Context, bigQueryConfig) (bigQueryAPI, error)) {
+		newBigQueryAPI = previous
+	}(newBigQueryAPI)
+	newBigQueryAPI = func(ctx context.Context, config bigQueryConfig) (bigQueryAPI, error) {
+		return api, nil
+	}
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "config/bigquery", Data: map[string]interface{}{
+		"plugin_name":   "bigquery-database-plugin",
+		"credentials":   `{"type": "service_account"}`,
+		"project":       "vault-project",
+		"allowed_roles": []string{"*"},
+	}})
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/bigquery"})
+	if _, ok := resp.Data["connection_details"].(map[string]interface{})["credentials"]; ok {
+		t.Fatal("expected the credentials not to be returned")
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "bigquery",
+		"creation_statements": `{"datasets": [{"dataset": "analytics", "role": "ADMIN"}]}`,
+	}
+	resp, err := request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/analyst", Data: role})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an invalid dataset role, got err:%s resp:%#v", err, resp)
+	}
+	role["creation_statements"] = `{"datasets": [{"dataset": "analytics", "role": "READER"}, {"project": "shared", "dataset": "events", "role": "roles/bigquery.dataViewer"}]}`
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/analyst", Data: role})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/analyst"})
+	email := resp.Data["username"].(string)
+	if !strings.HasPrefix(email, "vault-") || !strings.HasSuffix(email, "@vault-project.iam.gserviceaccount.com") {
+		t.Fatalf("unexpected service account %q", email)
+	}
+	if !strings.Contains(resp.Data["password"].(string), `"client_email": "`+email+`"`) {
+		t.Fatalf("expected the key of %q, got %q", email, resp.Data["password"])
+	}
+	for dataset, role := range map[string]string{"vault-project:analytics": "READER", "shared:events": "roles/bigquery.dataViewer"} {
+		access := api.access(dataset)
+		if len(access) != 1 || access[0].UserByEmail != email || access[0].Role != role {
+			t.Fatalf("expected %s to grant %s to %s, got %#v", dataset, role, email, access)
+		}
+	}
+
+	secret := resp.Secret
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	if _, err := api.getServiceAccount(context.Background(), email); err == nil {
+		t.Fatalf("expected %q to be deleted", email)
+	}
+	for _, dataset := range []string{"vault-project:analytics", "shared:events"} {
+		if access := api.access(dataset); len(access) != 0 {
+			t.Fatalf("expected the access to %s to be removed, got %#v", dataset, access)
+		}
+	}
+
+	// A service account that was already deleted is revoked
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+}
@@ -0,0 +1,516 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	breakGlassPath = "break-glass/"
+
+	// defaultBreakGlassRotateAfter is how long an escrowed credential may be
+	// used after it was released, if its escrow doesn't set rotate_after.
+	defaultBreakGlassRotateAfter = time.Hour
+)
+
+// breakGlassEscrow holds an emergency admin credential of a connection. Its
+// password is generated and set by Vault, so that it is only known once it is
+// released at break-glass/<name>, and rotated again after its use.
+type breakGlassEscrow struct {
+	Username           string        `json:"username"`
+	Password           string        `json:"password"`
+	RotationStatements []string      `json:"rotation_statements,omitempty"`
+	RotateAfter        time.Duration `json:"rotate_after"`
+
+	// AlertURL is posted a JSON alert, without the credential, each time
+	// the credential is released.
+	AlertURL string `json:"alert_url,omitempty"`
+
+	// PendingPassword is the password being set in the database. It is
+	// stored before the database is changed, so that a rotation that fails
+	// midway is retried with the same password rather than lost.
+	PendingPassword string    `json:"pending_password,omitempty"`
+	LastRotation    time.Time `json:"last_rotation"`
+
+	// Release is the last release of the credential since it was rotated.
+	Release *breakGlassRelease `json:"release,omitempty"`
+}
+
+type breakGlassRelease struct {
+	Time        time.Time `json:"time"`
+	EntityID    string    `json:"entity_id,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Reason      string    `json:"reason"`
+	RotateAt    time.Time `json:"rotate_at"`
+}
+
+// breakGlassAlert is posted to the alert_url of an escrow.
+type breakGlassAlert struct {
+	Connection  string    `json:"connection"`
+	Username    string    `json:"username"`
+	Time        time.Time `json:"time"`
+	EntityID    string    `json:"entity_id,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Reason      string    `json:"reason"`
+	RotateAt    time.Time `json:"rotate_at"`
+}
+
+func pathBreakGlass(b *databaseBackend) []*framework.Path {
+	nameField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Name of the database connection.",
+	}
+
+	return []*framework.Path{
+		{
+			Pattern: breakGlassPath + framework.GenericNameRegex("name") + "/config$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": nameField,
+				"username": {
+					Type:        framework.TypeString,
+					Description: "Name of the emergency admin user in the database. The user must exist.",
+				},
+				"rotation_statements": {
+					Type: framework.TypeStringSlice,
+					Description: `Statements that set the password of the user,
+	as for static roles. If unset, the plugin's default is used.`,
+				},
+				"rotate_after": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the credential may be used after it was released, before it is rotated. Defaults to 1 hour.",
+				},
+				"alert_url": {
+					Type:        framework.TypeString,
+					Description: "HTTP or HTTPS URL the alert of each release of the credential is posted to.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBreakGlassConfigWrite,
+					Summary:  "Escrow the emergency admin credential of a connection, rotating its password.",
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathBreakGlassConfigRead,
+					Summary:  "Read the escrow of a connection, without its credential.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathBreakGlassConfigDelete,
+					Summary:  "Delete the escrow of a connection. The user is left in the database.",
+				},
+			},
+
+			HelpSynopsis:    pathBreakGlassHelpSyn,
+			HelpDescription: pathBreakGlassHelpDesc,
+		},
+		{
+			Pattern: breakGlassPath + framework.GenericNameRegex("name") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": nameField,
+				"reason": {
+					Type:        framework.TypeString,
+					Description: "Why the credential is needed, recorded and included in the alert.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBreakGlassRelease,
+					Summary:  "Release the emergency admin credential of a connection.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"username":  "emergency-admin",
+									"password":  "A1a-1uDoi6PSBfu1QuDt",
+									"rotate_at": "2020-01-01T13:00:00Z",
+								},
+							},
+						}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathBreakGlassHelpSyn,
+			HelpDescription: pathBreakGlassHelpDesc,
+		},
+		{
+			Pattern: breakGlassPath + framework.GenericNameRegex("name") + "/rotate$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": nameField,
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBreakGlassRotate,
+					Summary:  "Rotate the emergency admin credential of a connection now.",
+				},
+			},
+
+			HelpSynopsis:    pathBreakGlassHelpSyn,
+			HelpDescription: pathBreakGlassHelpDesc,
+		},
+	}
+}
+
+func (b *databaseBackend) breakGlassEscrow(ctx context.Context, s logical.Storage, name string) (*breakGlassEscrow, error) {
+	entry, err := s.Get(ctx, breakGlassPath+name)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read break-glass escrow: {{err}}", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var escrow breakGlassEscrow
+	if err := entry.DecodeJSON(&escrow); err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+func putBreakGlassEscrow(ctx context.Context, s logical.Storage, name string, escrow *breakGlassEscrow) error {
+	entry, err := logical.StorageEntryJSON(breakGlassPath+name, escrow)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func (b *databaseBackend) pathBreakGlassConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	lock := locksutil.LockForKey(b.connectionLocks, breakGlassPath+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := b.DatabaseConfig(ctx, req.Storage, name); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	escrow, err := b.breakGlassEscrow(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	rotate := false
+	if escrow == nil {
+		escrow = &breakGlassEscrow{RotateAfter: defaultBreakGlassRotateAfter}
+		rotate = true
+	}
+
+	if usernameRaw, ok := data.GetOk("username"); ok && usernameRaw.(string) != escrow.Username {
+		escrow.Username = usernameRaw.(string)
+		escrow.Password = ""
+		escrow.PendingPassword = ""
+		escrow.Release = nil
+		rotate = true
+	}
+	if escrow.Username == "" {
+		return logical.ErrorResponse("username is required"), nil
+	}
+	if statementsRaw, ok := data.GetOk("rotation_statements"); ok {
+		escrow.RotationStatements = statementsRaw.([]string)
+	}
+	if rotateAfterRaw, ok := data.GetOk("rotate_after"); ok {
+		escrow.RotateAfter = time.Duration(rotateAfterRaw.(int)) * time.Second
+		if escrow.RotateAfter <= 0 {
+			return logical.ErrorResponse("rotate_after must be positive"), nil
+		}
+	}
+	if alertURLRaw, ok := data.GetOk("alert_url"); ok {
+		escrow.AlertURL = alertURLRaw.(string)
+		if escrow.AlertURL != "" {
+			u, err := url.Parse(escrow.AlertURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return logical.ErrorResponse("alert_url must be an http or https URL"), nil
+			}
+		}
+	}
+
+	if !rotate {
+		return nil, putBreakGlassEscrow(ctx, req.Storage, name, escrow)
+	}
+	// The password of a newly escrowed user is rotated right away, so that
+	// only Vault knows it.
+	if err := b.rotateBreakGlass(ctx, req.Storage, name, escrow); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *databaseBackend) pathBreakGlassConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	escrow, err := b.breakGlassEscrow(ctx, req.Storage, data.Get("name").(string))
+	if err != nil || escrow == nil {
+		return nil, err
+	}
+
+	respData := map[string]interface{}{
+		"username":            escrow.Username,
+		"rotation_statements": escrow.RotationStatements,
+		"rotate_after":        escrow.RotateAfter.Seconds(),
+		"last_rotation":       escrow.LastRotation.Format(time.RFC3339),
+	}
+	if len(escrow.RotationStatements) == 0 {
+		respData["rotation_statements"] = []string{}
+	}
+	if escrow.AlertURL != "" {
+		respData["alert_url"] = escrow.AlertURL
+	}
+	if escrow.PendingPassword != "" {
+		respData["rotation_pending"] = true
+	}
+	if r := escrow.Release; r != nil {
+		respData["release"] = map[string]interface{}{
+			"time":         r.Time.Format(time.RFC3339),
+			"entity_id":    r.EntityID,
+			"display_name": r.DisplayName,
+			"reason":       r.Reason,
+			"rotate_at":    r.RotateAt.Format(time.RFC3339),
+		}
+	}
+	return &logical.Response{Data: respData}, nil
+}
+
+func (b *databaseBackend) pathBreakGlassConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	lock := locksutil.LockForKey(b.connectionLocks, breakGlassPath+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return nil, req.Storage.Delete(ctx, breakGlassPath+name)
+}
+
+func (b *databaseBackend) pathBreakGlassRelease(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	reason := strings.TrimSpace(data.Get("reason").(string))
+	if reason == "" {
+		return logical.ErrorResponse("reason is required"), nil
+	}
+
+	lock := locksutil.LockForKey(b.connectionLocks, breakGlassPath+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	escrow, err := b.breakGlassEscrow(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if escrow == nil {
+		return logical.ErrorResponse(fmt.Sprintf("connection %q has no break-glass credential", name)), nil
+	}
+	if escrow.Password == "" {
+		return logical.ErrorResponse(fmt.Sprintf("the break-glass credential of connection %q has not been rotated yet", name)), nil
+	}
+
+	// Each release pushes the rotation back, so that a credential released
+	// again during an incident isn't rotated under its latest user.
+	now := b.clock.Now()
+	escrow.Release = &breakGlassRelease{
+		Time:        now,
+		EntityID:    req.EntityID,
+		DisplayName: req.DisplayName,
+		Reason:      reason,
+		RotateAt:    now.Add(escrow.RotateAfter),
+	}
+	if err := putBreakGlassEscrow(ctx, req.Storage, name, escrow); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"username":  escrow.Username,
+			"password":  escrow.Password,
+			"rotate_at": escrow.Release.RotateAt.Format(time.RFC3339),
+		},
+	}
+	// The credential is released even if the alert can't be sent, as it is
+	// needed in an emergency, but the failure is logged and returned.
+	if err := b.alertBreakGlass(ctx, name, escrow); err != nil {
+		resp.AddWarning(fmt.Sprintf("The break-glass alert could not be sent: %s", err))
+	}
+	return resp, nil
+}
+
+func (b *databaseBackend) pathBreakGlassRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	lock := locksutil.LockForKey(b.connectionLocks, breakGlassPath+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	escrow, err := b.breakGlassEscrow(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if escrow == nil {
+		return logical.ErrorResponse(fmt.Sprintf("connection %q has no break-glass credential", name)), nil
+	}
+	return nil, b.rotateBreakGlass(ctx, req.Storage, name, escrow)
+}
+
+// alertBreakGlass reports the release of an escrowed credential in the logs and
+// metrics, and to the escrow's alert_url.
+func (b *databaseBackend) alertBreakGlass(ctx context.Context, name string, escrow *breakGlassEscrow) error {
+	r := escrow.Release
+	b.Logger().Warn("break-glass credential released", "connection", name, "username", escrow.Username,
+		"entity_id", r.EntityID, "display_name", r.DisplayName, "reason", r.Reason, "rotate_at", r.RotateAt.Format(time.RFC3339))
+	metrics.IncrCounterWithLabels([]string{"database", "break_glass", "release"}, 1, withMountLabels([]metrics.Label{
+		{Name: "db_name", Value: name},
+	}, b.mountLabels))
+	if escrow.AlertURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(breakGlassAlert{
+		Connection:  name,
+		Username:    escrow.Username,
+		Time:        r.Time,
+		EntityID:    r.EntityID,
+		DisplayName: r.DisplayName,
+		Reason:      r.Reason,
+		RotateAt:    r.RotateAt,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rotationHookTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, escrow.AlertURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := doSinkRequest(http.DefaultClient, req.WithContext(ctx), nil); err != nil {
+		b.Logger().Error("failed to send break-glass alert", "connection", name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// rotateBreakGlass sets a new password for the escrowed user. The caller must
+// hold the escrow's lock.
+func (b *databaseBackend) rotateBreakGlass(ctx context.Context, s logical.Storage, name string, escrow *breakGlassEscrow) error {
+	db, err := b.GetConnection(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	if escrow.PendingPassword == "" {
+		password, err := db.GenerateCredentials(ctx)
+		if err != nil {
+			return err
+		}
+		escrow.PendingPassword = password
+		if err := putBreakGlassEscrow(ctx, s, name, escrow); err != nil {
+			return err
+		}
+	}
+
+	db.RLock()
+	_, _, err = db.SetCredentials(ctx, dbplugin.Statements{Rotation: escrow.RotationStatements}, dbplugin.StaticUserConfig{
+		Username: escrow.Username,
+		Password: escrow.PendingPassword,
+	})
+	db.RUnlock()
+	if err != nil {
+		b.CloseIfShutdown(db, err)
+		return errwrap.Wrapf("failed to rotate the break-glass credential: {{err}}", err)
+	}
+
+	escrow.Password = escrow.PendingPassword
+	escrow.PendingPassword = ""
+	escrow.LastRotation = b.clock.Now()
+	escrow.Release = nil
+	return putBreakGlassEscrow(ctx, s, name, escrow)
+}
+
+// rotateReleasedBreakGlassCredentials rotates the escrowed credentials whose
+// use has expired, and retries rotations that failed.
+func (b *databaseBackend) rotateReleasedBreakGlassCredentials(ctx context.Context, req *logical.Request) error {
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	names, err := req.Storage.List(ctx, breakGlassPath)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	now := b.clock.Now()
+	for _, name := range names {
+		if err := b.rotateReleasedBreakGlass(ctx, req.Storage, name, now); err != nil {
+			b.Logger().Error("failed to rotate break-glass credential", "connection", name, "error", err)
+			result = multierror.Append(result, fmt.Errorf("connection %q: %s", name, err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (b *databaseBackend) rotateReleasedBreakGlass(ctx context.Context, s logical.Storage, name string, now time.Time) error {
+	lock := locksutil.LockForKey(b.connectionLocks, breakGlassPath+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	escrow, err := b.breakGlassEscrow(ctx, s, name)
+	if err != nil || escrow == nil {
+		return err
+	}
+	due := escrow.Release != nil && !now.Before(escrow.Release.RotateAt)
+	if !due && escrow.PendingPassword == "" {
+		return nil
+	}
+	if err := b.rotateBreakGlass(ctx, s, name, escrow); err != nil {
+		return err
+	}
+	if due {
+		b.Logger().Info("rotated released break-glass credential", "connection", name, "username", escrow.Username)
+	}
+	return nil
+}
+
+const pathBreakGlassHelpSyn = `
+Escrow an emergency admin credential of a connection.
+`
+
+const pathBreakGlassHelpDesc = `
+Writing "break-glass/<name>/config" escrows the credential of an emergency
+admin user of the connection called name. The user must already exist; its
+password is rotated right away, so that only Vault knows it, using
+"rotation_statements" as for static roles.
+
+Writing a "reason" to "break-glass/<name>" releases the credential. Every
+release is logged at the warning level, counted in the
+"database.break_glass.release" metric and, if "alert_url" is set, posted to it
+as JSON with the requester and the reason, but never the credential. The
+credential is released even if the alert can't be sent, with a warning. The
+credential is rotated "rotate_after" after its last release, which defaults to
+1 hour, or when "break-glass/<name>/rotate" is written.
+
+All paths under "break-glass/" require the sudo capability, so that a policy
+granting broad access to the mount does not grant the emergency credentials
+without also granting, for example:
+
+	path "database/break-glass/my-database" {
+	  capabilities = ["update", "sudo"]
+	}
+`
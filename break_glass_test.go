@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_breakGlass(t *testing.T) {
+	var alerts []map[string]interface{}
+	alertStatus := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts = append(alerts, alert)
+		w.WriteHeader(alertStatus)
+	}))
+	defer srv.Close()
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	if !strutil.StrListContains(b.SpecialPaths().Root, "break-glass/*") {
+		t.Fatal("expected break-glass paths to require sudo")
+	}
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation:   op,
+			Path:        path,
+			Storage:     s,
+			Data:        data,
+			EntityID:    "entity-oncall",
+			DisplayName: "oidc-oncall",
+		})
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	config := map[string]interface{}{
+		"username":     "emergency-admin",
+		"rotate_after": "30m",
+		"alert_url":    srv.URL,
+	}
+	if resp, _ := request(logical.UpdateOperation, "break-glass/orders/config", config); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an escrow for an unknown connection to be rejected, got %#v", resp)
+	}
+
+	mustRequest(logical.CreateOperation, "config/orders", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+	})
+	fake := &fakeStaticDatabase{}
+	b.connections["orders"] = &dbPluginInstance{
+		Database: fake,
+		name:     "orders",
+		id:       "fake",
+	}
+
+	// Escrowing the user rotates its password
+	mustRequest(logical.UpdateOperation, "break-glass/orders/config", config)
+	if len(fake.set) != 1 || fake.set[0].Username != "emergency-admin" {
+		t.Fatalf("expected the escrowed password to be rotated, got %#v", fake.set)
+	}
+	resp := mustRequest(logical.ReadOperation, "break-glass/orders/config", nil)
+	if resp.Data["password"] != nil || resp.Data["username"] != "emergency-admin" || resp.Data["rotate_after"] != float64(1800) {
+		t.Fatalf("unexpected escrow %#v", resp.Data)
+	}
+
+	if resp, _ := request(logical.UpdateOperation, "break-glass/orders", nil); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a release without a reason to be rejected, got %#v", resp)
+	}
+	resp = mustRequest(logical.UpdateOperation, "break-glass/orders", map[string]interface{}{"reason": "INC-1234 primary is down"})
+	if resp.Data["username"] != "emergency-admin" || resp.Data["password"] != fake.set[0].Password || resp.Data["rotate_at"] != "2020-01-01T12:30:00Z" {
+		t.Fatalf("unexpected credential %#v", resp.Data)
+	}
+	if len(alerts) != 1 || alerts[0]["reason"] != "INC-1234 primary is down" || alerts[0]["entity_id"] != "entity-oncall" || alerts[0]["password"] != nil {
+		t.Fatalf("unexpected alerts %#v", alerts)
+	}
+
+	// The credential is rotated once its use has expired
+	ctx := namespace.RootContext(nil)
+	clock.advance(29 * time.Minute)
+	if err := b.rotateReleasedBreakGlassCredentials(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.set) != 1 {
+		t.Fatalf("expected no rotation before rotate_at, got %#v", fake.set)
+	}
+	clock.advance(time.Minute)
+	if err := b.rotateReleasedBreakGlassCredentials(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.set) != 2 {
+		t.Fatalf("expected the released credential to be rotated, got %#v", fake.set)
+	}
+	if resp := mustRequest(logical.ReadOperation, "break-glass/orders/config", nil); resp.Data["release"] != nil {
+		t.Fatalf("expected the release to be cleared by the rotation, got %#v", resp.Data)
+	}
+
+	// Credentials are released even if the alert fails
+	alertStatus = http.StatusInternalServerError
+	resp = mustRequest(logical.UpdateOperation, "break-glass/orders", map[string]interface{}{"reason": "INC-1235"})
+	if resp.Data["password"] == nil || len(resp.Warnings) != 1 {
+		t.Fatalf("expected the credential with a warning, got %#v", resp)
+	}
+	mustRequest(logical.UpdateOperation, "break-glass/orders/rotate", nil)
+	if len(fake.set) != 3 {
+		t.Fatalf("expected the credential to be rotated on demand, got %#v", fake.set)
+	}
+}
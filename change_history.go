@@ -0,0 +1,342 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	connectionHistoryPath = "history/db/"
+	roleHistoryPath       = "history/role/"
+
+	// changeHistoryLimit is the number of changes kept in the history of
+	// each connection and role, after which the oldest are dropped.
+	changeHistoryLimit = 100
+)
+
+// change records a write or deletion of a connection or role.
+type change struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+
+	// DisplayName and EntityID identify the token the change was made with,
+	// and RemoteAddr the client that sent it.
+	DisplayName string `json:"display_name,omitempty"`
+	EntityID    string `json:"entity_id,omitempty"`
+	RemoteAddr  string `json:"remote_address,omitempty"`
+
+	// Version is the version of the entry after the change.
+	Version int `json:"version,omitempty"`
+
+	// Changes holds the parameters whose values changed, as read from the
+	// entry before and after the change, so secrets are left out. Written
+	// lists the names of the parameters the request set, including secrets.
+	Changes map[string]parameterChange `json:"changes,omitempty"`
+	Written []string                   `json:"written,omitempty"`
+}
+
+type parameterChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// changeHistory records the changes made through a path's handlers in the
+// history of the entry they change.
+type changeHistory struct {
+	// prefix is the storage prefix of the entries, and historyPrefix of
+	// their histories.
+	prefix        string
+	historyPrefix string
+
+	// parameters returns the parameters of an entry, with its secrets
+	// redacted.
+	parameters func(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, error)
+}
+
+func (b *databaseBackend) connectionHistory() *changeHistory {
+	return &changeHistory{
+		prefix:        "config/",
+		historyPrefix: connectionHistoryPath,
+		parameters: func(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, error) {
+			params, _, err := b.exportConnection(ctx, s, name)
+			if err != nil || params == nil {
+				return nil, err
+			}
+			config, err := b.DatabaseConfig(ctx, s, name)
+			if err != nil {
+				return nil, err
+			}
+			params["version"] = entryVersion(config.Version)
+			return params, nil
+		},
+	}
+}
+
+func (b *databaseBackend) roleHistory(rolePath string) *changeHistory {
+	readPath, fields := "roles/", fieldsForType(databaseRolePath)
+	if rolePath == databaseStaticRolePath {
+		readPath, fields = "static-roles/", fieldsForType(databaseStaticRolePath)
+	}
+	return &changeHistory{
+		prefix:        rolePath,
+		historyPrefix: roleHistoryPath,
+		parameters: func(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, error) {
+			data, err := b.readParameters(ctx, s, readPath+name)
+			if err != nil || data == nil {
+				return nil, err
+			}
+			params := writableParameters(data, fields)
+			params["version"] = data["version"]
+			return params, nil
+		},
+	}
+}
+
+// read returns the parameters of the stored entry, or nil if it doesn't exist.
+// Entries that aren't stored, such as roles resolved from Kubernetes, have no
+// history.
+func (h *changeHistory) read(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, error) {
+	entry, err := s.Get(ctx, h.prefix+name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return h.parameters(ctx, s, name)
+}
+
+// recordChanges wraps the handler of a write or delete operation to record
+// the changes it makes in the entry's history. A change that can't be recorded
+// is returned as a warning, as the handler has already made it.
+func (b *databaseBackend) recordChanges(h *changeHistory, op framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		name := data.Get("name").(string)
+		before, err := h.read(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := op(ctx, req, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			return resp, err
+		}
+
+		return b.recordChange(ctx, req, h, name, before, resp), nil
+	}
+}
+
+// recordChange records the change a request made to the named entry, given
+// its parameters before the change, for handlers that change entries other
+// than the one named by the request, such as copying or renaming a role. A
+// change that can't be recorded is added to resp as a warning.
+func (b *databaseBackend) recordChange(ctx context.Context, req *logical.Request, h *changeHistory, name string, before map[string]interface{}, resp *logical.Response) *logical.Response {
+	after, err := h.read(ctx, req.Storage, name)
+	if err == nil {
+		err = b.appendChange(ctx, req, h.historyPrefix+name, before, after)
+	}
+	if err != nil {
+		b.Logger().Error("failed to record change", "path", req.Path, "error", err)
+		if resp == nil {
+			resp = &logical.Response{}
+		}
+		resp.AddWarning(fmt.Sprintf("The change was applied but could not be recorded in its history: %s", err))
+	}
+	return resp
+}
+
+func (b *databaseBackend) appendChange(ctx context.Context, req *logical.Request, key string, before, after map[string]interface{}) error {
+	c := change{
+		Time:        b.clock.Now().UTC(),
+		DisplayName: req.DisplayName,
+		EntityID:    req.EntityID,
+	}
+	if req.Connection != nil {
+		c.RemoteAddr = req.Connection.RemoteAddr
+	}
+	switch {
+	case before == nil && after == nil:
+		// Deleting an entry that doesn't exist changes nothing
+		return nil
+	case before == nil:
+		c.Operation = "create"
+	case after == nil:
+		c.Operation = "delete"
+	default:
+		c.Operation = "update"
+	}
+	if version, ok := after["version"].(int); ok {
+		c.Version = version
+	}
+	delete(before, "version")
+	delete(after, "version")
+	c.Changes = diffParameters(before, after)
+
+	for k := range req.Data {
+		if k != "name" {
+			c.Written = append(c.Written, k)
+		}
+	}
+	sort.Strings(c.Written)
+
+	b.historyLock.Lock()
+	defer b.historyLock.Unlock()
+
+	history, err := readChangeHistory(ctx, req.Storage, key)
+	if err != nil {
+		return err
+	}
+	history = append(history, c)
+	if len(history) > changeHistoryLimit {
+		history = history[len(history)-changeHistoryLimit:]
+	}
+	entry, err := logical.StorageEntryJSON(key, history)
+	if err != nil {
+		return err
+	}
+	return req.Storage.Put(ctx, entry)
+}
+
+func readChangeHistory(ctx context.Context, s logical.Storage, key string) ([]change, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read change history: {{err}}", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var history []change
+	if err := entry.DecodeJSON(&history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// diffParameters returns the parameters that were added, removed or changed.
+func diffParameters(before, after map[string]interface{}) map[string]parameterChange {
+	changes := make(map[string]parameterChange)
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes[k] = parameterChange{Old: old, New: v}
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes[k] = parameterChange{Old: v}
+		}
+	}
+	return changes
+}
+
+func pathChangeHistory(b *databaseBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "history/dbs/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the connection.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathChangeHistoryRead(connectionHistoryPath),
+					Summary:  "Read the changes made to a connection.",
+				},
+			},
+
+			HelpSynopsis:    pathChangeHistoryHelpSyn,
+			HelpDescription: pathChangeHistoryHelpDesc,
+		},
+		{
+			Pattern: "history/roles/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the role or static role.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathChangeHistoryRead(roleHistoryPath),
+					Summary:  "Read the changes made to a role or static role.",
+				},
+			},
+
+			HelpSynopsis:    pathChangeHistoryHelpSyn,
+			HelpDescription: pathChangeHistoryHelpDesc,
+		},
+	}
+}
+
+func (b *databaseBackend) pathChangeHistoryRead(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		history, err := readChangeHistory(ctx, req.Storage, prefix+data.Get("name").(string))
+		if err != nil {
+			return nil, err
+		}
+		if history == nil {
+			return nil, nil
+		}
+
+		changes := make([]map[string]interface{}, 0, len(history))
+		for _, c := range history {
+			record := map[string]interface{}{
+				"time":      c.Time.Format(time.RFC3339),
+				"operation": c.Operation,
+			}
+			if c.DisplayName != "" {
+				record["display_name"] = c.DisplayName
+			}
+			if c.EntityID != "" {
+				record["entity_id"] = c.EntityID
+			}
+			if c.RemoteAddr != "" {
+				record["remote_address"] = c.RemoteAddr
+			}
+			if c.Version != 0 {
+				record["version"] = c.Version
+			}
+			diff := make(map[string]interface{}, len(c.Changes))
+			for k, v := range c.Changes {
+				diff[k] = map[string]interface{}{"old": v.Old, "new": v.New}
+			}
+			record["changes"] = diff
+			if len(c.Written) > 0 {
+				record["written"] = c.Written
+			}
+			changes = append(changes, record)
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"changes": changes,
+			},
+		}, nil
+	}
+}
+
+const pathChangeHistoryHelpSyn = `
+Read the changes made to a connection or role.
+`
+
+const pathChangeHistoryHelpDesc = `
+Each write and deletion of a connection at "config/<name>", or of a role or
+static role, is recorded in its history, which is read at "history/dbs/<name>"
+or "history/roles/<name>". The changes are returned oldest first, with the time
+of the change, the display name and entity ID of the token that made it, the
+client's address, the version of the entry after the change, the parameters
+whose values changed and the names of the parameters the request set.
+
+Secrets are redacted as when reading the connection or role: the value of a
+secret such as the root password is never recorded, only that it was written.
+The last 100 changes are kept, and the history of an entry is kept after the
+entry is deleted. Copying a role is recorded as the creation of the copy, and
+renaming one as the creation of the role under its new name and the deletion
+of the old name. Changes made through other paths, such as rotating
+credentials or putting a connection in maintenance, are not recorded.
+`
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// casField is the check-and-set field of connection and role writes.
+var casField = &framework.FieldSchema{
+	Type: framework.TypeInt,
+	Description: `If set, the write is only applied if the entry is at this
+	version, as returned in "version" by reads, or doesn't exist yet if 0.`,
+	DisplayAttrs: &framework.DisplayAttributes{
+		Name: "Check-and-Set Version",
+	},
+}
+
+// entryVersion is the version of a stored connection or role. Entries written
+// before versions were tracked are version 1.
+func entryVersion(version int) int {
+	if version == 0 {
+		return 1
+	}
+	return version
+}
+
+// checkAndSet returns the version a write stores the entry at, or an error
+// response if the write sets "cas" and the entry isn't at that version. The
+// current version of an entry that doesn't exist is 0.
+func checkAndSet(data *framework.FieldData, current int) (int, *logical.Response) {
+	if casRaw, ok := data.GetOk("cas"); ok {
+		if cas := casRaw.(int); cas != current {
+			return 0, logical.ErrorResponse(fmt.Sprintf("check-and-set parameter did not match the current version: cas is %d, the current version is %d", cas, current))
+		}
+	}
+	return current + 1, nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_checkAndSet(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp := request(op, path, data)
+		if resp != nil && resp.IsError() {
+			t.Fatalf("unexpected error: %#v", resp)
+		}
+		return resp
+	}
+	conflicts := func(op logical.Operation, path string, data map[string]interface{}) {
+		t.Helper()
+		resp := request(op, path, data)
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "check-and-set") {
+			t.Fatalf("expected a check-and-set conflict writing %s, got %#v", path, resp)
+		}
+	}
+	version := func(path string) int {
+		t.Helper()
+		return mustRequest(logical.ReadOperation, path, nil).Data["version"].(int)
+	}
+
+	connection := map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+		"cas":               0,
+	}
+	mustRequest(logical.CreateOperation, "config/plugin-test", connection)
+	if v := version("config/plugin-test"); v != 1 {
+		t.Fatalf("expected a new connection to be version 1, got %d", v)
+	}
+
+	// cas 0 only creates the connection
+	conflicts(logical.UpdateOperation, "config/plugin-test", connection)
+	mustRequest(logical.UpdateOperation, "config/plugin-test", map[string]interface{}{
+		"allowed_roles":     []string{"app"},
+		"verify_connection": false,
+		"cas":               1,
+	})
+	conflicts(logical.UpdateOperation, "config/plugin-test", map[string]interface{}{
+		"allowed_roles":     []string{"*"},
+		"verify_connection": false,
+		"cas":               1,
+	})
+	resp := mustRequest(logical.ReadOperation, "config/plugin-test", nil)
+	if resp.Data["version"] != 2 || resp.Data["allowed_roles"].([]string)[0] != "app" {
+		t.Fatalf("expected only the write at the current version to be applied, got %#v", resp.Data)
+	}
+	if _, ok := resp.Data["connection_details"].(map[string]interface{})["cas"]; ok {
+		t.Fatal("expected cas not to be stored with the connection details")
+	}
+
+	// Writes without cas are always applied
+	mustRequest(logical.UpdateOperation, "config/plugin-test", map[string]interface{}{
+		"allowed_roles":     []string{"*"},
+		"verify_connection": false,
+	})
+	if v := version("config/plugin-test"); v != 3 {
+		t.Fatalf("expected version 3, got %d", v)
+	}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeStaticDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"cas":                 1,
+	}
+	conflicts(logical.CreateOperation, "roles/app", role)
+	role["cas"] = 0
+	mustRequest(logical.CreateOperation, "roles/app", role)
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{"default_ttl": "1h", "cas": 1})
+	conflicts(logical.UpdateOperation, "roles/app", map[string]interface{}{"default_ttl": "2h", "cas": 1})
+	resp = mustRequest(logical.ReadOperation, "roles/app", nil)
+	if resp.Data["version"] != 2 || resp.Data["default_ttl"] != float64(3600) {
+		t.Fatalf("expected only the write at the current version to be applied, got %#v", resp.Data)
+	}
+
+	mustRequest(logical.CreateOperation, "static-roles/static-app", map[string]interface{}{
+		"db_name":         "plugin-test",
+		"username":        "static-app",
+		"rotation_period": 3600,
+		"cas":             0,
+	})
+	conflicts(logical.UpdateOperation, "static-roles/static-app", map[string]interface{}{"rotation_period": 7200, "cas": 0})
+
+	// Rotations don't change the version of the role
+	mustRequest(logical.UpdateOperation, "rotate-role/static-app", nil)
+	mustRequest(logical.UpdateOperation, "static-roles/static-app", map[string]interface{}{"rotation_period": 7200, "cas": 1})
+	if v := version("static-roles/static-app"); v != 2 {
+		t.Fatalf("expected version 2, got %d", v)
+	}
+
+	// Entries written before versions were tracked are version 1
+	entry, err := logical.StorageEntryJSON(databaseRolePath+"legacy", &roleEntry{DBName: "plugin-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	if v := version("roles/legacy"); v != 1 {
+		t.Fatalf("expected a legacy role to be version 1, got %d", v)
+	}
+	conflicts(logical.UpdateOperation, "roles/legacy", map[string]interface{}{"default_ttl": "1h", "cas": 0})
+	mustRequest(logical.UpdateOperation, "roles/legacy", map[string]interface{}{"default_ttl": "1h", "cas": 1})
+}
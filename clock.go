@@ -0,0 +1,15 @@
+package database
+
+import "time"
+
+// clock tells the current time.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the clock of the host.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
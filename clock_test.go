@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeClock is a clock that only moves when it is advanced.
+type fakeClock struct {
+	l   sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestBackend_staticRoleRotationClock(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	fake := &fakeStaticDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	request(logical.CreateOperation, "static-roles/app", map[string]interface{}{
+		"db_name":         "plugin-test",
+		"username":        "app",
+		"rotation_period": 3600,
+	})
+	if len(fake.set) != 1 {
+		t.Fatalf("expected the password to be set when the role was created, got %d", len(fake.set))
+	}
+
+	clock.advance(30 * time.Minute)
+	resp := request(logical.ReadOperation, "static-creds/app", nil)
+	if ttl := resp.Data["ttl"]; ttl != float64(1800) {
+		t.Fatalf("expected a ttl of 30 minutes, got %v", ttl)
+	}
+	if lvr := resp.Data["last_vault_rotation"]; lvr != clock.now.Add(-30*time.Minute) {
+		t.Fatalf("expected the rotation time to come from the clock, got %v", lvr)
+	}
+
+	b.rotateCredentials(context.Background(), s)
+	if len(fake.set) != 1 {
+		t.Fatalf("expected no rotation before the period, got %d", len(fake.set))
+	}
+
+	clock.advance(31 * time.Minute)
+	b.rotateCredentials(context.Background(), s)
+	if len(fake.set) != 2 {
+		t.Fatalf("expected a rotation once the period passed, got %d", len(fake.set))
+	}
+	resp = request(logical.ReadOperation, "static-creds/app", nil)
+	if ttl := resp.Data["ttl"]; ttl != float64(3600) {
+		t.Fatalf("expected the next rotation to be scheduled from the clock, got %v", ttl)
+	}
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+// closedDatabase replaces the plugin of a closed instance, failing the calls
+// that were waiting for the instance while it was closed as a plugin that
+// shut down would. Callers then close and recreate the connection with
+// CloseIfShutdown.
+type closedDatabase struct {
+	dbplugin.Database
+}
+
+func (closedDatabase) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	return "", "", dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	return dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	return dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	return nil, dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) GenerateCredentials(ctx context.Context) (string, error) {
+	return "", dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticConfig dbplugin.StaticUserConfig) (string, string, error) {
+	return "", "", dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) Init(ctx context.Context, config map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	return nil, dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) Initialize(ctx context.Context, config map[string]interface{}, verifyConnection bool) error {
+	return dbplugin.ErrPluginShutdown
+}
+
+func (closedDatabase) Close() error {
+	return nil
+}
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// translatedDetailKeys are the connection details that pluginConnectionDetails
+// may rewrite. Their stored values are restored by restoreConnectionDetails.
+var translatedDetailKeys = []string{"connection_url", "hosts", "host", "tls", "insecure_tls", "pem_json"}
+
+// pluginConnectionDetails returns the connection details to initialize the
+// plugin of a connection with. Options the backend offers for every plugin,
+// such as insecure_tls, ca_cert, unix_socket and tag_sessions, are translated into each plugin's own
+// settings here rather than being stored in the connection details.
+func pluginConnectionDetails(files *connectionFiles, name string, config *DatabaseConfig) (map[string]interface{}, error) {
+	details := make(map[string]interface{}, len(config.ConnectionDetails))
+	for k, v := range config.ConnectionDetails {
+		details[k] = v
+	}
+
+	if err := normalizeAddresses(config.PluginName, details); err != nil {
+		return nil, err
+	}
+
+	if err := applyUnixSocket(config, details); err != nil {
+		return nil, err
+	}
+	if err := applyTLSOptions(files, name, config, details); err != nil {
+		return nil, err
+	}
+	if err := applySessionTag(name, config, details); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// restoreConnectionDetails undoes pluginConnectionDetails on the details a
+// plugin returned from Init or RotateRootCredentials, so that translated
+// options are not persisted.
+func restoreConnectionDetails(returned, stored map[string]interface{}) map[string]interface{} {
+	for _, k := range translatedDetailKeys {
+		if v, ok := stored[k]; ok {
+			returned[k] = v
+		} else {
+			delete(returned, k)
+		}
+	}
+	return returned
+}
+
+// applyUnixSocket points the connection URL of a connection at its
+// unix_socket, replacing the host or network address in the URL.
+func applyUnixSocket(config *DatabaseConfig, details map[string]interface{}) error {
+	if config.UnixSocket == "" {
+		return nil
+	}
+
+	connURL, _ := details["connection_url"].(string)
+
+	switch config.PluginName {
+	case "mysql-database-plugin", "mysql-aurora-database-plugin", "mysql-rds-database-plugin", "mysql-legacy-database-plugin", "mariadb-database-plugin", "vitess-database-plugin":
+		dsn, err := setMySQLAddress(connURL, "unix", config.UnixSocket)
+		if err != nil {
+			return err
+		}
+		details["connection_url"] = dsn
+
+	case "postgresql-database-plugin":
+		if config.InsecureTLS || config.CACert != "" {
+			return errors.New("insecure_tls and ca_cert cannot be used with unix_socket, as PostgreSQL does not use TLS over Unix domain sockets")
+		}
+
+		// The PostgreSQL driver takes the directory of the socket, and finds
+		// the socket file in it from the port.
+		params := map[string]string{"host": config.UnixSocket}
+		dir, file := filepath.Split(config.UnixSocket)
+		if strings.HasPrefix(file, ".s.PGSQL.") {
+			params["host"] = filepath.Clean(dir)
+			params["port"] = strings.TrimPrefix(file, ".s.PGSQL.")
+		}
+		if strings.HasPrefix(connURL, "postgres://") || strings.HasPrefix(connURL, "postgresql://") {
+			details["connection_url"] = setURLQuery(connURL, params)
+		} else {
+			for _, k := range []string{"host", "port"} {
+				if v, ok := params[k]; ok {
+					connURL += fmt.Sprintf(" %s='%s'", k, v)
+				}
+			}
+			details["connection_url"] = connURL
+		}
+
+	default:
+		return fmt.Errorf("%s does not support unix_socket", config.PluginName)
+	}
+
+	return nil
+}
+
+// setMySQLAddress replaces the network and address of a MySQL DSN, in the
+// form [username[:password]@][protocol[(address)]]/dbname[?params].
+func setMySQLAddress(dsn, network, address string) (string, error) {
+	// As in the driver, the last '/' separates the database name, since the
+	// credentials and the address may contain one.
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return "", errors.New("connection_url is not a valid MySQL DSN: missing the '/' before the database name")
+	}
+	at := strings.LastIndex(dsn[:slash], "@")
+
+	return fmt.Sprintf("%s%s(%s)%s", dsn[:at+1], network, address, dsn[slash:]), nil
+}
+
+// applyTLSOptions translates the insecure_tls and ca_cert options, and the
+// client certificate issued from pki_mount, of a connection into the settings
+// of its plugin. insecure_tls encrypts the connection without verifying the
+// server's certificate. ca_cert verifies the server's certificate, and its
+// hostname where the plugin supports it, against the given CA rather than the
+// system roots.
+func applyTLSOptions(files *connectionFiles, name string, config *DatabaseConfig, details map[string]interface{}) error {
+	clientCert := config.ClientCert
+	if !config.InsecureTLS && config.CACert == "" && clientCert == nil {
+		return nil
+	}
+
+	var roots *x509.CertPool
+	if config.CACert != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(config.CACert)) {
+			return errors.New("ca_cert does not contain a PEM encoded certificate")
+		}
+	}
+
+	connURL, _ := details["connection_url"].(string)
+
+	// Through a tunnel the driver connects to a local address, so the
+	// server's hostname is taken from the connection URL instead.
+	var serverName string
+	if config.SSHTunnel.Host != "" || config.ProxyURL != "" || config.Dialer != "" || len(config.FallbackEndpoints) > 0 {
+		remote, err := tunnelTarget(config.PluginName, connURL)
+		if err != nil {
+			return err
+		}
+		serverName, _, _ = net.SplitHostPort(remote)
+	}
+
+	switch config.PluginName {
+	case "mysql-database-plugin", "mysql-aurora-database-plugin", "mysql-rds-database-plugin", "mysql-legacy-database-plugin", "mariadb-database-plugin", "vitess-database-plugin":
+		tlsConfig := &tls.Config{
+			RootCAs:            roots,
+			ServerName:         serverName,
+			InsecureSkipVerify: config.InsecureTLS,
+		}
+		if clientCert != nil {
+			cert, err := tls.X509KeyPair([]byte(clientCert.Certificate), []byte(clientCert.PrivateKey))
+			if err != nil {
+				return fmt.Errorf("failed to load the client certificate: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		// The MySQL driver looks TLS configurations up by name from a
+		// process-wide registry, which the builtin plugins share with us.
+		key := files.tlsConfigKey(name)
+		if err := mysql.RegisterTLSConfig(key, tlsConfig); err != nil {
+			return err
+		}
+		details["connection_url"] = setURLQuery(connURL, map[string]string{"tls": key})
+
+	case "postgresql-database-plugin":
+		params := map[string]string{}
+		switch {
+		case config.InsecureTLS:
+			params["sslmode"] = "require"
+		case config.CACert != "":
+			path, err := files.write(name, "ca", config.CACert)
+			if err != nil {
+				return err
+			}
+			params["sslmode"] = "verify-full"
+			params["sslrootcert"] = path
+			if serverName != "" {
+				// The driver can only verify the hostname it connects to
+				params["sslmode"] = "verify-ca"
+			}
+		}
+		if clientCert != nil {
+			certPath, err := files.write(name, "cert", clientCert.Certificate)
+			if err != nil {
+				return err
+			}
+			keyPath, err := files.write(name, "key", clientCert.PrivateKey)
+			if err != nil {
+				return err
+			}
+			params["sslcert"] = certPath
+			params["sslkey"] = keyPath
+		}
+		if strings.HasPrefix(connURL, "postgres://") || strings.HasPrefix(connURL, "postgresql://") {
+			details["connection_url"] = setURLQuery(connURL, params)
+		} else {
+			// Later keywords take precedence in key/value connection strings
+			for _, k := range []string{"sslmode", "sslrootcert", "sslcert", "sslkey"} {
+				if v, ok := params[k]; ok {
+					connURL += fmt.Sprintf(" %s='%s'", k, v)
+				}
+			}
+			details["connection_url"] = connURL
+		}
+
+	case "mssql-database-plugin":
+		if clientCert != nil {
+			return errors.New("the MSSQL plugin does not support client certificates")
+		}
+		params := map[string]string{"encrypt": "true"}
+		if config.InsecureTLS {
+			params["TrustServerCertificate"] = "true"
+		} else {
+			path, err := files.write(name, "ca", config.CACert)
+			if err != nil {
+				return err
+			}
+			params["certificate"] = path
+			if serverName != "" {
+				params["hostNameInCertificate"] = serverName
+			}
+		}
+		if strings.HasPrefix(connURL, "sqlserver://") {
+			details["connection_url"] = setURLQuery(connURL, params)
+		} else {
+			// Later keywords take precedence in ADO connection strings
+			for _, k := range []string{"encrypt", "TrustServerCertificate", "certificate", "hostNameInCertificate"} {
+				if v, ok := params[k]; ok {
+					connURL = strings.TrimSuffix(connURL, ";") + fmt.Sprintf(";%s=%s", k, v)
+				}
+			}
+			details["connection_url"] = connURL
+		}
+
+	case "cassandra-database-plugin", "influxdb-database-plugin":
+		details["tls"] = true
+		details["insecure_tls"] = config.InsecureTLS
+		if config.CACert != "" || clientCert != nil {
+			if _, ok := details["pem_bundle"]; ok {
+				return errors.New("ca_cert and pki_mount cannot be combined with pem_bundle")
+			}
+			if _, ok := details["pem_json"]; ok {
+				return errors.New("ca_cert and pki_mount cannot be combined with pem_json")
+			}
+			bundle := map[string]string{}
+			if config.CACert != "" {
+				bundle["issuing_ca"] = config.CACert
+			}
+			if clientCert != nil {
+				bundle["certificate"] = clientCert.Certificate
+				bundle["private_key"] = clientCert.PrivateKey
+			}
+			pemJSON, err := json.Marshal(bundle)
+			if err != nil {
+				return err
+			}
+			details["pem_json"] = string(pemJSON)
+		}
+
+	default:
+		return fmt.Errorf("%s does not support insecure_tls, ca_cert or pki_mount", config.PluginName)
+	}
+
+	return nil
+}
+
+// setURLQuery sets query parameters on a URL or MySQL DSN, replacing any
+// existing values. The rest of the string, which may contain templated
+// credentials that are not valid URL syntax, is left untouched.
+func setURLQuery(rawURL string, params map[string]string) string {
+	base, rawQuery := rawURL, ""
+	start := strings.LastIndex(rawURL, "/") + 1
+	if i := strings.Index(rawURL[start:], "?"); i >= 0 {
+		base, rawQuery = rawURL[:start+i], rawURL[start+i+1:]
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		query = url.Values{}
+	}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+
+	return base + "?" + query.Encode()
+}
+
+// connectionFileKinds are the files connectionFiles may write for a
+// connection.
+var connectionFileKinds = []string{"ca", "cert", "key"}
+
+// connectionFiles are the certificates and keys of a backend's connections
+// written to files for drivers which only accept a path. They are kept in a
+// directory of the backend's own, readable only by Vault, so that the
+// connections of other mounts on the host, which may have the same names,
+// can neither read nor replace them. The MySQL TLS configurations of the
+// connections are named by the mount in the same way.
+type connectionFiles struct {
+	// mountUUID names the directory, which is created by the first write,
+	// and the TLS configurations
+	mountUUID string
+
+	l   sync.Mutex
+	dir string
+}
+
+func newConnectionFiles(mountUUID string) *connectionFiles {
+	return &connectionFiles{mountUUID: mountUUID}
+}
+
+// write writes a certificate or key of a connection to its file, returning
+// the path.
+func (f *connectionFiles) write(name, kind, contents string) (string, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if f.dir == "" {
+		prefix := "vault-database-"
+		if f.mountUUID != "" {
+			prefix += f.mountUUID + "-"
+		}
+		// The directory is created with mode 0700
+		dir, err := ioutil.TempDir("", prefix)
+		if err != nil {
+			return "", fmt.Errorf("failed to create the directory of the connection files: %s", err)
+		}
+		f.dir = dir
+	}
+
+	path := f.path(name, kind)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		return "", fmt.Errorf("failed to write the %s file: %s", kind, err)
+	}
+	return path, nil
+}
+
+// tlsConfigKey returns the name a connection's TLS configuration is
+// registered with the MySQL driver by, which is process-wide.
+func (f *connectionFiles) tlsConfigKey(name string) string {
+	if f.mountUUID == "" {
+		return "vault-database-" + name
+	}
+	return "vault-database-" + f.mountUUID + "-" + name
+}
+
+// path returns the path of a connection's file. It is called holding f.l.
+func (f *connectionFiles) path(name, kind string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(f.dir, fmt.Sprintf("%x-%s.pem", sum[:8], kind))
+}
+
+// remove removes the files of a connection.
+func (f *connectionFiles) remove(name string) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if f.dir == "" {
+		return nil
+	}
+	var err error
+	for _, kind := range connectionFileKinds {
+		if removeErr := os.Remove(f.path(name, kind)); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// removeAll removes the directory of the files and everything in it. A
+// later write creates a new directory.
+func (f *connectionFiles) removeAll() error {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if f.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(f.dir)
+	f.dir = ""
+	return err
+}
+
+// releaseConnectionDetails removes what pluginConnectionDetails left outside
+// the plugin for a connection: the files written for its certificates and
+// keys, and its MySQL TLS configuration. They are shared by every instance of
+// the connection, so this must only be called once the connection is no
+// longer used, rather than when one of its instances is replaced.
+func releaseConnectionDetails(files *connectionFiles, name string) error {
+	mysql.DeregisterTLSConfig(files.tlsConfigKey(name))
+	return files.remove(name)
+}
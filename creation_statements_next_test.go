@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_creationStatementsNext(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp := request(op, path, data)
+		if resp != nil && resp.IsError() {
+			t.Fatalf("unexpected error: %#v", resp)
+		}
+		return resp
+	}
+
+	mustRequest(logical.UpdateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	recorder := &recordingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{Database: recorder, name: "plugin-test", id: "fake"}
+
+	current := []string{`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}'; GRANT SELECT ON ALL TABLES IN SCHEMA v1 TO "{{name}}";`}
+	next := []string{`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}'; GRANT SELECT ON ALL TABLES IN SCHEMA v2 TO "{{name}}";`}
+
+	// Staged statements are validated like the role's own
+	resp := request(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":                     "plugin-test",
+		"creation_statements":         current,
+		"creation_statements_next":    []string{`GRANT SELECT ON ALL TABLES IN SCHEMA v2 TO "{{name}}";`},
+		"creation_statements_next_at": "2020-01-01T13:00:00Z",
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected creation_statements_next without {{password}} to be rejected, got %#v", resp)
+	}
+	resp = request(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":                     "plugin-test",
+		"creation_statements":         current,
+		"creation_statements_next_at": "tomorrow",
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid creation_statements_next_at to be rejected, got %#v", resp)
+	}
+
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":                     "plugin-test",
+		"creation_statements":         current,
+		"creation_statements_next":    next,
+		"creation_statements_next_at": "2020-01-01T13:00:00Z",
+	})
+	mustRequest(logical.ReadOperation, "creds/app", nil)
+	if !reflect.DeepEqual(recorder.creation, current) {
+		t.Fatalf("expected the current statements before the cutover, got %q", recorder.creation)
+	}
+	mustRequest(logical.UpdateOperation, "dbs/plugin-test/test-role/app", map[string]interface{}{"next": true})
+	if !reflect.DeepEqual(recorder.creation, next) {
+		t.Fatalf("expected the staged statements to be tested, got %q", recorder.creation)
+	}
+
+	clock.advance(time.Hour)
+	mustRequest(logical.ReadOperation, "creds/app", nil)
+	if !reflect.DeepEqual(recorder.creation, next) {
+		t.Fatalf("expected the staged statements from the cutover, got %q", recorder.creation)
+	}
+	resp = mustRequest(logical.ReadOperation, "roles/app", nil)
+	if resp.Data["creation_statements_next_active"] != true || resp.Data["creation_statements_next_at"] != "2020-01-01T13:00:00Z" {
+		t.Fatalf("expected the staged statements to be active, got %#v", resp.Data)
+	}
+
+	// The next write keeps them as the role's own
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{"default_ttl": "1h"})
+	resp = mustRequest(logical.ReadOperation, "roles/app", nil)
+	if !reflect.DeepEqual(resp.Data["creation_statements"], next) || resp.Data["creation_statements_next"] != nil {
+		t.Fatalf("expected the staged statements to be promoted, got %#v", resp.Data)
+	}
+
+	// Staged statements without a time wait to be activated
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{"creation_statements_next": current})
+	mustRequest(logical.ReadOperation, "creds/app", nil)
+	if !reflect.DeepEqual(recorder.creation, next) {
+		t.Fatalf("expected the role's statements until activation, got %q", recorder.creation)
+	}
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{"activate_creation_statements_next": true})
+	mustRequest(logical.ReadOperation, "creds/app", nil)
+	if !reflect.DeepEqual(recorder.creation, current) {
+		t.Fatalf("expected the activated statements, got %q", recorder.creation)
+	}
+	if resp := request(logical.UpdateOperation, "roles/app", map[string]interface{}{"activate_creation_statements_next": true}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected activation without staged statements to be rejected, got %#v", resp)
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+)
+
+// reservedCredentialTemplateNames are the response fields that the
+// credential_templates of a role can't replace.
+var reservedCredentialTemplateNames = []string{"username", "password", "credential_file", "refresh_after", "credential"}
+
+// credentialTemplateData is what a credential_file_template, or the
+// credential_templates of a role, are rendered with.
+type credentialTemplateData struct {
+	Username   string
+	Password   string
+	Role       string
+	DBName     string
+	Expiration string
+	Data       map[string]interface{}
+
+	// ConnectionURL is the connection URL of the connection with the user's
+	// credentials in place of its own, and Connection the connection
+	// details without the connection's credentials.
+	ConnectionURL string
+	Connection    map[string]interface{}
+}
+
+func parseCredentialTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	return tmpl, nil
+}
+
+// renderCredentialTemplate renders a template over the credentials of a new
+// user, zeroing the buffer it was rendered into.
+func renderCredentialTemplate(name, text string, data credentialTemplateData) (string, error) {
+	tmpl, err := parseCredentialTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	defer func() { zeroBytes(buf.Bytes()) }()
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %s", name, err)
+	}
+	return buf.String(), nil
+}
+
+// validateCredentialTemplate checks one of the credential_templates of a
+// role.
+func validateCredentialTemplate(name, text string) error {
+	for _, reserved := range reservedCredentialTemplateNames {
+		if name == reserved {
+			return fmt.Errorf("can't replace the %q field", name)
+		}
+	}
+	if _, err := template.New(credentialTemplateName(name)).Parse(text); err != nil {
+		return err
+	}
+	return nil
+}
+
+func credentialTemplateName(name string) string {
+	return fmt.Sprintf("credential_templates[%q]", name)
+}
+
+// renderCredentialTemplates renders the credential_templates of a role into
+// the fields of the response they are named after, in the order of their
+// names. The first that fails to render is returned.
+func renderCredentialTemplates(templates map[string]string, data credentialTemplateData) (map[string]interface{}, error) {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rendered := make(map[string]interface{}, len(templates))
+	for _, name := range names {
+		text, err := renderCredentialTemplate(credentialTemplateName(name), templates[name], data)
+		if err != nil {
+			return nil, err
+		}
+		rendered[name] = text
+	}
+	return rendered, nil
+}
+
+// userConnectionURL returns the connection URL of a connection with a
+// user's credentials in place of the connection's, or an empty string if
+// the connection has no URL or its own password would remain in it.
+func userConnectionURL(details map[string]interface{}, username, password string) string {
+	connURL, _ := details["connection_url"].(string)
+	if connURL == "" {
+		return ""
+	}
+
+	values := map[string]string{"username": username, "password": password}
+	isURL := strings.Contains(connURL, "://")
+	if isURL {
+		values = map[string]string{"username": url.PathEscape(username), "password": url.PathEscape(password)}
+	}
+	rendered := dbutil.QueryHelper(connURL, values)
+	if u, err := url.Parse(rendered); isURL && err == nil && u.User != nil {
+		u.User = url.UserPassword(username, password)
+		rendered = u.String()
+	}
+
+	if root, _ := details["password"].(string); root != "" && root != password && strings.Contains(rendered, root) {
+		return ""
+	}
+	return rendered
+}
+
+// credentialRefreshAfter is how long the writer of a credential file waits
+// before renewing the lease or fetching new credentials, leaving a third of
+// the lease to do so.
+func credentialRefreshAfter(ttl time.Duration) time.Duration {
+	return ttl * 2 / 3
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+)
+
+const (
+	minPasswordLength = 10
+	maxPasswordLength = 100
+
+	// rsaKeyBits is the size of the keys generated by the rsa-key producer.
+	rsaKeyBits = 2048
+
+	legacyCredentialsProducer = "legacy"
+)
+
+// producerPlaceholders are the template variables that credentials producers
+// may fill into creation statements, in addition to the username and
+// password.
+var producerPlaceholders = []string{"public_key"}
+
+// credentialsProducer generates the credentials of dynamic users in the
+// backend, in place of the plugin.
+type credentialsProducer interface {
+	produce(ctx context.Context, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (*producedCredentials, error)
+}
+
+// credentialsProducerFactory creates the producer configured by a connection,
+// returning an error suitable for the user if its settings are invalid. A nil
+// producer leaves the credentials to the plugin.
+type credentialsProducerFactory func(config *DatabaseConfig) (credentialsProducer, error)
+
+// credentialsProducers are the producers a connection can select with
+// credentials_producer, by name.
+var credentialsProducers = map[string]credentialsProducerFactory{
+	legacyCredentialsProducer: func(*DatabaseConfig) (credentialsProducer, error) {
+		return nil, nil
+	},
+	"template": func(config *DatabaseConfig) (credentialsProducer, error) {
+		p, err := newTemplateProducer(config)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	},
+	"rsa-key": newRSAKeyProducer,
+	"cert":    newCertProducer,
+}
+
+func credentialsProducerNames() []string {
+	names := make([]string, 0, len(credentialsProducers))
+	for name := range credentialsProducers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func credentialsProducerValues() []interface{} {
+	names := credentialsProducerNames()
+	values := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		values = append(values, name)
+	}
+	return values
+}
+
+// credentialsProducerName returns the producer a connection uses. Connections
+// that don't select one use the template producer if they set
+// username_template or password_length, and otherwise the plugin's own.
+func credentialsProducerName(config *DatabaseConfig) string {
+	switch {
+	case config.CredentialsProducer != "":
+		return config.CredentialsProducer
+	case config.UsernameTemplate != "" || config.PasswordLength != 0:
+		return "template"
+	default:
+		return legacyCredentialsProducer
+	}
+}
+
+// newCredentialsProducer creates the producer of a connection, which is nil if
+// the plugin generates the credentials.
+func newCredentialsProducer(config *DatabaseConfig) (credentialsProducer, error) {
+	name := credentialsProducerName(config)
+	factory, ok := credentialsProducers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown credentials_producer %q; available producers are %s", name, strings.Join(credentialsProducerNames(), ", "))
+	}
+	if name != legacyCredentialsProducer {
+		if _, ok := hostCredentialLimits[config.PluginName]; !ok {
+			return nil, fmt.Errorf("%s only supports the %s credentials_producer, so username_template and password_length cannot be used", config.PluginName, legacyCredentialsProducer)
+		}
+	}
+	return factory(config)
+}
+
+// templateProducer generates usernames from the username_template and
+// passwords of password_length characters. Either left unset is generated by
+// the plugin.
+type templateProducer struct {
+	usernameTemplate  *template.Template
+	maxUsernameLength int
+	passwordLength    int
+}
+
+func newTemplateProducer(config *DatabaseConfig) (*templateProducer, error) {
+	if config.PasswordLength != 0 && (config.PasswordLength < minPasswordLength || config.PasswordLength > maxPasswordLength) {
+		return nil, fmt.Errorf("password_length must be between %d and %d", minPasswordLength, maxPasswordLength)
+	}
+
+	p := &templateProducer{
+		maxUsernameLength: hostCredentialLimits[config.PluginName],
+		passwordLength:    config.PasswordLength,
+	}
+	if config.UsernameTemplate != "" {
+		tmpl, err := parseUsernameTemplate(config.UsernameTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := renderUsername(tmpl, dbplugin.UsernameConfig{DisplayName: "token", RoleName: "role"}); err != nil {
+			return nil, err
+		}
+		p.usernameTemplate = tmpl
+	}
+	return p, nil
+}
+
+func (p *templateProducer) produce(ctx context.Context, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (*producedCredentials, error) {
+	creds := &producedCredentials{}
+	if p.usernameTemplate != nil {
+		username, err := renderUsername(p.usernameTemplate, usernameConfig)
+		if err != nil {
+			return nil, err
+		}
+		if len(username) > p.maxUsernameLength {
+			return nil, fmt.Errorf("username_template rendered a username of %d characters, longer than the %d the plugin accepts; use truncate to shorten it", len(username), p.maxUsernameLength)
+		}
+		creds.username = username
+	}
+	if p.passwordLength > 0 {
+		password, err := credsutil.RandomAlphaNumeric(p.passwordLength, true)
+		if err != nil {
+			return nil, err
+		}
+		creds.password = password
+	}
+	return creds, nil
+}
+
+// rsaKeyProducer generates a key pair for each user, for databases that
+// authenticate users by their public key. The public key fills in
+// {{public_key}} in the creation statements, base64 encoded in DER form, and
+// the private key is returned to the client.
+type rsaKeyProducer struct {
+	*templateProducer
+}
+
+func newRSAKeyProducer(config *DatabaseConfig) (credentialsProducer, error) {
+	p, err := newTemplateProducer(config)
+	if err != nil {
+		return nil, err
+	}
+	return &rsaKeyProducer{templateProducer: p}, nil
+}
+
+func (p *rsaKeyProducer) produce(ctx context.Context, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (*producedCredentials, error) {
+	creds, err := p.templateProducer.produce(ctx, usernameConfig, expiration)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.statementValues = map[string]string{
+		"public_key": base64.StdEncoding.EncodeToString(publicKey),
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey})
+	creds.data = map[string]interface{}{
+		"private_key": string(encoded),
+	}
+	zeroBytes(privateKey)
+	zeroBytes(encoded)
+	return creds, nil
+}
+
+// zeroBytes overwrites a buffer that held credentials once they have been
+// copied out of it, so that they don't linger in the heap until it is
+// reused.
+func zeroBytes(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// certProducer issues each user a client certificate from the connection's
+// PKI mount, with the username as its common name, for databases that
+// authenticate users by certificate. The certificate expires with the user.
+type certProducer struct {
+	*templateProducer
+
+	pki pkiClientCertConfig
+}
+
+func newCertProducer(config *DatabaseConfig) (credentialsProducer, error) {
+	if config.UsernameTemplate == "" {
+		return nil, errors.New("the cert credentials_producer requires username_template, as the username is the certificate's common name")
+	}
+	if config.PKI.Mount == "" {
+		return nil, errors.New("the cert credentials_producer requires pki_mount to issue the certificates from")
+	}
+	p, err := newTemplateProducer(config)
+	if err != nil {
+		return nil, err
+	}
+	return &certProducer{templateProducer: p, pki: config.PKI}, nil
+}
+
+func (p *certProducer) produce(ctx context.Context, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (*producedCredentials, error) {
+	creds, err := p.templateProducer.produce(ctx, usernameConfig, expiration)
+	if err != nil {
+		return nil, err
+	}
+
+	pki := p.pki
+	pki.CommonName = creds.username
+	pki.TTL = time.Until(expiration)
+	cert, err := issueClientCertificate(ctx, pki)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue the certificate of the user: %s", err)
+	}
+
+	creds.data = map[string]interface{}{
+		"certificate": cert.Certificate,
+		"private_key": cert.PrivateKey,
+		"issuing_ca":  cert.IssuingCA,
+	}
+	return creds, nil
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCredentialsProducers(t *testing.T) {
+	producer, err := newCredentialsProducer(&DatabaseConfig{
+		PluginName:       "mysql-legacy-database-plugin",
+		UsernameTemplate: "{{.RoleName}}-{{.DisplayName}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = producer.produce(context.Background(), dbplugin.UsernameConfig{DisplayName: "a-long-display-name", RoleName: "app"}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "longer than the 16") {
+		t.Fatalf("expected the username to be rejected for its length, got %v", err)
+	}
+
+	producer, err = newCredentialsProducer(&DatabaseConfig{PluginName: "mongodb-database-plugin"})
+	if err != nil || producer != nil {
+		t.Fatalf("expected the plugin to generate the credentials by default, got %#v, %v", producer, err)
+	}
+
+	producer, err = newCredentialsProducer(&DatabaseConfig{
+		PluginName:          "postgresql-database-plugin",
+		CredentialsProducer: "rsa-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := producer.produce(context.Background(), dbplugin.UsernameConfig{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := base64.StdEncoding.DecodeString(creds.statementValues["public_key"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x509.ParsePKIXPublicKey(der); err != nil {
+		t.Fatalf("expected {{public_key}} to be a public key: %s", err)
+	}
+	block, _ := pem.Decode([]byte(creds.data["private_key"].(string)))
+	if block == nil {
+		t.Fatalf("expected the private key to be PEM encoded, got %q", creds.data["private_key"])
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	if creds.username != "" || creds.password != "" {
+		t.Fatalf("expected the plugin to generate the username and password, got %q/%q", creds.username, creds.password)
+	}
+}
+
+func TestBackend_credentialsProducerConfig(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	for data, expected := range map[[4]string]string{
+		{"mongodb-database-plugin", "", "v-{{.RoleName}}", ""}:      "only supports the legacy credentials_producer",
+		{"postgresql-database-plugin", "", "v-{{.Role}}", ""}:       "failed to render",
+		{"postgresql-database-plugin", "", "v-{{if}}", ""}:          "invalid username_template",
+		{"postgresql-database-plugin", "", "v {{.RoleName}}", ""}:   "may only contain",
+		{"postgresql-database-plugin", "", "", "8"}:                 "password_length must be between",
+		{"postgresql-database-plugin", "ldap", "", ""}:              "available producers are cert, legacy, rsa-key, template",
+		{"postgresql-database-plugin", "cert", "", ""}:              "requires username_template",
+		{"postgresql-database-plugin", "cert", "{{.RoleName}}", ""}: "requires pki_mount",
+	} {
+		req := map[string]interface{}{
+			"connection_url":       "postgres://localhost/postgres",
+			"plugin_name":          data[0],
+			"credentials_producer": data[1],
+			"username_template":    data[2],
+			"verify_connection":    false,
+		}
+		if data[3] != "" {
+			req["password_length"] = data[3]
+		}
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config/plugin-test",
+			Storage:   s,
+			Data:      req,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, got err:%s resp:%#v", data, err, resp)
+		}
+		if msg := resp.Data["error"].(string); !strings.Contains(msg, expected) {
+			t.Fatalf("expected the error for %v to contain %q, got %q", data, expected, msg)
+		}
+	}
+}
+
+func TestBackend_credentialsProducer(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":       "sample_connection_url",
+			"plugin_name":          "postgresql-database-plugin",
+			"verify_connection":    false,
+			"allowed_roles":        []string{"*"},
+			"credentials_producer": "rsa-key",
+			"username_template":    "v-{{.RoleName}}",
+		},
+	})
+	fake := &recordingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: newHostCredentials(fake),
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": []string{`CREATE ROLE "{{name}}" WITH LOGIN; ALTER ROLE "{{name}}" SET rsa_public_key = '{{public_key}}';`},
+		},
+	})
+
+	resp := request(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/app",
+	})
+	if resp.Data["username"] != "v-app" || resp.Data["private_key"] == nil {
+		t.Fatalf("expected the produced credentials to be returned, got %#v", resp.Data)
+	}
+	if len(fake.creation) != 1 || !strings.HasPrefix(fake.creation[0], `CREATE ROLE "v-app" WITH LOGIN; ALTER ROLE "v-app" SET rsa_public_key = 'MII`) {
+		t.Fatalf("expected the produced credentials to be filled in, got %q", fake.creation)
+	}
+}
+
+func TestBackend_producedCredentialsNotRetained(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+			"password_length":   32,
+		},
+	})
+	fake := &recordingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: newHostCredentials(fake),
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": []string{`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`},
+		},
+	})
+
+	password := request(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/app",
+	}).Data["password"].(string)
+	if len(fake.creation) != 1 || !strings.Contains(fake.creation[0], password) {
+		t.Fatalf("expected the produced password to be filled in, got %q", fake.creation)
+	}
+
+	for key, entry := range b.storageCache.entries {
+		if entry != nil && strings.Contains(string(entry.Value), password) {
+			t.Fatalf("expected the password not to be cached, found it in %q", key)
+		}
+	}
+	for stmt := range b.statementTemplates.templates {
+		if strings.Contains(stmt, password) {
+			t.Fatalf("expected the password not to be cached, found it in %q", stmt)
+		}
+	}
+
+	creds := &producedCredentials{
+		username:        "v-app",
+		password:        password,
+		statementValues: map[string]string{"public_key": "key"},
+		data:            map[string]interface{}{"private_key": "key"},
+	}
+	creds.clear()
+	if creds.password != "" || creds.statementValues != nil || creds.data != nil {
+		t.Fatalf("expected the credentials to be cleared, got %#v", creds)
+	}
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	deferredPasswordPath = "deferred-password/"
+
+	// passwordDeliveryResponse returns the password in the response of the
+	// credential request, and passwordDeliveryDeferred only returns the
+	// username, leaving the password to be picked up in a wrapped response.
+	passwordDeliveryResponse = "response"
+	passwordDeliveryDeferred = "deferred"
+
+	// defaultPasswordPickupWindow is how long a deferred password can be
+	// picked up for if the role doesn't set password_pickup_window.
+	defaultPasswordPickupWindow = 5 * time.Minute
+)
+
+// deferredPassword holds the secret fields of a credential response for a
+// role with deferred password delivery, until its requester picks them up.
+type deferredPassword struct {
+	ID       string `json:"id"`
+	Role     string `json:"role"`
+	Username string `json:"username"`
+
+	// RequestedBy is the requesterIdentity of the credential request, the
+	// only caller that may pick the password up.
+	RequestedBy string `json:"requested_by"`
+
+	Data       map[string]interface{} `json:"data"`
+	Expiration time.Time              `json:"expiration"`
+}
+
+// deferPassword stores every field of a credential response but the
+// username for its requester to pick up, returning the data to respond with
+// instead.
+func (b *databaseBackend) deferPassword(ctx context.Context, req *logical.Request, name string, role *roleEntry, username string, respData map[string]interface{}) (map[string]interface{}, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	window := role.PasswordPickupWindow
+	if window == 0 {
+		window = defaultPasswordPickupWindow
+	}
+
+	deferred := &deferredPassword{
+		ID:          id,
+		Role:        name,
+		Username:    username,
+		RequestedBy: requesterIdentity(req),
+		Data:        make(map[string]interface{}, len(respData)),
+		Expiration:  b.clock.Now().Add(window),
+	}
+	for k, v := range respData {
+		if k != "username" {
+			deferred.Data[k] = v
+		}
+	}
+	entry, err := logical.StorageEntryJSON(deferredPasswordPath+id, deferred)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"username":            username,
+		"password_id":         id,
+		"password_expiration": deferred.Expiration.Format(time.RFC3339),
+	}, nil
+}
+
+// deferredPassword returns the deferred password with the given ID, or nil
+// if it doesn't exist or has expired, in which case it is removed.
+func (b *databaseBackend) deferredPassword(ctx context.Context, s logical.Storage, id string) (*deferredPassword, error) {
+	entry, err := s.Get(ctx, deferredPasswordPath+id)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	var deferred deferredPassword
+	if err := entry.DecodeJSON(&deferred); err != nil {
+		return nil, err
+	}
+	if !b.clock.Now().Before(deferred.Expiration) {
+		return nil, s.Delete(ctx, deferredPasswordPath+id)
+	}
+	return &deferred, nil
+}
+
+func pathDeferredPassword(b *databaseBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "creds/" + framework.GenericNameRegex("name") + "/password/" + framework.GenericNameRegex("id") + "$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"id": {
+				Type:        framework.TypeString,
+				Description: "The password_id returned by the credential request.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathDeferredPasswordRead,
+				Summary:  "Pick up the password of a role with deferred password delivery, in a wrapped response.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"username": "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987",
+								"password": "A1a-1uDoi6PSBfu1QuDt",
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathDeferredPasswordHelpSyn,
+		HelpDescription: pathDeferredPasswordHelpDesc,
+	}
+}
+
+func (b *databaseBackend) pathDeferredPasswordRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	id := data.Get("id").(string)
+	lock := locksutil.LockForKey(b.deferredPasswordLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	deferred, err := b.deferredPassword(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if deferred == nil || deferred.Role != name {
+		return logical.ErrorResponse(fmt.Sprintf("no password %q to pick up for role %q; it may have expired or been picked up", id, name)), nil
+	}
+	if requesterIdentity(req) != deferred.RequestedBy {
+		return nil, logical.CodedError(http.StatusForbidden, "a deferred password can only be picked up by the requester of its credentials")
+	}
+
+	// Each password is picked up once
+	if err := req.Storage.Delete(ctx, deferredPasswordPath+id); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("deferred password picked up", "role", name, "username", deferred.Username)
+
+	deferred.Data["username"] = deferred.Username
+	return &logical.Response{
+		Data: deferred.Data,
+		WrapInfo: &wrapping.ResponseWrapInfo{
+			TTL: deferred.Expiration.Sub(b.clock.Now()),
+		},
+	}, nil
+}
+
+// removeExpiredDeferredPasswords removes the deferred passwords that weren't
+// picked up within their role's pickup window.
+func (b *databaseBackend) removeExpiredDeferredPasswords(ctx context.Context, req *logical.Request) error {
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	ids, err := req.Storage.List(ctx, deferredPasswordPath)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	for _, id := range ids {
+		if strings.HasSuffix(id, "/") {
+			continue
+		}
+		lock := locksutil.LockForKey(b.deferredPasswordLocks, id)
+		lock.Lock()
+		_, err := b.deferredPassword(ctx, req.Storage, id)
+		lock.Unlock()
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+const pathDeferredPasswordHelpSyn = `
+Pick up the password of a role with deferred password delivery.
+`
+
+const pathDeferredPasswordHelpDesc = `
+Reading "creds/<role>" for a role whose "password_delivery" is "deferred"
+creates the user and returns its "username" with a "password_id", but not its
+password, so that the systems the credential response passes through, such as
+deployment tooling that only needs the username, never see the password in
+plaintext.
+
+The requester of the credentials reads the password from
+"creds/<role>/password/<password_id>". The response is always wrapped, until
+the end of the role's "password_pickup_window" if the request doesn't ask for
+a wrapping TTL, so the password is only ever returned inside a single-use
+wrapping token. Every other secret field of the credential response, such as
+the fields rendered from the role's "credential_templates" or the
+"credential_file" of a Kubernetes role, is returned with it.
+
+Each password can be picked up once, only by the token, or the tokens of the
+entity, that requested the credentials, and only within the pickup window,
+which defaults to 5 minutes. The user's lease is unaffected: a password that
+isn't picked up leaves a user no one can log in as until it is revoked.
+`
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_deferredPasswordDelivery(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(accessor string, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation:           op,
+			Path:                path,
+			Storage:             s,
+			Data:                data,
+			ClientTokenAccessor: accessor,
+		})
+	}
+	mustRequest := func(accessor string, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(accessor, op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest("", logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	if resp, _ := request("", logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":           "plugin-test",
+		"password_delivery": "plaintext",
+	}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid password_delivery to be rejected, got %#v", resp)
+	}
+	mustRequest("", logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":                "plugin-test",
+		"creation_statements":    `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"credential_templates":   map[string]interface{}{"dsn": "{{.Username}}:{{.Password}}@db"},
+		"password_delivery":      "deferred",
+		"password_pickup_window": 60,
+	})
+	resp := mustRequest("", logical.ReadOperation, "roles/app", nil)
+	if resp.Data["password_delivery"] != "deferred" || resp.Data["password_pickup_window"] != float64(60) {
+		t.Fatalf("unexpected role %#v", resp.Data)
+	}
+
+	// Passwords can't be deferred for a token that can't pick them up
+	if resp, _ := request("", logical.ReadOperation, "creds/app", nil); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a request without an accessor to be refused, got %#v", resp)
+	}
+
+	resp = mustRequest("accessor-1", logical.ReadOperation, "creds/app", nil)
+	if resp.Data["username"] != "user-1" || resp.Data["password"] != nil || resp.Data["dsn"] != nil || resp.Data["password_expiration"] != "2020-01-01T12:01:00Z" {
+		t.Fatalf("expected only the username, got %#v", resp.Data)
+	}
+	pickup := "creds/app/password/" + resp.Data["password_id"].(string)
+
+	if _, err := request("accessor-2", logical.ReadOperation, pickup, nil); err == nil || err.(logical.HTTPCodedError).Code() != http.StatusForbidden {
+		t.Fatalf("expected another token to be forbidden, got %v", err)
+	}
+	if resp, _ := request("accessor-1", logical.ReadOperation, "creds/other/password/"+resp.Data["password_id"].(string), nil); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the password not to be picked up from another role, got %#v", resp)
+	}
+
+	clock.advance(20 * time.Second)
+	resp = mustRequest("accessor-1", logical.ReadOperation, pickup, nil)
+	if resp.Data["username"] != "user-1" || resp.Data["password"] != "password" || resp.Data["dsn"] != "user-1:password@db" {
+		t.Fatalf("unexpected pickup %#v", resp.Data)
+	}
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != 40*time.Second {
+		t.Fatalf("expected the pickup to be wrapped until the end of the window, got %#v", resp.WrapInfo)
+	}
+	if resp, _ := request("accessor-1", logical.ReadOperation, pickup, nil); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the password to be picked up once, got %#v", resp)
+	}
+
+	// Passwords that aren't picked up expire
+	resp = mustRequest("accessor-1", logical.ReadOperation, "creds/app", nil)
+	clock.advance(time.Minute)
+	if err := b.removeExpiredDeferredPasswords(context.Background(), &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := s.List(context.Background(), deferredPasswordPath); err != nil || len(ids) != 0 {
+		t.Fatalf("expected the expired password to be removed, got %q, %v", ids, err)
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DialContextFunc dials a database on behalf of a connection, in the same way
+// as net.Dialer's DialContext.
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+var (
+	dialersLock sync.RWMutex
+	dialers     = map[string]DialContextFunc{}
+)
+
+// RegisterDialer makes dial available to connections that set dialer to
+// name, for example to connect through a service mesh or with SPIFFE mTLS, or
+// over an in-memory transport in tests. Registering a dialer under an
+// existing name replaces it. Dialers must be registered before the backend is
+// mounted, as connections that refer to an unregistered dialer fail to open.
+func RegisterDialer(name string, dial DialContextFunc) {
+	dialersLock.Lock()
+	defer dialersLock.Unlock()
+	dialers[name] = dial
+}
+
+// lookupDialer returns the dialer registered as name.
+func lookupDialer(name string) (DialContextFunc, error) {
+	dialersLock.RLock()
+	defer dialersLock.RUnlock()
+
+	dial, ok := dialers[name]
+	if ok {
+		return dial, nil
+	}
+
+	names := make([]string, 0, len(dialers))
+	for name := range dialers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("dialer %q is not registered; no dialers are registered", name)
+	}
+	return nil, fmt.Errorf("dialer %q is not registered; registered dialers are %s", name, strings.Join(names, ", "))
+}
+
+// registeredDialer adapts a registered DialContextFunc to a tunnelDialer.
+type registeredDialer struct {
+	dial DialContextFunc
+}
+
+func newRegisteredDialer(name string) (*registeredDialer, error) {
+	dial, err := lookupDialer(name)
+	if err != nil {
+		return nil, err
+	}
+	return &registeredDialer{dial: dial}, nil
+}
+
+func (d *registeredDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dial(context.Background(), network, address)
+}
+
+func (d *registeredDialer) Close() error {
+	return nil
+}
@@ -0,0 +1,407 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const driftPath = "drift/"
+
+// driftTolerance is how much later than its lease a user may expire in the
+// database before it is reported. Users are created and renewed with an
+// expiration a few seconds past their lease.
+const driftTolerance = time.Minute
+
+// userExpirationQueries are the queries returning the expiration of a user,
+// as seconds since the epoch, for the plugins supporting drift checks.
+// Infinite or NULL expirations mean the user doesn't expire.
+var userExpirationQueries = map[string]string{
+	"postgresql-database-plugin": `SELECT extract(epoch FROM rolvaliduntil) FROM pg_roles WHERE rolname = $1;`,
+}
+
+// userExpirationReader reads the expirations of users in a database.
+type userExpirationReader interface {
+	// userExpiration returns when the user expires in the database, or a zero
+	// time if it doesn't, and whether the user exists.
+	userExpiration(ctx context.Context, username string) (time.Time, bool, error)
+	Close() error
+}
+
+// sqlExpirationReader reads the expirations of users with one of the
+// userExpirationQueries.
+type sqlExpirationReader struct {
+	db    *sql.DB
+	query string
+}
+
+// rootConnectionURL returns the connection URL of an open connection with
+// its own credentials filled in, through its tunnel if it has one.
+func rootConnectionURL(files *connectionFiles, name string, config *DatabaseConfig, db *dbPluginInstance) (string, error) {
+	details, err := pluginConnectionDetails(files, name, config)
+	if err != nil {
+		return "", err
+	}
+	connURL, _ := details["connection_url"].(string)
+	username, _ := details["username"].(string)
+	password, _ := details["password"].(string)
+	connURL = dbutil.QueryHelper(connURL, map[string]string{
+		"username": url.PathEscape(username),
+		"password": url.PathEscape(password),
+	})
+	if db.tunnel != nil {
+		if connURL, err = setTunnelAddress(config.PluginName, connURL, db.tunnel.listener.Addr().String()); err != nil {
+			return "", err
+		}
+	}
+	return connURL, nil
+}
+
+// newUserExpirationReader connects to the database of an open connection
+// with its own credentials, through its tunnel if it has one. Tests replace
+// it with a fake.
+var newUserExpirationReader = func(files *connectionFiles, name string, config *DatabaseConfig, db *dbPluginInstance) (userExpirationReader, error) {
+	query, ok := userExpirationQueries[config.PluginName]
+	if !ok {
+		return nil, fmt.Errorf("%s does not support drift checks; they are supported by the PostgreSQL plugin", config.PluginName)
+	}
+
+	connURL, err := rootConnectionURL(files, name, config, db)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := sql.Open("postgres", connURL)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return &sqlExpirationReader{db: sqlDB, query: query}, nil
+}
+
+func (r *sqlExpirationReader) userExpiration(ctx context.Context, username string) (time.Time, bool, error) {
+	var epoch sql.NullFloat64
+	switch err := r.db.QueryRowContext(ctx, r.query, username).Scan(&epoch); {
+	case err == sql.ErrNoRows:
+		return time.Time{}, false, nil
+	case err != nil:
+		return time.Time{}, false, err
+	case !epoch.Valid || math.IsInf(epoch.Float64, 0):
+		return time.Time{}, true, nil
+	}
+	sec, frac := math.Modf(epoch.Float64)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true, nil
+}
+
+func (r *sqlExpirationReader) Close() error {
+	return r.db.Close()
+}
+
+// driftReport is the outcome of the last drift check of a connection.
+type driftReport struct {
+	CheckedAt time.Time   `json:"checked_at"`
+	Checked   int         `json:"checked"`
+	Drifted   []userDrift `json:"drifted,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// userDrift is a user whose expiration in the database doesn't match its
+// lease.
+type userDrift struct {
+	Role               string    `json:"role"`
+	Username           string    `json:"username"`
+	LeaseExpiration    time.Time `json:"lease_expiration"`
+	DatabaseExpiration time.Time `json:"database_expiration,omitempty"`
+	Missing            bool      `json:"missing,omitempty"`
+}
+
+func (d userDrift) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"role":             d.Role,
+		"username":         d.Username,
+		"lease_expiration": d.LeaseExpiration.Format(time.RFC3339),
+	}
+	switch {
+	case d.Missing:
+		data["problem"] = "missing from the database"
+	case d.DatabaseExpiration.IsZero():
+		data["problem"] = "does not expire in the database"
+	case d.DatabaseExpiration.Before(d.LeaseExpiration):
+		data["problem"] = "expires before its lease"
+	default:
+		data["problem"] = "expires after its lease"
+	}
+	if !d.DatabaseExpiration.IsZero() {
+		data["database_expiration"] = d.DatabaseExpiration.Format(time.RFC3339)
+	}
+	return data
+}
+
+// drifted returns whether a user expiring at dbExpiration in the database
+// drifted from its lease expiring at leaseExpiration.
+func drifted(leaseExpiration, dbExpiration time.Time) bool {
+	if dbExpiration.IsZero() {
+		return true
+	}
+	return dbExpiration.Before(leaseExpiration) || dbExpiration.After(leaseExpiration.Add(driftTolerance))
+}
+
+func pathDrift(b *databaseBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: driftPath + framework.GenericNameRegex("name") + "$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the database connection.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathDriftRead,
+				Summary:  "Read the last drift check of a connection's users.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"checked_at": "2020-01-01T12:00:00Z",
+								"checked":    12,
+								"drifted": []map[string]interface{}{{
+									"role":                "readonly",
+									"username":            "v-root-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987",
+									"lease_expiration":    "2020-01-01T13:00:00Z",
+									"database_expiration": "2020-01-01T12:05:00Z",
+									"problem":             "expires before its lease",
+								}},
+							},
+						},
+					}},
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathDriftWrite,
+				Summary:  "Check the connection's users for drift now.",
+			},
+		},
+
+		HelpSynopsis:    pathDriftHelpSyn,
+		HelpDescription: pathDriftHelpDesc,
+	}
+}
+
+func (b *databaseBackend) pathDriftRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	report, err := getDriftReport(ctx, req.Storage, name)
+	if err != nil || report == nil {
+		return nil, err
+	}
+	return driftResponse(report), nil
+}
+
+func (b *databaseBackend) pathDriftWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	config, err := b.DatabaseConfig(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if _, ok := userExpirationQueries[config.PluginName]; !ok {
+		return logical.ErrorResponse(fmt.Sprintf("%s does not support drift checks; they are supported by the PostgreSQL plugin", config.PluginName)), nil
+	}
+
+	report := b.checkDrift(ctx, req.Storage, name, config)
+	if err := putDriftReport(ctx, req.Storage, name, report); err != nil {
+		return nil, err
+	}
+	return driftResponse(report), nil
+}
+
+func driftResponse(report *driftReport) *logical.Response {
+	drifted := make([]map[string]interface{}, 0, len(report.Drifted))
+	for _, d := range report.Drifted {
+		drifted = append(drifted, d.data())
+	}
+	data := map[string]interface{}{
+		"checked_at": report.CheckedAt.Format(time.RFC3339),
+		"checked":    report.Checked,
+		"drifted":    drifted,
+	}
+	if report.Error != "" {
+		data["error"] = report.Error
+	}
+	return &logical.Response{Data: data}
+}
+
+func getDriftReport(ctx context.Context, s logical.Storage, name string) (*driftReport, error) {
+	entry, err := s.Get(ctx, driftPath+name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	var report driftReport
+	if err := entry.DecodeJSON(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func putDriftReport(ctx context.Context, s logical.Storage, name string, report *driftReport) error {
+	entry, err := logical.StorageEntryJSON(driftPath+name, report)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// checkDrift compares the expirations in the database of the users of a
+// connection with unexpired leases to those of their leases. Errors reading
+// the database are kept in the report rather than returned, so that they
+// are visible when reading it.
+func (b *databaseBackend) checkDrift(ctx context.Context, s logical.Storage, name string, config *DatabaseConfig) *driftReport {
+	now := b.clock.Now()
+	report := &driftReport{CheckedAt: now}
+	fail := func(err error) *driftReport {
+		report.Error = err.Error()
+		return report
+	}
+
+	db, err := b.GetConnection(ctx, s, name)
+	if err != nil {
+		return fail(err)
+	}
+	reader, err := newUserExpirationReader(b.connectionFiles, name, config, db)
+	if err != nil {
+		return fail(err)
+	}
+	defer reader.Close()
+
+	roles, err := s.List(ctx, databaseActiveUserPath)
+	if err != nil {
+		return fail(err)
+	}
+	for _, roleName := range roles {
+		roleName = strings.TrimSuffix(roleName, "/")
+		role, err := b.Role(ctx, s, roleName)
+		if err != nil {
+			return fail(err)
+		}
+		if role == nil || role.DBName != name || role.SkipRevocation {
+			continue
+		}
+
+		users, err := activeUsers(ctx, s, roleName, now)
+		if err != nil {
+			return fail(err)
+		}
+		for _, user := range users {
+			dbExpiration, exists, err := reader.userExpiration(ctx, user.Username)
+			if err != nil {
+				return fail(fmt.Errorf("failed to read the expiration of %q: %s", user.Username, err))
+			}
+			report.Checked++
+			if exists && !drifted(user.Expiration, dbExpiration) {
+				continue
+			}
+			report.Drifted = append(report.Drifted, userDrift{
+				Role:               roleName,
+				Username:           user.Username,
+				LeaseExpiration:    user.Expiration,
+				DatabaseExpiration: dbExpiration,
+				Missing:            !exists,
+			})
+		}
+	}
+
+	if len(report.Drifted) > 0 {
+		b.Logger().Warn("users have drifted from their leases", "connection", name, "drifted", len(report.Drifted), "checked", report.Checked)
+	}
+	metrics.SetGaugeWithLabels([]string{"database", "drift", "users"}, float32(len(report.Drifted)), withMountLabels([]metrics.Label{
+		{Name: "db_name", Value: name},
+	}, b.mountLabels))
+	return report
+}
+
+// checkConnectionsForDrift checks the connections with a drift_check_interval
+// whose last check is older than it.
+func (b *databaseBackend) checkConnectionsForDrift(ctx context.Context, req *logical.Request) error {
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	names, err := req.Storage.List(ctx, "config/")
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	now := b.clock.Now()
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		config, err := b.DatabaseConfig(ctx, req.Storage, name)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if config.DriftCheckInterval <= 0 {
+			continue
+		}
+
+		last, err := getDriftReport(ctx, req.Storage, name)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if last != nil && now.Before(last.CheckedAt.Add(config.DriftCheckInterval)) {
+			continue
+		}
+
+		report := b.checkDrift(ctx, req.Storage, name, config)
+		if report.Error != "" {
+			b.Logger().Error("failed to check users for drift", "connection", name, "error", report.Error)
+		}
+		if err := putDriftReport(ctx, req.Storage, name, report); err != nil {
+			result = multierror.Append(result, fmt.Errorf("connection %q: %s", name, err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+const pathDriftHelpSyn = `
+Check that the connection's users expire in the database with their leases.
+`
+
+const pathDriftHelpDesc = `
+Reading "drift/<name>" returns the last drift check of the connection called
+name. Each check compares the expiration in the database of every user with an
+unexpired lease, such as "rolvaliduntil" in PostgreSQL, to the expiration of its
+lease, catching renewals that failed in the database after Vault renewed the
+lease. Users are reported as "drifted" if they are missing from the database,
+don't expire in it, or expire before their lease or more than a minute after
+it, which leaves room for the few seconds added to each expiration.
+
+Connections with a "drift_check_interval" are checked by the periodic function
+at that interval, and writing to "drift/<name>" checks the connection now. The
+number of drifted users is logged and reported in the "database.drift.users"
+metric. Checks are supported by the PostgreSQL plugin.
+`
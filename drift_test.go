@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeExpirationReader returns the expirations of the users in expirations,
+// treating others as missing.
+type fakeExpirationReader struct {
+	expirations map[string]time.Time
+	reads       *int
+}
+
+func (f *fakeExpirationReader) userExpiration(ctx context.Context, username string) (time.Time, bool, error) {
+	*f.reads++
+	expiration, ok := f.expirations[username]
+	return expiration, ok, nil
+}
+
+func (f *fakeExpirationReader) Close() error {
+	return nil
+}
+
+func TestBackend_drift(t *testing.T) {
+	expirations := map[string]time.Time{}
+	var reads int
+	defer func(f func(*connectionFiles, string, *DatabaseConfig, *dbPluginInstance) (userExpirationReader, error)) {
+		newUserExpirationReader = f
+	}(newUserExpirationReader)
+	newUserExpirationReader = func(files *connectionFiles, name string, config *DatabaseConfig, db *dbPluginInstance) (userExpirationReader, error) {
+		return &fakeExpirationReader{expirations: expirations, reads: &reads}, nil
+	}
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":       "sample_connection_url",
+		"plugin_name":          "postgresql-database-plugin",
+		"verify_connection":    false,
+		"allowed_roles":        []string{"*"},
+		"drift_check_interval": "1h",
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(logical.CreateOperation, "roles/app", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';`,
+		"default_ttl":         "1h",
+	})
+	for i := 0; i < 3; i++ {
+		request(logical.ReadOperation, "creds/app", nil)
+	}
+
+	leaseExpiration := clock.now.Add(time.Hour)
+	expirations["user-1"] = leaseExpiration.Add(5 * time.Second)
+	expirations["user-2"] = leaseExpiration.Add(-50 * time.Minute)
+
+	ctx := namespace.RootContext(nil)
+	if err := b.checkConnectionsForDrift(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	resp := request(logical.ReadOperation, "drift/plugin-test", nil)
+	drifted := resp.Data["drifted"].([]map[string]interface{})
+	if resp.Data["checked"] != 3 || resp.Data["checked_at"] != "2020-01-01T12:00:00Z" || len(drifted) != 2 {
+		t.Fatalf("unexpected report %#v", resp.Data)
+	}
+	if drifted[0]["username"] != "user-2" || drifted[0]["problem"] != "expires before its lease" || drifted[0]["database_expiration"] != "2020-01-01T12:10:00Z" {
+		t.Fatalf("unexpected drift %#v", drifted[0])
+	}
+	if drifted[1]["username"] != "user-3" || drifted[1]["problem"] != "missing from the database" {
+		t.Fatalf("unexpected drift %#v", drifted[1])
+	}
+
+	// Connections are only checked again after their interval
+	clock.advance(30 * time.Minute)
+	if err := b.checkConnectionsForDrift(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if reads != 3 {
+		t.Fatalf("expected no check within the interval, got %d reads", reads)
+	}
+
+	// Checks can be run on demand
+	expirations["user-2"] = leaseExpiration.Add(5 * time.Second)
+	expirations["user-3"] = leaseExpiration.Add(5 * time.Second)
+	resp = request(logical.UpdateOperation, "drift/plugin-test", nil)
+	if drifted := resp.Data["drifted"].([]map[string]interface{}); resp.Data["checked"] != 3 || len(drifted) != 0 {
+		t.Fatalf("expected no drift, got %#v", resp.Data)
+	}
+}
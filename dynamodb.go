@@ -0,0 +1,410 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/mitchellh/mapstructure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	dynamoDBTypeName = "dynamodb"
+
+	// dynamoDBPolicyName is the name of the inline policy of the IAM users
+	// created by the dynamodb-database-plugin.
+	dynamoDBPolicyName = "vault-dynamodb"
+
+	// The credential types of the dynamodb-database-plugin.
+	dynamoDBIAMUser     = "iam_user"
+	dynamoDBAssumedRole = "assumed_role"
+
+	// minSessionDuration and maxSessionDuration bound the duration of the
+	// credentials of an assumed role.
+	minSessionDuration = 15 * time.Minute
+	maxSessionDuration = 12 * time.Hour
+)
+
+// validateDynamoDBPolicy checks that the creation statement of a role using
+// the dynamodb-database-plugin is an IAM policy granting DynamoDB actions
+// only, so that the plugin can't be used to hand out other AWS access.
+func validateDynamoDBPolicy(stmt string, requireRoles bool) error {
+	var policy struct {
+		Version   string          `json:"Version"`
+		ID        string          `json:"Id"`
+		Statement json.RawMessage `json:"Statement"`
+	}
+	dec := json.NewDecoder(strings.NewReader(stmt))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return fmt.Errorf("statement is not an IAM policy document (%s)", err)
+		}
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON object")
+	}
+
+	var statements []map[string]json.RawMessage
+	if err := json.Unmarshal(policy.Statement, &statements); err != nil {
+		var single map[string]json.RawMessage
+		if err := json.Unmarshal(policy.Statement, &single); err != nil {
+			return errors.New(`"Statement" must be an object or a list of objects`)
+		}
+		statements = append(statements, single)
+	}
+	if len(statements) == 0 {
+		return errors.New(`"Statement" must grant at least one action`)
+	}
+
+	for i, statement := range statements {
+		if _, ok := statement["NotAction"]; ok {
+			return fmt.Errorf(`Statement[%d] uses "NotAction"; list the DynamoDB actions to grant in "Action" instead`, i)
+		}
+		var actions []string
+		if err := json.Unmarshal(statement["Action"], &actions); err != nil {
+			var action string
+			if err := json.Unmarshal(statement["Action"], &action); err != nil {
+				return fmt.Errorf(`Statement[%d] must have an "Action" string or list of strings`, i)
+			}
+			actions = []string{action}
+		}
+		for _, action := range actions {
+			if !strings.HasPrefix(strings.ToLower(action), "dynamodb:") {
+				return fmt.Errorf("Statement[%d] grants %q; only DynamoDB actions may be granted", i, action)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dynamoDBConfig is the connection configuration of the
+// dynamodb-database-plugin. Without an access_key, the credentials of the
+// Vault server's environment or instance profile are used.
+type dynamoDBConfig struct {
+	CredentialType         string `mapstructure:"credential_type"`
+	AccessKey              string `mapstructure:"access_key"`
+	SecretKey              string `mapstructure:"secret_key"`
+	SessionToken           string `mapstructure:"session_token"`
+	Region                 string `mapstructure:"region"`
+	RoleARN                string `mapstructure:"role_arn"`
+	UserPath               string `mapstructure:"user_path"`
+	PermissionsBoundaryARN string `mapstructure:"permissions_boundary_arn"`
+	IAMEndpoint            string `mapstructure:"iam_endpoint"`
+	STSEndpoint            string `mapstructure:"sts_endpoint"`
+}
+
+// newDynamoDBClients creates the AWS clients of a connection. Tests replace
+// it with fakes.
+var newDynamoDBClients = func(config dynamoDBConfig) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+	awsConfig := aws.NewConfig().WithRegion(config.Region)
+	if config.AccessKey != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, config.SessionToken))
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iamConfig, stsConfig := aws.NewConfig(), aws.NewConfig()
+	if config.IAMEndpoint != "" {
+		iamConfig = iamConfig.WithEndpoint(config.IAMEndpoint)
+	}
+	if config.STSEndpoint != "" {
+		stsConfig = stsConfig.WithEndpoint(config.STSEndpoint)
+	}
+	return iam.New(sess, iamConfig), sts.New(sess, stsConfig), nil
+}
+
+// dynamoDB issues AWS credentials scoped to DynamoDB by the IAM policy in the
+// creation statement of a role. Each credential is either the access key of a
+// new IAM user with the policy inline, or the temporary credentials of a
+// session of role_arn, restricted by the policy.
+type dynamoDB struct {
+	sync.RWMutex
+	credsutil.CredentialsProducer
+
+	config dynamoDBConfig
+	iam    iamiface.IAMAPI
+	sts    stsiface.STSAPI
+}
+
+func newDynamoDB() (interface{}, error) {
+	return &dynamoDB{
+		// IAM user names and role session names are at most 64
+		// characters long.
+		CredentialsProducer: &credsutil.SQLCredentialsProducer{
+			DisplayNameLen: 15,
+			RoleNameLen:    15,
+			UsernameLen:    64,
+			Separator:      "-",
+		},
+	}, nil
+}
+
+func (d *dynamoDB) Type() (string, error) {
+	return dynamoDBTypeName, nil
+}
+
+func (d *dynamoDB) Init(ctx context.Context, conf map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	var config dynamoDBConfig
+	if err := mapstructure.WeakDecode(conf, &config); err != nil {
+		return nil, err
+	}
+	if config.CredentialType == "" {
+		config.CredentialType = dynamoDBIAMUser
+	}
+	switch config.CredentialType {
+	case dynamoDBIAMUser:
+		if config.RoleARN != "" {
+			return nil, errors.New("role_arn requires a credential_type of assumed_role")
+		}
+	case dynamoDBAssumedRole:
+		if config.RoleARN == "" {
+			return nil, errors.New("role_arn is required with a credential_type of assumed_role")
+		}
+		if config.UserPath != "" || config.PermissionsBoundaryARN != "" {
+			return nil, errors.New("user_path and permissions_boundary_arn require a credential_type of iam_user")
+		}
+	default:
+		return nil, fmt.Errorf("credential_type must be %s or %s", dynamoDBIAMUser, dynamoDBAssumedRole)
+	}
+	if (config.AccessKey == "") != (config.SecretKey == "") {
+		return nil, errors.New("access_key and secret_key must be set together")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.UserPath == "" {
+		config.UserPath = "/"
+	}
+
+	iamClient, stsClient, err := newDynamoDBClients(config)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	d.config, d.iam, d.sts = config, iamClient, stsClient
+	d.Unlock()
+
+	if verifyConnection {
+		if _, err := stsClient.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+			return nil, fmt.Errorf("error verifying the AWS credentials: %s", err)
+		}
+	}
+
+	return conf, nil
+}
+
+func (d *dynamoDB) Initialize(ctx context.Context, conf map[string]interface{}, verifyConnection bool) error {
+	_, err := d.Init(ctx, conf, verifyConnection)
+	return err
+}
+
+func (d *dynamoDB) clients() (dynamoDBConfig, iamiface.IAMAPI, stsiface.STSAPI) {
+	d.RLock()
+	defer d.RUnlock()
+	return d.config, d.iam, d.sts
+}
+
+// CreateUser returns the access key ID and secret access key of the new
+// credentials as the username and password. The session token of an assumed
+// role is returned as session_token.
+func (d *dynamoDB) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Creation) == 0 {
+		return "", "", dbutil.ErrEmptyCreationStatement
+	}
+
+	name, err := d.GenerateUsername(usernameConfig)
+	if err != nil {
+		return "", "", err
+	}
+	policy := dbutil.QueryHelper(statements.Creation[0], map[string]string{"name": name})
+	if err := validateDynamoDBPolicy(policy, true); err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	config, iamClient, stsClient := d.clients()
+	if config.CredentialType == dynamoDBAssumedRole {
+		return d.assumeRole(ctx, stsClient, config, name, policy, expiration)
+	}
+
+	input := &iam.CreateUserInput{
+		UserName: aws.String(name),
+		Path:     aws.String(config.UserPath),
+	}
+	if config.PermissionsBoundaryARN != "" {
+		input.PermissionsBoundary = aws.String(config.PermissionsBoundaryARN)
+	}
+	if _, err := iamClient.CreateUserWithContext(ctx, input); err != nil {
+		return "", "", awsStatusError(err)
+	}
+
+	key, err := d.createAccessKey(ctx, iamClient, name, policy)
+	if err != nil {
+		// Don't leave a user behind that no lease will revoke
+		if deleteErr := deleteIAMUser(ctx, iamClient, name); deleteErr != nil {
+			err = fmt.Errorf("%s; the IAM user %q could not be deleted: %s", err, name, deleteErr)
+		}
+		return "", "", awsStatusError(err)
+	}
+	return aws.StringValue(key.AccessKeyId), aws.StringValue(key.SecretAccessKey), nil
+}
+
+func (d *dynamoDB) createAccessKey(ctx context.Context, iamClient iamiface.IAMAPI, name, policy string) (*iam.AccessKey, error) {
+	_, err := iamClient.PutUserPolicyWithContext(ctx, &iam.PutUserPolicyInput{
+		UserName:       aws.String(name),
+		PolicyName:     aws.String(dynamoDBPolicyName),
+		PolicyDocument: aws.String(policy),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := iamClient.CreateAccessKeyWithContext(ctx, &iam.CreateAccessKeyInput{UserName: aws.String(name)})
+	if err != nil {
+		return nil, err
+	}
+	return out.AccessKey, nil
+}
+
+// assumeRole returns credentials for a session of the connection's role_arn
+// lasting until expiration, within the 15 minute to 12 hour bounds of STS.
+// The role's maximum session duration must be at least as long as the
+// leases of the role.
+func (d *dynamoDB) assumeRole(ctx context.Context, stsClient stsiface.STSAPI, config dynamoDBConfig, name, policy string, expiration time.Time) (string, string, error) {
+	duration := time.Until(expiration)
+	if duration < minSessionDuration {
+		duration = minSessionDuration
+	}
+	if duration > maxSessionDuration {
+		duration = maxSessionDuration
+	}
+
+	out, err := stsClient.AssumeRoleWithContext(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(config.RoleARN),
+		RoleSessionName: aws.String(name),
+		Policy:          aws.String(policy),
+		DurationSeconds: aws.Int64(int64(duration.Seconds())),
+	})
+	if err != nil {
+		return "", "", awsStatusError(err)
+	}
+
+	setPluginCredentialData(ctx, "session_token", aws.StringValue(out.Credentials.SessionToken))
+	return aws.StringValue(out.Credentials.AccessKeyId), aws.StringValue(out.Credentials.SecretAccessKey), nil
+}
+
+// RenewUser does nothing for IAM users, whose access keys don't expire, and
+// fails for assumed roles, whose sessions can't be extended.
+func (d *dynamoDB) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	if config, _, _ := d.clients(); config.CredentialType == dynamoDBAssumedRole {
+		return status.Error(codes.FailedPrecondition, "the credentials of an assumed role expire with their session and cannot be renewed")
+	}
+	return nil
+}
+
+// RevokeUser deletes the IAM user owning the access key. The credentials of
+// an assumed role can't be revoked, and expire with their session.
+func (d *dynamoDB) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	config, iamClient, _ := d.clients()
+	if config.CredentialType == dynamoDBAssumedRole {
+		return nil
+	}
+
+	out, err := iamClient.GetAccessKeyLastUsedWithContext(ctx, &iam.GetAccessKeyLastUsedInput{AccessKeyId: aws.String(username)})
+	if err != nil {
+		return awsStatusError(err)
+	}
+	if out.UserName == nil {
+		return status.Error(codes.NotFound, fmt.Sprintf("access key %s does not belong to an IAM user", username))
+	}
+	return awsStatusError(deleteIAMUser(ctx, iamClient, aws.StringValue(out.UserName)))
+}
+
+// deleteIAMUser deletes an IAM user along with its access keys and inline
+// policies, which IAM requires to be deleted first.
+func deleteIAMUser(ctx context.Context, iamClient iamiface.IAMAPI, name string) error {
+	keys, err := iamClient.ListAccessKeysWithContext(ctx, &iam.ListAccessKeysInput{UserName: aws.String(name)})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys.AccessKeyMetadata {
+		if _, err := iamClient.DeleteAccessKeyWithContext(ctx, &iam.DeleteAccessKeyInput{UserName: aws.String(name), AccessKeyId: key.AccessKeyId}); err != nil {
+			return err
+		}
+	}
+
+	policies, err := iamClient.ListUserPoliciesWithContext(ctx, &iam.ListUserPoliciesInput{UserName: aws.String(name)})
+	if err != nil {
+		return err
+	}
+	for _, policy := range policies.PolicyNames {
+		if _, err := iamClient.DeleteUserPolicyWithContext(ctx, &iam.DeleteUserPolicyInput{UserName: aws.String(name), PolicyName: policy}); err != nil {
+			return err
+		}
+	}
+
+	_, err = iamClient.DeleteUserWithContext(ctx, &iam.DeleteUserInput{UserName: aws.String(name)})
+	return err
+}
+
+func (d *dynamoDB) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticUser dbplugin.StaticUserConfig) (string, string, error) {
+	return "", "", errors.New("static roles are not supported by the dynamodb-database-plugin")
+}
+
+func (d *dynamoDB) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	return nil, errors.New("root credential rotation is not supported by the dynamodb-database-plugin")
+}
+
+func (d *dynamoDB) Close() error {
+	return nil
+}
+
+// awsErrorCodes maps the error codes of the IAM and STS APIs to the gRPC
+// codes classified by classifyPluginError.
+var awsErrorCodes = map[string]codes.Code{
+	iam.ErrCodeNoSuchEntityException:            codes.NotFound,
+	iam.ErrCodeMalformedPolicyDocumentException: codes.InvalidArgument,
+	iam.ErrCodeLimitExceededException:           codes.FailedPrecondition,
+	iam.ErrCodeEntityAlreadyExistsException:     codes.AlreadyExists,
+	sts.ErrCodePackedPolicyTooLargeException:    codes.InvalidArgument,
+	"ValidationError":                           codes.InvalidArgument,
+	"AccessDenied":                              codes.PermissionDenied,
+	"Throttling":                                codes.Unavailable,
+	iam.ErrCodeServiceFailureException:          codes.Unavailable,
+}
+
+// awsStatusError returns an error of the AWS APIs with the gRPC code of its
+// error code, if it has one.
+func awsStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		if code, ok := awsErrorCodes[awsErr.Code()]; ok {
+			return status.Error(code, awsErr.Error())
+		}
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type fakeIAMUser struct {
+	path     string
+	policies map[string]string
+	keys     []string
+}
+
+// fakeIAM implements the IAM calls made by the dynamodb-database-plugin.
+type fakeIAM struct {
+	iamiface.IAMAPI
+	users map[string]*fakeIAMUser
+	keys  map[string]string
+}
+
+func newFakeIAM() *fakeIAM {
+	return &fakeIAM{users: map[string]*fakeIAMUser{}, keys: map[string]string{}}
+}
+
+func (f *fakeIAM) user(name string) (*fakeIAMUser, error) {
+	user, ok := f.users[name]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "The user with name "+name+" cannot be found.", nil)
+	}
+	return user, nil
+}
+
+func (f *fakeIAM) CreateUserWithContext(ctx aws.Context, input *iam.CreateUserInput, opts ...request.Option) (*iam.CreateUserOutput, error) {
+	f.users[*input.UserName] = &fakeIAMUser{path: *input.Path, policies: map[string]string{}}
+	return &iam.CreateUserOutput{}, nil
+}
+
+func (f *fakeIAM) PutUserPolicyWithContext(ctx aws.Context, input *iam.PutUserPolicyInput, opts ...request.Option) (*iam.PutUserPolicyOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	user.policies[*input.PolicyName] = *input.PolicyDocument
+	return &iam.PutUserPolicyOutput{}, nil
+}
+
+func (f *fakeIAM) CreateAccessKeyWithContext(ctx aws.Context, input *iam.CreateAccessKeyInput, opts ...request.Option) (*iam.CreateAccessKeyOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("AKIA%d", len(f.keys))
+	f.keys[id] = *input.UserName
+	user.keys = append(user.keys, id)
+	return &iam.CreateAccessKeyOutput{AccessKey: &iam.AccessKey{AccessKeyId: aws.String(id), SecretAccessKey: aws.String("secret-" + id)}}, nil
+}
+
+func (f *fakeIAM) GetAccessKeyLastUsedWithContext(ctx aws.Context, input *iam.GetAccessKeyLastUsedInput, opts ...request.Option) (*iam.GetAccessKeyLastUsedOutput, error) {
+	name, ok := f.keys[*input.AccessKeyId]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "The Access Key with id "+*input.AccessKeyId+" cannot be found.", nil)
+	}
+	return &iam.GetAccessKeyLastUsedOutput{UserName: aws.String(name)}, nil
+}
+
+func (f *fakeIAM) ListAccessKeysWithContext(ctx aws.Context, input *iam.ListAccessKeysInput, opts ...request.Option) (*iam.ListAccessKeysOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	out := &iam.ListAccessKeysOutput{}
+	for _, id := range user.keys {
+		out.AccessKeyMetadata = append(out.AccessKeyMetadata, &iam.AccessKeyMetadata{AccessKeyId: aws.String(id)})
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) DeleteAccessKeyWithContext(ctx aws.Context, input *iam.DeleteAccessKeyInput, opts ...request.Option) (*iam.DeleteAccessKeyOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range user.keys {
+		if id == *input.AccessKeyId {
+			user.keys = append(user.keys[:i], user.keys[i+1:]...)
+			break
+		}
+	}
+	delete(f.keys, *input.AccessKeyId)
+	return &iam.DeleteAccessKeyOutput{}, nil
+}
+
+func (f *fakeIAM) ListUserPoliciesWithContext(ctx aws.Context, input *iam.ListUserPoliciesInput, opts ...request.Option) (*iam.ListUserPoliciesOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	out := &iam.ListUserPoliciesOutput{}
+	for name := range user.policies {
+		out.PolicyNames = append(out.PolicyNames, aws.String(name))
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) DeleteUserPolicyWithContext(ctx aws.Context, input *iam.DeleteUserPolicyInput, opts ...request.Option) (*iam.DeleteUserPolicyOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	delete(user.policies, *input.PolicyName)
+	return &iam.DeleteUserPolicyOutput{}, nil
+}
+
+func (f *fakeIAM) DeleteUserWithContext(ctx aws.Context, input *iam.DeleteUserInput, opts ...request.Option) (*iam.DeleteUserOutput, error) {
+	user, err := f.user(*input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.keys) > 0 || len(user.policies) > 0 {
+		return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must delete policies first.", nil)
+	}
+	delete(f.users, *input.UserName)
+	return &iam.DeleteUserOutput{}, nil
+}
+
+// fakeSTS records the roles assumed by the dynamodb-database-plugin.
+type fakeSTS struct {
+	stsiface.STSAPI
+	assumed []*sts.AssumeRoleInput
+}
+
+func (f *fakeSTS) GetCallerIdentityWithContext(ctx aws.Context, input *sts.GetCallerIdentityInput, opts ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::123456789012:user/vault")}, nil
+}
+
+func (f *fakeSTS) AssumeRoleWithContext(ctx aws.Context, input *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+	f.assumed = append(f.assumed, input)
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("ASIA1"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+	}}, nil
+}
+
+func TestBackend_dynamoDB(t *testing.T) {
+	iamClient, stsClient := newFakeIAM(), &fakeSTS{}
+	defer func(previous func(dynamoDBConfig) (iamiface.IAMAPI, stsiface.STSAPI, error)) {
+		newDynamoDBClients = previous
+	}(newDynamoDBClients)
+	newDynamoDBClients = func(config dynamoDBConfig) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+		return iamClient, stsClient, nil
+	}
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "config/users", Data: map[string]interface{}{
+		"plugin_name":   "dynamodb-database-plugin",
+		"access_key":    "AKIAVAULT",
+		"secret_key":    "secret",
+		"user_path":     "/vault/",
+		"allowed_roles": []string{"*"},
+	}})
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/users"})
+	if _, ok := resp.Data["connection_details"].(map[string]interface{})["secret_key"]; ok {
+		t.Fatal("expected the secret key not to be returned")
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "users",
+		"creation_statements": `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": ["dynamodb:GetItem", "s3:GetObject"], "Resource": "*"}]}`,
+	}
+	resp, err := request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a policy granting other actions, got err:%s resp:%#v", err, resp)
+	}
+	policy := `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Action": "dynamodb:*", "Resource": "arn:aws:dynamodb:us-east-1:123456789012:table/{{name}}"}}`
+	role["creation_statements"] = policy
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	keyID := resp.Data["username"].(string)
+	if resp.Data["password"] != "secret-"+keyID {
+		t.Fatalf("expected the access key to be returned, got %#v", resp.Data)
+	}
+	name := iamClient.keys[keyID]
+	user, ok := iamClient.users[name]
+	if !ok || user.path != "/vault/" {
+		t.Fatalf("expected an IAM user to be created, got %#v", iamClient.users)
+	}
+	if expected := strings.Replace(policy, "{{name}}", name, 1); user.policies[dynamoDBPolicyName] != expected {
+		t.Fatalf("expected policy %s, got %s", expected, user.policies[dynamoDBPolicyName])
+	}
+
+	secret := resp.Secret
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	if len(iamClient.users) != 0 {
+		t.Fatalf("expected the IAM user to be deleted, got %#v", iamClient.users)
+	}
+	// A user that was already deleted is revoked
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+
+	// Assumed roles return a session token, and expire rather than being
+	// revoked
+	config := map[string]interface{}{
+		"plugin_name":     "dynamodb-database-plugin",
+		"credential_type": "assumed_role",
+		"user_path":       "/vault/",
+		"allowed_roles":   []string{"*"},
+	}
+	resp, err = request(&logical.Request{Operation: logical.CreateOperation, Path: "config/sessions", Data: config})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected an error without role_arn")
+	}
+	delete(config, "user_path")
+	config["role_arn"] = "arn:aws:iam::123456789012:role/dynamodb"
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "config/sessions", Data: config})
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/session", Data: map[string]interface{}{
+		"db_name":             "sessions",
+		"creation_statements": policy,
+		"default_ttl":         "1m",
+	}})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/session"})
+	if resp.Data["username"] != "ASIA1" || resp.Data["password"] != "secret" || resp.Data["session_token"] != "token" {
+		t.Fatalf("expected the session credentials to be returned, got %#v", resp.Data)
+	}
+	if len(stsClient.assumed) != 1 {
+		t.Fatalf("expected the role to be assumed once, got %d", len(stsClient.assumed))
+	}
+	assumed := stsClient.assumed[0]
+	if *assumed.RoleArn != config["role_arn"] || *assumed.DurationSeconds != int64(minSessionDuration/time.Second) ||
+		*assumed.Policy != strings.Replace(policy, "{{name}}", *assumed.RoleSessionName, 1) {
+		t.Fatalf("unexpected AssumeRole input %s", assumed)
+	}
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: resp.Secret})
+}
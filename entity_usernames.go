@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// entityUsernameHashLen is the number of hex characters of the hash of the
+// entity and role in a deterministic username.
+const entityUsernameHashLen = 16
+
+// entityUsername derives the username of an entity's user of a role, for
+// roles with deterministic_usernames. The role name is shortened to fit the
+// plugin's limit, and left out if even the hash alone doesn't fit.
+func entityUsername(pluginName, entityID, roleName string) string {
+	sum := sha256.Sum256([]byte(entityID + "\x00" + roleName))
+	hash := hex.EncodeToString(sum[:])[:entityUsernameHashLen]
+
+	limit := hostCredentialLimits[pluginName]
+	role := usernameInvalidChars.ReplaceAllString(roleName, "_")
+	if keep := limit - len("v--") - len(hash); keep < len(role) {
+		if keep < 1 {
+			if len(hash) > limit {
+				return hash[:limit]
+			}
+			return hash
+		}
+		role = role[:keep]
+	}
+	return fmt.Sprintf("v-%s-%s", role, hash)
+}
+
+// validateDeterministicUsernames checks that the backend can set the
+// usernames of a connection's users.
+func validateDeterministicUsernames(pluginName string) error {
+	if _, ok := hostCredentialLimits[pluginName]; !ok {
+		return fmt.Errorf("%s does not support deterministic_usernames; they are supported by the PostgreSQL, MySQL and MSSQL plugins", pluginName)
+	}
+	return nil
+}
+
+// produceEntityCredentials produces the credentials of the requesting
+// entity's user of a role with deterministic_usernames, reserving its
+// username. An entity can hold one lease for the role at a time, as each
+// would be for the same user: its index entry is only removed once the lease
+// is revoked, or once it is stale.
+func (b *databaseBackend) produceEntityCredentials(ctx context.Context, req *logical.Request, name string, role *roleEntry, dbConfig *DatabaseConfig, producer credentialsProducer, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (*producedCredentials, error) {
+	username := entityUsername(dbConfig.PluginName, req.EntityID, name)
+
+	user, err := getActiveUser(ctx, req.Storage, name, username)
+	if err != nil {
+		return nil, err
+	}
+	if now := b.clock.Now(); user != nil && !user.stale(now) {
+		if !now.After(user.Expiration) {
+			return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("the entity already holds unexpired credentials for role %q as %q; renew or revoke that lease instead", name, username))
+		}
+		return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("the expired lease of the entity's user %q of role %q has not been revoked yet; retry once it has", username, name))
+	}
+
+	creds := &producedCredentials{}
+	if producer != nil {
+		if creds, err = producer.produce(ctx, usernameConfig, expiration); err != nil {
+			return nil, err
+		}
+	}
+	creds.username = username
+
+	reserved, err := b.reserveUsername(ctx, req.Storage, role.DBName, username, name)
+	if err != nil {
+		return nil, err
+	}
+	if !reserved {
+		return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("the user %q of role %q is being created by another request", username, name))
+	}
+	return creds, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestEntityUsername(t *testing.T) {
+	username := entityUsername("postgresql-database-plugin", "entity-a", "app")
+	if !strings.HasPrefix(username, "v-app-") || len(username) != len("v-app-")+entityUsernameHashLen {
+		t.Fatalf("unexpected username %q", username)
+	}
+	if entityUsername("postgresql-database-plugin", "entity-a", "app") != username {
+		t.Fatal("expected the username to be deterministic")
+	}
+	if entityUsername("postgresql-database-plugin", "entity-b", "app") == username || entityUsername("postgresql-database-plugin", "entity-a", "other") == username {
+		t.Fatal("expected entities and roles to get different usernames")
+	}
+
+	for plugin, limit := range hostCredentialLimits {
+		for _, role := range []string{"app", "k8s_" + strings.Repeat("long-role-name", 10) + "_ns"} {
+			if username := entityUsername(plugin, "entity-a", role); len(username) > limit || !usernameValid.MatchString(username) {
+				t.Fatalf("username %q of %s doesn't fit its limit of %d", username, plugin, limit)
+			}
+		}
+	}
+}
+
+func TestBackend_deterministicUsernames(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(entity string, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+			EntityID:  entity,
+		})
+	}
+	mustRequest := func(entity string, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(entity, op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	for _, name := range []string{"plugin-test", "mongo"} {
+		plugin := "postgresql-database-plugin"
+		if name == "mongo" {
+			plugin = "mongodb-database-plugin"
+		}
+		mustRequest("", logical.CreateOperation, "config/"+name, map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       plugin,
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		})
+	}
+	fake := &recordingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: newHostCredentials(fake),
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":                 "mongo",
+		"creation_statements":     `{"db": "admin", "roles": [{"role": "read"}]}`,
+		"deterministic_usernames": true,
+	}
+	if resp, _ := request("", logical.CreateOperation, "roles/app", role); resp == nil || !resp.IsError() {
+		t.Fatalf("expected deterministic usernames to be rejected for MongoDB, got %#v", resp)
+	}
+	role["db_name"] = "plugin-test"
+	role["creation_statements"] = `DO $$ BEGIN CREATE ROLE "{{name}}"; EXCEPTION WHEN duplicate_object THEN NULL; END $$; ALTER ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`
+	role["revocation_statements"] = `ALTER ROLE "{{name}}" NOLOGIN;`
+	mustRequest("", logical.CreateOperation, "roles/app", role)
+
+	if resp, _ := request("", logical.ReadOperation, "creds/app", nil); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a request without an entity to be refused, got %#v", resp)
+	}
+
+	expected := entityUsername("postgresql-database-plugin", "entity-a", "app")
+	issued := mustRequest("entity-a", logical.ReadOperation, "creds/app", nil)
+	if issued.Data["username"] != expected || !strings.Contains(fake.creation[0], `CREATE ROLE "`+expected+`"`) {
+		t.Fatalf("expected the entity's username %q, got %#v and statements %q", expected, issued.Data, fake.creation)
+	}
+
+	// An entity holds one lease of the role at a time
+	resp, err := request("entity-a", logical.ReadOperation, "creds/app", nil)
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusConflict {
+		t.Fatalf("expected a 409 error, got err:%v resp:%#v", err, resp)
+	}
+	if other := mustRequest("entity-b", logical.ReadOperation, "creds/app", nil); other.Data["username"] == expected {
+		t.Fatalf("expected another entity to get another user, got %#v", other.Data)
+	}
+
+	// Nor once the lease has expired, until it is revoked
+	clock := &fakeClock{now: time.Now()}
+	b.clock = clock
+	clock.advance(25 * time.Hour)
+	resp, err = request("entity-a", logical.ReadOperation, "creds/app", nil)
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusConflict || !strings.Contains(err.Error(), "not been revoked") {
+		t.Fatalf("expected a 409 error while the revocation is pending, got err:%v resp:%#v", err, resp)
+	}
+
+	// The same user is reused once the lease is revoked
+	if _, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{Operation: logical.RevokeOperation, Storage: s, Secret: issued.Secret}); err != nil {
+		t.Fatal(err)
+	}
+	if reissued := mustRequest("entity-a", logical.ReadOperation, "creds/app", nil); reissued.Data["username"] != expected {
+		t.Fatalf("expected the entity's user to be reused, got %#v", reissued.Data)
+	}
+}
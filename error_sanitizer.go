@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/plugins/database/mysql"
+	"github.com/hashicorp/vault/plugins/database/postgresql"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+)
+
+// errorSanitizer removes the secrets of a plugin from the messages of the
+// errors it returns, as dbplugin.DatabaseErrorSanitizerMiddleware does, but
+// keeps the original error for classifyPluginError. The middleware replaces
+// every error with a new one once the connection has a password, so the
+// SQLSTATE or error number of the driver's error would be lost.
+//
+// Only the message of the original error is sanitized: it can be inspected
+// with errors.As, but must not be logged or returned itself.
+type errorSanitizer struct {
+	next      dbplugin.Database
+	secretsFn func() map[string]interface{}
+}
+
+func newErrorSanitizer(next dbplugin.Database, secretsFn func() map[string]interface{}) dbplugin.Database {
+	return &errorSanitizer{
+		next:      next,
+		secretsFn: secretsFn,
+	}
+}
+
+// sanitizedError is an error with the secrets removed from its message.
+type sanitizedError struct {
+	msg string
+	err error
+}
+
+func (e *sanitizedError) Error() string { return e.msg }
+
+func (e *sanitizedError) Unwrap() error { return e.err }
+
+// newMySQL builds the MySQL plugin as mysql.New does, with an errorSanitizer.
+func newMySQL(displayNameLen, roleNameLen, usernameLen int) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		db := &mysql.MySQL{
+			SQLConnectionProducer: &connutil.SQLConnectionProducer{Type: "mysql"},
+			CredentialsProducer: &credsutil.SQLCredentialsProducer{
+				DisplayNameLen: displayNameLen,
+				RoleNameLen:    roleNameLen,
+				UsernameLen:    usernameLen,
+				Separator:      "-",
+			},
+		}
+		return newErrorSanitizer(newMissingUserCheck(db, db.SQLConnectionProducer, mysqlUserExistsQuery), db.SecretValues), nil
+	}
+}
+
+// newPostgreSQL builds the PostgreSQL plugin as postgresql.New does, with an
+// errorSanitizer.
+func newPostgreSQL() (interface{}, error) {
+	db := &postgresql.PostgreSQL{
+		SQLConnectionProducer: &connutil.SQLConnectionProducer{Type: "postgres"},
+		CredentialsProducer: &credsutil.SQLCredentialsProducer{
+			DisplayNameLen: 8,
+			RoleNameLen:    8,
+			UsernameLen:    63,
+			Separator:      "-",
+		},
+	}
+	return newErrorSanitizer(newMissingUserCheck(db, db.SQLConnectionProducer, postgresUserExistsQuery), db.SecretValues), nil
+}
+
+func (s *errorSanitizer) sanitize(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errwrap.ContainsType(err, new(url.Error)) {
+		return &sanitizedError{msg: "unable to parse connection url", err: err}
+	}
+	msg := err.Error()
+	if s.secretsFn != nil {
+		for k, v := range s.secretsFn() {
+			if k == "" {
+				continue
+			}
+			msg = strings.Replace(msg, k, v.(string), -1)
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &sanitizedError{msg: msg, err: err}
+}
+
+func (s *errorSanitizer) Type() (string, error) {
+	dbType, err := s.next.Type()
+	return dbType, s.sanitize(err)
+}
+
+func (s *errorSanitizer) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	username, password, err := s.next.CreateUser(ctx, statements, usernameConfig, expiration)
+	return username, password, s.sanitize(err)
+}
+
+func (s *errorSanitizer) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	return s.sanitize(s.next.RenewUser(ctx, statements, username, expiration))
+}
+
+func (s *errorSanitizer) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	return s.sanitize(s.next.RevokeUser(ctx, statements, username))
+}
+
+func (s *errorSanitizer) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	conf, err := s.next.RotateRootCredentials(ctx, statements)
+	return conf, s.sanitize(err)
+}
+
+func (s *errorSanitizer) GenerateCredentials(ctx context.Context) (string, error) {
+	password, err := s.next.GenerateCredentials(ctx)
+	return password, s.sanitize(err)
+}
+
+func (s *errorSanitizer) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticConfig dbplugin.StaticUserConfig) (string, string, error) {
+	username, password, err := s.next.SetCredentials(ctx, statements, staticConfig)
+	return username, password, s.sanitize(err)
+}
+
+func (s *errorSanitizer) Init(ctx context.Context, conf map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	saveConf, err := s.next.Init(ctx, conf, verifyConnection)
+	return saveConf, s.sanitize(err)
+}
+
+func (s *errorSanitizer) Initialize(ctx context.Context, conf map[string]interface{}, verifyConnection bool) error {
+	_, err := s.Init(ctx, conf, verifyConnection)
+	return err
+}
+
+func (s *errorSanitizer) Close() error {
+	return s.sanitize(s.next.Close())
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// expirationModeNative leaves the expiration of users to the database,
+	// which the creation statements give them, as with VALID UNTIL on
+	// PostgreSQL.
+	expirationModeNative = "native"
+
+	// expirationModeHost has the periodic function revoke the users whose
+	// credentials expired, for databases that can't expire users.
+	expirationModeHost = "host"
+
+	// expirationModeLease leaves the expiration of users to the revocation
+	// of their leases.
+	expirationModeLease = "lease"
+)
+
+// nativeExpirationPlugins are the plugins whose databases expire users
+// themselves.
+var nativeExpirationPlugins = map[string]bool{
+	"postgresql-database-plugin": true,
+	"hana-database-plugin":       true,
+}
+
+// hostExpirationGrace is how long after their credentials expired users are
+// revoked by the backend, so that the revocation of their leases, which
+// also removes them, comes first.
+const hostExpirationGrace = time.Minute
+
+// expirationMode returns how the users of a connection expire: as set by
+// expiration_mode, or natively if the database supports it and by their
+// leases otherwise.
+func (c *DatabaseConfig) expirationMode() string {
+	switch {
+	case c.ExpirationMode != "":
+		return c.ExpirationMode
+	case nativeExpirationPlugins[c.PluginName]:
+		return expirationModeNative
+	default:
+		return expirationModeLease
+	}
+}
+
+// validateExpirationMode returns an error suitable for the user if a
+// connection of the plugin can't use the expiration mode.
+func validateExpirationMode(pluginName, mode string) error {
+	switch mode {
+	case "", expirationModeHost, expirationModeLease:
+		return nil
+	case expirationModeNative:
+		if !nativeExpirationPlugins[pluginName] {
+			return fmt.Errorf("%s can't expire users itself; use %q for the backend to revoke them", pluginName, expirationModeHost)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid mode %q; must be %q, %q or %q", mode, expirationModeNative, expirationModeHost, expirationModeLease)
+	}
+}
+
+// revokeExpiredUsers revokes the users of the connections with host-managed
+// expiration whose credentials expired, in case the revocation of their
+// leases didn't remove them. Users are revoked in the database and removed
+// from the index; the revocation of their leases then finds them gone.
+func (b *databaseBackend) revokeExpiredUsers(ctx context.Context, req *logical.Request) error {
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	roles, err := req.Storage.List(ctx, databaseActiveUserPath)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	hostManaged := map[string]bool{}
+	now := b.clock.Now()
+	for _, roleName := range roles {
+		roleName = strings.TrimSuffix(roleName, "/")
+		// Users stay indexed under the name their leases record, which may
+		// be that of a role since renamed
+		role, _, err := b.leaseRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return err
+		}
+		if role == nil {
+			continue
+		}
+
+		usernames, err := req.Storage.List(ctx, databaseActiveUserPath+roleName+"/")
+		if err != nil {
+			return err
+		}
+		for _, username := range usernames {
+			user, err := getActiveUser(ctx, req.Storage, roleName, username)
+			if err != nil {
+				return err
+			}
+			if user == nil || now.Before(user.Expiration.Add(hostExpirationGrace)) {
+				continue
+			}
+			dbName := user.DBName
+			if dbName == "" {
+				dbName = role.DBName
+			}
+			managed, ok := hostManaged[dbName]
+			if !ok {
+				config, err := b.DatabaseConfig(ctx, req.Storage, dbName)
+				if err != nil {
+					result = multierror.Append(result, err)
+					continue
+				}
+				managed = config.expirationMode() == expirationModeHost
+				hostManaged[dbName] = managed
+			}
+			if !managed {
+				continue
+			}
+			if err := b.revokeIndexedUser(ctx, req.Storage, roleName, role, user); err != nil {
+				b.Logger().Error("failed to revoke an expired user", "role", roleName, "username", username, "error", err)
+				result = multierror.Append(result, fmt.Errorf("role %q user %q: %s", roleName, username, err))
+				continue
+			}
+
+			b.Logger().Warn("revoked an expired user", "role", roleName, "db_name", dbName, "username", username, "expiration", user.Expiration.Format(time.RFC3339))
+			metrics.IncrCounterWithLabels([]string{"database", "host_expiration", "revocation"}, 1, withMountLabels([]metrics.Label{
+				{Name: "db_name", Value: dbName},
+				{Name: "role", Value: roleName},
+			}, b.mountLabels))
+		}
+	}
+	return result.ErrorOrNil()
+}
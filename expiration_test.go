@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDatabaseConfig_expirationMode(t *testing.T) {
+	for _, tc := range []struct {
+		config   DatabaseConfig
+		expected string
+	}{
+		{DatabaseConfig{PluginName: "postgresql-database-plugin"}, expirationModeNative},
+		{DatabaseConfig{PluginName: "mysql-database-plugin"}, expirationModeLease},
+		{DatabaseConfig{PluginName: "postgresql-database-plugin", ExpirationMode: expirationModeHost}, expirationModeHost},
+	} {
+		if mode := tc.config.expirationMode(); mode != tc.expected {
+			t.Fatalf("expected %q for %#v, got %q", tc.expected, tc.config, mode)
+		}
+	}
+
+	if err := validateExpirationMode("mysql-database-plugin", expirationModeNative); err == nil {
+		t.Fatal("expected native expiration to be rejected for mysql")
+	}
+	if err := validateExpirationMode("hana-database-plugin", expirationModeNative); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackend_hostExpiration(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Now()}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	connection := func(name, pluginName, mode string) *logical.Response {
+		return request(logical.UpdateOperation, "config/"+name, map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       pluginName,
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+			"expiration_mode":   mode,
+		})
+	}
+
+	if resp := connection("mysql", "mysql-database-plugin", expirationModeNative); resp == nil || !resp.IsError() {
+		t.Fatalf("expected native expiration to be rejected for mysql, got %#v", resp)
+	}
+	if resp := connection("plugin-test", "postgresql-database-plugin", expirationModeHost); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	if resp := connection("native", "postgresql-database-plugin", ""); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	if resp := request(logical.ReadOperation, "config/native", nil); resp.Data["expiration_mode"] != expirationModeNative {
+		t.Fatalf("expected the native mode to be the default for postgres, got %#v", resp)
+	}
+
+	host := &fakeIssuingDatabase{}
+	native := &fakeIssuingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{Database: host, name: "plugin-test", id: "fake"}
+	b.connections["native"] = &dbPluginInstance{Database: native, name: "native", id: "fake"}
+	for role, db := range map[string]string{"host": "plugin-test", "native": "native"} {
+		if resp := request(logical.UpdateOperation, "roles/"+role, map[string]interface{}{
+			"db_name":             db,
+			"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			"default_ttl":         "30m",
+		}); resp != nil && resp.IsError() {
+			t.Fatalf("unexpected error: %#v", resp)
+		}
+		if resp := request(logical.ReadOperation, "creds/"+role, nil); resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+	}
+
+	ctx := namespace.RootContext(nil)
+	clock.advance(30 * time.Minute)
+	if err := b.revokeExpiredUsers(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(host.revoked) != 0 {
+		t.Fatalf("expected no revocation within the grace period, got %v", host.revoked)
+	}
+
+	clock.advance(hostExpirationGrace)
+	if err := b.revokeExpiredUsers(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(host.revoked) != 1 || host.revoked[0] != "user-1" {
+		t.Fatalf("expected the expired user to be revoked, got %v", host.revoked)
+	}
+	if user, err := getActiveUser(ctx, s, "host", "user-1"); err != nil || user != nil {
+		t.Fatalf("expected the revoked user to be removed from the index, got %#v, %v", user, err)
+	}
+	if len(native.revoked) != 0 {
+		t.Fatalf("expected the database to expire its own users, got %v", native.revoked)
+	}
+
+	// Users are revoked as they were issued, on their connection, after the
+	// role is renamed and changed, including the users of service accounts
+	entry, err := logical.StorageEntryJSON("serviceaccount/default/s-ledger", saCacheObject{Keyspace: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"creds/host", "creds/k8s_host_s-ledger_default"} {
+		if resp := request(logical.ReadOperation, path, nil); resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+	}
+	if resp := request(logical.UpdateOperation, "roles/host/rename", map[string]interface{}{"new_name": "moved"}); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	if resp := request(logical.UpdateOperation, "roles/moved", map[string]interface{}{
+		"db_name":         "native",
+		"skip_revocation": true,
+	}); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+
+	clock.advance(30*time.Minute + hostExpirationGrace)
+	if err := b.revokeExpiredUsers(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(host.revoked) != 3 {
+		t.Fatalf("expected the users to be revoked on their connection, got %v", host.revoked)
+	}
+	if len(native.revoked) != 0 {
+		t.Fatalf("expected no users to be revoked on the role's new connection, got %v", native.revoked)
+	}
+}
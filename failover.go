@@ -0,0 +1,177 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	log "github.com/hashicorp/go-hclog"
+)
+
+const (
+	// defaultHealthCheckInterval is how often the endpoints of a connection
+	// with fallback_endpoints are checked, unless health_check_interval is
+	// set.
+	defaultHealthCheckInterval = 10 * time.Second
+
+	// healthCheckTimeout bounds how long connecting to an endpoint may take
+	// for it to be considered healthy.
+	healthCheckTimeout = 5 * time.Second
+)
+
+// validateFallbackEndpoints checks the fallback_endpoints of a connection.
+func validateFallbackEndpoints(endpoints []string) error {
+	for _, endpoint := range endpoints {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err == nil && host != "" {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil || host == "" {
+			return fmt.Errorf("fallback endpoint %q must be a host:port address", endpoint)
+		}
+	}
+	return nil
+}
+
+// directDialer dials the database directly, for tunnels that only fail over
+// between endpoints.
+type directDialer struct{}
+
+func (directDialer) Dial(network, address string) (net.Conn, error) {
+	return net.DialTimeout(network, address, healthCheckTimeout)
+}
+
+func (directDialer) Close() error {
+	return nil
+}
+
+// endpointFailover picks the endpoint a tunnel forwards connections to. The
+// endpoints are health checked in order, and the first healthy one is used,
+// so the tunnel fails over when the primary endpoint goes down and fails back
+// when it recovers.
+type endpointFailover struct {
+	name      string
+	endpoints []string
+	dialer    tunnelDialer
+	interval  time.Duration
+	logger    log.Logger
+
+	mountLabels []metrics.Label
+
+	// checkLock serializes health checks, which may be triggered by a failed
+	// connection as well as by the interval.
+	checkLock sync.Mutex
+
+	l      sync.RWMutex
+	active int
+
+	stopCh chan struct{}
+}
+
+func newEndpointFailover(name string, endpoints []string, dialer tunnelDialer, interval time.Duration, logger log.Logger) *endpointFailover {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	return &endpointFailover{
+		name:      name,
+		endpoints: endpoints,
+		dialer:    dialer,
+		interval:  interval,
+		logger:    logger,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// current returns the endpoint connections are forwarded to.
+func (f *endpointFailover) current() string {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	return f.endpoints[f.active]
+}
+
+func (f *endpointFailover) run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	f.check()
+	for {
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+			f.check()
+		}
+	}
+}
+
+func (f *endpointFailover) stop() {
+	close(f.stopCh)
+}
+
+// check makes the first healthy endpoint the active one. If none are
+// healthy, the active endpoint is kept.
+func (f *endpointFailover) check() {
+	f.checkLock.Lock()
+	defer f.checkLock.Unlock()
+
+	for i, endpoint := range f.endpoints {
+		if f.healthy(endpoint) {
+			f.setActive(i)
+			return
+		}
+	}
+	f.logger.Error("no endpoint of the connection is healthy", "connection", f.name, "endpoints", f.endpoints)
+}
+
+// healthy reports whether the endpoint accepts connections within
+// healthCheckTimeout.
+func (f *endpointFailover) healthy(endpoint string) bool {
+	result := make(chan net.Conn, 1)
+	go func() {
+		conn, err := f.dialer.Dial("tcp", endpoint)
+		if err != nil {
+			conn = nil
+		}
+		result <- conn
+	}()
+
+	select {
+	case conn := <-result:
+		if conn == nil {
+			return false
+		}
+		conn.Close()
+		return true
+	case <-time.After(healthCheckTimeout):
+		go func() {
+			if conn := <-result; conn != nil {
+				conn.Close()
+			}
+		}()
+		return false
+	}
+}
+
+func (f *endpointFailover) setActive(i int) {
+	f.l.Lock()
+	previous := f.active
+	f.active = i
+	f.l.Unlock()
+
+	if i == previous {
+		return
+	}
+
+	event := "failover"
+	if i < previous {
+		event = "failback"
+	}
+	f.logger.Warn("switching the endpoint of the connection", "connection", f.name, "event", event, "from", f.endpoints[previous], "to", f.endpoints[i])
+	metrics.IncrCounterWithLabels([]string{"database", "connection", event}, 1, withMountLabels([]metrics.Label{
+		{Name: "connection", Value: f.name},
+		{Name: "endpoint", Value: f.endpoints[i]},
+	}, f.mountLabels))
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+func TestValidateFallbackEndpoints(t *testing.T) {
+	for endpoints, valid := range map[string]bool{
+		"replica:5432":               true,
+		"10.0.0.2:3306,[::1]:3306":   true,
+		"replica":                    false,
+		"replica:5432,postgres://db": false,
+	} {
+		if err := validateFallbackEndpoints(strings.Split(endpoints, ",")); (err == nil) != valid {
+			t.Fatalf("%s: expected valid to be %t, got error %v", endpoints, valid, err)
+		}
+	}
+}
+
+func TestFailoverTunnel(t *testing.T) {
+	primary := testEchoServer(t)
+	primaryAddr := primary.Addr().String()
+	fallback := testEchoServer(t)
+	defer fallback.Close()
+
+	config := &DatabaseConfig{
+		PluginName:          "postgresql-database-plugin",
+		FallbackEndpoints:   []string{fallback.Addr().String()},
+		HealthCheckInterval: time.Hour,
+	}
+	details := map[string]interface{}{
+		"connection_url": "postgres://{{username}}:{{password}}@" + primaryAddr + "/postgres",
+	}
+
+	tunnel, err := openTunnel("test", config, details, log.NewNullLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	testTunnelEcho(t, tunnel)
+	if active := tunnel.failover.current(); active != primaryAddr {
+		t.Fatalf("expected the primary to be active, got %s", active)
+	}
+
+	// A failed connection fails over without waiting for a health check
+	primary.Close()
+	testTunnelEcho(t, tunnel)
+	if active := tunnel.failover.current(); active != fallback.Addr().String() {
+		t.Fatalf("expected the fallback to be active, got %s", active)
+	}
+
+	// The connection fails back once the primary recovers
+	recovered, err := net.Listen("tcp", primaryAddr)
+	if err != nil {
+		t.Skipf("could not listen on the primary's address again: %s", err)
+	}
+	defer recovered.Close()
+	go func() {
+		for {
+			conn, err := recovered.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	tunnel.failover.check()
+	if active := tunnel.failover.current(); active != primaryAddr {
+		t.Fatalf("expected the primary to be active again, got %s", active)
+	}
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fieldErrors collects the validation errors of a write by the path of the
+// field they concern, such as "max_ttl" or "connection_details.port", so
+// that every invalid field is reported in a single response rather than one
+// per attempt.
+type fieldErrors map[string]string
+
+// add records the error of a field, keeping the first if it has several.
+func (e fieldErrors) add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// nonNegative records an error for each of the integer fields given a
+// negative number. Vault refuses negative durations itself.
+func (e fieldErrors) nonNegative(data *framework.FieldData, fields ...string) {
+	for _, field := range fields {
+		if raw, ok := data.GetOk(field); ok && raw.(int) < 0 {
+			e.add(field, "must not be negative")
+		}
+	}
+}
+
+// response returns an error response listing the errors in the order of
+// their fields, or nil if there are none.
+func (e fieldErrors) response() *logical.Response {
+	if len(e) == 0 {
+		return nil
+	}
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+": "+e[field])
+	}
+	return logical.ErrorResponse(strings.Join(messages, "; "))
+}
+
+// sqlConnectionDetailTypes are the typed connection details of the plugins
+// built on connutil.SQLConnectionProducer.
+var sqlConnectionDetailTypes = map[string]framework.FieldType{
+	"max_open_connections":    framework.TypeInt,
+	"max_idle_connections":    framework.TypeInt,
+	"max_connection_lifetime": framework.TypeDurationSecond,
+}
+
+// connectionDetailTypes are the connection details that the builtin plugins
+// decode into numbers, booleans or durations, by plugin, so that values of
+// the wrong type are reported with their field before the plugin fails to
+// decode them. The other details are strings, which any value converts to.
+var connectionDetailTypes = map[string]map[string]framework.FieldType{
+	"postgresql-database-plugin":   sqlConnectionDetailTypes,
+	"mysql-database-plugin":        sqlConnectionDetailTypes,
+	"mysql-aurora-database-plugin": sqlConnectionDetailTypes,
+	"mysql-rds-database-plugin":    sqlConnectionDetailTypes,
+	"mysql-legacy-database-plugin": sqlConnectionDetailTypes,
+	"mariadb-database-plugin":      sqlConnectionDetailTypes,
+	"vitess-database-plugin":       sqlConnectionDetailTypes,
+	"mssql-database-plugin":        sqlConnectionDetailTypes,
+	"hana-database-plugin":         sqlConnectionDetailTypes,
+	"cassandra-database-plugin": {
+		"port":              framework.TypeInt,
+		"protocol_version":  framework.TypeInt,
+		"tls":               framework.TypeBool,
+		"skip_verification": framework.TypeBool,
+		"connect_timeout":   framework.TypeDurationSecond,
+		"socket_keep_alive": framework.TypeDurationSecond,
+	},
+	"influxdb-database-plugin": {
+		"tls":             framework.TypeBool,
+		"connect_timeout": framework.TypeDurationSecond,
+	},
+}
+
+var fieldTypeMessages = map[framework.FieldType]string{
+	framework.TypeInt:            "must be an integer",
+	framework.TypeBool:           "must be true or false",
+	framework.TypeDurationSecond: `must be a duration such as "30s", or a number of seconds`,
+}
+
+// connectionDetails checks the connection details of a connection write,
+// which are the fields the path's schema doesn't define, against the types
+// the connection's plugin decodes them into.
+func (e fieldErrors) connectionDetails(pluginName string, data *framework.FieldData) {
+	types := connectionDetailTypes[pluginName]
+	for field, value := range data.Raw {
+		if _, ok := data.Schema[field]; ok {
+			continue
+		}
+		fieldType, ok := types[field]
+		if !ok {
+			continue
+		}
+		detail := &framework.FieldData{
+			Raw:    map[string]interface{}{field: value},
+			Schema: map[string]*framework.FieldSchema{field: {Type: fieldType}},
+		}
+		if _, _, err := detail.GetOkErr(field); err != nil {
+			e.add("connection_details."+field, fieldTypeMessages[fieldType])
+		}
+	}
+}
+
+// connectionFieldErrors validates the fields of a connection write that can
+// be checked on their own, before the connection is configured from them.
+func connectionFieldErrors(pluginName string, data *framework.FieldData) fieldErrors {
+	errs := fieldErrors{}
+	errs.nonNegative(data, "root_password_history", "rotation_max_retries", "max_concurrent_operations")
+
+	if raw, ok := data.GetOk("root_rotation_period"); ok {
+		if period := raw.(int); period != 0 && period < int(minRootRotationPeriod.Seconds()) {
+			errs.add("root_rotation_period", fmt.Sprintf("must be 0 or at least %s", minRootRotationPeriod))
+		}
+	}
+	if raw, ok := data.GetOk("unix_socket"); ok {
+		if socket := raw.(string); socket != "" && !filepath.IsAbs(socket) {
+			errs.add("unix_socket", "must be an absolute path")
+		}
+	}
+	if raw, ok := data.GetOk("log_level"); ok {
+		if level := strings.ToLower(raw.(string)); level != "" && log.LevelFromString(level) == log.NoLevel {
+			errs.add("log_level", fmt.Sprintf("invalid level %q", level))
+		}
+	}
+	if raw, ok := data.GetOk("expiration_mode"); ok {
+		if err := validateExpirationMode(pluginName, raw.(string)); err != nil {
+			errs.add("expiration_mode", err.Error())
+		}
+	}
+	errs.connectionDetails(pluginName, data)
+	return errs
+}
+
+// roleFieldErrors validates the fields of a role write that can be checked
+// on their own, before the role is updated from them.
+func roleFieldErrors(data *framework.FieldData) fieldErrors {
+	errs := fieldErrors{}
+	errs.nonNegative(data, "max_creds_per_minute", "max_concurrent_users")
+
+	if raw, ok := data.GetOk("ttl_jitter"); ok {
+		if jitter := raw.(int); jitter < 0 || jitter > maxTTLJitter {
+			errs.add("ttl_jitter", fmt.Sprintf("must be between 0 and %d", maxTTLJitter))
+		}
+	}
+	if raw, ok := data.GetOk("credential_templates"); ok {
+		for name, text := range raw.(map[string]string) {
+			if err := validateCredentialTemplate(name, text); err != nil {
+				errs.add("credential_templates."+name, err.Error())
+			}
+		}
+	}
+	if raw, ok := data.GetOk("creation_statements_next_at"); ok {
+		if _, err := parseCreationStatementsNextAt(raw.(string)); err != nil {
+			errs.add("creation_statements_next_at", err.Error())
+		}
+	}
+	if raw, ok := data.GetOk("response_format"); ok {
+		if format := raw.(string); format != responseFormatFlat && format != responseFormatNested {
+			errs.add("response_format", fmt.Sprintf("invalid format %q; must be %q or %q", format, responseFormatFlat, responseFormatNested))
+		}
+	}
+	if raw, ok := data.GetOk("password_delivery"); ok {
+		if delivery := raw.(string); delivery != passwordDeliveryResponse && delivery != passwordDeliveryDeferred {
+			errs.add("password_delivery", fmt.Sprintf("invalid delivery %q; must be %q or %q", delivery, passwordDeliveryResponse, passwordDeliveryDeferred))
+		}
+	}
+	return errs
+}
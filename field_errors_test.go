@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_fieldErrors(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+
+	for _, tc := range []struct {
+		path     string
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			path: "config/plugin-test",
+			data: map[string]interface{}{
+				"connection_url":          "sample_connection_url",
+				"plugin_name":             "postgresql-database-plugin",
+				"verify_connection":       false,
+				"max_open_connections":    "lots",
+				"max_connection_lifetime": "1 hour",
+				"max_idle_connections":    "2",
+				"rotation_max_retries":    -1,
+				"log_level":               "loud",
+			},
+			expected: `connection_details.max_connection_lifetime: must be a duration such as "30s", or a number of seconds; ` +
+				`connection_details.max_open_connections: must be an integer; log_level: invalid level "loud"; rotation_max_retries: must not be negative`,
+		},
+		{
+			path: "config/cassandra",
+			data: map[string]interface{}{
+				"hosts":             "cassandra",
+				"port":              "cql",
+				"tls":               "sometimes",
+				"plugin_name":       "cassandra-database-plugin",
+				"verify_connection": false,
+			},
+			expected: "connection_details.port: must be an integer; connection_details.tls: must be true or false",
+		},
+		{
+			path: "roles/app",
+			data: map[string]interface{}{
+				"db_name":              "plugin-test",
+				"max_creds_per_minute": -60,
+				"ttl_jitter":           500,
+				"response_format":      "xml",
+				"credential_templates": map[string]interface{}{"password": "{{.Password}}", "dsn": "{{.Username"},
+			},
+			expected: `credential_templates.dsn: template: credential_templates["dsn"]:1: unclosed action; ` +
+				`credential_templates.password: can't replace the "password" field; max_creds_per_minute: must not be negative; ` +
+				`response_format: invalid format "xml"; must be "flat" or "nested"; ttl_jitter: must be between 0 and 50`,
+		},
+	} {
+		resp, err := request(tc.path, tc.data)
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected %s to be rejected, got %#v, %v", tc.path, resp, err)
+		}
+		if msg := resp.Error().Error(); msg != tc.expected {
+			t.Fatalf("unexpected errors for %s:\n%s\nexpected:\n%s", tc.path, msg, tc.expected)
+		}
+	}
+
+	// Values the plugins convert are accepted
+	resp, err := request("config/plugin-test", map[string]interface{}{
+		"connection_url":          "sample_connection_url",
+		"plugin_name":             "postgresql-database-plugin",
+		"verify_connection":       false,
+		"allowed_roles":           []string{"app"},
+		"max_open_connections":    "4",
+		"max_connection_lifetime": "30s",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+}
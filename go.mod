@@ -3,8 +3,13 @@ module github.com/monzo/vault-plugin-database-k8s-controller
 go 1.13
 
 require (
+	github.com/armon/go-metrics v0.3.0
+	github.com/aws/aws-sdk-go v1.19.39
+	github.com/denisenkom/go-mssqldb v0.0.0-20190412130859-3b1d194e553a
 	github.com/fatih/structs v1.1.0
+	github.com/go-sql-driver/mysql v1.4.1
 	github.com/go-test/deep v1.0.2
+	github.com/gocql/gocql v0.0.0-20190402132108-0e1d5de854df
 	github.com/hashicorp/errwrap v1.0.0
 	github.com/hashicorp/go-hclog v0.9.2
 	github.com/hashicorp/go-multierror v1.0.0
@@ -15,6 +20,13 @@ require (
 	github.com/lib/pq v1.2.0
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/ory/dockertest v3.3.4+incompatible
+	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
+	golang.org/x/net v0.0.0-20191004110552-13f9640d40b9
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
+	google.golang.org/api v0.5.0
+	google.golang.org/grpc v1.22.0
+	gopkg.in/square/go-jose.v2 v2.3.1
 	k8s.io/api v0.0.0-20191115135540-bbc9463b57e5
 	k8s.io/apimachinery v0.0.0-20191115015347-3c7067801da2
 	k8s.io/client-go v0.0.0-20191115215802-0a8a1d7b7fae
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+)
+
+// hostCredentialLimits maps the plugins that the backend can generate
+// credentials for to the longest username they accept. These plugins only use
+// the credentials they generate to fill in the creation statements, so the
+// backend can fill them in itself instead.
+var hostCredentialLimits = map[string]int{
+	"postgresql-database-plugin":   63,
+	"mysql-database-plugin":        32,
+	"mysql-aurora-database-plugin": 16,
+	"mysql-rds-database-plugin":    16,
+	"mysql-legacy-database-plugin": 16,
+	"mariadb-database-plugin":      mariaDBUsernameLen,
+	"vitess-database-plugin":       vitessUsernameLen,
+	"mssql-database-plugin":        128,
+}
+
+var (
+	// usernameInvalidChars matches the characters that are replaced with an
+	// underscore in the display and role names given to a username_template,
+	// so that usernames can be quoted safely by any statement.
+	usernameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.@-]`)
+	usernameValid        = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// usernameTemplateData is what a username_template is rendered with.
+type usernameTemplateData struct {
+	DisplayName string
+	RoleName    string
+}
+
+var usernameTemplateFuncs = template.FuncMap{
+	"random": func(length int) (string, error) {
+		return credsutil.RandomAlphaNumeric(length, false)
+	},
+	"unix_time": func() string {
+		return strconv.FormatInt(time.Now().Unix(), 10)
+	},
+	"truncate": func(length int, s string) string {
+		if len(s) > length {
+			return s[:length]
+		}
+		return s
+	},
+}
+
+func parseUsernameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("username_template").Funcs(usernameTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username_template: %s", err)
+	}
+	return tmpl, nil
+}
+
+func renderUsername(tmpl *template.Template, usernameConfig dbplugin.UsernameConfig) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, usernameTemplateData{
+		DisplayName: usernameInvalidChars.ReplaceAllString(usernameConfig.DisplayName, "_"),
+		RoleName:    usernameInvalidChars.ReplaceAllString(usernameConfig.RoleName, "_"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render username_template: %s", err)
+	}
+	username := buf.String()
+	if !usernameValid.MatchString(username) {
+		return "", fmt.Errorf("username_template rendered %q; usernames may only contain letters, digits and the characters _.@-", username)
+	}
+	return username, nil
+}
+
+// producedCredentials are the credentials of a new user generated by a
+// credentialsProducer. An empty username or password is left to the plugin to
+// generate.
+type producedCredentials struct {
+	username string
+	password string
+
+	// statementValues are further template variables filled into the
+	// creation statements, such as the user's public key.
+	statementValues map[string]string
+
+	// data is returned to the client alongside the username and password.
+	data map[string]interface{}
+}
+
+// clear drops the produced credentials once they have been copied into the
+// response, so that nothing outlives the request holding them.
+func (c *producedCredentials) clear() {
+	if c == nil {
+		return
+	}
+	c.password = ""
+	c.statementValues = nil
+	c.data = nil
+}
+
+type producedCredentialsKey struct{}
+
+// withProducedCredentials passes the credentials generated for a user to the
+// hostCredentials of a connection's plugin instance.
+func withProducedCredentials(ctx context.Context, creds *producedCredentials) context.Context {
+	if creds == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, producedCredentialsKey{}, creds)
+}
+
+// pluginCredentialData collects what a builtin plugin returns with a new user
+// besides its username and password, such as the session token of temporary
+// AWS credentials. It is returned to the client with the credentials.
+type pluginCredentialData map[string]interface{}
+
+type pluginCredentialDataKey struct{}
+
+// withPluginCredentialData returns a context that builtin plugins can pass
+// further credential data back through, with setPluginCredentialData.
+func withPluginCredentialData(ctx context.Context) (context.Context, pluginCredentialData) {
+	data := pluginCredentialData{}
+	return context.WithValue(ctx, pluginCredentialDataKey{}, data), data
+}
+
+func setPluginCredentialData(ctx context.Context, key string, value interface{}) {
+	if data, ok := ctx.Value(pluginCredentialDataKey{}).(pluginCredentialData); ok {
+		data[key] = value
+	}
+}
+
+// hostCredentials wraps the plugin instance of a connection to create users
+// with the credentials generated by the backend's credentialsProducer, rather
+// than by the plugin. The credentials are filled into the creation statements
+// before they are passed to the plugin. As it sits beneath the
+// statementLogger, the statements are logged with the credentials redacted.
+// They are rendered with dbutil.QueryHelper rather than the backend's
+// statementTemplateCache, as the rendered statements hold the password.
+type hostCredentials struct {
+	dbplugin.Database
+}
+
+func newHostCredentials(db dbplugin.Database) dbplugin.Database {
+	return &hostCredentials{Database: db}
+}
+
+func (h *hostCredentials) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	creds, ok := ctx.Value(producedCredentialsKey{}).(*producedCredentials)
+	if !ok {
+		return h.Database.CreateUser(ctx, statements, usernameConfig, expiration)
+	}
+
+	values := map[string]string{}
+	for k, v := range creds.statementValues {
+		values[k] = v
+	}
+	if creds.username != "" {
+		values["name"] = creds.username
+	}
+	if creds.password != "" {
+		values[passwordPlaceholder] = creds.password
+	}
+
+	creation := make([]string, 0, len(statements.Creation))
+	for _, stmt := range statements.Creation {
+		creation = append(creation, dbutil.QueryHelper(stmt, values))
+	}
+	statements.Creation = creation
+
+	username, password, err := h.Database.CreateUser(ctx, statements, usernameConfig, expiration)
+	if err != nil {
+		return "", "", err
+	}
+	if creds.username != "" {
+		username = creds.username
+	}
+	if creds.password != "" {
+		password = creds.password
+	}
+	return username, password, nil
+}
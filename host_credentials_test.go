@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+func TestHostCredentials(t *testing.T) {
+	fake := &recordingDatabase{}
+	db := newHostCredentials(fake)
+
+	statements := dbplugin.Statements{
+		Creation: []string{`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';`},
+	}
+	produce := func(config *DatabaseConfig, usernameConfig dbplugin.UsernameConfig) *producedCredentials {
+		t.Helper()
+		config.PluginName = "postgresql-database-plugin"
+		producer, err := newCredentialsProducer(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds, err := producer.produce(context.Background(), usernameConfig, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return creds
+	}
+
+	creds := produce(&DatabaseConfig{
+		UsernameTemplate: "v-{{.RoleName}}-{{truncate 5 .DisplayName}}-{{random 10}}",
+		PasswordLength:   24,
+	}, dbplugin.UsernameConfig{DisplayName: "token's", RoleName: "app"})
+	username, password, err := db.CreateUser(withProducedCredentials(context.Background(), creds), statements, dbplugin.UsernameConfig{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(username, "v-app-token-") || len(username) != len("v-app-token-")+10 {
+		t.Fatalf("unexpected username %q", username)
+	}
+	if len(password) != 24 || password == "password" {
+		t.Fatalf("expected a password generated by the backend, got %q", password)
+	}
+	expected := `CREATE ROLE "` + username + `" WITH LOGIN PASSWORD '` + password + `' VALID UNTIL '{{expiration}}';`
+	if len(fake.creation) != 1 || fake.creation[0] != expected {
+		t.Fatalf("expected the credentials to be filled in, got %q", fake.creation)
+	}
+
+	// Without password_length, the plugin generates the password
+	creds = produce(&DatabaseConfig{
+		UsernameTemplate: "{{.RoleName}}-{{.DisplayName}}",
+	}, dbplugin.UsernameConfig{DisplayName: "token", RoleName: "app"})
+	username, password, err = db.CreateUser(withProducedCredentials(context.Background(), creds), statements, dbplugin.UsernameConfig{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "app-token" || password != "password" {
+		t.Fatalf("unexpected credentials %q/%q", username, password)
+	}
+	if !strings.Contains(fake.creation[0], "PASSWORD '{{password}}'") {
+		t.Fatalf("expected the password to be left to the plugin, got %q", fake.creation)
+	}
+
+	// Without produced credentials, the plugin generates them all
+	username, password, err = db.CreateUser(context.Background(), statements, dbplugin.UsernameConfig{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(username, "user-") || password != "password" || fake.creation[0] != statements.Creation[0] {
+		t.Fatalf("expected the plugin to generate the credentials, got %q/%q and %q", username, password, fake.creation)
+	}
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+// statementsHash identifies the statements of a role a user was issued
+// with, after the annotation of a Kubernetes role is filled in and before
+// the request's own values are, so that the users of the same version of a
+// role share it. The hash changes whenever any of the statements do.
+func statementsHash(statements dbplugin.Statements) string {
+	h := sha256.New()
+	for _, group := range [][]string{statements.Creation, statements.Revocation, statements.Rollback, statements.Renewal} {
+		for _, stmt := range group {
+			h.Write([]byte(stmt))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
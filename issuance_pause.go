@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// issuancePausePath is the storage key of the issuance pauses of the mount.
+const issuancePausePath = "issuance/pause"
+
+// issuancePause is an emergency stop of credential issuance, for example
+// while credentials are suspected to have leaked.
+type issuancePause struct {
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// issuancePauses are the pauses of the whole mount and of single connections.
+// They are read on every credential request, so the backend keeps them in
+// memory in an issuancePauseCache.
+type issuancePauses struct {
+	Mount       *issuancePause            `json:"mount,omitempty"`
+	Connections map[string]*issuancePause `json:"connections,omitempty"`
+}
+
+// err returns the error that credential requests for the named connection are
+// refused with, if issuance is paused for it.
+func (p *issuancePauses) err(connection string) error {
+	var msg string
+	pause := p.Mount
+	if pause != nil {
+		msg = fmt.Sprintf("credential issuance has been paused on this mount since %s", pause.Since.Format(time.RFC3339))
+	} else if pause = p.Connections[connection]; pause != nil {
+		msg = fmt.Sprintf("credential issuance has been paused for connection %q since %s", connection, pause.Since.Format(time.RFC3339))
+	} else {
+		return nil
+	}
+	if pause.Reason != "" {
+		msg += ": " + pause.Reason
+	}
+	return logical.CodedError(http.StatusServiceUnavailable, msg)
+}
+
+// issuancePauseCache keeps the issuance pauses in memory. Unlike the
+// storageCache it also remembers that none are stored, which is the common
+// case. It is cleared when the pauses are written, or when Vault invalidates
+// them after another node wrote them.
+type issuancePauseCache struct {
+	l      sync.Mutex
+	pauses *issuancePauses
+
+	// generation is incremented by every invalidation, so that pauses read
+	// concurrently with a write aren't cached after it.
+	generation uint64
+
+	// writeLock serializes the updates of the pauses, which are read,
+	// modified and written back.
+	writeLock sync.Mutex
+}
+
+func (c *issuancePauseCache) invalidate() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.generation++
+	c.pauses = nil
+}
+
+// issuancePauses returns the issuance pauses of the mount. The result must not
+// be modified.
+func (b *databaseBackend) issuancePauses(ctx context.Context, s logical.Storage) (*issuancePauses, error) {
+	c := &b.pauseCache
+	c.l.Lock()
+	pauses, generation := c.pauses, c.generation
+	c.l.Unlock()
+	if pauses != nil {
+		return pauses, nil
+	}
+
+	pauses, err := readIssuancePauses(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.generation == generation {
+		c.pauses = pauses
+	}
+	return pauses, nil
+}
+
+func readIssuancePauses(ctx context.Context, s logical.Storage) (*issuancePauses, error) {
+	pauses := &issuancePauses{}
+	entry, err := s.Get(ctx, issuancePausePath)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read issuance pauses: {{err}}", err)
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(pauses); err != nil {
+			return nil, err
+		}
+	}
+	return pauses, nil
+}
+
+// issuancePaused returns the error that credential requests for the named
+// connection are refused with, if issuance is paused for it.
+func (b *databaseBackend) issuancePaused(ctx context.Context, s logical.Storage, connection string) error {
+	pauses, err := b.issuancePauses(ctx, s)
+	if err != nil {
+		return err
+	}
+	return pauses.err(connection)
+}
+
+// updateIssuancePauses applies update to the stored issuance pauses, writing
+// them back if it returns true.
+func (b *databaseBackend) updateIssuancePauses(ctx context.Context, s logical.Storage, update func(*issuancePauses) bool) error {
+	b.pauseCache.writeLock.Lock()
+	defer b.pauseCache.writeLock.Unlock()
+
+	pauses, err := readIssuancePauses(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !update(pauses) {
+		return nil
+	}
+
+	defer b.pauseCache.invalidate()
+	if pauses.Mount == nil && len(pauses.Connections) == 0 {
+		return s.Delete(ctx, issuancePausePath)
+	}
+	entry, err := logical.StorageEntryJSON(issuancePausePath, pauses)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// resumeIssuance ends the pause of the named connection, or of the mount if
+// connection is empty.
+func (b *databaseBackend) resumeIssuance(ctx context.Context, s logical.Storage, connection string) error {
+	return b.updateIssuancePauses(ctx, s, func(pauses *issuancePauses) bool {
+		if connection == "" {
+			if pauses.Mount == nil {
+				return false
+			}
+			pauses.Mount = nil
+			return true
+		}
+		if _, ok := pauses.Connections[connection]; !ok {
+			return false
+		}
+		delete(pauses.Connections, connection)
+		return true
+	})
+}
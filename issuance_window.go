@@ -0,0 +1,163 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// issuanceWindow is a weekly period during which a role issues credentials,
+// parsed from the form "<days> <HH:MM>-<HH:MM>", such as "Mon-Fri 08:00-18:00".
+type issuanceWindow struct {
+	days [7]bool
+
+	// start and end are minutes since midnight. A window whose end isn't
+	// after its start runs past midnight into the following day.
+	start, end int
+}
+
+func parseIssuanceWindow(s string) (*issuanceWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("issuance window %q must be of the form <days> <HH:MM>-<HH:MM>", s)
+	}
+
+	w := &issuanceWindow{}
+	for _, days := range strings.Split(fields[0], ",") {
+		if days == "*" {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+		from, to := days, days
+		if i := strings.Index(days, "-"); i >= 0 {
+			from, to = days[:i], days[i+1:]
+		}
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return nil, fmt.Errorf("issuance window %q has an invalid day %q", s, from)
+		}
+		last, ok := weekdays[strings.ToLower(to)]
+		if !ok {
+			return nil, fmt.Errorf("issuance window %q has an invalid day %q", s, to)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("issuance window %q must be of the form <days> <HH:MM>-<HH:MM>", s)
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(times[0]); err != nil {
+		return nil, fmt.Errorf("issuance window %q: %s", s, err)
+	}
+	if w.end, err = parseTimeOfDay(times[1]); err != nil {
+		return nil, fmt.Errorf("issuance window %q: %s", s, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("issuance window %q is empty", s)
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight. 24:00 is allowed as
+// the end of a day.
+func parseTimeOfDay(s string) (int, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	hours, err := strconv.Atoi(s[:i])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	minutes, err := strconv.Atoi(s[i+1:])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// contains returns whether t, in the window's time zone, is within the window.
+func (w *issuanceWindow) contains(t time.Time) bool {
+	day, minute := t.Weekday(), t.Hour()*60+t.Minute()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	previous := (day + 6) % 7
+	return (w.days[day] && minute >= w.start) || (w.days[previous] && minute < w.end)
+}
+
+// nextStart returns the first time after t at which the window opens.
+func (w *issuanceWindow) nextStart(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for i := 0; i <= 7; i++ {
+		day := midnight.AddDate(0, 0, i)
+		start := day.Add(time.Duration(w.start) * time.Minute)
+		if w.days[day.Weekday()] && start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// validateIssuanceWindows checks a role's issuance windows and time zone.
+func validateIssuanceWindows(windows []string, timezone string) error {
+	for _, window := range windows {
+		if _, err := parseIssuanceWindow(window); err != nil {
+			return err
+		}
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid issuance_window_timezone %q: %s", timezone, err)
+	}
+	return nil
+}
+
+// issuanceWindowError returns the error that a credential request for the
+// named role is refused with at now, if the role has issuance windows and none
+// of them contains it.
+func (r *roleEntry) issuanceWindowError(name string, now time.Time) error {
+	if len(r.IssuanceWindows) == 0 {
+		return nil
+	}
+	location, err := time.LoadLocation(r.IssuanceWindowTimezone)
+	if err != nil {
+		return err
+	}
+	now = now.In(location)
+
+	var next time.Time
+	for _, raw := range r.IssuanceWindows {
+		w, err := parseIssuanceWindow(raw)
+		if err != nil {
+			return err
+		}
+		if w.contains(now) {
+			return nil
+		}
+		if start := w.nextStart(now); next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return logical.CodedError(http.StatusForbidden, fmt.Sprintf("role %q only issues credentials during its issuance windows; the next one opens at %s", name, next.Format(time.RFC3339)))
+}
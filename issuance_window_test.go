@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestIssuanceWindow_contains(t *testing.T) {
+	// 2020-01-06 is a Monday
+	at := func(day int, clock string) time.Time {
+		ts, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2020, 1, day, ts.Hour(), ts.Minute(), 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		window string
+		time   time.Time
+		want   bool
+	}{
+		{"Mon-Fri 08:00-18:00", at(6, "08:00"), true},
+		{"Mon-Fri 08:00-18:00", at(6, "18:00"), false},
+		{"Mon-Fri 08:00-18:00", at(4, "12:00"), false},
+		{"Sat,Sun 10:00-16:00", at(5, "12:00"), true},
+		{"fri-mon 00:00-24:00", at(5, "23:59"), true},
+		{"fri-mon 00:00-24:00", at(7, "12:00"), false},
+		{"* 22:00-02:00", at(6, "23:00"), true},
+		{"* 22:00-02:00", at(7, "01:59"), true},
+		{"* 22:00-02:00", at(7, "02:00"), false},
+		{"Mon 22:00-02:00", at(7, "01:00"), true},
+		{"Mon 22:00-02:00", at(6, "01:00"), false},
+	}
+	for _, c := range cases {
+		w, err := parseIssuanceWindow(c.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.contains(c.time); got != c.want {
+			t.Errorf("%q contains %s: got %t, want %t", c.window, c.time, got, c.want)
+		}
+	}
+
+	for _, invalid := range []string{"Mon-Fri", "Mon-Fri 08:00", "Funday 08:00-18:00", "* 8-18", "* 08:00-25:00", "* 08:00-08:00"} {
+		if _, err := parseIssuanceWindow(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestBackend_issuanceWindows(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	// Monday 2020-01-06 at 17:30 UTC
+	clock := &fakeClock{now: time.Date(2020, 1, 6, 17, 30, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":                  "plugin-test",
+		"creation_statements":      `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"issuance_windows":         []string{"Mon-Fri 08:00-17:00"},
+		"issuance_window_timezone": "Mars/Olympus_Mons",
+	}
+	if resp, _ := request(logical.CreateOperation, "roles/reporting", role); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid time zone to be rejected, got %#v", resp)
+	}
+	role["issuance_window_timezone"] = "America/New_York"
+	mustRequest(logical.CreateOperation, "roles/reporting", role)
+
+	// 12:30 in New York
+	mustRequest(logical.ReadOperation, "creds/reporting", nil)
+
+	// Outside of the window, which opens again on Tuesday at 08:00 New York time
+	clock.advance(5 * time.Hour)
+	_, err := request(logical.ReadOperation, "creds/reporting", nil)
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusForbidden {
+		t.Fatalf("expected the request to be forbidden, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "2020-01-07T08:00:00-05:00") {
+		t.Fatalf("expected the error to say when the next window opens: %v", err)
+	}
+
+	mustRequest(logical.UpdateOperation, "roles/reporting", map[string]interface{}{
+		"issuance_windows": []string{"Mon-Fri 08:00-17:00", "* 17:00-20:00"},
+	})
+	resp := mustRequest(logical.ReadOperation, "roles/reporting", nil)
+	if len(resp.Data["issuance_windows"].([]string)) != 2 || resp.Data["issuance_window_timezone"] != "America/New_York" {
+		t.Fatalf("unexpected role %#v", resp.Data)
+	}
+	mustRequest(logical.ReadOperation, "creds/reporting", nil)
+
+	// Clearing the windows issues credentials at any time
+	clock.advance(3 * time.Hour)
+	if _, err := request(logical.ReadOperation, "creds/reporting", nil); err == nil {
+		t.Fatal("expected the request to be forbidden")
+	}
+	mustRequest(logical.UpdateOperation, "roles/reporting", map[string]interface{}{"issuance_windows": []string{}})
+	mustRequest(logical.ReadOperation, "creds/reporting", nil)
+}
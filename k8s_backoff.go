@@ -0,0 +1,121 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchInitialBackoff is how long the watch of service accounts waits
+// before it is restarted, doubled every time it fails in a row.
+const watchInitialBackoff = time.Second
+
+// defaultWatchMaxBackoff caps the wait between restarts of a failing watch
+// if watch_max_backoff isn't set.
+const defaultWatchMaxBackoff = 5 * time.Minute
+
+// maxSyncChangeIntervalFactor caps how many times sync_change_interval the
+// changes of a flapping service account are held back for.
+const maxSyncChangeIntervalFactor = 32
+
+// nextWatchBackoff doubles the wait before restarting a watch that failed
+// again, up to max.
+func nextWatchBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// runReflector lists and watches service accounts until stopCh is closed.
+// Unlike cache.Reflector's Run, which retries every second, a watch that
+// fails, such as when the API server is unreachable, is retried with
+// exponential backoff and jitter, so that many Vault nodes don't retry in
+// step. The backoff is reset once a watch lasts longer than it.
+func (b *databaseBackend) runReflector(reflector *cache.Reflector, maxBackoff time.Duration, stopCh <-chan struct{}) {
+	backoff := watchInitialBackoff
+	for {
+		started := time.Now()
+		err := reflector.ListAndWatch(stopCh)
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		if time.Since(started) > backoff {
+			backoff = watchInitialBackoff
+		}
+		delay := wait.Jitter(backoff, 0.5)
+		if err != nil {
+			b.logger.Warn("watch of service accounts failed; retrying", "backoff", delay.String(), "error", err)
+			backoff = nextWatchBackoff(backoff, maxBackoff)
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// appliedServiceAccountChange is the last change applied to a service
+// account, and how long the next is held back for.
+type appliedServiceAccountChange struct {
+	appliedAt time.Time
+	interval  time.Duration
+}
+
+// serviceAccountChangeLimiter holds back the changes to a service account
+// that was changed less than sync_change_interval ago, so that a flapping
+// annotation doesn't change its roles every sync. The interval doubles for
+// each change made within twice the last one, up to
+// maxSyncChangeIntervalFactor times sync_change_interval. Changes are kept
+// per node and are not persisted.
+type serviceAccountChangeLimiter struct {
+	l       sync.Mutex
+	applied map[string]appliedServiceAccountChange
+}
+
+// heldUntil returns when a change to the service account stored at key may
+// next be applied, and whether that is after now.
+func (c *serviceAccountChangeLimiter) heldUntil(key string, now time.Time, interval time.Duration) (time.Time, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	last, ok := c.applied[key]
+	if !ok || interval <= 0 {
+		return time.Time{}, false
+	}
+	until := last.appliedAt.Add(last.interval)
+	return until, now.Before(until)
+}
+
+// record notes that a change to the service account stored at key was
+// applied at now.
+func (c *serviceAccountChangeLimiter) record(key string, now time.Time, interval time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if interval <= 0 {
+		delete(c.applied, key)
+		return
+	}
+	if c.applied == nil {
+		c.applied = make(map[string]appliedServiceAccountChange)
+	}
+
+	next := interval
+	if last, ok := c.applied[key]; ok && now.Before(last.appliedAt.Add(2*last.interval)) {
+		next = last.interval * 2
+		if max := interval * maxSyncChangeIntervalFactor; next > max {
+			next = max
+		}
+	}
+	c.applied[key] = appliedServiceAccountChange{appliedAt: now, interval: next}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextWatchBackoff(t *testing.T) {
+	backoff := watchInitialBackoff
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if backoff = nextWatchBackoff(backoff, 10*time.Second); backoff != expected {
+			t.Fatalf("expected %s, got %s", expected, backoff)
+		}
+	}
+}
+
+func TestServiceAccountChangeLimiter(t *testing.T) {
+	var c serviceAccountChangeLimiter
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, held := c.heldUntil("serviceaccount/default/s-ledger", now, time.Minute); held {
+		t.Fatal("expected the first change not to be held")
+	}
+	c.record("serviceaccount/default/s-ledger", now, time.Minute)
+	if until, held := c.heldUntil("serviceaccount/default/s-ledger", now.Add(30*time.Second), time.Minute); !held || !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the change to be held for a minute, got %s, %t", until, held)
+	}
+	if _, held := c.heldUntil("serviceaccount/default/s-other", now, time.Minute); held {
+		t.Fatal("expected other service accounts not to be held")
+	}
+
+	// Flapping doubles the interval, up to its cap
+	for i := 1; i <= 10; i++ {
+		now = now.Add(c.applied["serviceaccount/default/s-ledger"].interval)
+		c.record("serviceaccount/default/s-ledger", now, time.Minute)
+	}
+	if interval := c.applied["serviceaccount/default/s-ledger"].interval; interval != maxSyncChangeIntervalFactor*time.Minute {
+		t.Fatalf("expected the interval to reach its cap, got %s", interval)
+	}
+
+	// It is reset once the service account is quiet
+	now = now.Add(2 * maxSyncChangeIntervalFactor * time.Minute)
+	c.record("serviceaccount/default/s-ledger", now, time.Minute)
+	if interval := c.applied["serviceaccount/default/s-ledger"].interval; interval != time.Minute {
+		t.Fatalf("expected the interval to be reset, got %s", interval)
+	}
+}
+
+func TestBackend_syncChangeInterval(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	entry, err := logical.StorageEntryJSON(kubeconfigPath, kubeConfig{
+		KeyspaceAnnotation: "monzo.com/keyspace",
+		DBNameAnnotation:   "monzo.com/cluster",
+		SyncChangeInterval: 10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	annotate := func(keyspace string) {
+		t.Helper()
+		err := b.saCache.Update(&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "s-ledger",
+			Namespace:   "default",
+			Annotations: map[string]string{"monzo.com/keyspace": keyspace},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.syncServiceAccounts(namespace.RootContext(nil), &logical.Request{Storage: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := func() string {
+		t.Helper()
+		entry, err := s.Get(context.Background(), "serviceaccount/default/s-ledger")
+		if err != nil || entry == nil {
+			t.Fatalf("expected a stored annotation, got %#v, %v", entry, err)
+		}
+		var sa saCacheObject
+		if err := entry.DecodeJSON(&sa); err != nil {
+			t.Fatal(err)
+		}
+		return sa.Keyspace
+	}
+
+	annotate("ledger")
+	if keyspace := stored(); keyspace != "ledger" {
+		t.Fatalf("expected the first annotation to be applied, got %q", keyspace)
+	}
+
+	clock.advance(time.Minute)
+	annotate("billing")
+	if keyspace := stored(); keyspace != "ledger" {
+		t.Fatalf("expected the change to be held back, got %q", keyspace)
+	}
+	resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{Operation: logical.ReadOperation, Path: "kubeconfig/sync", Storage: s})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if changes := resp.Data["changes"].([]map[string]interface{}); len(changes) != 1 || changes[0]["held_until"] != "2020-01-01T12:10:00Z" {
+		t.Fatalf("expected the held change to be listed, got %#v", resp.Data)
+	}
+
+	clock.advance(10 * time.Minute)
+	annotate("billing")
+	if keyspace := stored(); keyspace != "billing" {
+		t.Fatalf("expected the change to be applied after the interval, got %q", keyspace)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/logical"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -40,7 +43,11 @@ func (b *databaseBackend) watchServiceAccounts(kubeconfig *kubeConfig) (func(),
 	reflector := cache.NewReflector(lw, &v1.ServiceAccount{}, b.saCache, time.Hour)
 
 	stopCh := make(chan struct{})
-	go reflector.Run(stopCh)
+	maxBackoff := kubeconfig.WatchMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultWatchMaxBackoff
+	}
+	go b.runReflector(reflector, maxBackoff, stopCh)
 
 	return func() {
 		b.logger.Info("Closing reflector")
@@ -111,7 +118,8 @@ func (b *databaseBackend) getServiceAccountAnnotations(ctx context.Context, s lo
 			return "", "", err
 		}
 
-		if config != nil {
+		// In dry-run mode, only applied annotations are used
+		if config != nil && !config.SyncDryRun {
 			keyspace, dbName, err := b.getObjectAnnotations(config.KeyspaceAnnotation, config.DBNameAnnotation, sa)
 			if err != nil {
 				return "", "", err
@@ -146,25 +154,41 @@ type saCacheObject struct {
 	DBName   string `json:"db_name"`
 }
 
-// syncServiceAccounts lists all known service accounts to obtain a mapping of name to annotation
-// and stores this mapping durably in Vault. This allows us to load it immediately on plugin start.
-// Vault should call this function every minute.
-func (b *databaseBackend) syncServiceAccounts(ctx context.Context, req *logical.Request) error {
-	sas := b.saCache.List()
-
-	if len(sas) == 0 {
-		return nil
-	}
+// serviceAccountChange is a change to the stored annotations of a service
+// account that a sync makes.
+type serviceAccountChange struct {
+	Key      string
+	Action   string
+	Current  saCacheObject
+	Previous saCacheObject
+
+	// Gone is set on the deletion of a service account that is no longer in
+	// the cache, as when it or its namespace was deleted, rather than one
+	// whose annotation was removed.
+	Gone bool
+}
 
-	config, err := b.kubeconfig(ctx, req.Storage)
-	if err != nil {
-		return err
+// planServiceAccountSync compares the annotations of the service accounts in
+// the cache with those stored, returning the changes a sync would make to
+// storage. Nothing is changed while the cache is empty, as it may not have
+// been populated yet.
+func (b *databaseBackend) planServiceAccountSync(ctx context.Context, s logical.Storage, config *kubeConfig) ([]serviceAccountChange, error) {
+	sas := b.saCache.List()
+	if len(sas) == 0 || config == nil {
+		return nil, nil
 	}
 
-	b.logger.Debug(fmt.Sprintf("Syncing %d service accounts", len(sas)))
-
-	written := map[string]struct{}{}
+	wanted := map[string]saCacheObject{}
+	present := map[string]bool{}
 	for _, sa := range sas {
+		key, err := keyFunc(sa)
+		if err != nil {
+			return nil, err
+		}
+		// stored in serviceaccount/default/s-ledger
+		key = path.Join("serviceaccount", key)
+		present[key] = true
+
 		keyspace, dbName, err := b.getObjectAnnotations(config.KeyspaceAnnotation, config.DBNameAnnotation, sa)
 		if err != nil {
 			b.logger.Error(fmt.Sprintf("error getting annotation for object: %v", err))
@@ -175,44 +199,184 @@ func (b *databaseBackend) syncServiceAccounts(ctx context.Context, req *logical.
 			continue
 		}
 
-		toStore := saCacheObject{Keyspace: keyspace, DBName: dbName}
+		wanted[key] = saCacheObject{Keyspace: keyspace, DBName: dbName}
+	}
 
-		key, err := keyFunc(sa)
+	keys, err := logical.CollectKeysWithPrefix(ctx, s, "serviceaccount/")
+	if err != nil {
+		return nil, err
+	}
+
+	stored := map[string]saCacheObject{}
+	for _, k := range keys {
+		entry, err := s.Get(ctx, k)
 		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var existing saCacheObject
+		if err := entry.DecodeJSON(&existing); err != nil {
+			return nil, err
+		}
+		stored[k] = existing
+	}
+
+	var changes []serviceAccountChange
+	for k, current := range wanted {
+		previous, ok := stored[k]
+		switch {
+		case !ok:
+			changes = append(changes, serviceAccountChange{Key: k, Action: "create", Current: current})
+		case previous != current:
+			changes = append(changes, serviceAccountChange{Key: k, Action: "update", Current: current, Previous: previous})
+		}
+	}
+	// we should also delete any service accounts that no longer have the annotation
+	for k, previous := range stored {
+		if _, ok := wanted[k]; !ok {
+			changes = append(changes, serviceAccountChange{Key: k, Action: "delete", Previous: previous, Gone: !present[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes, nil
+}
+
+// applyServiceAccountChanges makes the changes of a sync to storage. If
+// configured, the users of a service account that is gone, because it or its
+// namespace was deleted, are revoked first, while its roles can still be
+// resolved. If any fail to revoke, the annotation is kept so the next sync
+// retries. The annotation of a service account that still exists is deleted
+// without revoking its users, as it may have been edited away by mistake or
+// no longer match a changed keyspace_annotation.
+func (b *databaseBackend) applyServiceAccountChanges(ctx context.Context, s logical.Storage, config *kubeConfig, changes []serviceAccountChange) error {
+	var result *multierror.Error
+	for _, change := range changes {
+		b.saChanges.record(change.Key, b.clock.Now(), config.SyncChangeInterval)
+		if change.Action == "delete" {
+			if config.RevokeDeletedServiceAccounts && change.Gone {
+				if err := b.revokeServiceAccountUsers(ctx, s, change); err != nil {
+					result = multierror.Append(result, err)
+					continue
+				}
+			}
+			if err := s.Delete(ctx, change.Key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry, err := logical.StorageEntryJSON(change.Key, change.Current)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, entry); err != nil {
 			return err
 		}
+	}
+	return result.ErrorOrNil()
+}
+
+// revokeServiceAccountUsers revokes the users issued for the k8s_ roles of a
+// service account that was deleted. The roles are resolved with the
+// annotation being deleted, as the service account is gone.
+func (b *databaseBackend) revokeServiceAccountUsers(ctx context.Context, s logical.Storage, change serviceAccountChange) error {
+	namespace, svcAccountName := path.Split(strings.TrimPrefix(change.Key, "serviceaccount/"))
+	namespace = strings.TrimSuffix(namespace, "/")
 
-		// store in serviceaccount/default/s-ledger
-		entry, err := logical.StorageEntryJSON(path.Join("serviceaccount", key), toStore)
+	roles, err := s.List(ctx, databaseActiveUserPath)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	for _, roleName := range roles {
+		roleName = strings.TrimSuffix(roleName, "/")
+		subs := strings.SplitN(roleName, "_", 4)
+		if len(subs) < 4 || subs[0] != "k8s" || subs[2] != svcAccountName || subs[3] != namespace {
+			continue
+		}
+		role, err := b.Role(ctx, s, subs[1])
 		if err != nil {
 			return err
 		}
+		if role == nil {
+			result = multierror.Append(result, fmt.Errorf("role %q of the users of %q no longer exists", subs[1], roleName))
+			continue
+		}
+		if change.Previous.DBName != "" {
+			role.DBName = change.Previous.DBName
+		}
 
-		err = req.Storage.Put(ctx, entry)
+		usernames, err := s.List(ctx, databaseActiveUserPath+roleName+"/")
 		if err != nil {
 			return err
 		}
+		for _, username := range usernames {
+			user, err := getActiveUser(ctx, s, roleName, username)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				continue
+			}
+			if err := b.revokeIndexedUser(ctx, s, roleName, role, user); err != nil {
+				b.logger.Error("failed to revoke a user of a deleted service account", "role", roleName, "username", username, "error", err)
+				result = multierror.Append(result, fmt.Errorf("role %q user %q: %s", roleName, username, err))
+				continue
+			}
+			b.logger.Info("revoked a user of a deleted service account", "role", roleName, "username", username)
+		}
+	}
+	return result.ErrorOrNil()
+}
 
-		written[entry.Key] = struct{}{}
+// syncServiceAccounts lists all known service accounts to obtain a mapping of name to annotation
+// and stores this mapping durably in Vault. This allows us to load it immediately on plugin start.
+// Vault should call this function every minute. In dry-run mode the changes are only logged, for
+// review with kubeconfig/sync.
+func (b *databaseBackend) syncServiceAccounts(ctx context.Context, req *logical.Request) error {
+	if len(b.saCache.ListKeys()) == 0 {
+		return nil
 	}
 
-	// we should also delete any service accounts that no longer have the annotation
-	keys, err := logical.CollectKeysWithPrefix(ctx, req.Storage, "serviceaccount/")
+	config, err := b.kubeconfig(ctx, req.Storage)
 	if err != nil {
 		return err
 	}
 
-	var deleted int
-	for _, k := range keys {
-		if _, ok := written[k]; !ok {
-			if err := req.Storage.Delete(ctx, k); err != nil {
-				return err
-			}
-			deleted++
+	changes, err := b.planServiceAccountSync(ctx, req.Storage, config)
+	if err != nil {
+		return err
+	}
+
+	if config != nil && config.SyncDryRun {
+		if len(changes) > 0 {
+			b.logger.Info(fmt.Sprintf("dry run: %d service account changes are pending review at kubeconfig/sync", len(changes)))
 		}
+		return nil
+	}
+
+	// Changes to service accounts that were changed recently wait for a
+	// later sync
+	now := b.clock.Now()
+	var due []serviceAccountChange
+	for _, change := range changes {
+		if until, held := b.saChanges.heldUntil(change.Key, now, config.SyncChangeInterval); held {
+			b.logger.Debug("holding back the change of a flapping service account", "service_account", change.Key, "held_until", until.Format(time.RFC3339))
+			continue
+		}
+		due = append(due, change)
+	}
+	changes = due
+
+	if err := b.applyServiceAccountChanges(ctx, req.Storage, config, changes); err != nil {
+		return err
 	}
 
-	b.logger.Debug(fmt.Sprintf("wrote %d service accounts to storage, deleted %d", len(written), deleted))
+	b.logger.Debug(fmt.Sprintf("applied %d service account changes", len(changes)))
 
 	return nil
 }
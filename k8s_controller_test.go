@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBackend_serviceAccountSyncDryRun(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	request(logical.CreateOperation, "roles/rw", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": testK8SRole,
+	})
+
+	for key, value := range map[string]interface{}{
+		"serviceaccount/default/s-ledger": saCacheObject{Keyspace: "ledger"},
+		"serviceaccount/default/s-old":    saCacheObject{Keyspace: "old"},
+		kubeconfigPath: kubeConfig{
+			KeyspaceAnnotation: "monzo.com/keyspace",
+			DBNameAnnotation:   "monzo.com/cluster",
+			SyncDryRun:         true,
+		},
+	} {
+		entry, err := logical.StorageEntryJSON(key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, keyspace := range map[string]string{"s-ledger": "billing", "s-new": "new"} {
+		err := b.saCache.Add(&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{"monzo.com/keyspace": keyspace},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The periodic sync holds the changes back
+	if err := b.syncServiceAccounts(namespace.RootContext(nil), &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	resp := request(logical.ReadOperation, "roles/k8s_rw_s-ledger_default", nil)
+	if statements := resp.Data["creation_statements"].([]string); !strings.Contains(statements[0], "SCHEMA ledger") {
+		t.Fatalf("expected the applied annotation to be used, got %q", statements)
+	}
+
+	resp = request(logical.ReadOperation, "kubeconfig/sync", map[string]interface{}{"role": "rw"})
+	changes := resp.Data["changes"].([]map[string]interface{})
+	if resp.Data["dry_run"] != true || len(changes) != 3 {
+		t.Fatalf("unexpected changes %#v", resp.Data)
+	}
+	for i, expected := range []struct{ serviceAccount, action string }{
+		{"default/s-ledger", "update"},
+		{"default/s-new", "create"},
+		{"default/s-old", "delete"},
+	} {
+		if changes[i]["service_account"] != expected.serviceAccount || changes[i]["action"] != expected.action {
+			t.Fatalf("expected %s to be %sd, got %#v", expected.serviceAccount, expected.action, changes[i])
+		}
+	}
+	if changes[0]["previous_keyspace"] != "ledger" || changes[0]["keyspace"] != "billing" ||
+		!strings.Contains(changes[0]["creation_statements"].([]string)[0], "SCHEMA billing") ||
+		!strings.Contains(changes[0]["previous_creation_statements"].([]string)[0], "SCHEMA ledger") {
+		t.Fatalf("unexpected update %#v", changes[0])
+	}
+
+	// Applying the changes makes them take effect
+	request(logical.UpdateOperation, "kubeconfig/sync", nil)
+	if resp := request(logical.ReadOperation, "kubeconfig/sync", nil); len(resp.Data["changes"].([]map[string]interface{})) != 0 {
+		t.Fatalf("expected no pending changes, got %#v", resp.Data)
+	}
+	resp = request(logical.ReadOperation, "roles/k8s_rw_s-ledger_default", nil)
+	if statements := resp.Data["creation_statements"].([]string); !strings.Contains(statements[0], "SCHEMA billing") {
+		t.Fatalf("expected the new annotation to be used, got %q", statements)
+	}
+	if resp, _ := b.HandleRequest(namespace.RootContext(nil), &logical.Request{Operation: logical.ReadOperation, Path: "roles/k8s_rw_s-old_default", Storage: s}); resp != nil {
+		t.Fatalf("expected the deleted annotation's role to be gone, got %#v", resp)
+	}
+}
+
+func TestBackend_revokeDeletedServiceAccounts(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	fake := &fakeIssuingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(logical.CreateOperation, "roles/rw", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": testK8SRole,
+	})
+
+	entry, err := logical.StorageEntryJSON(kubeconfigPath, kubeConfig{
+		KeyspaceAnnotation:           "monzo.com/keyspace",
+		DBNameAnnotation:             "monzo.com/cluster",
+		RevokeDeletedServiceAccounts: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	serviceAccounts := map[string]*v1.ServiceAccount{}
+	for _, name := range []string{"s-ledger", "s-other"} {
+		serviceAccounts[name] = &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{"monzo.com/keyspace": "public"},
+		}}
+		if err := b.saCache.Add(serviceAccounts[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.syncServiceAccounts(namespace.RootContext(nil), &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := request(logical.ReadOperation, "creds/k8s_rw_s-ledger_default", nil).Data["username"]
+	request(logical.ReadOperation, "creds/k8s_rw_s-other_default", nil)
+	request(logical.ReadOperation, "creds/rw", nil)
+
+	// The service account is deleted with its namespace
+	if err := b.saCache.Delete(serviceAccounts["s-ledger"]); err != nil {
+		t.Fatal(err)
+	}
+	resp := request(logical.ReadOperation, "kubeconfig/sync", nil)
+	if changes := resp.Data["changes"].([]map[string]interface{}); len(changes) != 1 || changes[0]["action"] != "delete" || changes[0]["revokes_users"] != true {
+		t.Fatalf("expected the deletion to revoke users, got %#v", resp.Data)
+	}
+	if err := b.syncServiceAccounts(namespace.RootContext(nil), &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.revoked) != 1 || fake.revoked[0] != deleted {
+		t.Fatalf("expected only %q to be revoked, got %q", deleted, fake.revoked)
+	}
+	if user, err := getActiveUser(context.Background(), s, "k8s_rw_s-ledger_default", deleted.(string)); err != nil || user != nil {
+		t.Fatalf("expected the user to be removed from the index, got %#v, %v", user, err)
+	}
+	if entry, err := s.Get(context.Background(), "serviceaccount/default/s-ledger"); err != nil || entry != nil {
+		t.Fatalf("expected the annotation to be deleted, got %#v, %v", entry, err)
+	}
+
+	// Removing the annotation from a service account that still exists
+	// doesn't revoke its users
+	unannotated := serviceAccounts["s-other"].DeepCopy()
+	unannotated.Annotations = nil
+	if err := b.saCache.Update(unannotated); err != nil {
+		t.Fatal(err)
+	}
+	resp = request(logical.ReadOperation, "kubeconfig/sync", nil)
+	if changes := resp.Data["changes"].([]map[string]interface{}); len(changes) != 1 || changes[0]["action"] != "delete" || changes[0]["revokes_users"] != false {
+		t.Fatalf("expected the removed annotation not to revoke users, got %#v", resp.Data)
+	}
+	if err := b.syncServiceAccounts(namespace.RootContext(nil), &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.revoked) != 1 {
+		t.Fatalf("expected no more users to be revoked, got %q", fake.revoked)
+	}
+	if entry, err := s.Get(context.Background(), "serviceaccount/default/s-other"); err != nil || entry != nil {
+		t.Fatalf("expected the annotation to be deleted, got %#v, %v", entry, err)
+	}
+}
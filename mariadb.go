@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/plugins/database/mysql"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+)
+
+const (
+	// mariaDBUsernameLen is the longest username MariaDB accepts, where
+	// MySQL accepts 32 characters, or 16 before 5.7.
+	mariaDBUsernameLen = 80
+
+	// mariaDBRevocationStatement is the default revocation statement of the
+	// mariadb-database-plugin. Dropping a user revokes its privileges, and
+	// unlike MySQL before 5.7, MariaDB can drop a user only if it exists,
+	// so revoking a user that was already dropped succeeds.
+	mariaDBRevocationStatement = `DROP USER IF EXISTS '{{name}}'@'%';`
+)
+
+// mariaDB is the MySQL plugin with the username length and default
+// revocation statement of MariaDB. Servers running MySQL before 5.7 use the
+// mysql-legacy-database-plugin instead, which generates usernames of up to
+// 16 characters and doesn't depend on DROP USER IF EXISTS.
+type mariaDB struct {
+	dbplugin.Database
+}
+
+func newMariaDB() (interface{}, error) {
+	raw, err := newMySQL(mysql.MetadataLen, mysql.MetadataLen, mariaDBUsernameLen)()
+	if err != nil {
+		return nil, err
+	}
+	db, ok := raw.(dbplugin.Database)
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %T", raw)
+	}
+	return &mariaDB{Database: db}, nil
+}
+
+func (m *mariaDB) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Revocation) == 0 {
+		statements.Revocation = []string{mariaDBRevocationStatement}
+	}
+	return m.Database.RevokeUser(ctx, statements, username)
+}
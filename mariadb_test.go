@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+func TestMariaDB_revocationStatements(t *testing.T) {
+	raw, err := databasePlugins["mariadb-database-plugin"]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw.(*mariaDB); !ok {
+		t.Fatalf("expected the mariadb plugin, got %T", raw)
+	}
+
+	recorder := &recordingDatabase{}
+	db := &mariaDB{Database: recorder}
+
+	if err := db.RevokeUser(context.Background(), dbplugin.Statements{}, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorder.revocation, []string{mariaDBRevocationStatement}) {
+		t.Fatalf("expected the default revocation statement, got %q", recorder.revocation)
+	}
+
+	// The role's own statements are kept, including the deprecated string
+	// form
+	statements := dbplugin.Statements{RevocationStatements: `DROP USER '{{name}}'@'localhost';`}
+	if err := db.RevokeUser(context.Background(), statements, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorder.revocation, []string{`DROP USER '{{name}}'@'localhost';`}) {
+		t.Fatalf("expected the role's revocation statement, got %q", recorder.revocation)
+	}
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// capMaxTTL returns the lease max TTL of a credential whose user must not
+// outlive limit after its issue, where a zero maxTTL is unlimited.
+func capMaxTTL(maxTTL, limit time.Duration) time.Duration {
+	if maxTTL <= 0 || maxTTL > limit {
+		return limit
+	}
+	return maxTTL
+}
+
+// leaseNotAfter returns the time after which the user of a lease issued by a
+// role with a max_lifetime is revoked, if it has one.
+func leaseNotAfter(secret *logical.Secret) (time.Time, bool, error) {
+	notAfterRaw, ok := secret.InternalData["not_after"].(string)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	notAfter, err := time.Parse(time.RFC3339Nano, notAfterRaw)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("lease has an invalid not_after %q: %s", notAfterRaw, err)
+	}
+	return notAfter, true, nil
+}
+
+// revokeUsersPastMaxLifetime revokes the users of dynamic roles that have
+// outlived the max_lifetime of their role, whatever happened to their
+// leases. Users are revoked in the database and removed from the index; the
+// revocation of their leases then finds them gone.
+func (b *databaseBackend) revokeUsersPastMaxLifetime(ctx context.Context, req *logical.Request) error {
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	roles, err := req.Storage.List(ctx, databaseActiveUserPath)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	now := b.clock.Now()
+	for _, roleName := range roles {
+		roleName = strings.TrimSuffix(roleName, "/")
+		usernames, err := req.Storage.List(ctx, databaseActiveUserPath+roleName+"/")
+		if err != nil {
+			return err
+		}
+		for _, username := range usernames {
+			user, err := getActiveUser(ctx, req.Storage, roleName, username)
+			if err != nil {
+				return err
+			}
+			if user == nil || user.NotAfter.IsZero() || now.Before(user.NotAfter) {
+				continue
+			}
+			if err := b.revokeUserPastMaxLifetime(ctx, req.Storage, roleName, user); err != nil {
+				b.Logger().Error("failed to revoke a user past its role's max_lifetime", "role", roleName, "username", username, "error", err)
+				result = multierror.Append(result, fmt.Errorf("role %q user %q: %s", roleName, username, err))
+			}
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (b *databaseBackend) revokeUserPastMaxLifetime(ctx context.Context, s logical.Storage, roleName string, user *activeUser) error {
+	role, _, err := b.leaseRole(ctx, s, roleName)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return fmt.Errorf("role %q no longer exists", roleName)
+	}
+	if err := b.revokeIndexedUser(ctx, s, roleName, role, user); err != nil {
+		return err
+	}
+
+	b.Logger().Warn("revoked a user past its role's max_lifetime", "role", roleName, "db_name", role.DBName, "username", user.Username, "not_after", user.NotAfter.Format(time.RFC3339))
+	metrics.IncrCounterWithLabels([]string{"database", "max_lifetime", "revocation"}, 1, withMountLabels([]metrics.Label{
+		{Name: "db_name", Value: role.DBName},
+		{Name: "role", Value: roleName},
+	}, b.mountLabels))
+	return nil
+}
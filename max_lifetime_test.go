@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_maxLifetime(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	// Lease renewals are checked against the real time, so the clock starts
+	// from it
+	clock := &fakeClock{now: time.Now()}
+	b.clock = clock
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp := request(req)
+		if resp != nil && resp.IsError() {
+			t.Fatalf("unexpected error: %#v", resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	fake := &renewingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"default_ttl":         "30m",
+		"max_lifetime":        "1h",
+		"skip_revocation":     true,
+	}
+	if resp := request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected max_lifetime to be rejected with skip_revocation, got %#v", resp)
+	}
+	delete(role, "skip_revocation")
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/unbounded", Data: map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"default_ttl":         "30m",
+	}})
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "roles/app"})
+	if resp.Data["max_lifetime"] != float64(3600) {
+		t.Fatalf("unexpected role %#v", resp.Data)
+	}
+
+	issued := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if issued.Secret.MaxTTL != time.Hour || issued.Secret.InternalData["not_after"] != clock.now.Add(time.Hour).Format(time.RFC3339Nano) {
+		t.Fatalf("expected the lease to be capped to the max lifetime, got %#v", issued.Secret)
+	}
+	mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/unbounded"})
+
+	// Renewals are capped to the lifetime, and refused once it is reached
+	issued.Secret.IssueTime = clock.now
+	resp = mustRequest(&logical.Request{Operation: logical.RenewOperation, Secret: issued.Secret})
+	if fake.renewed != 1 || resp.Secret.MaxTTL != time.Hour {
+		t.Fatalf("expected a renewal capped to the max lifetime, got %d renewals and %#v", fake.renewed, resp.Secret)
+	}
+
+	ctx := namespace.RootContext(nil)
+	clock.advance(59 * time.Minute)
+	if err := b.revokeUsersPastMaxLifetime(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.revoked) != 0 {
+		t.Fatalf("expected no revocation before not_after, got %v", fake.revoked)
+	}
+
+	clock.advance(time.Minute)
+	if resp := request(&logical.Request{Operation: logical.RenewOperation, Secret: issued.Secret}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the renewal to be refused past the max lifetime, got %#v", resp)
+	}
+	if err := b.revokeUsersPastMaxLifetime(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.revoked) != 1 || fake.revoked[0] != "user-1" {
+		t.Fatalf("expected only the user past its max lifetime to be revoked, got %v", fake.revoked)
+	}
+	if user, err := getActiveUser(ctx, s, "app", "user-1"); err != nil || user != nil {
+		t.Fatalf("expected the revoked user to be removed from the index, got %#v, %v", user, err)
+	}
+	if user, err := getActiveUser(ctx, s, "unbounded", "user-2"); err != nil || user == nil {
+		t.Fatalf("expected the user without a max lifetime to be kept, got %#v, %v", user, err)
+	}
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	"github.com/lib/pq"
+)
+
+// Queries checking whether a user exists, by the type of the connection.
+const (
+	postgresUserExistsQuery = `SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)`
+	mysqlUserExistsQuery    = `SELECT EXISTS (SELECT 1 FROM mysql.user WHERE user = ?)`
+	mssqlUserExistsQuery    = `SELECT CAST(CASE WHEN EXISTS (SELECT 1 FROM sys.server_principals WHERE name = @p1) THEN 1 ELSE 0 END AS BIT)`
+)
+
+// userNotFoundError is the error of revoking a user that the database has
+// confirmed doesn't exist. classifyPluginError classifies it as
+// pluginErrorNotFound.
+type userNotFoundError struct {
+	err error
+}
+
+func (e *userNotFoundError) Error() string { return e.err.Error() }
+
+func (e *userNotFoundError) Unwrap() error { return e.err }
+
+// missingUserCheck looks up the user of a revocation that fails with an error
+// naming a missing object, returning a userNotFoundError only if the user is
+// gone. The error alone doesn't tell: the role's statements may reference
+// another object that is missing, or a REVOKE may fail before the DROP USER
+// of a user that still exists.
+type missingUserCheck struct {
+	dbplugin.Database
+
+	// exists reports whether a user exists in the database. Tests replace
+	// it with a fake.
+	exists func(ctx context.Context, username string) (bool, error)
+}
+
+func newMissingUserCheck(db dbplugin.Database, conn *connutil.SQLConnectionProducer, query string) *missingUserCheck {
+	return &missingUserCheck{
+		Database: db,
+		exists: func(ctx context.Context, username string) (bool, error) {
+			raw, err := conn.Connection(ctx)
+			if err != nil {
+				return false, err
+			}
+			var exists bool
+			err = raw.(*sql.DB).QueryRowContext(ctx, query, username).Scan(&exists)
+			return exists, err
+		},
+	}
+}
+
+func (c *missingUserCheck) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	err := c.Database.RevokeUser(ctx, statements, username)
+	if err == nil || !isMissingObjectError(err) {
+		return err
+	}
+	if exists, existsErr := c.exists(ctx, username); existsErr != nil || exists {
+		return err
+	}
+	return &userNotFoundError{err: err}
+}
+
+// isMissingObjectError reports whether an error of a builtin SQL plugin is
+// the database's refusal to act on an object that doesn't exist.
+func isMissingObjectError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == postgresUndefinedObject
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return containsErrorNumber(mysqlMissingObject, mysqlErr.Number)
+	}
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == mssqlMissingObject
+	}
+	return false
+}
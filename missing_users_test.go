@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/lib/pq"
+)
+
+func TestMissingUserCheck(t *testing.T) {
+	missing := &pq.Error{Code: "42704", Message: `role "user-1" does not exist`}
+	for name, tc := range map[string]struct {
+		revokeErr error
+		exists    bool
+		existsErr error
+		kind      pluginErrorKind
+	}{
+		"gone":          {revokeErr: missing, kind: pluginErrorNotFound},
+		"still exists":  {revokeErr: missing, exists: true, kind: pluginErrorUser},
+		"lookup failed": {revokeErr: missing, existsErr: errors.New("connection refused"), kind: pluginErrorUser},
+		"other error":   {revokeErr: &pq.Error{Code: "42601"}, kind: pluginErrorUser},
+	} {
+		looked := false
+		db := &missingUserCheck{
+			Database: &failingRevocationDatabase{revokeErr: tc.revokeErr},
+			exists: func(ctx context.Context, username string) (bool, error) {
+				looked = true
+				if username != "user-1" {
+					t.Fatalf("%s: unexpected username %q", name, username)
+				}
+				return tc.exists, tc.existsErr
+			},
+		}
+
+		err := db.RevokeUser(context.Background(), dbplugin.Statements{}, "user-1")
+		if kind := classifyPluginError(err); kind != tc.kind {
+			t.Fatalf("%s: expected kind %d, got %d for %v", name, tc.kind, kind, err)
+		}
+		if !errors.Is(err, tc.revokeErr) {
+			t.Fatalf("%s: expected the plugin's error, got %v", name, err)
+		}
+		if looked != (tc.revokeErr == missing) {
+			t.Fatalf("%s: expected the user to be looked up only for a missing object", name)
+		}
+	}
+}
@@ -2,47 +2,267 @@ package database
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/plugins/database/cassandra"
 	"github.com/hashicorp/vault/plugins/database/hana"
 	"github.com/hashicorp/vault/plugins/database/influxdb"
 	"github.com/hashicorp/vault/plugins/database/mongodb"
-	"github.com/hashicorp/vault/plugins/database/mssql"
 	"github.com/hashicorp/vault/plugins/database/mysql"
-	"github.com/hashicorp/vault/plugins/database/postgresql"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
 	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/pluginutil"
 	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
 )
 
 // If you want more database plugins, you'll have to add them here.
 // Copied from hashicorp/vault/helper/builtinplugins/registry.go
 var databasePlugins = map[string]func() (interface{}, error){
 	// These four plugins all use the same mysql implementation but with
-	// different username settings passed by the constructor.
-	"mysql-database-plugin":        mysql.New(mysql.MetadataLen, mysql.MetadataLen, mysql.UsernameLen),
-	"mysql-aurora-database-plugin": mysql.New(credsutil.NoneLength, mysql.LegacyMetadataLen, mysql.LegacyUsernameLen),
-	"mysql-rds-database-plugin":    mysql.New(credsutil.NoneLength, mysql.LegacyMetadataLen, mysql.LegacyUsernameLen),
-	"mysql-legacy-database-plugin": mysql.New(credsutil.NoneLength, mysql.LegacyMetadataLen, mysql.LegacyUsernameLen),
-
-	"postgresql-database-plugin": postgresql.New,
-	"mssql-database-plugin":      mssql.New,
+	// different username settings passed by the constructor. They, postgres
+	// and mssql are built with an errorSanitizer, which keeps the driver's
+	// errors for classifyPluginError.
+	"mysql-database-plugin":        newMySQL(mysql.MetadataLen, mysql.MetadataLen, mysql.UsernameLen),
+	"mysql-aurora-database-plugin": newMySQL(credsutil.NoneLength, mysql.LegacyMetadataLen, mysql.LegacyUsernameLen),
+	"mysql-rds-database-plugin":    newMySQL(credsutil.NoneLength, mysql.LegacyMetadataLen, mysql.LegacyUsernameLen),
+	"mysql-legacy-database-plugin": newMySQL(credsutil.NoneLength, mysql.LegacyMetadataLen, mysql.LegacyUsernameLen),
+
+	// MariaDB also uses the mysql implementation, with its own username
+	// length and revocation statement.
+	"mariadb-database-plugin": newMariaDB,
+
+	// Vitess uses the mysql implementation too, rejecting the statements
+	// that Vitess doesn't support.
+	"vitess-database-plugin": newVitess,
+
+	// MSSQL wraps the mssql implementation to render the credentials of ODBC
+	// and ADO connection strings and release schemas on revocation.
+	"mssql-database-plugin": newSQLServer,
+
+	"postgresql-database-plugin": newPostgreSQL,
 	"cassandra-database-plugin":  cassandra.New,
 	"mongodb-database-plugin":    mongodb.New,
 	"hana-database-plugin":       hana.New,
 	"influxdb-database-plugin":   influxdb.New,
+
+	// The Atlas plugin provisions users through the Atlas Admin API rather
+	// than a connection to the database.
+	"mongodbatlas-database-plugin": newMongoDBAtlas,
+
+	// The DynamoDB plugin issues AWS credentials, with access to DynamoDB
+	// only, through the IAM and STS APIs.
+	"dynamodb-database-plugin": newDynamoDB,
+
+	// The BigQuery plugin issues service account keys with access to
+	// specific datasets through the IAM and BigQuery APIs.
+	"bigquery-database-plugin": newBigQuery,
+
+	// The RabbitMQ plugin provisions broker users through the management
+	// API, alongside the database credentials of the mount.
+	"rabbitmq-database-plugin": newRabbitMQ,
+
+	// The Trino plugin grants catalog and schema access to users that
+	// authenticate with a JSON Web Token signed by the plugin.
+	"trino-database-plugin": newTrino,
+}
+
+// builtinPluginVersion is the version of the plugins in databasePlugins,
+// which is the Vault release they are vendored from.
+const builtinPluginVersion = "v1.3.0"
+
+// lookupPluginFactory resolves a plugin name and version against the builtin
+// plugins. An empty version is builtinPluginVersion; connections pinned to
+// other versions run the binary of that version from the plugin directory
+// instead, so that a new version can be rolled out one connection at a time.
+func lookupPluginFactory(name, version string) (func() (interface{}, error), error) {
+	factory, ok := databasePlugins[name]
+	if !ok {
+		return nil, errors.New("builtin database plugin not found; set plugin_command to run an external plugin")
+	}
+	if version != "" && version != builtinPluginVersion {
+		return nil, fmt.Errorf("the builtin %s is %s; other versions are run from the mount's %s", name, builtinPluginVersion, pluginDirectoryOption)
+	}
+	return factory, nil
+}
+
+// pinnedVersionCommand returns the binary that runs a connection's plugin
+// pinned to a version other than builtinPluginVersion, or "" if the connection
+// isn't pinned. The binaries of the versions a mount can run are put in its
+// plugin directory by the operator, named after the plugin and version, such
+// as "postgresql-database-plugin-v1.4.0".
+func (c *DatabaseConfig) pinnedVersionCommand() string {
+	if c.PluginVersion == "" || c.PluginVersion == builtinPluginVersion {
+		return ""
+	}
+	return c.PluginName + "-" + c.PluginVersion
+}
+
+// pluginCommand returns the binary in the plugin directory that the
+// connection runs, or "" for a builtin plugin.
+func (c *DatabaseConfig) pluginCommand() string {
+	if command := c.pinnedVersionCommand(); command != "" {
+		return command
+	}
+	return c.PluginCommand
+}
+
+// pluginDirectoryOption is the mount option naming the directory of the
+// binaries that connections can run with plugin_command. It is set by the
+// operator enabling or tuning the mount, so that those who can write a
+// connection can only choose between the binaries the operator put there, as
+// with the plugin_directory of Vault's catalog.
+const pluginDirectoryOption = "plugin_directory"
+
+// parsePluginDirectoryOption returns the plugin directory of the mount
+// options, or "" if external plugins are disabled.
+func parsePluginDirectoryOption(options map[string]string) (string, error) {
+	dir := options[pluginDirectoryOption]
+	if dir == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("%s must be an absolute path", pluginDirectoryOption)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// validateExternalPlugin checks the settings of a connection running an
+// external plugin from the mount's plugin directory, with plugin_command or
+// a pinned plugin_version.
+func validateExternalPlugin(config *DatabaseConfig, dir string) error {
+	command := config.pinnedVersionCommand()
+	switch {
+	case command != "" && config.PluginCommand != "":
+		return fmt.Errorf("plugin_command can't be set with plugin_version %s, which is run from its binary %q", config.PluginVersion, command)
+	case command != "":
+		if _, err := pluginCommandPath(dir, command); err != nil {
+			return fmt.Errorf("the builtin %s is %s, and other versions are run from the mount's %s: %s", config.PluginName, builtinPluginVersion, pluginDirectoryOption, err)
+		}
+	case config.PluginCommand != "":
+		if _, err := pluginCommandPath(dir, config.PluginCommand); err != nil {
+			return err
+		}
+	default:
+		if len(config.PluginArgs) > 0 || config.PluginSHA256 != "" {
+			return errors.New("plugin_args and plugin_sha256 require plugin_command or a pinned plugin_version")
+		}
+		return nil
+	}
+	if _, err := externalPluginSHA256(config.PluginSHA256); err != nil {
+		return err
+	}
+	return nil
 }
 
+// pluginCommandPath returns the path of the binary that a command names in
+// the plugin directory. The command is the name of a file in the directory
+// rather than a path, and may not be a link leading out of it.
+func pluginCommandPath(dir, command string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("external plugins require the mount to be enabled with the %s option, naming the directory of the binaries connections can run", pluginDirectoryOption)
+	}
+	if command != filepath.Base(command) || command == "." || command == ".." || strings.ContainsAny(command, `/\`) {
+		return "", fmt.Errorf("%q must be the name of a binary in the mount's %s", command, pluginDirectoryOption)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the mount's %s: %s", pluginDirectoryOption, err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, command))
+	if err != nil {
+		return "", fmt.Errorf("%q is not in the mount's %s", command, pluginDirectoryOption)
+	}
+	if filepath.Dir(path) != realDir {
+		return "", fmt.Errorf("%q links outside the mount's %s", command, pluginDirectoryOption)
+	}
+	return path, nil
+}
+
+func externalPluginSHA256(sum string) ([]byte, error) {
+	if sum == "" {
+		return nil, errors.New("plugin_sha256 is required with plugin_command")
+	}
+	decoded, err := hex.DecodeString(sum)
+	if err != nil || len(decoded) != 32 {
+		return nil, errors.New("plugin_sha256 must be the hex encoded SHA256 sum of the plugin_command binary")
+	}
+	return decoded, nil
+}
+
+// newPluginClient creates the plugin of a connection. Connections with
+// plugin_command run it and dispense the plugin over gRPC, as Vault does for
+// plugins in its catalog, and otherwise the builtin plugin runs in the
+// backend's process. A plugin_command named after a builtin plugin shadows it
+// for the connection, keeping the behaviour the backend has for that plugin,
+// such as its statement dialect.
+func (b *databaseBackend) newPluginClient(ctx context.Context, config *DatabaseConfig, logger log.Logger) (dbplugin.Database, error) {
+	looker, err := newPluginLooker(config, b.pluginDirectory, b.System())
+	if err != nil {
+		return nil, err
+	}
+	return dbplugin.PluginFactory(ctx, config.PluginName, looker, logger)
+}
+
+// newPluginLooker returns the plugin looker of a connection. A connection
+// pinned to a version other than builtinPluginVersion runs the binary of that
+// version from the plugin directory dir, as connections with plugin_command
+// run theirs, checked against plugin_sha256.
+func newPluginLooker(config *DatabaseConfig, dir string, sys logical.SystemView) (*mockPluginLooker, error) {
+	// We have to create a custom plugin lookup mock, as plugins can't look up other plugins
+	// We instead just manually pack all the builtin database plugins into this binary
+	looker := &mockPluginLooker{
+		version: config.PluginVersion,
+		sys:     sys,
+	}
+
+	if name := config.pluginCommand(); name != "" {
+		command, err := pluginCommandPath(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := externalPluginSHA256(config.PluginSHA256)
+		if err != nil {
+			return nil, err
+		}
+		looker.external = &pluginutil.PluginRunner{
+			Name:    config.PluginName,
+			Command: command,
+			Args:    config.PluginArgs,
+			Sha256:  sum,
+		}
+	}
+	return looker, nil
+}
+
+// mockPluginLooker looks up the external plugin of a connection using
+// plugin_command, and otherwise the builtin plugins, at version if it is set.
 type mockPluginLooker struct {
+	version  string
+	external *pluginutil.PluginRunner
+
+	// sys wraps the TLS configuration handed to external plugins.
+	sys logical.SystemView
 }
 
 func (s *mockPluginLooker) LookupPlugin(ctx context.Context, name string, pluginType consts.PluginType) (*pluginutil.PluginRunner, error) {
-	factory, ok := databasePlugins[name]
-	if !ok {
-		return nil, errors.New("builtin database plugin not found; custom plugins not supported")
+	if s.external != nil && s.external.Name == name {
+		runner := *s.external
+		runner.Type = pluginType
+		return &runner, nil
+	}
+
+	factory, err := lookupPluginFactory(name, s.version)
+	if err != nil {
+		return nil, err
 	}
 
 	return &pluginutil.PluginRunner{
@@ -54,8 +274,12 @@ func (s *mockPluginLooker) LookupPlugin(ctx context.Context, name string, plugin
 }
 
 func (s *mockPluginLooker) ResponseWrapData(ctx context.Context, data map[string]interface{}, ttl time.Duration, jwt bool) (*wrapping.ResponseWrapInfo, error) {
-	panic("not implemented")
+	if s.sys == nil {
+		return nil, errors.New("response wrapping is not available")
+	}
+	return s.sys.ResponseWrapData(ctx, data, ttl, jwt)
 }
+
 func (s *mockPluginLooker) MlockEnabled() bool {
-	panic("not implemented")
+	return s.sys != nil && s.sys.MlockEnabled()
 }
@@ -0,0 +1,431 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/mitchellh/mapstructure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	mongoDBAtlasTypeName = "mongodbatlas"
+
+	// defaultAtlasAPIURL is the Atlas Admin API used unless api_url is set.
+	defaultAtlasAPIURL = "https://cloud.mongodb.com/api/atlas/v1.0"
+
+	// atlasAuthDatabase is the database Atlas authenticates every password
+	// user against.
+	atlasAuthDatabase = "admin"
+
+	// atlasRequestTimeout bounds each request to the Atlas Admin API.
+	atlasRequestTimeout = 30 * time.Second
+)
+
+// atlasStatement is the schema of the creation statement of a role using the
+// mongodbatlas-database-plugin. Roles without a db are granted on the
+// statement's db, or on admin if it is also unset. Without scopes, the user
+// can access every cluster and data lake in the project.
+type atlasStatement struct {
+	DB    string `json:"db"`
+	Roles []struct {
+		Role       string `json:"role"`
+		DB         string `json:"db"`
+		Collection string `json:"collection"`
+	} `json:"roles"`
+	Scopes []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"scopes"`
+}
+
+// validateAtlasStatement decodes the creation statement of a role using the
+// mongodbatlas-database-plugin, rejecting unknown keys as
+// validateJSONStatement does for the mongodb-database-plugin.
+func validateAtlasStatement(stmt string, requireRoles bool) error {
+	_, err := parseAtlasStatement(stmt, requireRoles)
+	return err
+}
+
+func parseAtlasStatement(stmt string, requireRoles bool) (*atlasStatement, error) {
+	dec := json.NewDecoder(strings.NewReader(stmt))
+	dec.DisallowUnknownFields()
+
+	var parsed atlasStatement
+	if err := dec.Decode(&parsed); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("statement looks like a query rather than JSON (%s)", err)
+		}
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+
+	if requireRoles && len(parsed.Roles) == 0 {
+		return nil, errors.New(`"roles" must contain at least one role`)
+	}
+	for i, role := range parsed.Roles {
+		if role.Role == "" {
+			return nil, fmt.Errorf(`roles[%d] is missing "role"`, i)
+		}
+	}
+	for i, scope := range parsed.Scopes {
+		if scope.Name == "" {
+			return nil, fmt.Errorf(`scopes[%d] is missing "name"`, i)
+		}
+		if scope.Type != "CLUSTER" && scope.Type != "DATA_LAKE" {
+			return nil, fmt.Errorf(`scopes[%d] must have a "type" of CLUSTER or DATA_LAKE`, i)
+		}
+	}
+
+	return &parsed, nil
+}
+
+// atlasDatabaseUser is a database user of the Atlas Admin API.
+type atlasDatabaseUser struct {
+	DatabaseName string      `json:"databaseName,omitempty"`
+	GroupID      string      `json:"groupId,omitempty"`
+	Username     string      `json:"username,omitempty"`
+	Password     string      `json:"password,omitempty"`
+	Roles        []atlasRole `json:"roles,omitempty"`
+	Scopes       []atlasRole `json:"scopes,omitempty"`
+}
+
+// atlasRole is a role or scope of an atlasDatabaseUser. Roles set the
+// database, collection and role names, and scopes the name and type.
+type atlasRole struct {
+	DatabaseName   string `json:"databaseName,omitempty"`
+	CollectionName string `json:"collectionName,omitempty"`
+	RoleName       string `json:"roleName,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Type           string `json:"type,omitempty"`
+}
+
+// atlasConfig is the connection configuration of the
+// mongodbatlas-database-plugin.
+type atlasConfig struct {
+	PublicKey  string `mapstructure:"public_key"`
+	PrivateKey string `mapstructure:"private_key"`
+	ProjectID  string `mapstructure:"project_id"`
+	APIURL     string `mapstructure:"api_url"`
+}
+
+// mongoDBAtlas provisions the users of a MongoDB Atlas project through the
+// Atlas Admin API, authenticating with a programmatic API key, for projects
+// whose clusters don't accept administrative connections.
+type mongoDBAtlas struct {
+	sync.RWMutex
+	credsutil.CredentialsProducer
+
+	config atlasConfig
+	client *http.Client
+}
+
+func newMongoDBAtlas() (interface{}, error) {
+	return &mongoDBAtlas{
+		CredentialsProducer: &credsutil.SQLCredentialsProducer{
+			DisplayNameLen: 15,
+			RoleNameLen:    15,
+			UsernameLen:    20,
+			Separator:      "-",
+		},
+		client: &http.Client{Timeout: atlasRequestTimeout},
+	}, nil
+}
+
+func (m *mongoDBAtlas) Type() (string, error) {
+	return mongoDBAtlasTypeName, nil
+}
+
+func (m *mongoDBAtlas) Init(ctx context.Context, conf map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	var config atlasConfig
+	if err := mapstructure.WeakDecode(conf, &config); err != nil {
+		return nil, err
+	}
+	switch {
+	case config.PublicKey == "":
+		return nil, errors.New("public_key cannot be empty")
+	case config.PrivateKey == "":
+		return nil, errors.New("private_key cannot be empty")
+	case config.ProjectID == "":
+		return nil, errors.New("project_id cannot be empty")
+	}
+	if config.APIURL == "" {
+		config.APIURL = defaultAtlasAPIURL
+	}
+	if _, err := url.Parse(config.APIURL); err != nil {
+		return nil, fmt.Errorf("invalid api_url: %s", err)
+	}
+
+	m.Lock()
+	m.config = config
+	m.Unlock()
+
+	if verifyConnection {
+		if err := m.request(ctx, http.MethodGet, "", nil, nil); err != nil {
+			return nil, fmt.Errorf("error verifying the Atlas API key: %s", err)
+		}
+	}
+
+	return conf, nil
+}
+
+func (m *mongoDBAtlas) Initialize(ctx context.Context, conf map[string]interface{}, verifyConnection bool) error {
+	_, err := m.Init(ctx, conf, verifyConnection)
+	return err
+}
+
+func (m *mongoDBAtlas) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Creation) == 0 {
+		return "", "", dbutil.ErrEmptyCreationStatement
+	}
+	stmt, err := parseAtlasStatement(statements.Creation[0], true)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	username, err := m.GenerateUsername(usernameConfig)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := m.GeneratePassword()
+	if err != nil {
+		return "", "", err
+	}
+
+	user := atlasDatabaseUser{
+		DatabaseName: atlasAuthDatabase,
+		Username:     username,
+		Password:     password,
+	}
+	for _, role := range stmt.Roles {
+		db := role.DB
+		if db == "" {
+			db = stmt.DB
+		}
+		if db == "" {
+			db = atlasAuthDatabase
+		}
+		user.Roles = append(user.Roles, atlasRole{
+			DatabaseName:   db,
+			CollectionName: role.Collection,
+			RoleName:       role.Role,
+		})
+	}
+	for _, scope := range stmt.Scopes {
+		user.Scopes = append(user.Scopes, atlasRole{Name: scope.Name, Type: scope.Type})
+	}
+
+	m.RLock()
+	user.GroupID = m.config.ProjectID
+	m.RUnlock()
+
+	if err := m.request(ctx, http.MethodPost, "/databaseUsers", user, nil); err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// RenewUser does nothing, as Atlas users don't expire.
+func (m *mongoDBAtlas) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	return nil
+}
+
+// RevokeUser deletes the user. Revocation statements are not used.
+func (m *mongoDBAtlas) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	return m.request(ctx, http.MethodDelete, atlasUserPath(username), nil, nil)
+}
+
+func (m *mongoDBAtlas) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticUser dbplugin.StaticUserConfig) (string, string, error) {
+	if staticUser.Username == "" || staticUser.Password == "" {
+		return "", "", errors.New("must provide both username and password")
+	}
+	if err := m.request(ctx, http.MethodPatch, atlasUserPath(staticUser.Username), atlasDatabaseUser{Password: staticUser.Password}, nil); err != nil {
+		return "", "", err
+	}
+	return staticUser.Username, staticUser.Password, nil
+}
+
+func (m *mongoDBAtlas) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	return nil, errors.New("root credential rotation is not supported by the Atlas Admin API; rotate the programmatic API key in Atlas instead")
+}
+
+func (m *mongoDBAtlas) Close() error {
+	return nil
+}
+
+func atlasUserPath(username string) string {
+	return "/databaseUsers/" + atlasAuthDatabase + "/" + url.PathEscape(username)
+}
+
+// request sends a request to the Atlas Admin API for the project, at path
+// relative to the project, decoding the response into out if it is set.
+// Atlas authenticates programmatic API keys with HTTP digest authentication,
+// so the request is sent again with the key once Atlas has challenged it.
+func (m *mongoDBAtlas) request(ctx context.Context, method, path string, in, out interface{}) error {
+	m.RLock()
+	config := m.config
+	m.RUnlock()
+
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	endpoint := strings.TrimSuffix(config.APIURL, "/") + "/groups/" + url.PathEscape(config.ProjectID) + path
+	send := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return m.client.Do(req)
+	}
+
+	resp, err := send("")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		drainBody(resp)
+		authorization, err := digestAuthorization(challenge, method, resp.Request.URL.RequestURI(), config.PublicKey, config.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if resp, err = send(authorization); err != nil {
+			return err
+		}
+	}
+	defer drainBody(resp)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var apiErr struct {
+		Detail    string `json:"detail"`
+		ErrorCode string `json:"errorCode"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+	msg := fmt.Sprintf("atlas API returned %d", resp.StatusCode)
+	if apiErr.ErrorCode != "" {
+		msg = fmt.Sprintf("%s %s", msg, apiErr.ErrorCode)
+	}
+	if apiErr.Detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, apiErr.Detail)
+	}
+	return httpStatusError(resp.StatusCode, msg)
+}
+
+func drainBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// digestAuthorization answers the HTTP digest authentication challenge of a
+// response, as described by RFC 2617, with the MD5 algorithm and the "auth"
+// quality of protection that Atlas uses.
+func digestAuthorization(challenge, method, uri, username, password string) (string, error) {
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", errors.New("the Atlas API did not accept the API key")
+	}
+	params := parseDigestChallenge(strings.TrimPrefix(challenge, "Digest "))
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	cnonceBytes := make([]byte, 8)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	const nc = "00000001"
+
+	ha1 := md5Hex(username + ":" + params["realm"] + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	var response string
+	if params["qop"] == "" {
+		response = md5Hex(ha1 + ":" + params["nonce"] + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + params["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	}
+
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s", algorithm=MD5`,
+		username, params["realm"], params["nonce"], uri, response)
+	if params["qop"] != "" {
+		authorization += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s"`, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		authorization += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return authorization, nil
+}
+
+// parseDigestChallenge parses the comma separated key="value" parameters of a
+// digest challenge.
+func parseDigestChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, ", ")
+		eq := strings.IndexByte(challenge, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(challenge[:eq]))
+		challenge = challenge[eq+1:]
+
+		var value string
+		if strings.HasPrefix(challenge, `"`) {
+			end := strings.IndexByte(challenge[1:], '"')
+			if end < 0 {
+				value, challenge = challenge[1:], ""
+			} else {
+				value, challenge = challenge[1:end+1], challenge[end+2:]
+			}
+		} else if comma := strings.IndexByte(challenge, ','); comma >= 0 {
+			value, challenge = strings.TrimSpace(challenge[:comma]), challenge[comma:]
+		} else {
+			value, challenge = strings.TrimSpace(challenge), ""
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testAtlasServer serves the database users of project "project" over the
+// Atlas Admin API, authenticating requests with the API key public/private.
+type testAtlasServer struct {
+	*httptest.Server
+
+	l     sync.Mutex
+	users map[string]atlasDatabaseUser
+}
+
+func newTestAtlasServer() *testAtlasServer {
+	server := &testAtlasServer{users: map[string]atlasDatabaseUser{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+func (s *testAtlasServer) serve(w http.ResponseWriter, r *http.Request) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Digest ") {
+		w.Header().Set("WWW-Authenticate", `Digest realm="MMS Public API", domain="", nonce="nonce", algorithm=MD5, qop="auth", stale=false`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	params := parseDigestChallenge(strings.TrimPrefix(authorization, "Digest "))
+	ha1 := md5Hex("public:" + params["realm"] + ":private")
+	ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+	if params["username"] != "public" || params["uri"] != r.URL.RequestURI() ||
+		params["response"] != md5Hex(ha1+":nonce:"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	const users = "/api/atlas/v1.0/groups/project/databaseUsers"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/atlas/v1.0/groups/project":
+		w.Write([]byte(`{"id": "project"}`))
+
+	case r.Method == http.MethodPost && r.URL.Path == users:
+		var user atlasDatabaseUser
+		json.NewDecoder(r.Body).Decode(&user)
+		s.users[user.Username] = user
+		w.WriteHeader(http.StatusCreated)
+
+	case strings.HasPrefix(r.URL.Path, users+"/admin/"):
+		username := strings.TrimPrefix(r.URL.Path, users+"/admin/")
+		user, ok := s.users[username]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"detail": "No user with username ` + username + ` exists.", "errorCode": "USERNAME_NOT_FOUND"}`))
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			delete(s.users, username)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPatch:
+			var update atlasDatabaseUser
+			json.NewDecoder(r.Body).Decode(&update)
+			user.Password = update.Password
+			s.users[username] = user
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *testAtlasServer) user(username string) (atlasDatabaseUser, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	user, ok := s.users[username]
+	return user, ok
+}
+
+func TestBackend_mongoDBAtlas(t *testing.T) {
+	server := newTestAtlasServer()
+	defer server.Close()
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	config := map[string]interface{}{
+		"plugin_name":   "mongodbatlas-database-plugin",
+		"public_key":    "public",
+		"private_key":   "wrong",
+		"project_id":    "project",
+		"api_url":       server.URL + "/api/atlas/v1.0",
+		"allowed_roles": []string{"*"},
+	}
+	resp, err := request(&logical.Request{Operation: logical.CreateOperation, Path: "config/atlas", Data: config})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected the wrong API key to be rejected")
+	}
+	config["private_key"] = "private"
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "config/atlas", Data: config})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/atlas"})
+	details := resp.Data["connection_details"].(map[string]interface{})
+	if _, ok := details["private_key"]; ok {
+		t.Fatal("expected the private key not to be returned")
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "atlas",
+		"creation_statements": `{"roles": [{"role": "readWrite"}], "scopes": [{"name": "Cluster0", "type": "SHARD"}]}`,
+	}
+	resp, err = request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an invalid scope, got err:%s resp:%#v", err, resp)
+	}
+	role["creation_statements"] = `{"db": "app", "roles": [{"role": "readWrite"}, {"role": "read", "db": "reports", "collection": "daily"}], "scopes": [{"name": "Cluster0", "type": "CLUSTER"}]}`
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	username := resp.Data["username"].(string)
+	user, ok := server.user(username)
+	if !ok {
+		t.Fatalf("expected %q to be created in Atlas", username)
+	}
+	if user.Password != resp.Data["password"] || user.DatabaseName != "admin" || user.GroupID != "project" {
+		t.Fatalf("unexpected user %#v", user)
+	}
+	expectedRoles := []atlasRole{
+		{DatabaseName: "app", RoleName: "readWrite"},
+		{DatabaseName: "reports", CollectionName: "daily", RoleName: "read"},
+	}
+	if len(user.Roles) != 2 || user.Roles[0] != expectedRoles[0] || user.Roles[1] != expectedRoles[1] {
+		t.Fatalf("expected roles %#v, got %#v", expectedRoles, user.Roles)
+	}
+	if len(user.Scopes) != 1 || user.Scopes[0] != (atlasRole{Name: "Cluster0", Type: "CLUSTER"}) {
+		t.Fatalf("unexpected scopes %#v", user.Scopes)
+	}
+
+	secret := resp.Secret
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	if _, ok := server.user(username); ok {
+		t.Fatalf("expected %q to be deleted from Atlas", username)
+	}
+
+	// A user that was already deleted is revoked
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+}
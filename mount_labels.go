@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// mountMetricLabels returns the labels identifying the mount in the
+// backend's metrics, so that the metrics of mounts in different Vault
+// namespaces, which may have connections and roles of the same names, are
+// kept apart. Storage and caches need no scoping, as Vault gives every mount
+// its own storage view and backend, but state shared by the process or the
+// host, such as the connection files and the MySQL driver's TLS
+// configurations, is named by the mount's UUID as well. The namespace is only
+// known to builtin mounts, whose factory is called with it.
+func mountMetricLabels(ctx context.Context, conf *logical.BackendConfig) []metrics.Label {
+	var labels []metrics.Label
+	if ns, err := namespace.FromContext(ctx); err == nil {
+		path := strings.TrimSuffix(ns.Path, "/")
+		if path == "" {
+			path = namespace.RootNamespaceID
+		}
+		labels = append(labels, metrics.Label{Name: "namespace", Value: path})
+	}
+	if conf.BackendUUID != "" {
+		labels = append(labels, metrics.Label{Name: "mount_uuid", Value: conf.BackendUUID})
+	}
+	return labels
+}
+
+// withMountLabels appends the labels of the mount to the labels of a metric.
+func withMountLabels(labels []metrics.Label, mount []metrics.Label) []metrics.Label {
+	return append(labels[:len(labels):len(labels)], mount...)
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMountMetricLabels(t *testing.T) {
+	conf := &logical.BackendConfig{BackendUUID: "e1b2"}
+
+	for ctx, expected := range map[context.Context][]metrics.Label{
+		context.Background(): {
+			{Name: "mount_uuid", Value: "e1b2"},
+		},
+		namespace.RootContext(nil): {
+			{Name: "namespace", Value: "root"},
+			{Name: "mount_uuid", Value: "e1b2"},
+		},
+		namespace.ContextWithNamespace(context.Background(), &namespace.Namespace{ID: "abc12", Path: "team-a/payments/"}): {
+			{Name: "namespace", Value: "team-a/payments"},
+			{Name: "mount_uuid", Value: "e1b2"},
+		},
+	} {
+		if labels := mountMetricLabels(ctx, conf); !reflect.DeepEqual(labels, expected) {
+			t.Fatalf("expected %v, got %v", expected, labels)
+		}
+	}
+
+	// The labels of a metric are not changed by appending the mount's
+	labels := make([]metrics.Label, 1, 4)
+	labels[0] = metrics.Label{Name: "db_name", Value: "orders"}
+	first := withMountLabels(labels, []metrics.Label{{Name: "namespace", Value: "a"}})
+	second := withMountLabels(labels, []metrics.Label{{Name: "namespace", Value: "b"}})
+	if first[1].Value != "a" || second[1].Value != "b" {
+		t.Fatalf("expected separate label slices, got %v and %v", first, second)
+	}
+}
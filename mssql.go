@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/plugins/database/mssql"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+)
+
+// sqlServer is the MSSQL plugin with the credentials of ODBC and ADO
+// connection strings rendered in their own syntax, and a default revocation
+// that drops the users of the login that own schemas too.
+//
+// The plugin URL-escapes the username and password it substitutes into
+// connection_url, which suits sqlserver:// URLs only: a Windows login such as
+// DOMAIN\user, which the driver authenticates with NTLM, or a password with
+// special characters would otherwise reach an ODBC or ADO connection string
+// escaped.
+type sqlServer struct {
+	dbplugin.Database
+
+	// conn is the plugin's connection producer, which the backend runs the
+	// statements of the default revocation on.
+	conn *connutil.SQLConnectionProducer
+
+	// connectionURL is the connection_url the plugin was initialized with,
+	// before its credentials were rendered.
+	connectionURL string
+}
+
+func newSQLServer() (interface{}, error) {
+	// As mssql.New builds it, which doesn't expose its connection producer
+	db := &mssql.MSSQL{
+		SQLConnectionProducer: &connutil.SQLConnectionProducer{Type: "mssql"},
+		CredentialsProducer: &credsutil.SQLCredentialsProducer{
+			DisplayNameLen: 20,
+			RoleNameLen:    20,
+			UsernameLen:    128,
+			Separator:      "-",
+		},
+	}
+	return &sqlServer{
+		Database: newErrorSanitizer(newMissingUserCheck(db, db.SQLConnectionProducer, mssqlUserExistsQuery), db.SecretValues),
+		conn:     db.SQLConnectionProducer,
+	}, nil
+}
+
+func (s *sqlServer) Init(ctx context.Context, config map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	s.connectionURL, _ = config["connection_url"].(string)
+	username, _ := config["username"].(string)
+	password, _ := config["password"].(string)
+	rendered, err := renderSQLServerCredentials(s.connectionURL, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if rendered == s.connectionURL {
+		return s.Database.Init(ctx, config, verifyConnection)
+	}
+
+	conf := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		conf[k] = v
+	}
+	conf["connection_url"] = rendered
+	returned, err := s.Database.Init(ctx, conf, verifyConnection)
+	return s.restoreConnectionURL(returned), err
+}
+
+func (s *sqlServer) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	returned, err := s.Database.RotateRootCredentials(ctx, statements)
+	return s.restoreConnectionURL(returned), err
+}
+
+// restoreConnectionURL replaces the rendered connection_url in the
+// configuration the plugin returns with the templated one, so that the
+// credentials aren't stored in it.
+func (s *sqlServer) restoreConnectionURL(config map[string]interface{}) map[string]interface{} {
+	if config != nil {
+		config["connection_url"] = s.connectionURL
+	}
+	return config
+}
+
+// renderSQLServerCredentials substitutes the username and password into an
+// ODBC or ADO connection string, leaving sqlserver:// URLs for the plugin to
+// escape. ODBC values are braced, with their closing braces doubled; ADO
+// values can't be quoted, so credentials containing a semicolon are
+// rejected.
+func renderSQLServerCredentials(connURL, username, password string) (string, error) {
+	if isURL(connURL, "sqlserver") || !strings.Contains(connURL, "{{") {
+		return connURL, nil
+	}
+
+	quote := func(v string) (string, error) {
+		if strings.HasPrefix(connURL, "odbc:") {
+			return "{" + strings.Replace(v, "}", "}}", -1) + "}", nil
+		}
+		if strings.Contains(v, ";") {
+			return "", fmt.Errorf(`the credentials of an ADO connection_url can't contain ";"; use an "odbc:" connection string, which quotes them`)
+		}
+		return v, nil
+	}
+	quotedUsername, err := quote(username)
+	if err != nil {
+		return "", err
+	}
+	quotedPassword, err := quote(password)
+	if err != nil {
+		return "", err
+	}
+	return dbutil.QueryHelper(connURL, map[string]string{
+		"username": quotedUsername,
+		"password": quotedPassword,
+	}), nil
+}
+
+func (s *sqlServer) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Revocation) > 0 {
+		return s.Database.RevokeUser(ctx, statements, username)
+	}
+
+	// The plugin's default revocation stops at the first user it can't drop,
+	// leaving the login behind, so it runs whether or not the schemas were
+	// released and their errors are only reported if it fails
+	releaseErr := s.releaseSchemas(ctx, username)
+	err := s.Database.RevokeUser(ctx, statements, username)
+	if err != nil && releaseErr != nil {
+		return multierror.Append(releaseErr, err)
+	}
+	return err
+}
+
+// sqlServerOwnedSchemasQuery lists the schemas owned by a user in a database,
+// whose name is formatted in.
+const sqlServerOwnedSchemasQuery = `
+SELECT s.name
+FROM %[1]s.sys.schemas s
+JOIN %[1]s.sys.database_principals p ON p.principal_id = s.principal_id
+WHERE p.name = @p1`
+
+// releaseSchemas transfers the schemas that the users of a login own to dbo,
+// in each database the login is mapped to, as SQL Server refuses to drop a
+// user who owns a schema. The objects in the schemas are kept.
+func (s *sqlServer) releaseSchemas(ctx context.Context, username string) error {
+	raw, err := s.conn.Connection(ctx)
+	if err != nil {
+		return err
+	}
+	db := raw.(*sql.DB)
+
+	databases, err := sqlServerLoginDatabases(ctx, db, username)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	for _, database := range databases {
+		schemas, err := sqlServerOwnedSchemas(ctx, db, database, username)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("database %q: %s", database, err))
+			continue
+		}
+		for _, schema := range schemas {
+			// sp_executesql runs the statement in the database it is called
+			// from, without changing the database of the pooled session
+			stmt := fmt.Sprintf("ALTER AUTHORIZATION ON SCHEMA::%s TO [dbo]", quoteSQLServerName(schema))
+			query := fmt.Sprintf("EXEC %s.sys.sp_executesql N'%s'", quoteSQLServerName(database), strings.Replace(stmt, "'", "''", -1))
+			if _, err := db.ExecContext(ctx, query); err != nil {
+				result = multierror.Append(result, fmt.Errorf("database %q schema %q: %s", database, schema, err))
+			}
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// sqlServerLoginDatabases returns the databases a login has users in, as the
+// plugin finds them when it revokes the login.
+func sqlServerLoginDatabases(ctx context.Context, db *sql.DB, username string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "EXEC master.dbo.sp_msloginmappings @p1", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var loginName, dbName, dbUsername, aliasName sql.NullString
+		if err := rows.Scan(&loginName, &dbName, &dbUsername, &aliasName); err != nil {
+			return nil, err
+		}
+		if dbName.Valid {
+			databases = append(databases, dbName.String)
+		}
+	}
+	return databases, rows.Err()
+}
+
+func sqlServerOwnedSchemas(ctx context.Context, db *sql.DB, database, username string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(sqlServerOwnedSchemasQuery, quoteSQLServerName(database)), username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+// quoteSQLServerName quotes an identifier in brackets, as QUOTENAME does.
+func quoteSQLServerName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
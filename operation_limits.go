@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+// operationLimiter wraps a connection's plugin instance to bound how many
+// users are created and revoked on its database at once, as set by
+// max_concurrent_operations. Calls beyond the limit wait for a slot, or
+// fail once their request is canceled.
+type operationLimiter struct {
+	dbplugin.Database
+
+	slots chan struct{}
+}
+
+// newOperationLimiter returns db bounded to max concurrent operations, or
+// db itself if max is zero.
+func newOperationLimiter(db dbplugin.Database, max int) dbplugin.Database {
+	if max <= 0 {
+		return db
+	}
+	return &operationLimiter{Database: db, slots: make(chan struct{}, max)}
+}
+
+func (l *operationLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for one of the connection's %d concurrent operations to finish: %w", cap(l.slots), ctx.Err())
+	}
+}
+
+func (l *operationLimiter) release() {
+	<-l.slots
+}
+
+func (l *operationLimiter) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	if err := l.acquire(ctx); err != nil {
+		return "", "", err
+	}
+	defer l.release()
+	return l.Database.CreateUser(ctx, statements, usernameConfig, expiration)
+}
+
+func (l *operationLimiter) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.release()
+	return l.Database.RevokeUser(ctx, statements, username)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// concurrencyRecordingDatabase records the most users it was creating at
+// once.
+type concurrencyRecordingDatabase struct {
+	fakeStaticDatabase
+
+	l       sync.Mutex
+	current int
+	max     int
+}
+
+func (f *concurrencyRecordingDatabase) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	f.l.Lock()
+	f.current++
+	if f.current > f.max {
+		f.max = f.current
+	}
+	f.l.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	f.l.Lock()
+	f.current--
+	f.l.Unlock()
+	return "user", "password", nil
+}
+
+func TestOperationLimiter(t *testing.T) {
+	fake := &concurrencyRecordingDatabase{}
+	if db := newOperationLimiter(fake, 0); db != dbplugin.Database(fake) {
+		t.Fatal("expected no limit to leave the plugin unwrapped")
+	}
+	db := newOperationLimiter(fake, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := db.CreateUser(context.Background(), dbplugin.Statements{}, dbplugin.UsernameConfig{}, time.Now()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fake.max != 2 {
+		t.Fatalf("expected at most 2 users to be created at once, got %d", fake.max)
+	}
+
+	// A request that can't get a slot gives up when it is canceled
+	limiter := db.(*operationLimiter)
+	limiter.slots <- struct{}{}
+	limiter.slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.RevokeUser(ctx, dbplugin.Statements{}, "user"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the revocation to time out waiting, got %v", err)
+	}
+}
+
+func TestBackend_maxConcurrentOperations(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(op logical.Operation, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      "config/plugin-test",
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	data := map[string]interface{}{
+		"connection_url":            "sample_connection_url",
+		"plugin_name":               "postgresql-database-plugin",
+		"verify_connection":         false,
+		"max_concurrent_operations": -1,
+	}
+	if resp := request(logical.CreateOperation, data); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a negative limit to be rejected, got %#v", resp)
+	}
+
+	data["max_concurrent_operations"] = 4
+	if resp := request(logical.CreateOperation, data); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error %#v", resp)
+	}
+	if resp := request(logical.ReadOperation, nil); resp.Data["max_concurrent_operations"] != 4 {
+		t.Fatalf("expected the limit to be returned, got %#v", resp.Data)
+	}
+	db, err := b.GetConnection(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter, ok := db.Database.(*operationLimiter); !ok || cap(limiter.slots) != 4 {
+		t.Fatalf("expected the connection's plugin to be limited, got %T", db.Database)
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+// maxPasswordAttempts bounds how many passwords are generated when looking
+// for one that is not in an account's password history.
+const maxPasswordAttempts = 10
+
+// passwordHash is a salted SHA-256 hash of a password that was set on a
+// database account, so that reuse can be detected without storing the
+// password itself.
+type passwordHash struct {
+	Salt []byte `json:"salt"`
+	Hash []byte `json:"hash"`
+}
+
+func newPasswordHash(password string) (passwordHash, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return passwordHash{}, err
+	}
+	return passwordHash{Salt: salt, Hash: hashPassword(salt, password)}, nil
+}
+
+func hashPassword(salt []byte, password string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return sum[:]
+}
+
+func (h passwordHash) matches(password string) bool {
+	return subtle.ConstantTimeCompare(h.Hash, hashPassword(h.Salt, password)) == 1
+}
+
+// passwordHistory holds the hashes of the most recent passwords set on an
+// account, newest first.
+type passwordHistory []passwordHash
+
+// contains reports whether password was previously set on the account.
+func (h passwordHistory) contains(password string) bool {
+	for _, entry := range h {
+		if entry.matches(password) {
+			return true
+		}
+	}
+	return false
+}
+
+// add returns the history with password recorded as the newest entry, keeping
+// at most size entries. A size of zero or less disables the history.
+func (h passwordHistory) add(password string, size int) (passwordHistory, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+
+	entry, err := newPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	result := append(passwordHistory{entry}, h...)
+	if len(result) > size {
+		result = result[:size]
+	}
+	return result, nil
+}
+
+// generateUnusedPassword asks the plugin for passwords until it returns one
+// that is not in history, since some databases refuse to reuse a password and
+// a rotation that collides would otherwise fail every time it is retried.
+func generateUnusedPassword(ctx context.Context, db dbplugin.Database, history passwordHistory) (string, error) {
+	for i := 0; i < maxPasswordAttempts; i++ {
+		password, err := db.GenerateCredentials(ctx)
+		if err != nil {
+			return "", err
+		}
+		if !history.contains(password) {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate a password not in the password history after %d attempts", maxPasswordAttempts)
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+// sequenceDatabase generates the given passwords in order.
+type sequenceDatabase struct {
+	dbplugin.Database
+	passwords []string
+}
+
+func (s *sequenceDatabase) GenerateCredentials(ctx context.Context) (string, error) {
+	password := s.passwords[0]
+	s.passwords = s.passwords[1:]
+	return password, nil
+}
+
+func TestPasswordHistory(t *testing.T) {
+	var history passwordHistory
+	var err error
+	for _, password := range []string{"one", "two", "three"} {
+		history, err = history.add(password, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(history))
+	}
+	if history.contains("one") {
+		t.Fatal("expected the oldest password to be dropped")
+	}
+	if !history.contains("two") || !history.contains("three") {
+		t.Fatal("expected the most recent passwords to be kept")
+	}
+
+	disabled, err := history.add("four", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disabled) != 0 {
+		t.Fatalf("expected no history when disabled, got %d entries", len(disabled))
+	}
+}
+
+func TestGenerateUnusedPassword(t *testing.T) {
+	history, err := passwordHistory(nil).add("reused", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &sequenceDatabase{passwords: []string{"reused", "reused", "fresh"}}
+	password, err := generateUnusedPassword(context.Background(), db, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "fresh" {
+		t.Fatalf("expected a password outside the history, got %q", password)
+	}
+
+	db = &sequenceDatabase{}
+	for i := 0; i < maxPasswordAttempts; i++ {
+		db.passwords = append(db.passwords, "reused")
+	}
+	if _, err := generateUnusedPassword(context.Background(), db, history); err == nil {
+		t.Fatal("expected an error when every generated password is in the history")
+	}
+}
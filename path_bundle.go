@@ -0,0 +1,616 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// bundleVersion is the version of the bundles written by export. Import
+// converts bundles of older versions, back to minBundleVersion, and refuses
+// newer ones.
+const bundleVersion = 2
+
+const minBundleVersion = 1
+
+// bundle holds the connections, secret sinks, roles and static roles of a
+// mount as the parameters that are written to create them, so that importing
+// it replays those writes on another mount.
+type bundle struct {
+	Version     int                               `json:"version"`
+	Connections map[string]map[string]interface{} `json:"connections"`
+	Sinks       map[string]map[string]interface{} `json:"sinks"`
+	Roles       map[string]map[string]interface{} `json:"roles"`
+	StaticRoles map[string]map[string]interface{} `json:"static_roles"`
+
+	// Kubeconfig holds the Kubernetes configuration, without its JWT, which
+	// must be supplied on import. It was added in version 2.
+	Kubeconfig map[string]interface{} `json:"kubeconfig,omitempty"`
+
+	// RedactedConnections and RedactedSinks list the secret parameters left
+	// out of each connection and sink, which must be supplied on import.
+	RedactedConnections map[string][]string `json:"redacted_connections,omitempty"`
+	RedactedSinks       map[string][]string `json:"redacted_sinks,omitempty"`
+}
+
+func pathBundle(b *databaseBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "export$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathExportRead,
+					Summary:  "Export the connections, secret sinks and roles of the mount as a bundle, without their secrets.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"bundle": map[string]interface{}{
+										"version": bundleVersion,
+										"connections": map[string]interface{}{
+											"orders": map[string]interface{}{
+												"plugin_name":   "postgresql-database-plugin",
+												"allowed_roles": []string{"orders-*"},
+												"username":      "vault",
+											},
+										},
+										"roles": map[string]interface{}{
+											"orders-readonly": map[string]interface{}{
+												"db_name": "orders",
+												"preset":  "postgres-readonly",
+											},
+										},
+										"redacted_connections": map[string]interface{}{
+											"orders": []string{"connection_url", "password"},
+										},
+									},
+								},
+							},
+						}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathBundleHelpSyn,
+			HelpDescription: pathBundleHelpDesc,
+		},
+		{
+			Pattern: "import$",
+			Fields: map[string]*framework.FieldSchema{
+				"bundle": {
+					Type:        framework.TypeMap,
+					Description: `A bundle read from "export".`,
+				},
+				"connection_secrets": {
+					Type: framework.TypeMap,
+					Description: `The redacted parameters of the bundle's
+					connections, keyed by connection name, such as
+					{"orders": {"connection_url": "...", "password": "..."}}.
+					Other parameters of a connection may also be given, to
+					override the bundle's.`,
+				},
+				"sink_secrets": {
+					Type:        framework.TypeMap,
+					Description: `The redacted parameters of the bundle's secret sinks, keyed by sink name.`,
+				},
+				"kubeconfig_secrets": {
+					Type:        framework.TypeMap,
+					Description: `The redacted parameters of the bundle's kubeconfig, such as {"jwt": "..."}.`,
+				},
+				"verify_connection": {
+					Type:        framework.TypeBool,
+					Default:     true,
+					Description: `If true, each connection is verified when it is imported.`,
+				},
+				"overwrite": {
+					Type: framework.TypeBool,
+					Description: `If true, entries that already exist on the mount
+					are updated with the bundle's parameters. Otherwise the
+					import is refused if any exist.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathImportUpdate,
+					Summary:  "Import a bundle of connections, secret sinks and roles.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"connections":  []string{"orders"},
+									"sinks":        []string{},
+									"roles":        []string{"orders-readonly"},
+									"static_roles": []string{},
+									"kubeconfig":   false,
+								},
+							},
+						}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathBundleHelpSyn,
+			HelpDescription: pathBundleHelpDesc,
+		},
+	}
+}
+
+// readParameters reads an entry through the backend's own read handler, so
+// that it is redacted as when it is read over the API. It returns nil if the
+// entry doesn't exist.
+func (b *databaseBackend) readParameters(ctx context.Context, s logical.Storage, path string) (map[string]interface{}, error) {
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      path,
+		Storage:   s,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, nil
+	}
+	if resp.IsError() {
+		return nil, resp.Error()
+	}
+	return resp.Data, nil
+}
+
+// writableParameters returns the data that are fields of a path, leaving out
+// read-only state such as the time of the last rotation.
+func writableParameters(data map[string]interface{}, fields map[string]*framework.FieldSchema) map[string]interface{} {
+	params := make(map[string]interface{})
+	for k, v := range data {
+		if _, ok := fields[k]; ok && k != "name" {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// listEntries lists the entries under a storage prefix, without the prefixes
+// nested within it.
+func listEntries(ctx context.Context, s logical.Storage, prefix string) ([]string, error) {
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := keys[:0]
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			names = append(names, key)
+		}
+	}
+	return names, nil
+}
+
+func (b *databaseBackend) pathExportRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	export := &bundle{
+		Version:             bundleVersion,
+		Connections:         make(map[string]map[string]interface{}),
+		Sinks:               make(map[string]map[string]interface{}),
+		Roles:               make(map[string]map[string]interface{}),
+		StaticRoles:         make(map[string]map[string]interface{}),
+		RedactedConnections: make(map[string][]string),
+		RedactedSinks:       make(map[string][]string),
+	}
+
+	names, err := listEntries(ctx, req.Storage, "config/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		params, redacted, err := b.exportConnection(ctx, req.Storage, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export connection %q: %s", name, err)
+		}
+		if params == nil {
+			continue
+		}
+		export.Connections[name] = params
+		if len(redacted) > 0 {
+			export.RedactedConnections[name] = redacted
+		}
+	}
+
+	names, err = listEntries(ctx, req.Storage, secretSinkPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		params, redacted, err := b.exportSink(ctx, req.Storage, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export secret sink %q: %s", name, err)
+		}
+		if params == nil {
+			continue
+		}
+		export.Sinks[name] = params
+		if len(redacted) > 0 {
+			export.RedactedSinks[name] = redacted
+		}
+	}
+
+	roleFields := fieldsForType(databaseRolePath)
+	names, err = listEntries(ctx, req.Storage, databaseRolePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := b.readParameters(ctx, req.Storage, "roles/"+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export role %q: %s", name, err)
+		}
+		if data == nil {
+			continue
+		}
+		params := writableParameters(data, roleFields)
+		// The preset is expanded again by the importing mount
+		if params["preset"] != nil {
+			delete(params, "creation_statements")
+			delete(params, "revocation_statements")
+		}
+		export.Roles[name] = params
+	}
+
+	staticRoleFields := fieldsForType(databaseStaticRolePath)
+	names, err = listEntries(ctx, req.Storage, databaseStaticRolePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := b.readParameters(ctx, req.Storage, "static-roles/"+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export static role %q: %s", name, err)
+		}
+		if data == nil {
+			continue
+		}
+		export.StaticRoles[name] = writableParameters(data, staticRoleFields)
+	}
+
+	kubeconfig, err := b.readParameters(ctx, req.Storage, kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export kubeconfig: %s", err)
+	}
+	if kubeconfig != nil {
+		export.Kubeconfig = writableParameters(kubeconfig, pathKubeconfig(b)[0].Fields)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundle": export,
+		},
+	}, nil
+}
+
+// exportConnection returns the parameters of a connection, and the names of
+// the secret parameters that were left out.
+func (b *databaseBackend) exportConnection(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, []string, error) {
+	config, err := b.DatabaseConfig(ctx, s, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := b.readParameters(ctx, s, "config/"+name)
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+
+	params := writableParameters(data, pathConfigurePluginConnection(b).Fields)
+	if statements, ok := data["root_credentials_rotate_statements"]; ok {
+		params["root_rotation_statements"] = statements
+	}
+
+	// The connection details that were removed or masked when reading the
+	// connection are secrets
+	var redacted []string
+	details, _ := data["connection_details"].(map[string]interface{})
+	for k, v := range config.ConnectionDetails {
+		if exported, ok := details[k]; ok && reflect.DeepEqual(exported, v) {
+			params[k] = v
+		} else {
+			redacted = append(redacted, k)
+		}
+	}
+	for k, secret := range map[string]bool{
+		"ssh_private_key":   config.SSHTunnel.PrivateKey != "",
+		"pki_token":         config.PKI.Token != "",
+		"proxy_url":         redactProxyURL(config.ProxyURL) != config.ProxyURL,
+		"rotation_hook_url": redactProxyURL(config.RotationHook.URL) != config.RotationHook.URL,
+	} {
+		if secret {
+			delete(params, k)
+			redacted = append(redacted, k)
+		}
+	}
+	sort.Strings(redacted)
+
+	return params, redacted, nil
+}
+
+// exportSink returns the parameters of a secret sink, and the names of its
+// credentials, which were left out.
+func (b *databaseBackend) exportSink(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, []string, error) {
+	config, err := b.secretSink(ctx, s, name)
+	if err != nil || config == nil {
+		return nil, nil, err
+	}
+	data, err := b.readParameters(ctx, s, "sinks/"+name)
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+
+	var redacted []string
+	for k, v := range map[string]string{
+		"secret_key":    config.SecretKey,
+		"session_token": config.SessionToken,
+		"credentials":   config.Credentials,
+		"client_secret": config.ClientSecret,
+	} {
+		if v != "" {
+			redacted = append(redacted, k)
+		}
+	}
+	sort.Strings(redacted)
+
+	return writableParameters(data, pathSinks(b).Fields), redacted, nil
+}
+
+// convertBundle converts an imported bundle to the current version, one
+// version at a time.
+func convertBundle(imported *bundle) error {
+	if imported.Version > bundleVersion {
+		return fmt.Errorf("bundle version %d was exported by a newer version of the plugin; this mount imports versions %d to %d", imported.Version, minBundleVersion, bundleVersion)
+	}
+	if imported.Version < minBundleVersion {
+		return fmt.Errorf("unsupported bundle version %d; this mount imports versions %d to %d", imported.Version, minBundleVersion, bundleVersion)
+	}
+
+	for imported.Version < bundleVersion {
+		switch imported.Version {
+		case 1:
+			// Version 1 bundles have no kubeconfig, which is left as it is
+			// on the importing mount
+			imported.Kubeconfig = nil
+		}
+		imported.Version++
+	}
+	return nil
+}
+
+// bundleEntry is an entry of a bundle to write on import.
+type bundleEntry struct {
+	kind   string
+	name   string
+	path   string
+	params map[string]interface{}
+}
+
+func (b *databaseBackend) pathImportUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var imported bundle
+	raw, err := json.Marshal(data.Get("bundle"))
+	if err == nil {
+		err = json.Unmarshal(raw, &imported)
+	}
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid bundle: %s", err)), nil
+	}
+	if err := convertBundle(&imported); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	connectionSecrets, err := parseBundleSecrets(data.Get("connection_secrets"))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid connection_secrets: %s", err)), nil
+	}
+	sinkSecrets, err := parseBundleSecrets(data.Get("sink_secrets"))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid sink_secrets: %s", err)), nil
+	}
+	kubeconfigSecrets, _ := data.Get("kubeconfig_secrets").(map[string]interface{})
+	if len(kubeconfigSecrets) > 0 && imported.Kubeconfig == nil {
+		return logical.ErrorResponse("kubeconfig_secrets was given, but the bundle has no kubeconfig"), nil
+	}
+	verifyConnection := data.Get("verify_connection").(bool)
+	overwrite := data.Get("overwrite").(bool)
+
+	// Secret sinks come first as static roles write to them when they are
+	// created, and connections before the roles that use them
+	var entries []bundleEntry
+	add := func(kind, prefix string, items map[string]map[string]interface{}, secrets map[string]map[string]interface{}, redacted map[string][]string) error {
+		names := make([]string, 0, len(items))
+		for name := range items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			params := make(map[string]interface{}, len(items[name]))
+			for k, v := range items[name] {
+				params[k] = v
+			}
+			for k, v := range secrets[name] {
+				params[k] = v
+			}
+			for _, k := range redacted[name] {
+				if _, ok := params[k]; !ok {
+					return fmt.Errorf("%s %q requires %q to be supplied", kind, name, k)
+				}
+			}
+			entries = append(entries, bundleEntry{kind: kind, name: name, path: prefix + name, params: params})
+		}
+		return nil
+	}
+	for name := range connectionSecrets {
+		if _, ok := imported.Connections[name]; !ok {
+			return logical.ErrorResponse(fmt.Sprintf("connection_secrets has connection %q, which is not in the bundle", name)), nil
+		}
+	}
+	for name := range sinkSecrets {
+		if _, ok := imported.Sinks[name]; !ok {
+			return logical.ErrorResponse(fmt.Sprintf("sink_secrets has secret sink %q, which is not in the bundle", name)), nil
+		}
+	}
+	if err := add("secret sink", "sinks/", imported.Sinks, sinkSecrets, imported.RedactedSinks); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := add("connection", "config/", imported.Connections, connectionSecrets, imported.RedactedConnections); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	// Importing must not choose the binaries the backend runs, so external
+	// plugins are configured on config/ directly
+	for _, entry := range entries {
+		if entry.kind != "connection" {
+			continue
+		}
+		for _, field := range []string{"plugin_command", "plugin_args", "plugin_sha256"} {
+			if _, ok := entry.params[field]; ok {
+				return logical.ErrorResponse(fmt.Sprintf("connection %q sets %s, which can't be imported; write the connection to config/%s instead", entry.name, field, entry.name)), nil
+			}
+		}
+	}
+	if err := add("role", "roles/", imported.Roles, nil, nil); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := add("static role", "static-roles/", imported.StaticRoles, nil, nil); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if imported.Kubeconfig != nil {
+		// The kubeconfig is written last, as it restarts the watch of
+		// service accounts
+		items := map[string]map[string]interface{}{kubeconfigPath: imported.Kubeconfig}
+		secrets := map[string]map[string]interface{}{kubeconfigPath: kubeconfigSecrets}
+		redacted := map[string][]string{kubeconfigPath: {"jwt"}}
+		if err := add("kubeconfig", "", items, secrets, redacted); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	// Existing entries are checked before anything is written, so that a
+	// refused import leaves the mount unchanged
+	storagePaths := map[string]string{
+		"secret sink": secretSinkPath,
+		"connection":  "config/",
+		"role":        databaseRolePath,
+		"static role": databaseStaticRolePath,
+		"kubeconfig":  "",
+	}
+	exists := make(map[string]bool, len(entries))
+	var conflicts []string
+	for _, entry := range entries {
+		stored, err := req.Storage.Get(ctx, storagePaths[entry.kind]+entry.name)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			exists[entry.path] = true
+			conflicts = append(conflicts, entry.path)
+		}
+	}
+	if len(conflicts) > 0 && !overwrite {
+		return logical.ErrorResponse(fmt.Sprintf("the mount already has %s; set overwrite to update them", strings.Join(conflicts, ", "))), nil
+	}
+
+	written := map[string][]string{
+		"secret sink": {},
+		"connection":  {},
+		"role":        {},
+		"static role": {},
+		"kubeconfig":  {},
+	}
+	for i, entry := range entries {
+		op := logical.CreateOperation
+		if exists[entry.path] {
+			op = logical.UpdateOperation
+		}
+		if entry.kind == "connection" {
+			entry.params["verify_connection"] = verifyConnection
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:   op,
+			Path:        entry.path,
+			Storage:     req.Storage,
+			Data:        entry.params,
+			DisplayName: req.DisplayName,
+			EntityID:    req.EntityID,
+			Connection:  req.Connection,
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		if err != nil {
+			// Entries written before the failure are kept, so that the import
+			// can be completed with overwrite once the failure is fixed
+			return logical.ErrorResponse(fmt.Sprintf("failed to import %s %q after importing %d entries: %s", entry.kind, entry.name, i, err)), nil
+		}
+		written[entry.kind] = append(written[entry.kind], entry.name)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"sinks":        written["secret sink"],
+			"connections":  written["connection"],
+			"roles":        written["role"],
+			"static_roles": written["static role"],
+			"kubeconfig":   len(written["kubeconfig"]) > 0,
+		},
+	}, nil
+}
+
+// parseBundleSecrets converts the secrets given to import, keyed by name, into
+// maps of parameters.
+func parseBundleSecrets(raw interface{}) (map[string]map[string]interface{}, error) {
+	secrets := make(map[string]map[string]interface{})
+	m, _ := raw.(map[string]interface{})
+	for name, v := range m {
+		params, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the secrets of %q must be an object", name)
+		}
+		secrets[name] = params
+	}
+	return secrets, nil
+}
+
+const pathBundleHelpSyn = `
+Export and import the connections, secret sinks, roles and Kubernetes
+configuration of the mount.
+`
+
+const pathBundleHelpDesc = `
+Reading "export" returns a bundle of the mount's connections, secret sinks,
+roles and static roles, as the parameters that are written to create them, to
+migrate the mount to another cluster or environment. Secrets are redacted as
+when reading each entry, and the names of the parameters left out of each
+connection and sink are listed in "redacted_connections" and "redacted_sinks".
+Roles using a statement preset are exported with the name of the preset, which
+the importing mount expands with its own statements. State such as the time of
+the next root rotation is not exported. The Kubernetes configuration is
+exported as "kubeconfig", without its JWT.
+
+Writing the bundle to "import" writes its secret sinks, connections, roles and
+static roles in that order, and then its kubeconfig. Every redacted parameter
+must be supplied again, in "connection_secrets", "sink_secrets" and
+"kubeconfig_secrets". The import is refused if any
+entry already exists, unless "overwrite" is set. If a write fails, the entries
+written before it are kept, and the import can be retried with "overwrite".
+
+Bundles carry the version of their format. Bundles exported by older
+versions of the plugin are converted when they are imported, so that they
+keep importing as the format evolves: version 1 bundles, written before the
+kubeconfig was exported, import without changing the mount's kubeconfig.
+Bundles of a newer version than the mount's are refused.
+
+The passwords of static roles are not exported: importing a static role rotates
+its password, so the source mount should stop managing the account first.
+`
@@ -3,6 +3,10 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -35,7 +39,8 @@ func pathKubeconfig(b *databaseBackend) []*framework.Path {
 				Type:        framework.TypeString,
 				Description: "A JWT used to access the K8S API to read service accounts.",
 				DisplayAttrs: &framework.DisplayAttributes{
-					Name: "JWT",
+					Name:      "JWT",
+					Sensitive: true,
 				},
 				Required: true,
 			},
@@ -55,18 +60,211 @@ func pathKubeconfig(b *databaseBackend) []*framework.Path {
 				},
 				Default: "monzo.com/cluster",
 			},
+			"credential_wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "If set, credentials for service accounts are always returned response-wrapped with this TTL, so that only a single-use wrapping token is delivered to the workload.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Credential Wrap TTL",
+				},
+			},
+			"credential_file_template": {
+				Type:        framework.TypeString,
+				Description: "If set, a Go template rendered with the credentials for service accounts, returned as credential_file for a sidecar to write to a memory-backed volume.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Credential File Template",
+				},
+			},
+			"sync_dry_run": {
+				Type:        framework.TypeBool,
+				Description: "If set, changes to service account annotations are not applied until reviewed and applied with kubeconfig/sync.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Sync Dry Run",
+				},
+			},
+			"revoke_deleted_service_accounts": {
+				Type:        framework.TypeBool,
+				Description: "If set, the users of a service account's roles are revoked when it or its namespace is deleted. Removing the annotation from a service account that still exists doesn't revoke them.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Revoke Deleted Service Accounts",
+				},
+			},
+			"watch_max_backoff": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The longest wait between restarts of a failing watch of service accounts, which backs off exponentially with jitter. Defaults to 5 minutes.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Watch Max Backoff",
+				},
+			},
+			"sync_change_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "If set, the minimum time between changes applied to the same service account, doubled while its annotation keeps changing.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Sync Change Interval",
+				},
+			},
 		},
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathKubeconfigWrite(),
-			logical.CreateOperation: b.pathKubeconfigWrite(),
-			logical.ReadOperation:   b.pathKubeconfigRead(),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKubeconfigWrite(),
+				Summary:  "Configure access to the Kubernetes API and restart the service account watch.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "The configuration was saved."}},
+				},
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathKubeconfigWrite(),
+				Summary:  "Configure access to the Kubernetes API and start watching service accounts.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathKubeconfigRead(),
+				Summary:  "Read the Kubernetes configuration. The JWT is not returned.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"kubernetes_host":                 "https://127.0.0.1:6443",
+								"kubernetes_ca_cert":              "-----BEGIN CERTIFICATE-----\n...",
+								"keyspace_annotation":             "monzo.com/keyspace",
+								"db_name_annotation":              "monzo.com/cluster",
+								"credential_wrap_ttl":             300,
+								"credential_file_template":        "postgres://{{.Username}}:{{.Password}}@db:5432/app",
+								"sync_dry_run":                    false,
+								"revoke_deleted_service_accounts": true,
+								"watch_max_backoff":               300,
+								"sync_change_interval":            0,
+							},
+						},
+					}},
+				},
+			},
 		},
 
 		HelpSynopsis:    confHelpSyn,
 		HelpDescription: confHelpDesc,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			Action: "Configure",
+		},
+	}, {
+		Pattern: "kubeconfig/sync$",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "A concrete role whose creation statements for each changed service account are included.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathKubeconfigSyncRead,
+				Summary:  "List the changes the next sync of service account annotations would make, without applying them.",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKubeconfigSyncWrite,
+				Summary:  "Sync service account annotations now, applying any changes held back by sync_dry_run.",
+			},
+		},
+
+		HelpSynopsis:    confSyncHelpSyn,
+		HelpDescription: confSyncHelpDesc,
 	}}
 }
 
+// pathKubeconfigSyncRead lists the pending changes of a sync
+func (b *databaseBackend) pathKubeconfigSyncRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.kubeconfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("kubeconfig is not configured"), nil
+	}
+
+	changes, err := b.planServiceAccountSync(ctx, req.Storage, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.serviceAccountChangesResponse(ctx, req.Storage, config, changes, data.Get("role").(string))
+}
+
+// pathKubeconfigSyncWrite applies the pending changes of a sync
+func (b *databaseBackend) pathKubeconfigSyncWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.kubeconfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("kubeconfig is not configured"), nil
+	}
+
+	changes, err := b.planServiceAccountSync(ctx, req.Storage, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.applyServiceAccountChanges(ctx, req.Storage, config, changes); err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		b.logger.Info(fmt.Sprintf("applied %d service account changes", len(changes)))
+	}
+
+	return b.serviceAccountChangesResponse(ctx, req.Storage, config, changes, data.Get("role").(string))
+}
+
+// serviceAccountChangesResponse describes the changes of a sync, with the
+// creation statements of roleName before and after each change if set.
+func (b *databaseBackend) serviceAccountChangesResponse(ctx context.Context, s logical.Storage, config *kubeConfig, changes []serviceAccountChange, roleName string) (*logical.Response, error) {
+	var role *roleEntry
+	if roleName != "" {
+		var err error
+		if role, err = b.Role(ctx, s, roleName); err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return logical.ErrorResponse("unknown role: %s", roleName), nil
+		}
+	}
+
+	changeData := []map[string]interface{}{}
+	for _, change := range changes {
+		d := map[string]interface{}{
+			"service_account": strings.TrimPrefix(change.Key, "serviceaccount/"),
+			"action":          change.Action,
+		}
+		if change.Action != "delete" {
+			d["keyspace"] = change.Current.Keyspace
+			d["db_name"] = change.Current.DBName
+		}
+		if change.Action != "create" {
+			d["previous_keyspace"] = change.Previous.Keyspace
+			d["previous_db_name"] = change.Previous.DBName
+		}
+		if change.Action == "delete" {
+			d["revokes_users"] = config.RevokeDeletedServiceAccounts && change.Gone
+		}
+		if until, held := b.saChanges.heldUntil(change.Key, b.clock.Now(), config.SyncChangeInterval); held {
+			d["held_until"] = until.Format(time.RFC3339)
+		}
+		if role != nil {
+			if change.Action != "delete" {
+				d["creation_statements"] = b.annotatedStatements(role.Statements.Creation, change.Current.Keyspace)
+			}
+			if change.Action != "create" {
+				d["previous_creation_statements"] = b.annotatedStatements(role.Statements.Creation, change.Previous.Keyspace)
+			}
+		}
+		changeData = append(changeData, d)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run": config.SyncDryRun,
+			"changes": changeData,
+		},
+	}, nil
+}
+
 // kubeconfig takes a storage object and returns a kubeConfig object
 func (b *databaseBackend) kubeconfig(ctx context.Context, s logical.Storage) (*kubeConfig, error) {
 	raw, err := s.Get(ctx, kubeconfigPath)
@@ -96,10 +294,16 @@ func (b *databaseBackend) pathKubeconfigRead() framework.OperationFunc {
 			// Create a map of data to be returned
 			resp := &logical.Response{
 				Data: map[string]interface{}{
-					"kubernetes_host":     config.Host,
-					"kubernetes_ca_cert":  config.CACert,
-					"keyspace_annotation": config.KeyspaceAnnotation,
-					"db_name_annotation":  config.DBNameAnnotation,
+					"kubernetes_host":                 config.Host,
+					"kubernetes_ca_cert":              config.CACert,
+					"keyspace_annotation":             config.KeyspaceAnnotation,
+					"db_name_annotation":              config.DBNameAnnotation,
+					"credential_wrap_ttl":             int64(config.CredentialWrapTTL.Seconds()),
+					"credential_file_template":        config.CredentialFileTemplate,
+					"sync_dry_run":                    config.SyncDryRun,
+					"revoke_deleted_service_accounts": config.RevokeDeletedServiceAccounts,
+					"watch_max_backoff":               int64(config.WatchMaxBackoff.Seconds()),
+					"sync_change_interval":            int64(config.SyncChangeInterval.Seconds()),
 				},
 			}
 
@@ -127,12 +331,42 @@ func (b *databaseBackend) pathKubeconfigWrite() framework.OperationFunc {
 		}
 		keyspaceAnnotationKey := data.Get("keyspace_annotation").(string)
 		dbNameAnnotationKey := data.Get("db_name_annotation").(string)
+		credentialWrapTTL := time.Duration(data.Get("credential_wrap_ttl").(int)) * time.Second
+		if credentialWrapTTL < 0 {
+			return logical.ErrorResponse("credential_wrap_ttl must not be negative"), nil
+		}
+		watchMaxBackoff := time.Duration(data.Get("watch_max_backoff").(int)) * time.Second
+		if watchMaxBackoff < 0 {
+			return logical.ErrorResponse("watch_max_backoff must not be negative"), nil
+		}
+		if watchMaxBackoff == 0 {
+			watchMaxBackoff = defaultWatchMaxBackoff
+		}
+		if watchMaxBackoff < watchInitialBackoff {
+			return logical.ErrorResponse(fmt.Sprintf("watch_max_backoff must be at least %s", watchInitialBackoff)), nil
+		}
+		syncChangeInterval := time.Duration(data.Get("sync_change_interval").(int)) * time.Second
+		if syncChangeInterval < 0 {
+			return logical.ErrorResponse("sync_change_interval must not be negative"), nil
+		}
+		credentialFileTemplate := data.Get("credential_file_template").(string)
+		if credentialFileTemplate != "" {
+			if _, err := parseCredentialTemplate("credential_file_template", credentialFileTemplate); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
 		config := &kubeConfig{
-			Host:               host,
-			CACert:             caCert,
-			JWT:                jwt,
-			KeyspaceAnnotation: keyspaceAnnotationKey,
-			DBNameAnnotation:   dbNameAnnotationKey,
+			Host:                         host,
+			CACert:                       caCert,
+			JWT:                          jwt,
+			KeyspaceAnnotation:           keyspaceAnnotationKey,
+			DBNameAnnotation:             dbNameAnnotationKey,
+			CredentialWrapTTL:            credentialWrapTTL,
+			CredentialFileTemplate:       credentialFileTemplate,
+			SyncDryRun:                   data.Get("sync_dry_run").(bool),
+			RevokeDeletedServiceAccounts: data.Get("revoke_deleted_service_accounts").(bool),
+			WatchMaxBackoff:              watchMaxBackoff,
+			SyncChangeInterval:           syncChangeInterval,
 		}
 
 		entry, err := logical.StorageEntryJSON(kubeconfigPath, config)
@@ -174,10 +408,75 @@ type kubeConfig struct {
 	KeyspaceAnnotation string `json:"keyspace_annotation"`
 	// DBNameAnnotation is the annotation key to look for in service accounts to override database name for a role
 	DBNameAnnotation string `json:"db_name_annotation"`
+	// CredentialWrapTTL, if set, response-wraps the credentials issued for k8s_ roles
+	CredentialWrapTTL time.Duration `json:"credential_wrap_ttl,omitempty"`
+	// CredentialFileTemplate, if set, renders the credentials issued for k8s_ roles into file contents
+	CredentialFileTemplate string `json:"credential_file_template,omitempty"`
+	// SyncDryRun, if set, holds back changes to service account annotations until they are applied with kubeconfig/sync
+	SyncDryRun bool `json:"sync_dry_run,omitempty"`
+	// RevokeDeletedServiceAccounts, if set, revokes the users of service accounts whose annotation is deleted
+	RevokeDeletedServiceAccounts bool `json:"revoke_deleted_service_accounts,omitempty"`
+	// WatchMaxBackoff caps the exponential backoff between restarts of a failing service account watch
+	WatchMaxBackoff time.Duration `json:"watch_max_backoff,omitempty"`
+	// SyncChangeInterval, if set, is the minimum time between changes applied to the same service account
+	SyncChangeInterval time.Duration `json:"sync_change_interval,omitempty"`
 }
 
 const confHelpSyn = `Configures the JWT Public Key and Kubernetes API information.`
 const confHelpDesc = `
 The k8s-controller database reads service account objects via the k8s API.
 This endpoint configures the necessary information to access the Kubernetes API.
+
+If "credential_wrap_ttl" is set, credentials issued for service accounts, from
+roles prefixed with k8s_, are always response-wrapped, whether or not the
+request asked for wrapping. The requester, such as an init container or an
+injector writing to a pod's projected volume, then delivers only the wrapping
+token, which the pod unwraps for the password. A wrapping token can be
+unwrapped once, so a token that was intercepted and used fails to unwrap in
+the pod, rather than the password being silently shared.
+
+If "credential_file_template" is set, credentials issued for service accounts
+also carry "credential_file", the template rendered with .Username,
+.Password, .Role, .DBName, .Expiration and the other response fields under
+.Data, and "refresh_after", the seconds after which to renew the lease or
+fetch new credentials. This is for teams that don't allow database passwords
+in Kubernetes Secrets: an injector sidecar writes "credential_file" to a
+memory-backed (tmpfs) volume shared with the application, rewriting it on
+refresh, so the password is never stored in the Kubernetes API.
+
+If "sync_dry_run" is set, changes to the annotations of service accounts don't
+take effect: roles prefixed with k8s_ use the annotations last applied, and
+the pending changes can be reviewed, and applied, at kubeconfig/sync.
+
+If "revoke_deleted_service_accounts" is set, the users issued for the roles of
+a service account are revoked in the database when the sync deletes its
+annotation, which happens when the service account, or its namespace, is
+deleted, or the annotation is removed. The annotation is only deleted once
+all of them are revoked, so that a failed revocation is retried by the next
+sync. Their leases stay until they expire or are revoked, which then finds
+the users already gone.
+
+A watch of service accounts that fails, such as while the Kubernetes API is
+unreachable, is restarted after a second, doubling with each failure in a row
+up to "watch_max_backoff", with jitter so that Vault nodes don't retry in
+step. If "sync_change_interval" is set, a change to a service account's
+annotation is applied by the periodic sync at least that long after its last
+change. The interval doubles, up to 32 times, while the annotation keeps
+changing, so that a flapping service account doesn't change its roles every
+minute; held changes are listed with "held_until" at kubeconfig/sync, and
+writing to kubeconfig/sync applies them at once.
+`
+
+const confSyncHelpSyn = `Review and apply changes to service account annotations.`
+const confSyncHelpDesc = `
+Reading this endpoint lists the changes the next sync of service account
+annotations would make, without applying them: the service accounts whose
+annotation is created, updated or deleted, with their keyspace and database
+name before and after. If "role" names a concrete role, the role's creation
+statements for each service account before and after the change are included,
+as the k8s_ role of that service account would be changed.
+
+Writing to it syncs the annotations now, applying the changes, and returns
+them. With "sync_dry_run" set on kubeconfig, changes are only applied this
+way, so that they can be reviewed first, such as in a GitOps workflow.
 `
@@ -3,11 +3,15 @@ package database
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/database/dbplugin"
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -22,12 +26,32 @@ func pathCredsCreate(b *databaseBackend) []*framework.Path {
 				},
 			},
 
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ReadOperation: b.pathCredsCreateRead(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathCredsCreateRead(),
+					Summary:  "Generate database credentials for a role.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"username": "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987",
+									"password": "A1a-1uDoi6PSBfu1QuDt",
+								},
+							},
+						}},
+						http.StatusTooManyRequests: {{Description: "The role's max_creds_per_minute or max_concurrent_users was exceeded."}},
+					},
+				},
 			},
 
 			HelpSynopsis:    pathCredsCreateReadHelpSyn,
 			HelpDescription: pathCredsCreateReadHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType: "Role",
+				Action:   "Generate",
+			},
 		},
 		&framework.Path{
 			Pattern: "static-creds/" + framework.GenericNameRegex("name"),
@@ -38,83 +62,352 @@ func pathCredsCreate(b *databaseBackend) []*framework.Path {
 				},
 			},
 
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ReadOperation: b.pathStaticCredsRead(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticCredsRead(),
+					Summary:  "Read the current credentials of a static role.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"username":            "app",
+									"password":            "A1a-1uDoi6PSBfu1QuDt",
+									"ttl":                 3600,
+									"rotation_period":     86400,
+									"last_vault_rotation": "2019-11-01T12:00:00Z",
+								},
+							},
+						}},
+					},
+				},
 			},
 
 			HelpSynopsis:    pathStaticCredsReadHelpSyn,
 			HelpDescription: pathStaticCredsReadHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType: "Static Role",
+				Action:   "Generate",
+			},
 		},
 	}
 }
 
 func (b *databaseBackend) pathCredsCreateRead() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-		name := data.Get("name").(string)
+		return b.issueCredentials(ctx, req, data.Get("name").(string), nil)
+	}
+}
+
+// issueCredentials creates a user for the role called name. For roles that
+// require approval, it creates a pending approval grant instead, unless grant
+// is the approved grant the credentials are issued for.
+func (b *databaseBackend) issueCredentials(ctx context.Context, req *logical.Request, name string, grant *approvalGrant) (*logical.Response, error) {
+	// Get the role
+	role, err := b.Role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
+	}
+	role.Statements.Creation = role.activeCreationStatements(b.clock.Now())
+
+	dbConfig, err := b.DatabaseConfig(ctx, req.Storage, role.DBName)
+	if err != nil {
+		return nil, err
+	}
+
+	// If role name isn't in the database's allowed roles, send back a
+	// permission denied.
+	if !b.roleAllowed(dbConfig, name) {
+		return nil, fmt.Errorf("%q is not an allowed role", name)
+	}
+	if err := b.authorizeRequester(ctx, req, name, role); err != nil {
+		return nil, err
+	}
+
+	if err := dbConfig.issuanceDisabled("the root credentials", dbConfig.RootRotationFailures); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := b.issuancePaused(ctx, req.Storage, role.DBName); err != nil {
+		return nil, err
+	}
+	if err := dbConfig.maintenanceError(role.DBName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := role.issuanceWindowError(name, b.clock.Now()); err != nil {
+		return nil, err
+	}
+	if role.DeterministicUsernames && req.EntityID == "" {
+		return logical.ErrorResponse(fmt.Sprintf("role %q derives usernames from the requesting entity, and the token has none", name)), nil
+	}
+	if role.PasswordDelivery == passwordDeliveryDeferred && requesterIdentity(req) == "" {
+		return logical.ErrorResponse(fmt.Sprintf("role %q defers its passwords, which can only be picked up by a token with an entity or accessor", name)), nil
+	}
+	if role.RequireApproval && grant == nil {
+		return b.createApprovalGrant(ctx, req, name, role)
+	}
 
-		// Get the role
-		role, err := b.Role(ctx, req.Storage, name)
+	// Everything that can refuse the request is checked before the
+	// connection is locked, so that denied or misconfigured requests
+	// don't contend with the requests issuing credentials.
+	ttl, _, err := framework.CalculateTTL(b.System(), 0, role.DefaultTTL, 0, role.MaxTTL, 0, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if role.TTLJitter > 0 {
+		// Jitter the effective TTL, then cap it to the maximums again
+		ttl, _, err = framework.CalculateTTL(b.System(), 0, jitterTTL(ttl, role.TTLJitter), 0, role.MaxTTL, 0, time.Time{})
 		if err != nil {
 			return nil, err
 		}
-		if role == nil {
-			return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
-		}
+	}
+	if role.MaxLifetime > 0 && ttl > role.MaxLifetime {
+		ttl = role.MaxLifetime
+	}
 
-		dbConfig, err := b.DatabaseConfig(ctx, req.Storage, role.DBName)
+	usernameConfig := dbplugin.UsernameConfig{
+		DisplayName: req.DisplayName,
+		RoleName:    name,
+	}
+
+	hash := statementsHash(role.Statements)
+	statements := role.Statements
+	var requestIP string
+	statements.Creation, requestIP, err = b.requestMetadataStatements(role, req, name)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if role.UserSchema {
+		statements, err = withUserSchema(dbConfig.PluginName, statements)
 		if err != nil {
-			return nil, err
+			return logical.ErrorResponse(err.Error()), nil
 		}
+	}
+	producer, err := newCredentialsProducer(dbConfig)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
-		// If role name isn't in the database's allowed roles, send back a
-		// permission denied.
-		if !strutil.StrListContains(dbConfig.AllowedRoles, "*") && !strutil.StrListContainsGlob(dbConfig.AllowedRoles, name) {
-			return nil, fmt.Errorf("%q is not an allowed role", name)
-		}
+	// The virtual roles of Kubernetes service accounts share the limits of
+	// the role they are based on
+	entryName, err := b.roleEntryName(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
 
-		// Get the Database object
-		db, err := b.GetConnection(ctx, req.Storage, role.DBName)
+	if role.MaxConcurrentUsers > 0 {
+		// Hold the role's lock until the new user is indexed, so that
+		// concurrent requests cannot exceed the quota.
+		lock := locksutil.LockForKey(b.roleLocks, entryName)
+		lock.Lock()
+		defer lock.Unlock()
+
+		count, err := b.countRoleUsers(ctx, req.Storage, entryName, b.clock.Now())
 		if err != nil {
 			return nil, err
 		}
+		if count >= role.MaxConcurrentUsers {
+			return nil, logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf("role %q has reached its quota of %d concurrent users; revoke existing leases to issue more", name, role.MaxConcurrentUsers))
+		}
+	}
 
-		db.RLock()
-		defer db.RUnlock()
+	// Tokens are only taken for requests that the quota allows
+	if !b.credLimiters.allow(entryName, role.MaxCredsPerMinute, b.clock.Now()) {
+		return nil, logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf("role %q has reached its limit of %d credentials per minute", name, role.MaxCredsPerMinute))
+	}
 
-		ttl, _, err := framework.CalculateTTL(b.System(), 0, role.DefaultTTL, 0, role.MaxTTL, 0, time.Time{})
-		if err != nil {
-			return nil, err
+	expiration := b.clock.Now().Add(ttl)
+	// Adding a small buffer since the TTL will be calculated again after this call
+	// to ensure the database credential does not expire before the lease
+	expiration = expiration.Add(5 * time.Second)
+
+	var creds *producedCredentials
+	switch {
+	case role.DeterministicUsernames:
+		creds, err = b.produceEntityCredentials(ctx, req, name, role, dbConfig, producer, usernameConfig, expiration)
+	case producer != nil:
+		creds, err = b.produceReservedCredentials(ctx, req.Storage, role.DBName, producer, usernameConfig, expiration)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer creds.clear()
+
+	// Get the Database object
+	db, err := b.GetConnection(ctx, req.Storage, role.DBName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the user, holding the connection's lock only for the call
+	// to the plugin
+	issueTime := b.clock.Now()
+	createCtx, pluginData := withPluginCredentialData(withProducedCredentials(ctx, creds))
+	db.RLock()
+	username, password, err := db.CreateUser(createCtx, statements, usernameConfig, expiration)
+	db.RUnlock()
+	if err != nil {
+		b.CloseIfShutdown(db, err)
+		return b.pluginErrorResponse("create the user", err)
+	}
+	if creds != nil && creds.username != "" {
+		b.releaseUsername(ctx, req.Storage, role.DBName, creds.username)
+	}
+
+	respData := map[string]interface{}{
+		"username": username,
+		"password": password,
+	}
+	credentialFields := []string{"username", "password"}
+	if creds != nil {
+		for k, v := range creds.data {
+			respData[k] = v
+			credentialFields = append(credentialFields, k)
+		}
+	}
+	for k, v := range pluginData {
+		respData[k] = v
+		credentialFields = append(credentialFields, k)
+	}
+
+	err = waitForReplication(ctx, dbConfig)
+	if err == nil && role.VerifyCredentials {
+		err = b.verifyCredentials(ctx, role.DBName, dbConfig, username, password)
+	}
+	if err == nil && len(role.CredentialTemplates) > 0 {
+		var rendered map[string]interface{}
+		rendered, err = renderCredentialTemplates(role.CredentialTemplates, credentialTemplateData{
+			Username:      username,
+			Password:      password,
+			Role:          name,
+			DBName:        role.DBName,
+			Expiration:    issueTime.Add(ttl).Format(time.RFC3339),
+			Data:          respData,
+			ConnectionURL: userConnectionURL(dbConfig.ConnectionDetails, username, password),
+			Connection:    redactedConnectionDetails(dbConfig.ConnectionDetails),
+		})
+		for k, v := range rendered {
+			respData[k] = v
+		}
+	}
+	var kubeconfig *kubeConfig
+	if err == nil && strings.HasPrefix(name, "k8s_") {
+		kubeconfig, err = b.kubeconfig(ctx, req.Storage)
+	}
+	if err == nil && kubeconfig != nil && kubeconfig.CredentialFileTemplate != "" {
+		// Rendered for a sidecar writing the credentials to a file in a
+		// memory-backed volume of the pod
+		rendered, renderErr := renderCredentialTemplate("credential_file_template", kubeconfig.CredentialFileTemplate, credentialTemplateData{
+			Username:   username,
+			Password:   password,
+			Role:       name,
+			DBName:     role.DBName,
+			Expiration: issueTime.Add(ttl).Format(time.RFC3339),
+			Data:       respData,
+		})
+		if renderErr != nil {
+			b.Logger().Error("failed to render the credential file", "role", name, "username", username, "error", renderErr)
+		} else {
+			respData["credential_file"] = rendered
+			respData["refresh_after"] = int64(credentialRefreshAfter(ttl).Seconds())
+		}
+	}
+	if role.ResponseFormat == responseFormatNested {
+		nestCredential(respData, credentialFields, credentialType(dbConfig))
+	}
+	if err == nil && role.PasswordDelivery == passwordDeliveryDeferred {
+		// Everything that reveals the password is held back for the
+		// requester to pick up
+		respData, err = b.deferPassword(ctx, req, name, role, username, respData)
+	}
+	if err != nil {
+		// The user is removed rather than returned to a client that
+		// couldn't use it
+		db.RLock()
+		revokeErr := db.RevokeUser(ctx, withRequestIP(statements, requestIP), username)
+		db.RUnlock()
+		if revokeErr != nil {
+			b.CloseIfShutdown(db, revokeErr)
+			b.Logger().Error("failed to revoke a user that could not be returned", "role", name, "username", username, "error", revokeErr)
 		}
-		expiration := time.Now().Add(ttl)
-		// Adding a small buffer since the TTL will be calculated again after this call
-		// to ensure the database credential does not expire before the lease
-		expiration = expiration.Add(5 * time.Second)
+		return nil, logical.CodedError(http.StatusServiceUnavailable, fmt.Sprintf("revoked the new user of role %q before returning it: %s", name, err))
+	}
+
+	// The user exists now, so a failure to index it is logged rather
+	// than returned, which would leave it without a lease.
+	user := &activeUser{
+		Username:       username,
+		IssueTime:      issueTime,
+		Expiration:     issueTime.Add(ttl),
+		RequestIP:      requestIP,
+		UserSchema:     role.UserSchema,
+		SkipRevocation: role.SkipRevocation,
+
+		DBName:         role.DBName,
+		RoleVersion:    entryVersion(role.Version),
+		StatementsHash: hash,
+	}
+	if role.MaxLifetime > 0 {
+		user.NotAfter = issueTime.Add(role.MaxLifetime)
+	}
+	if err := putActiveUser(ctx, req.Storage, name, user); err != nil {
+		b.Logger().Error("failed to index the new user", "role", name, "username", username, "error", err)
+	}
 
-		usernameConfig := dbplugin.UsernameConfig{
-			DisplayName: req.DisplayName,
-			RoleName:    name,
+	resp := b.Secret(SecretCredsType).Response(respData, map[string]interface{}{
+		"username":              username,
+		"role":                  name,
+		"db_name":               role.DBName,
+		"revocation_statements": role.Statements.Revocation,
+		"role_version":          user.RoleVersion,
+		"statements_hash":       hash,
+	})
+	if role.UserSchema {
+		resp.Secret.InternalData["user_schema"] = true
+	}
+	if role.SkipRevocation {
+		resp.Secret.InternalData["skip_revocation"] = true
+	}
+	if requestIP != "" {
+		resp.Secret.InternalData["request_ip"] = requestIP
+	}
+	if grant != nil {
+		resp.Secret.InternalData["approval_id"] = grant.ID
+		resp.Secret.InternalData["approved_by"] = grant.ApprovedBy
+	}
+	resp.Secret.TTL = role.DefaultTTL
+	if role.TTLJitter > 0 {
+		resp.Secret.TTL = ttl
+	}
+	resp.Secret.MaxTTL = role.MaxTTL
+	if role.MaxLifetime > 0 {
+		resp.Secret.InternalData["not_after"] = user.NotAfter.Format(time.RFC3339Nano)
+		resp.Secret.MaxTTL = capMaxTTL(role.MaxTTL, role.MaxLifetime)
+		if resp.Secret.TTL > ttl {
+			resp.Secret.TTL = ttl
 		}
+	}
 
-		// Create the user
-		username, password, err := db.CreateUser(ctx, role.Statements, usernameConfig, expiration)
-		if err != nil {
-			b.CloseIfShutdown(db, err)
-			return nil, err
+	if kubeconfig != nil && kubeconfig.CredentialWrapTTL > 0 {
+		resp.WrapInfo = &wrapping.ResponseWrapInfo{
+			TTL: kubeconfig.CredentialWrapTTL,
 		}
+	}
 
-		resp := b.Secret(SecretCredsType).Response(map[string]interface{}{
-			"username": username,
-			"password": password,
-		}, map[string]interface{}{
-			"username":              username,
-			"role":                  name,
-			"db_name":               role.DBName,
-			"revocation_statements": role.Statements.Revocation,
-		})
-		resp.Secret.TTL = role.DefaultTTL
-		resp.Secret.MaxTTL = role.MaxTTL
-		return resp, nil
+	return resp, nil
+}
+
+// jitterTTL returns ttl changed by a random amount of up to percent of it,
+// in either direction.
+func jitterTTL(ttl time.Duration, percent int) time.Duration {
+	max := int64(ttl) * int64(percent) / 100
+	if max <= 0 {
+		return ttl
 	}
+	return ttl + time.Duration(rand.Int63n(2*max+1)-max)
 }
 
 func (b *databaseBackend) pathStaticCredsRead() framework.OperationFunc {
@@ -136,15 +429,22 @@ func (b *databaseBackend) pathStaticCredsRead() framework.OperationFunc {
 
 		// If role name isn't in the database's allowed roles, send back a
 		// permission denied.
-		if !strutil.StrListContains(dbConfig.AllowedRoles, "*") && !strutil.StrListContainsGlob(dbConfig.AllowedRoles, name) {
+		if !b.roleAllowed(dbConfig, name) {
 			return nil, fmt.Errorf("%q is not an allowed role", name)
 		}
 
+		if err := dbConfig.issuanceDisabled("this static role", role.StaticAccount.RotationFailures); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err := b.issuancePaused(ctx, req.Storage, role.DBName); err != nil {
+			return nil, err
+		}
+
 		return &logical.Response{
 			Data: map[string]interface{}{
 				"username":            role.StaticAccount.Username,
 				"password":            role.StaticAccount.Password,
-				"ttl":                 role.StaticAccount.PasswordTTL().Seconds(),
+				"ttl":                 role.StaticAccount.PasswordTTL(b.clock.Now()).Seconds(),
 				"rotation_period":     role.StaticAccount.RotationPeriod.Seconds(),
 				"last_vault_rotation": role.StaticAccount.LastVaultRotation,
 			},
@@ -0,0 +1,425 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestJitterTTL(t *testing.T) {
+	if ttl := jitterTTL(time.Hour, 0); ttl != time.Hour {
+		t.Fatalf("expected no jitter, got %s", ttl)
+	}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		ttl := jitterTTL(time.Hour, 10)
+		if ttl < 54*time.Minute || ttl > 66*time.Minute {
+			t.Fatalf("jittered ttl %s is outside the jitter window", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("expected the ttl to vary")
+	}
+}
+
+func TestBackend_ttlJitter(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+
+	resp, err := request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"default_ttl":         "1h",
+		"max_ttl":             "65m",
+		"ttl_jitter":          60,
+	}
+	resp, err = request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for too much jitter, got err:%s resp:%#v", err, resp)
+	}
+	role["ttl_jitter"] = 20
+	resp, err = request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		ttl := resp.Secret.TTL
+		if ttl < 48*time.Minute || ttl > 65*time.Minute {
+			t.Fatalf("ttl %s is outside the jitter window or above max_ttl", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("expected the ttl to vary")
+	}
+}
+
+func TestBackend_k8sCredentialWrapTTL(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/rw",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": testK8SRole,
+		},
+	})
+
+	for key, value := range map[string]interface{}{
+		"serviceaccount/default/s-ledger": saCacheObject{Keyspace: "public"},
+		kubeconfigPath:                    kubeConfig{CredentialWrapTTL: 5 * time.Minute},
+	} {
+		entry, err := logical.StorageEntryJSON(key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/k8s_rw_s-ledger_default"})
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != 5*time.Minute {
+		t.Fatalf("expected the credentials to be wrapped, got %#v", resp.WrapInfo)
+	}
+
+	resp = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/rw"})
+	if resp.WrapInfo != nil {
+		t.Fatalf("expected only service account credentials to be wrapped, got %#v", resp.WrapInfo)
+	}
+}
+
+func TestBackend_k8sCredentialFile(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/rw",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": testK8SRole,
+			"default_ttl":         "1h",
+		},
+	})
+
+	kubeconfig := map[string]interface{}{
+		"kubernetes_host":          "https://127.0.0.1:6443",
+		"kubernetes_ca_cert":       "cert",
+		"jwt":                      "jwt",
+		"credential_file_template": "{{.Username",
+	}
+	if resp, _ := request(&logical.Request{Operation: logical.UpdateOperation, Path: "kubeconfig", Data: kubeconfig}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid template to be rejected, got %#v", resp)
+	}
+
+	for key, value := range map[string]interface{}{
+		"serviceaccount/default/s-ledger": saCacheObject{Keyspace: "public"},
+		kubeconfigPath:                    kubeConfig{CredentialFileTemplate: "postgres://{{.Username}}:{{.Password}}@db/{{.DBName}}"},
+	} {
+		entry, err := logical.StorageEntryJSON(key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/k8s_rw_s-ledger_default"})
+	expected := fmt.Sprintf("postgres://%s:password@db/plugin-test", resp.Data["username"])
+	if resp.Data["credential_file"] != expected || resp.Data["refresh_after"] != int64(40*60) {
+		t.Fatalf("expected the credential file %q, got %#v", expected, resp.Data)
+	}
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/rw"})
+	if _, ok := resp.Data["credential_file"]; ok {
+		t.Fatalf("expected only service account credentials to be rendered, got %#v", resp.Data)
+	}
+}
+
+func TestBackend_credsRefusedWithoutConnectionLock(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	// request fails the test if the request waits on the connection
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		type result struct {
+			resp *logical.Response
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+			done <- result{resp, err}
+		}()
+		select {
+		case r := <-done:
+			return r.resp, r.err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request to %s waited on the connection's lock", req.Path)
+			return nil, nil
+		}
+	}
+
+	resp, err := request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"app"},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	db := &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	b.connections["plugin-test"] = db
+
+	for _, name := range []string{"app", "other"} {
+		resp, err = request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/" + name, Data: map[string]interface{}{
+			"db_name":              "plugin-test",
+			"creation_statements":  `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			"max_creds_per_minute": 1,
+		}})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+	}
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	// Hold the connection's lock, as a reload would
+	db.Lock()
+	defer db.Unlock()
+
+	if _, err := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/other"}); err == nil {
+		t.Fatal("expected an error for a role that isn't allowed")
+	}
+	if _, err := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"}); err == nil {
+		t.Fatal("expected an error for a role over its rate limit")
+	}
+
+	config, err := b.DatabaseConfig(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CredentialsProducer = "unknown"
+	entry, err := logical.StorageEntryJSON("config/plugin-test", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	b.Invalidate(context.Background(), "config/plugin-test")
+
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a misconfigured connection, got err:%s resp:%#v", err, resp)
+	}
+}
+
+func TestUserConnectionURL(t *testing.T) {
+	for details, expected := range map[[2]string]string{
+		{"postgres://{{username}}:{{password}}@db:5432/app", ""}: "postgres://v-app:p%40ss@db:5432/app",
+		{"postgres://vault:root@db:5432/app", "root"}:            "postgres://v-app:p%40ss@db:5432/app",
+		{"{{username}}:{{password}}@tcp(db:3306)/", ""}:          "v-app:p@ss@tcp(db:3306)/",
+		{"user=vault password=root host=db", "root"}:             "",
+		{"", ""}: "",
+	} {
+		connURL := userConnectionURL(map[string]interface{}{"connection_url": details[0], "password": details[1]}, "v-app", "p@ss")
+		if connURL != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, details[0], connURL)
+		}
+	}
+}
+
+func TestBackend_credentialTemplates(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "postgres://{{username}}:{{password}}@db:5432/app",
+			"username":          "vault",
+			"password":          "root-password",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	fake := &fakeIssuingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := func(templates map[string]interface{}) (*logical.Response, error) {
+		return request(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/app",
+			Data: map[string]interface{}{
+				"db_name":              "plugin-test",
+				"creation_statements":  `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+				"credential_templates": templates,
+			},
+		})
+	}
+	for _, templates := range []map[string]interface{}{
+		{"password": "{{.Password}}"},
+		{"jdbc": "{{.Username"},
+	} {
+		if resp, _ := role(templates); resp == nil || !resp.IsError() {
+			t.Fatalf("expected %v to be rejected, got %#v", templates, resp)
+		}
+	}
+
+	if resp, err := role(map[string]interface{}{
+		"jdbc":   "jdbc:postgresql://db:5432/{{.DBName}}?user={{.Username}}&password={{urlquery .Password}}",
+		"dsn":    "{{.ConnectionURL}}",
+		"pgpass": `db:5432:app:{{.Username}}:{{.Password}}:{{index .Connection "username"}}`,
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "roles/app"})
+	if templates := resp.Data["credential_templates"].(map[string]string); len(templates) != 3 {
+		t.Fatalf("expected the templates to be returned, got %#v", resp.Data)
+	}
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if resp.Data["jdbc"] != "jdbc:postgresql://db:5432/plugin-test?user=user-1&password=password" ||
+		resp.Data["dsn"] != "postgres://user-1:password@db:5432/app" ||
+		resp.Data["pgpass"] != "db:5432:app:user-1:password:vault" {
+		t.Fatalf("unexpected rendered fields %#v", resp.Data)
+	}
+
+	// The user is revoked if a template fails to render
+	if resp, err := role(map[string]interface{}{"secret": "{{.Connection.password}}"}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	if resp, err := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"}); err == nil || resp != nil {
+		t.Fatalf("expected the request to fail, got %#v", resp)
+	}
+	if len(fake.revoked) != 1 || fake.revoked[0] != "user-2" {
+		t.Fatalf("expected the user to be revoked, got %q", fake.revoked)
+	}
+}
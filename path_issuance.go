@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathIssuance(b *databaseBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "issuance$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathIssuanceRead,
+					Summary:  "Read whether credential issuance is paused on the mount or for any connection.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"paused": false,
+									"paused_connections": map[string]interface{}{
+										"payments": map[string]interface{}{
+											"reason": "INC-1234",
+											"since":  "2019-11-13T17:26:27Z",
+										},
+									},
+								},
+							},
+						}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathIssuanceHelpSyn,
+			HelpDescription: pathIssuanceHelpDesc,
+		},
+		{
+			Pattern: "issuance/pause$",
+			Fields: map[string]*framework.FieldSchema{
+				"connection": {
+					Type:        framework.TypeString,
+					Description: "Name of a connection to pause. Defaults to pausing the whole mount.",
+				},
+				"reason": {
+					Type: framework.TypeString,
+					Description: `Why issuance is paused, such as an incident
+					reference, included in the errors returned to requests for
+					credentials.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathIssuancePause,
+					Summary:  "Pause credential issuance on the mount or for a connection.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "Issuance was paused."}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathIssuanceHelpSyn,
+			HelpDescription: pathIssuanceHelpDesc,
+		},
+		{
+			Pattern: "issuance/resume$",
+			Fields: map[string]*framework.FieldSchema{
+				"connection": {
+					Type:        framework.TypeString,
+					Description: "Name of a connection to resume. Defaults to resuming the whole mount.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathIssuanceResume,
+					Summary:  "Resume credential issuance on the mount or for a connection.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "Issuance was resumed."}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathIssuanceHelpSyn,
+			HelpDescription: pathIssuanceHelpDesc,
+		},
+	}
+}
+
+func (b *databaseBackend) pathIssuanceRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pauses, err := readIssuancePauses(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"paused": pauses.Mount != nil,
+		},
+	}
+	if pauses.Mount != nil {
+		resp.Data["reason"] = pauses.Mount.Reason
+		resp.Data["since"] = pauses.Mount.Since.Format(time.RFC3339)
+	}
+	connections := make(map[string]interface{}, len(pauses.Connections))
+	for name, pause := range pauses.Connections {
+		connections[name] = map[string]interface{}{
+			"reason": pause.Reason,
+			"since":  pause.Since.Format(time.RFC3339),
+		}
+	}
+	resp.Data["paused_connections"] = connections
+	return resp, nil
+}
+
+func (b *databaseBackend) pathIssuancePause(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	connection := data.Get("connection").(string)
+	reason := data.Get("reason").(string)
+	if connection != "" {
+		if _, err := b.DatabaseConfig(ctx, req.Storage, connection); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	// Pausing again updates the reason, but keeps when the pause started
+	pause := &issuancePause{Reason: reason, Since: b.clock.Now().UTC()}
+	err := b.updateIssuancePauses(ctx, req.Storage, func(pauses *issuancePauses) bool {
+		current := pauses.Mount
+		if connection != "" {
+			current = pauses.Connections[connection]
+		}
+		if current != nil {
+			pause.Since = current.Since
+		}
+
+		if connection == "" {
+			pauses.Mount = pause
+			return true
+		}
+		if pauses.Connections == nil {
+			pauses.Connections = make(map[string]*issuancePause)
+		}
+		pauses.Connections[connection] = pause
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Warn("credential issuance paused", "connection", connection, "reason", reason)
+	return nil, nil
+}
+
+func (b *databaseBackend) pathIssuanceResume(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	connection := data.Get("connection").(string)
+
+	if err := b.resumeIssuance(ctx, req.Storage, connection); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("credential issuance resumed", "connection", connection)
+	return nil, nil
+}
+
+const pathIssuanceHelpSyn = `
+Pause and resume credential issuance on the mount or for a connection.
+`
+
+const pathIssuanceHelpDesc = `
+Writing to "issuance/pause" stops the mount from issuing credentials, for
+example as an emergency brake during an incident. With "connection", only
+the roles of that connection are paused. Requests for dynamic credentials and
+for the passwords of static roles are refused with a 503 error that includes
+"reason", while leases already issued can still be renewed and revoked.
+
+Pauses are stored, so they are kept across restarts and applied by every node
+of the cluster. "issuance/resume" ends a pause; resuming the mount leaves the
+pauses of single connections in place. Reading "issuance" returns the current
+pauses.
+`
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_issuancePause(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(b *databaseBackend, req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(b, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+	paused := func(b *databaseBackend, path, reason string) {
+		t.Helper()
+		_, err := request(b, &logical.Request{Operation: logical.ReadOperation, Path: path})
+		coded, ok := err.(logical.HTTPCodedError)
+		if !ok || coded.Code() != http.StatusServiceUnavailable || !strings.Contains(err.Error(), reason) {
+			t.Fatalf("expected %s to be paused with %q, got %v", path, reason, err)
+		}
+	}
+
+	for _, name := range []string{"orders", "payments"} {
+		mustRequest(&logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config/" + name,
+			Data: map[string]interface{}{
+				"connection_url":    "sample_connection_url",
+				"plugin_name":       "postgresql-database-plugin",
+				"verify_connection": false,
+				"allowed_roles":     []string{"*"},
+			},
+		})
+		b.connections[name] = &dbPluginInstance{
+			Database: &fakeIssuingDatabase{},
+			name:     name,
+			id:       "fake",
+		}
+		mustRequest(&logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + name,
+			Data: map[string]interface{}{
+				"db_name":             name,
+				"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			},
+		})
+	}
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "static-roles/payments-app",
+		Data: map[string]interface{}{
+			"db_name":         "payments",
+			"username":        "app",
+			"rotation_period": 3600,
+		},
+	})
+
+	resp, _ := request(b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issuance/pause",
+		Data:      map[string]interface{}{"connection": "missing"},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected a missing connection to be rejected, got %#v", resp)
+	}
+
+	// Pausing a connection leaves the others issuing
+	mustRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issuance/pause",
+		Data:      map[string]interface{}{"connection": "payments", "reason": "INC-1234"},
+	})
+	paused(b, "creds/payments", `connection "payments" since 2020-01-01T00:00:00Z: INC-1234`)
+	paused(b, "static-creds/payments-app", "INC-1234")
+	mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/orders"})
+
+	// Pausing the mount stops every connection, and survives a restart
+	clock.advance(time.Minute)
+	mustRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issuance/pause",
+		Data:      map[string]interface{}{"reason": "credentials leaked"},
+	})
+	paused(b, "creds/orders", "paused on this mount since 2020-01-01T00:01:00Z: credentials leaked")
+
+	config := logical.TestBackendConfig()
+	config.StorageView = s
+	restarted, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Cleanup(context.Background())
+	paused(restarted.(*databaseBackend), "creds/orders", "credentials leaked")
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "issuance"})
+	connections := resp.Data["paused_connections"].(map[string]interface{})
+	if resp.Data["paused"] != true || resp.Data["reason"] != "credentials leaked" || len(connections) != 1 ||
+		connections["payments"].(map[string]interface{})["reason"] != "INC-1234" {
+		t.Fatalf("unexpected pauses %#v", resp.Data)
+	}
+
+	// Resuming the mount keeps the connection's pause
+	mustRequest(&logical.Request{Operation: logical.UpdateOperation, Path: "issuance/resume"})
+	mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/orders"})
+	paused(b, "creds/payments", "INC-1234")
+
+	// Another node resuming the connection is applied once Vault invalidates
+	// the pauses
+	if err := restarted.(*databaseBackend).resumeIssuance(context.Background(), s, "payments"); err != nil {
+		t.Fatal(err)
+	}
+	paused(b, "creds/payments", "INC-1234")
+	b.Invalidate(context.Background(), issuancePausePath)
+	mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/payments"})
+	mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "static-creds/payments-app"})
+
+	if entry, err := s.Get(context.Background(), issuancePausePath); err != nil || entry != nil {
+		t.Fatalf("expected the pauses to be removed from storage, got %v %v", entry, err)
+	}
+}
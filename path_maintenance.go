@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maintenanceMode refuses new credentials for a connection during a planned
+// maintenance window of its database. Renewals and revocations of the
+// credentials already issued continue, so that their leases are unaffected.
+type maintenanceMode struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+}
+
+// maintenanceError returns an error describing the maintenance of the named
+// connection if it is in maintenance.
+func (c *DatabaseConfig) maintenanceError(name string) error {
+	if !c.Maintenance.Enabled {
+		return nil
+	}
+	msg := fmt.Sprintf("connection %q has been in maintenance since %s, and is not issuing new credentials", name, c.Maintenance.Since.Format(time.RFC3339))
+	if c.Maintenance.Message != "" {
+		msg += ": " + c.Maintenance.Message
+	}
+	return errors.New(msg)
+}
+
+func pathConnectionMaintenance(b *databaseBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/" + framework.GenericNameRegex("name") + "/maintenance$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the database connection.",
+			},
+			"enabled": {
+				Type:        framework.TypeBool,
+				Description: "If true, new credentials are refused for the connection.",
+			},
+			"message": {
+				Type: framework.TypeString,
+				Description: `Why the connection is in maintenance, such as a
+				link to the maintenance window, included in the errors returned
+				to requests for credentials.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConnectionMaintenanceRead,
+				Summary:  "Read whether a connection is in maintenance.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"enabled": true,
+								"message": "Upgrading to PostgreSQL 12",
+								"since":   "2019-11-13T17:26:27Z",
+							},
+						},
+					}},
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConnectionMaintenanceUpdate,
+				Summary:  "Start or end the maintenance of a connection.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "The maintenance mode was saved."}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathConnectionMaintenanceHelpSyn,
+		HelpDescription: pathConnectionMaintenanceHelpDesc,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			ItemType: "Connection",
+			Action:   "Maintenance",
+		},
+	}
+}
+
+func (b *databaseBackend) pathConnectionMaintenanceRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse(respErrEmptyName), nil
+	}
+
+	config, err := b.DatabaseConfig(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"enabled": config.Maintenance.Enabled,
+		},
+	}
+	if config.Maintenance.Enabled {
+		resp.Data["message"] = config.Maintenance.Message
+		resp.Data["since"] = config.Maintenance.Since.Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
+func (b *databaseBackend) pathConnectionMaintenanceUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse(respErrEmptyName), nil
+	}
+
+	// The configuration is rewritten whole, so a rotation of the root
+	// credentials must not be stored in between
+	lock := locksutil.LockForKey(b.connectionLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.DatabaseConfig(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	enabledRaw, ok := data.GetOk("enabled")
+	if !ok {
+		return logical.ErrorResponse("enabled is required"), nil
+	}
+	enabled := enabledRaw.(bool)
+
+	switch {
+	case !enabled:
+		config.Maintenance = maintenanceMode{}
+	case !config.Maintenance.Enabled:
+		config.Maintenance = maintenanceMode{Enabled: true, Since: b.clock.Now().UTC()}
+	}
+	if messageRaw, ok := data.GetOk("message"); ok && enabled {
+		config.Maintenance.Message = messageRaw.(string)
+	}
+
+	if err := b.updateDatabaseConfig(ctx, req.Storage, name, config); err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		b.Logger().Info("connection is in maintenance", "connection", name, "message", config.Maintenance.Message)
+	} else {
+		b.Logger().Info("connection maintenance ended", "connection", name)
+	}
+	return nil, nil
+}
+
+const pathConnectionMaintenanceHelpSyn = `
+Start or end the maintenance of a database connection.
+`
+
+const pathConnectionMaintenanceHelpDesc = `
+While a connection is in maintenance, requests for new credentials from its
+roles are refused with an error that includes "message", so that applications
+can tell a planned maintenance window from an outage. The credentials already
+issued can still be renewed and revoked, and the passwords of static roles can
+still be read, as they do not create users on the database.
+
+Maintenance is stored with the connection, so it is kept across restarts and
+applies on every node until it is ended by writing "enabled" as false.
+`
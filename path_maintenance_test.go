@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// renewingDatabase issues users and counts their renewals.
+type renewingDatabase struct {
+	fakeIssuingDatabase
+	renewed int
+}
+
+func (r *renewingDatabase) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	r.renewed++
+	return nil
+}
+
+func TestBackend_connectionMaintenance(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp := request(req)
+		if resp != nil && resp.IsError() {
+			t.Fatalf("unexpected error: %#v", resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	fake := &renewingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			"default_ttl":         "1h",
+			"max_ttl":             "24h",
+		},
+	})
+
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/plugin-test/maintenance"})
+	if resp.Data["enabled"] != false {
+		t.Fatalf("expected the connection not to be in maintenance, got %#v", resp.Data)
+	}
+	issued := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+
+	resp = request(&logical.Request{Operation: logical.UpdateOperation, Path: "config/plugin-test/maintenance"})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected enabled to be required, got %#v", resp)
+	}
+	mustRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/plugin-test/maintenance",
+		Data: map[string]interface{}{
+			"enabled": true,
+			"message": "Upgrading to PostgreSQL 12",
+		},
+	})
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/plugin-test/maintenance"})
+	if resp.Data["enabled"] != true || resp.Data["message"] != "Upgrading to PostgreSQL 12" || resp.Data["since"] != "2020-01-01T00:00:00Z" {
+		t.Fatalf("unexpected maintenance %#v", resp.Data)
+	}
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/plugin-test"})
+	if resp.Data["maintenance"] != true {
+		t.Fatalf("expected the connection to report its maintenance, got %#v", resp.Data)
+	}
+
+	resp = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "Upgrading to PostgreSQL 12") {
+		t.Fatalf("expected issuance to be refused, got %#v", resp)
+	}
+	if fake.created != 1 {
+		t.Fatalf("expected no user to be created in maintenance, got %d", fake.created)
+	}
+
+	// Leases already issued can be renewed and revoked
+	issued.Secret.IssueTime = time.Now()
+	mustRequest(&logical.Request{Operation: logical.RenewOperation, Secret: issued.Secret})
+	if fake.renewed != 1 {
+		t.Fatalf("expected the lease to be renewed, got %d renewals", fake.renewed)
+	}
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: issued.Secret})
+	if len(fake.revoked) != 1 {
+		t.Fatalf("expected the user to be revoked, got %v", fake.revoked)
+	}
+
+	// Updating the message keeps when the maintenance started
+	clock.advance(time.Hour)
+	mustRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/plugin-test/maintenance",
+		Data: map[string]interface{}{
+			"enabled": true,
+			"message": "Extended until 02:00",
+		},
+	})
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/plugin-test/maintenance"})
+	if resp.Data["message"] != "Extended until 02:00" || resp.Data["since"] != "2020-01-01T00:00:00Z" {
+		t.Fatalf("unexpected maintenance %#v", resp.Data)
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/plugin-test/maintenance",
+		Data:      map[string]interface{}{"enabled": false},
+	})
+	mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if fake.created != 2 {
+		t.Fatalf("expected issuance to resume, got %d users", fake.created)
+	}
+
+	// Maintenance waits for the other writes of the connection
+	lock := locksutil.LockForKey(b.connectionLocks, "plugin-test")
+	lock.Lock()
+	updated := make(chan *logical.Response)
+	go func() {
+		resp, _ := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/plugin-test/maintenance",
+			Storage:   s,
+			Data:      map[string]interface{}{"enabled": true},
+		})
+		updated <- resp
+	}()
+	select {
+	case <-updated:
+		t.Fatal("expected maintenance to wait for the connection's lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Unlock()
+	if resp := <-updated; resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+}
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathReloadConnection(b *databaseBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/" + framework.GenericNameRegex("name") + "/reload$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the database connection to reload.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathConnectionReload,
+					Summary:  "Replace the plugin instance of a connection, once calls in flight have finished.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The connection was reloaded."}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathReloadConnectionHelpSyn,
+			HelpDescription: pathReloadConnectionHelpDesc,
+		},
+		{
+			Pattern: "plugins/" + framework.GenericNameRegex("name") + "/reload$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the database plugin, such as mysql-database-plugin.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathPluginReload,
+					Summary:  "Replace the plugin instances of every connection that uses a plugin.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"reloaded": []string{"orders", "payments"},
+								},
+							},
+						}},
+					},
+				},
+			},
+
+			HelpSynopsis:    pathReloadPluginHelpSyn,
+			HelpDescription: pathReloadPluginHelpDesc,
+		},
+	}
+}
+
+func (b *databaseBackend) pathConnectionReload(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse(respErrEmptyName), nil
+	}
+
+	if _, err := b.DatabaseConfig(ctx, req.Storage, name); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := b.reloadConnection(ctx, req.Storage, name); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *databaseBackend) pathPluginReload(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pluginName := data.Get("name").(string)
+	if pluginName == "" {
+		return logical.ErrorResponse(respErrEmptyName), nil
+	}
+
+	names, err := req.Storage.List(ctx, "config/")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	resp := &logical.Response{}
+	reloaded := []string{}
+	matched := false
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		config, err := b.DatabaseConfig(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if config.PluginName != pluginName {
+			continue
+		}
+		matched = true
+
+		if err := b.reloadConnection(ctx, req.Storage, name); err != nil {
+			resp.AddWarning(fmt.Sprintf("failed to reload connection %q: %s", name, err))
+			continue
+		}
+		reloaded = append(reloaded, name)
+	}
+
+	if !matched {
+		return logical.ErrorResponse(fmt.Sprintf("no connections use plugin %q", pluginName)), nil
+	}
+
+	resp.Data = map[string]interface{}{
+		"reloaded": reloaded,
+	}
+	return resp, nil
+}
+
+// reloadConnection replaces the cached plugin instance of a connection with a
+// new one. The new instance is initialized first, so the connection keeps its
+// current instance if the reload fails. Closing the old instance waits for
+// the calls in flight on it to finish.
+//
+// As when GetConnection creates an instance, the reload is registered in
+// b.connectionCalls, so that an instance created from a configuration that
+// was written, deleted or cleared in the meantime is discarded rather than
+// replacing the newer one.
+func (b *databaseBackend) reloadConnection(ctx context.Context, s logical.Storage, name string) error {
+	b.Lock()
+	if call, ok := b.connectionCalls[name]; ok {
+		// An instance is already being created from the current configuration
+		b.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if b.connectionCalls == nil {
+		b.connectionCalls = make(map[string]*connectionCall)
+	}
+	call := &connectionCall{done: make(chan struct{})}
+	b.connectionCalls[name] = call
+	b.Unlock()
+
+	config, err := b.DatabaseConfig(ctx, s, name)
+	var db *dbPluginInstance
+	if err == nil {
+		db, err = b.newConnection(ctx, name, config)
+	}
+
+	b.Lock()
+	delete(b.connectionCalls, name)
+	old, ok := b.connections[name]
+	switch {
+	case err != nil:
+		ok = false
+	case call.cleared:
+		db.Close()
+		db, ok = nil, false
+		err = fmt.Errorf("connection %q was changed while it was reloaded; retry the reload", name)
+	default:
+		b.connections[name] = db
+	}
+	call.db, call.err = db, err
+	b.Unlock()
+	close(call.done)
+
+	if err != nil {
+		return err
+	}
+	if ok {
+		// Ignore error here since the old instance has already been replaced
+		old.Close()
+	}
+
+	b.Logger().Info("reloaded connection", "connection", name, "plugin", config.PluginName)
+	return nil
+}
+
+const pathReloadConnectionHelpSyn = `
+Reload the plugin instance of a database connection.
+`
+
+const pathReloadConnectionHelpDesc = `
+This path starts a new plugin instance for the connection, initializes it with
+the stored configuration and then swaps it in for the existing instance.
+Requests that started before the reload finish on the existing instance, which
+is closed once they are done. If the new instance cannot be initialized, the
+existing one is kept and the error is returned.
+
+Unlike "reset", the connection is not unavailable while the reload is in
+progress.
+`
+
+const pathReloadPluginHelpSyn = `
+Reload the plugin instances of every connection that uses a database plugin.
+`
+
+const pathReloadPluginHelpDesc = `
+This path reloads each connection whose "plugin_name" matches, as if
+"config/<name>/reload" had been called for it. The names of the reloaded
+connections are returned, along with a warning for each connection that could
+not be reloaded; those connections keep their existing instances.
+
+Builtin plugins run in the backend's process, so their reload re-creates the
+plugin instances, and upgrading their code requires upgrading the backend's
+plugin binary. Connections that run a binary from the mount's
+"plugin_directory", through "plugin_command" or a pinned "plugin_version",
+relaunch it, picking up a binary replaced in the directory; update
+"plugin_sha256" first if the connection sets it. A reload can also be used to
+pick up changed connection settings or to recover a misbehaving instance
+without remounting the backend.
+`
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// reloadTestDatabase is a plugin that fails to initialize if its connection
+// details set "fail".
+type reloadTestDatabase struct {
+	fakeStaticDatabase
+	closed bool
+}
+
+func (f *reloadTestDatabase) Type() (string, error) {
+	return "reload-test", nil
+}
+
+func (f *reloadTestDatabase) Init(ctx context.Context, config map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	if _, ok := config["fail"]; ok {
+		return nil, errors.New("initialization failed")
+	}
+	return config, nil
+}
+
+func (f *reloadTestDatabase) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestBackend_reload(t *testing.T) {
+	databasePlugins["reload-test-database-plugin"] = func() (interface{}, error) {
+		return dbplugin.Database(&reloadTestDatabase{}), nil
+	}
+	defer delete(databasePlugins, "reload-test-database-plugin")
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	write := func(path string, data map[string]interface{}) (*logical.Response, error) {
+		t.Helper()
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+
+	resp, err := write("config/plugin-test", map[string]interface{}{
+		"connection_url":    "test",
+		"plugin_name":       "reload-test-database-plugin",
+		"verify_connection": false,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	first, err := b.GetConnection(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = write("config/plugin-test/reload", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	second, err := b.GetConnection(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.id == first.id || !first.closed {
+		t.Fatal("expected the connection's instance to be replaced and the old one closed")
+	}
+
+	resp, err = write("plugins/reload-test-database-plugin/reload", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["reloaded"], []string{"plugin-test"}) {
+		t.Fatalf("expected plugin-test to be reloaded, got %#v", resp.Data)
+	}
+
+	resp, err = write("plugins/mysql-database-plugin/reload", nil)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a plugin no connection uses, got err:%s resp:%#v", err, resp)
+	}
+
+	resp, err = write("config/missing/reload", nil)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a missing connection, got err:%s resp:%#v", err, resp)
+	}
+
+	// A connection that fails to reload keeps its existing instance
+	current, err := b.GetConnection(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := b.DatabaseConfig(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnectionDetails["fail"] = true
+	entry, err := logical.StorageEntryJSON("config/plugin-test", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	b.Invalidate(context.Background(), "config/plugin-test")
+
+	if _, err := write("config/plugin-test/reload", nil); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	resp, err = write("plugins/reload-test-database-plugin/reload", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	if len(resp.Warnings) != 1 || len(resp.Data["reloaded"].([]string)) != 0 {
+		t.Fatalf("expected a warning for the failed reload, got %#v", resp)
+	}
+	db, err := b.GetConnection(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.id != current.id || current.closed {
+		t.Fatal("expected the existing instance to be kept")
+	}
+}
+
+func TestBackend_reloadDeletedConnection(t *testing.T) {
+	var inits int32
+	started, release := make(chan struct{}), make(chan struct{})
+	var reloaded *slowInitDatabase
+	databasePlugins["slow-init-database-plugin"] = func() (interface{}, error) {
+		reloaded = &slowInitDatabase{inits: &inits, started: started, release: release}
+		return dbplugin.Database(reloaded), nil
+	}
+	defer delete(databasePlugins, "slow-init-database-plugin")
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	entry, err := logical.StorageEntryJSON("config/plugin-test", &DatabaseConfig{
+		PluginName:        "slow-init-database-plugin",
+		ConnectionDetails: map[string]interface{}{"connection_url": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	current := &reloadTestDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{Database: current, name: "plugin-test", id: "current"}
+
+	done := make(chan error)
+	go func() {
+		_, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/plugin-test/reload",
+			Storage:   s,
+		})
+		done <- err
+	}()
+
+	// The connection is deleted while the new instance is initialized
+	<-started
+	resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/plugin-test",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	close(release)
+
+	if err := <-done; err == nil {
+		t.Fatal("expected the reload of a deleted connection to fail")
+	}
+	if _, ok := b.connections["plugin-test"]; ok {
+		t.Fatal("expected the deleted connection not to be brought back by the reload")
+	}
+	if !current.closed || !reloaded.closed {
+		t.Fatal("expected both the old and the reloaded instances to be closed")
+	}
+}
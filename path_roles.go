@@ -3,9 +3,11 @@ package database
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/database/dbplugin"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
@@ -14,27 +16,60 @@ import (
 	"github.com/hashicorp/vault/sdk/queue"
 )
 
+// maxTTLJitter is the largest ttl_jitter percentage a role may set.
+const maxTTLJitter = 50
+
 func pathListRoles(b *databaseBackend) []*framework.Path {
 	return []*framework.Path{
 		&framework.Path{
 			Pattern: "roles/?$",
-
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ListOperation: b.pathRoleList,
+			Fields:  roleListFields(),
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathRoleList,
+					Summary:  "List the configured roles.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example:     logical.ListResponse([]string{"readonly", "readwrite"}),
+						}},
+					},
+				},
 			},
 
 			HelpSynopsis:    pathRoleHelpSyn,
 			HelpDescription: pathRoleHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType:   "Role",
+				Navigation: true,
+			},
 		},
 		&framework.Path{
 			Pattern: "static-roles/?$",
-
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ListOperation: b.pathRoleList,
+			Fields:  roleListFields(),
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathRoleList,
+					Summary:  "List the configured static roles.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example:     logical.ListResponse([]string{"app-static"}),
+						}},
+					},
+				},
 			},
 
 			HelpSynopsis:    pathStaticRoleHelpSyn,
 			HelpDescription: pathStaticRoleHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType:   "Static Role",
+				Navigation: true,
+			},
 		},
 	}
 }
@@ -45,34 +80,127 @@ func pathRoles(b *databaseBackend) []*framework.Path {
 			Pattern:        "roles/" + framework.GenericNameRegex("name"),
 			Fields:         fieldsForType(databaseRolePath),
 			ExistenceCheck: b.pathRoleExistenceCheck,
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ReadOperation:   b.pathRoleRead,
-				logical.CreateOperation: b.pathRoleCreateUpdate,
-				logical.UpdateOperation: b.pathRoleCreateUpdate,
-				logical.DeleteOperation: b.pathRoleDelete,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathRoleRead,
+					Summary:  "Read a role. Names prefixed with k8s_ are resolved against Kubernetes service accounts.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"db_name":               "my-postgres",
+									"creation_statements":   []string{`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';`},
+									"revocation_statements": []string{`DROP ROLE IF EXISTS "{{name}}";`},
+									"rollback_statements":   []string{},
+									"renew_statements":      []string{},
+									"default_ttl":           3600,
+									"max_ttl":               86400,
+								},
+							},
+						}},
+					},
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.recordChanges(b.roleHistory(databaseRolePath), b.pathRoleCreateUpdate),
+					Summary:  "Create a role.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.recordChanges(b.roleHistory(databaseRolePath), b.pathRoleCreateUpdate),
+					Summary:  "Create or update a role.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The role was saved."}},
+						http.StatusOK: {{
+							Description: "The role was saved, with warnings about its configuration.",
+							Example: &logical.Response{
+								Warnings: []string{"No revocation_statements were provided; the plugin's default revocation will be used, which may not remove all grants made by the creation_statements."},
+							},
+						}},
+					},
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.recordChanges(b.roleHistory(databaseRolePath), b.pathRoleDelete),
+					Summary:  "Delete a role.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The role was deleted."}},
+					},
+				},
 			},
 
 			HelpSynopsis:    pathRoleHelpSyn,
 			HelpDescription: pathRoleHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType: "Role",
+				Action:   "Create",
+			},
 		},
 
 		&framework.Path{
 			Pattern:        "static-roles/" + framework.GenericNameRegex("name"),
 			Fields:         fieldsForType(databaseStaticRolePath),
 			ExistenceCheck: b.pathStaticRoleExistenceCheck,
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ReadOperation:   b.pathStaticRoleRead,
-				logical.CreateOperation: b.pathStaticRoleCreateUpdate,
-				logical.UpdateOperation: b.pathStaticRoleCreateUpdate,
-				logical.DeleteOperation: b.pathStaticRoleDelete,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticRoleRead,
+					Summary:  "Read a static role.",
+					Responses: map[int][]framework.Response{
+						http.StatusOK: {{
+							Description: "OK",
+							Example: &logical.Response{
+								Data: map[string]interface{}{
+									"db_name":             "my-postgres",
+									"username":            "app",
+									"rotation_period":     86400,
+									"rotation_statements": []string{`ALTER USER "{{name}}" WITH PASSWORD '{{password}}';`},
+									"last_vault_rotation": "2019-11-01T12:00:00Z",
+								},
+							},
+						}},
+					},
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.recordChanges(b.roleHistory(databaseStaticRolePath), b.pathStaticRoleCreateUpdate),
+					Summary:  "Create a static role, rotating the account's password immediately.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.recordChanges(b.roleHistory(databaseStaticRolePath), b.pathStaticRoleCreateUpdate),
+					Summary:  "Create or update a static role.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The static role was saved."}},
+					},
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.recordChanges(b.roleHistory(databaseStaticRolePath), b.pathStaticRoleDelete),
+					Summary:  "Delete a static role and stop rotating its password.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The static role was deleted."}},
+					},
+				},
 			},
 
 			HelpSynopsis:    pathStaticRoleHelpSyn,
 			HelpDescription: pathStaticRoleHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType: "Static Role",
+				Action:   "Create",
+			},
 		},
 	}
 }
 
+// roleListFields returns the fields accepted when listing roles.
+func roleListFields() map[string]*framework.FieldSchema {
+	fields := listFields()
+	fields["db"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Only return roles which use this database connection.",
+		Query:       true,
+	}
+	return fields
+}
+
 // fieldsForType returns a map of string/FieldSchema items for the given role
 // type. The purpose is to keep the shared fields between dynamic and static
 // roles consistent, and allow for each type to override or provide their own
@@ -82,11 +210,18 @@ func fieldsForType(roleType string) map[string]*framework.FieldSchema {
 		"name": {
 			Type:        framework.TypeString,
 			Description: "Name of the role.",
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Role Name",
+			},
 		},
 		"db_name": {
 			Type:        framework.TypeString,
 			Description: "Name of the database this role acts on.",
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Connection Name",
+			},
 		},
+		"cas": casField,
 	}
 
 	// Get the fields that are specific to the type of role, and add them to the
@@ -113,22 +248,237 @@ func dynamicFields() map[string]*framework.FieldSchema {
 		"default_ttl": {
 			Type:        framework.TypeDurationSecond,
 			Description: "Default ttl for role.",
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Default TTL",
+			},
 		},
 		"max_ttl": {
 			Type:        framework.TypeDurationSecond,
 			Description: "Maximum time a credential is valid for",
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Max TTL",
+			},
+		},
+		"max_lifetime": {
+			Type: framework.TypeDurationSecond,
+			Description: `Hard limit on how long each user of the role exists
+	in the database, from its creation. Leases are not renewed past it, and
+	users still in the database after it are revoked. If unset or zero, users
+	are only limited by max_ttl.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Max Lifetime",
+			},
+		},
+		"ttl_jitter": {
+			Type: framework.TypeInt,
+			Description: `Percentage, up to 50, by which the TTL of each
+	credential is randomly lengthened or shortened, so that credentials issued
+	together do not all expire at once. If unset or zero, the TTL is exact.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "TTL Jitter",
+			},
+		},
+		"max_creds_per_minute": {
+			Type: framework.TypeInt,
+			Description: `Maximum number of credentials issued for the role
+	per minute, allowing bursts of up to this many. Requests over the limit
+	are rejected. If unset or zero, issuance is not limited.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Max Credentials Per Minute",
+			},
+		},
+		"max_concurrent_users": {
+			Type: framework.TypeInt,
+			Description: `Maximum number of users of the role with
+	unexpired leases. Requests over the quota are rejected until leases are
+	revoked or expire. If unset or zero, there is no quota.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Max Concurrent Users",
+			},
+		},
+		"allowed_entity_aliases": {
+			Type: framework.TypeCommaStringSlice,
+			Description: `If set, only entities with one of these aliases may
+	request credentials, given as <mount accessor or type>:<alias name>, such as
+	"kubernetes:payments-*". Alias names may contain globs.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Allowed Entity Aliases",
+			},
+		},
+		"allowed_policies": {
+			Type: framework.TypeCommaStringSlice,
+			Description: `If set, only tokens with one of these policies may
+	request credentials. Requires token-lookup to be configured.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Allowed Policies",
+			},
+		},
+		"issuance_windows": {
+			Type: framework.TypeStringSlice,
+			Description: `If set, credentials are only issued during these
+	weekly windows, given as "<days> <HH:MM>-<HH:MM>", such as
+	"Mon-Fri 08:00-18:00" or "Sat,Sun 10:00-16:00".`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Issuance Windows",
+			},
+		},
+		"issuance_window_timezone": {
+			Type:        framework.TypeString,
+			Description: `Time zone of the issuance windows, such as "Europe/London". Defaults to UTC.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Issuance Window Timezone",
+			},
+		},
+		"require_approval": {
+			Type: framework.TypeBool,
+			Description: `If true, credential requests must be approved by
+	another caller at approvals/<id>/approve before the requester can read the
+	credentials from approvals/<id>/creds.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Require Approval",
+			},
+		},
+		"approval_window": {
+			Type: framework.TypeDurationSecond,
+			Description: `How long a credential request waits to be approved,
+	and once approved to be picked up. Defaults to 15 minutes.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Approval Window",
+			},
+		},
+		"password_delivery": {
+			Type: framework.TypeString,
+			Description: `How the password of each user is delivered. With
+	"response", the default, it is returned by the credential request. With
+	"deferred", the credential request only returns the username, and the
+	requester picks the password up once, in a wrapped response, from
+	creds/<role>/password/<password_id>.`,
+			AllowedValues: []interface{}{passwordDeliveryResponse, passwordDeliveryDeferred},
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Password Delivery",
+			},
+		},
+		"password_pickup_window": {
+			Type: framework.TypeDurationSecond,
+			Description: `How long a deferred password can be picked up for.
+	Defaults to 5 minutes.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Password Pickup Window",
+			},
+		},
+		"skip_revocation": {
+			Type: framework.TypeBool,
+			Description: `If true, revoking or expiring a lease of the role does
+	not remove the user from the database, for roles whose users are
+	de-provisioned by another system. The username is still logged.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Skip Revocation",
+			},
+		},
+		"deterministic_usernames": {
+			Type: framework.TypeBool,
+			Description: `If true, the username of each user is derived from
+	the requesting entity and the role, so that the entity gets the same
+	database user every time.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Deterministic Usernames",
+			},
+		},
+		"verify_credentials": {
+			Type: framework.TypeBool,
+			Description: `If true, a connection is opened as each new user
+	before its credentials are returned, retrying for up to the connection's
+	replication_timeout.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Verify Credentials",
+			},
+		},
+		"credential_templates": {
+			Type: framework.TypeKVPairs,
+			Description: `Further fields of the credentials response, each
+	rendered from a Go template over the new user's credentials and the
+	connection, such as a JDBC URL or a .pgpass line.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Credential Templates",
+			},
+		},
+		"response_format": {
+			Type: framework.TypeString,
+			Description: `Format of the credentials response. With "flat",
+	the default, the credentials are top-level fields such as "username" and
+	"password". With "nested", they are returned under a "credential" object
+	that also gives their "type".`,
+			AllowedValues: []interface{}{responseFormatFlat, responseFormatNested},
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Response Format",
+			},
+		},
+		"user_schema": {
+			Type: framework.TypeBool,
+			Description: `If true, each user is given a schema of the same
+	name that it owns, created with the user and dropped, with its contents,
+	when the user is revoked. Supported by the PostgreSQL and MSSQL plugins.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "User Schema",
+			},
+		},
+		"preset": {
+			Type: framework.TypeString,
+			Description: `Name of a builtin set of creation and revocation
+	statements to use instead of "creation_statements" and
+	"revocation_statements", such as "postgres-readonly". Set to an empty
+	string to keep the expanded statements as the role's own.`,
+			AllowedValues: presetAllowedValues(),
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Statement Preset",
+			},
 		},
 		"creation_statements": {
 			Type: framework.TypeStringSlice,
 			Description: `Specifies the database statements executed to
 	create and configure a user. See the plugin's API page for more
 	information on support and formatting for this parameter.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:     "Creation Statements",
+				EditType: "textarea",
+			},
+		},
+		"creation_statements_next": {
+			Type: framework.TypeStringSlice,
+			Description: `Creation statements staged to replace
+	"creation_statements", such as for the grants of a schema migration.
+	They are used from "creation_statements_next_at", or once activated by
+	"activate_creation_statements_next".`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:     "Next Creation Statements",
+				EditType: "textarea",
+			},
+		},
+		"creation_statements_next_at": {
+			Type: framework.TypeString,
+			Description: `The RFC 3339 time from which users are created with
+	"creation_statements_next". If unset, they are used once activated.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Next Creation Statements At",
+			},
+		},
+		"activate_creation_statements_next": {
+			Type: framework.TypeBool,
+			Description: `If true, "creation_statements_next" replace
+	"creation_statements" with this write.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Activate Next Creation Statements",
+			},
 		},
 		"revocation_statements": {
 			Type: framework.TypeStringSlice,
 			Description: `Specifies the database statements to be executed
 	to revoke a user. See the plugin's API page for more information
 	on support and formatting for this parameter.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:     "Revocation Statements",
+				EditType: "textarea",
+			},
 		},
 		"renew_statements": {
 			Type: framework.TypeStringSlice,
@@ -136,6 +486,10 @@ func dynamicFields() map[string]*framework.FieldSchema {
 	to renew a user. Not every plugin type will support this
 	functionality. See the plugin's API page for more information on
 	support and formatting for this parameter. `,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:     "Renew Statements",
+				EditType: "textarea",
+			},
 		},
 		"rollback_statements": {
 			Type: framework.TypeStringSlice,
@@ -143,6 +497,10 @@ func dynamicFields() map[string]*framework.FieldSchema {
 	rollback a create operation in the event of an error. Not every plugin
 	type will support this functionality. See the plugin's API page for
 	more information on support and formatting for this parameter.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:     "Rollback Statements",
+				EditType: "textarea",
+			},
 		},
 	}
 	return fields
@@ -156,12 +514,44 @@ func staticFields() map[string]*framework.FieldSchema {
 			Type: framework.TypeString,
 			Description: `Name of the static user account for Vault to manage.
 	Requires "rotation_period" to be specified`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Username",
+			},
 		},
 		"rotation_period": {
 			Type: framework.TypeDurationSecond,
 			Description: `Period for automatic
 	credential rotation of the given username. Not valid unless used with
 	"username".`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Rotation Period",
+			},
+		},
+		"password_history": {
+			Type: framework.TypeInt,
+			Description: `Number of previous passwords that rotations of the
+	account must not reuse. A new password is generated whenever one matches
+	the history. Defaults to 0, keeping no history.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Password History",
+			},
+		},
+		"sync_sink": {
+			Type: framework.TypeString,
+			Description: `Name of a secret sink, configured at "sinks/", to
+	write the account's credentials to each time they are rotated. Set to an
+	empty string to stop writing them.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Sync Sink",
+			},
+		},
+		"sync_secret_name": {
+			Type: framework.TypeString,
+			Description: `Name of the secret in "sync_sink" that the
+	credentials are written to. Required with "sync_sink".`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Sync Secret Name",
+			},
 		},
 		"rotation_statements": {
 			Type: framework.TypeStringSlice,
@@ -169,6 +559,10 @@ func staticFields() map[string]*framework.FieldSchema {
 	rotate the accounts credentials. Not every plugin type will support
 	this functionality. See the plugin's API page for more information on
 	support and formatting for this parameter.`,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:     "Rotation Statements",
+				EditType: "textarea",
+			},
 		},
 	}
 	return fields
@@ -191,10 +585,15 @@ func (b *databaseBackend) pathStaticRoleExistenceCheck(ctx context.Context, req
 }
 
 func (b *databaseBackend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, databaseRolePath+data.Get("name").(string))
+	name := data.Get("name").(string)
+	err := b.deleteEntry(ctx, req.Storage, databaseRolePath+name)
 	if err != nil {
 		return nil, err
 	}
+	b.credLimiters.remove(name)
+	if err := forgetRenamesTo(ctx, req.Storage, name); err != nil {
+		return nil, err
+	}
 
 	return nil, nil
 }
@@ -210,7 +609,7 @@ func (b *databaseBackend) pathStaticRoleDelete(ctx context.Context, req *logical
 	// Remove the item from the queue
 	_, _ = b.popFromRotationQueueByKey(name)
 
-	err := req.Storage.Delete(ctx, databaseStaticRolePath+name)
+	err := b.deleteEntry(ctx, req.Storage, databaseStaticRolePath+name)
 	if err != nil {
 		return nil, err
 	}
@@ -230,6 +629,7 @@ func (b *databaseBackend) pathStaticRoleRead(ctx context.Context, req *logical.R
 	data := map[string]interface{}{
 		"db_name":             role.DBName,
 		"rotation_statements": role.Statements.Rotation,
+		"version":             entryVersion(role.Version),
 	}
 
 	// guard against nil StaticAccount; shouldn't happen but we'll be safe
@@ -237,6 +637,21 @@ func (b *databaseBackend) pathStaticRoleRead(ctx context.Context, req *logical.R
 		data["username"] = role.StaticAccount.Username
 		data["rotation_statements"] = role.Statements.Rotation
 		data["rotation_period"] = role.StaticAccount.RotationPeriod.Seconds()
+		data["password_history"] = role.StaticAccount.PasswordHistorySize
+		data["rotation_failures"] = role.StaticAccount.RotationFailures
+		if role.StaticAccount.LastRotationError != "" {
+			data["last_rotation_error"] = role.StaticAccount.LastRotationError
+		}
+		if role.StaticAccount.SyncSink != "" {
+			data["sync_sink"] = role.StaticAccount.SyncSink
+			data["sync_secret_name"] = role.StaticAccount.SyncSecretName
+			if role.StaticAccount.LastSyncError != "" {
+				data["last_sync_error"] = role.StaticAccount.LastSyncError
+			}
+		}
+		if role.StaticAccount.LastRotationHookError != "" {
+			data["last_rotation_hook_error"] = role.StaticAccount.LastRotationHookError
+		}
 		if !role.StaticAccount.LastVaultRotation.IsZero() {
 			data["last_vault_rotation"] = role.StaticAccount.LastVaultRotation
 		}
@@ -268,6 +683,65 @@ func (b *databaseBackend) pathRoleRead(ctx context.Context, req *logical.Request
 		"renew_statements":      role.Statements.Renewal,
 		"default_ttl":           role.DefaultTTL.Seconds(),
 		"max_ttl":               role.MaxTTL.Seconds(),
+		"ttl_jitter":            role.TTLJitter,
+		"max_creds_per_minute":  role.MaxCredsPerMinute,
+		"max_concurrent_users":  role.MaxConcurrentUsers,
+		"version":               entryVersion(role.Version),
+	}
+	if role.Preset != "" {
+		data["preset"] = role.Preset
+	}
+	if len(role.CreationStatementsNext) > 0 {
+		data["creation_statements_next"] = role.CreationStatementsNext
+		data["creation_statements_next_active"] = role.creationStatementsNextActive(b.clock.Now())
+		if !role.CreationStatementsNextAt.IsZero() {
+			data["creation_statements_next_at"] = role.CreationStatementsNextAt.Format(time.RFC3339)
+		}
+	}
+	if role.UserSchema {
+		data["user_schema"] = true
+	}
+	if role.SkipRevocation {
+		data["skip_revocation"] = true
+	}
+	if role.VerifyCredentials {
+		data["verify_credentials"] = true
+	}
+	if len(role.CredentialTemplates) > 0 {
+		data["credential_templates"] = role.CredentialTemplates
+	}
+	if role.ResponseFormat != "" {
+		data["response_format"] = role.ResponseFormat
+	}
+	if role.DeterministicUsernames {
+		data["deterministic_usernames"] = true
+	}
+	if role.MaxLifetime > 0 {
+		data["max_lifetime"] = role.MaxLifetime.Seconds()
+	}
+	if len(role.AllowedEntityAliases) > 0 {
+		data["allowed_entity_aliases"] = role.AllowedEntityAliases
+	}
+	if len(role.AllowedPolicies) > 0 {
+		data["allowed_policies"] = role.AllowedPolicies
+	}
+	if len(role.IssuanceWindows) > 0 {
+		data["issuance_windows"] = role.IssuanceWindows
+	}
+	if role.IssuanceWindowTimezone != "" {
+		data["issuance_window_timezone"] = role.IssuanceWindowTimezone
+	}
+	if role.RequireApproval {
+		data["require_approval"] = true
+	}
+	if role.ApprovalWindow > 0 {
+		data["approval_window"] = role.ApprovalWindow.Seconds()
+	}
+	if role.PasswordDelivery != "" {
+		data["password_delivery"] = role.PasswordDelivery
+	}
+	if role.PasswordPickupWindow > 0 {
+		data["password_pickup_window"] = role.PasswordPickupWindow.Seconds()
 	}
 	if len(role.Statements.Creation) == 0 {
 		data["creation_statements"] = []string{}
@@ -297,6 +771,25 @@ func (b *databaseBackend) pathRoleList(ctx context.Context, req *logical.Request
 		return nil, err
 	}
 
+	if dbName := data.Get("db").(string); dbName != "" {
+		var filtered []string
+		for _, entry := range entries {
+			role, err := b.roleAtPath(ctx, req.Storage, entry, path)
+			if err != nil {
+				return nil, err
+			}
+			if role != nil && role.DBName == dbName {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+
+	entries, err = paginate(entries, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	return logical.ListResponse(entries), nil
 }
 
@@ -306,6 +799,10 @@ func (b *databaseBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 		return logical.ErrorResponse("empty role name attribute given"), nil
 	}
 
+	lock := locksutil.LockForKey(b.roleLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
 	exists, err := b.pathStaticRoleExistenceCheck(ctx, req, data)
 	if err != nil {
 		return nil, err
@@ -318,8 +815,20 @@ func (b *databaseBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 	if err != nil {
 		return nil, err
 	}
+	current := 0
 	if role == nil {
 		role = &roleEntry{}
+	} else {
+		current = entryVersion(role.Version)
+	}
+	version, errResp := checkAndSet(data, current)
+	if errResp != nil {
+		return errResp, nil
+	}
+	role.Version = version
+
+	if errResp := roleFieldErrors(data).response(); errResp != nil {
+		return errResp, nil
 	}
 
 	createOperation := (req.Operation == logical.CreateOperation)
@@ -362,6 +871,45 @@ func (b *databaseBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 			role.Statements.Renewal = data.Get("renew_statements").([]string)
 		}
 
+		if presetRaw, ok := data.GetOk("preset"); ok {
+			role.Preset = presetRaw.(string)
+			if role.Preset != "" {
+				_, creationSet := data.GetOk("creation_statements")
+				_, revocationSet := data.GetOk("revocation_statements")
+				if creationSet || revocationSet {
+					return logical.ErrorResponse("preset cannot be combined with creation_statements or revocation_statements"), nil
+				}
+
+				preset, err := lookupStatementPreset(role.Preset)
+				if err != nil {
+					return logical.ErrorResponse(err.Error()), nil
+				}
+				role.Statements.Creation = append([]string{}, preset.creation...)
+				role.Statements.Revocation = append([]string{}, preset.revocation...)
+			}
+		} else if _, ok := data.GetOk("creation_statements"); ok {
+			// The role's statements no longer come from its preset
+			role.Preset = ""
+		} else if _, ok := data.GetOk("revocation_statements"); ok {
+			role.Preset = ""
+		}
+
+		if nextRaw, ok := data.GetOk("creation_statements_next"); ok {
+			role.CreationStatementsNext = strutil.RemoveEmpty(nextRaw.([]string))
+		}
+		if nextAtRaw, ok := data.GetOk("creation_statements_next_at"); ok {
+			// Validated by roleFieldErrors
+			role.CreationStatementsNextAt, _ = parseCreationStatementsNextAt(nextAtRaw.(string))
+		}
+		// Staged statements that have taken over are kept as the role's own
+		// from its next write
+		activate := data.Get("activate_creation_statements_next").(bool)
+		if activate || role.creationStatementsNextActive(b.clock.Now()) {
+			if err := role.promoteCreationStatementsNext(); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
 		// Do not persist deprecated statements that are populated on role read
 		role.Statements.CreationStatements = ""
 		role.Statements.RevocationStatements = ""
@@ -371,6 +919,35 @@ func (b *databaseBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 
 	role.Statements.Revocation = strutil.RemoveEmpty(role.Statements.Revocation)
 
+	if skipRevocationRaw, ok := data.GetOk("skip_revocation"); ok {
+		role.SkipRevocation = skipRevocationRaw.(bool)
+	}
+	if userSchemaRaw, ok := data.GetOk("user_schema"); ok {
+		role.UserSchema = userSchemaRaw.(bool)
+	}
+	if verifyRaw, ok := data.GetOk("verify_credentials"); ok {
+		role.VerifyCredentials = verifyRaw.(bool)
+	}
+	if deterministicRaw, ok := data.GetOk("deterministic_usernames"); ok {
+		role.DeterministicUsernames = deterministicRaw.(bool)
+	}
+	if templatesRaw, ok := data.GetOk("credential_templates"); ok {
+		role.CredentialTemplates = templatesRaw.(map[string]string)
+	}
+	if formatRaw, ok := data.GetOk("response_format"); ok {
+		role.ResponseFormat = formatRaw.(string)
+		if role.ResponseFormat == responseFormatFlat {
+			role.ResponseFormat = ""
+		}
+	}
+	if role.UserSchema && (len(role.Statements.Creation) == 0 || len(role.Statements.Revocation) == 0) {
+		return logical.ErrorResponse("user_schema requires creation_statements and revocation_statements, as the plugin's defaults would be replaced"), nil
+	}
+
+	if err := b.validateRoleStatements(ctx, req.Storage, role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	// TTLs
 	{
 		if defaultTTLRaw, ok := data.GetOk("default_ttl"); ok {
@@ -385,17 +962,110 @@ func (b *databaseBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 		}
 	}
 
+	if maxLifetimeRaw, ok := data.GetOk("max_lifetime"); ok {
+		role.MaxLifetime = time.Duration(maxLifetimeRaw.(int)) * time.Second
+	}
+	if role.MaxLifetime > 0 && role.SkipRevocation {
+		return logical.ErrorResponse("max_lifetime can't be combined with skip_revocation, as its users are never revoked"), nil
+	}
+
+	if ttlJitterRaw, ok := data.GetOk("ttl_jitter"); ok {
+		role.TTLJitter = ttlJitterRaw.(int)
+	}
+
+	if maxCredsRaw, ok := data.GetOk("max_creds_per_minute"); ok {
+		role.MaxCredsPerMinute = maxCredsRaw.(int)
+	}
+	if maxUsersRaw, ok := data.GetOk("max_concurrent_users"); ok {
+		role.MaxConcurrentUsers = maxUsersRaw.(int)
+	}
+
+	if aliasesRaw, ok := data.GetOk("allowed_entity_aliases"); ok {
+		role.AllowedEntityAliases = strutil.RemoveEmpty(aliasesRaw.([]string))
+		if err := validateEntityAliases(role.AllowedEntityAliases); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if policiesRaw, ok := data.GetOk("allowed_policies"); ok {
+		role.AllowedPolicies = strutil.RemoveEmpty(policiesRaw.([]string))
+	}
+
+	if windowsRaw, ok := data.GetOk("issuance_windows"); ok {
+		role.IssuanceWindows = strutil.RemoveEmpty(windowsRaw.([]string))
+	}
+	if timezoneRaw, ok := data.GetOk("issuance_window_timezone"); ok {
+		role.IssuanceWindowTimezone = timezoneRaw.(string)
+	}
+	if err := validateIssuanceWindows(role.IssuanceWindows, role.IssuanceWindowTimezone); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if requireApprovalRaw, ok := data.GetOk("require_approval"); ok {
+		role.RequireApproval = requireApprovalRaw.(bool)
+	}
+	if approvalWindowRaw, ok := data.GetOk("approval_window"); ok {
+		role.ApprovalWindow = time.Duration(approvalWindowRaw.(int)) * time.Second
+	}
+
+	if deliveryRaw, ok := data.GetOk("password_delivery"); ok {
+		role.PasswordDelivery = deliveryRaw.(string)
+		if role.PasswordDelivery == passwordDeliveryResponse {
+			role.PasswordDelivery = ""
+		}
+	}
+	if windowRaw, ok := data.GetOk("password_pickup_window"); ok {
+		role.PasswordPickupWindow = time.Duration(windowRaw.(int)) * time.Second
+	}
+
 	// Store it
 	entry, err := logical.StorageEntryJSON(databaseRolePath+name, role)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if err := b.putEntry(ctx, req.Storage, entry); err != nil {
+		return nil, err
+	}
+	if err := forgetRoleRename(ctx, req.Storage, name); err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	warnings := b.roleWarnings(role)
+	if len(warnings) == 0 {
+		return nil, nil
+	}
+
+	resp := &logical.Response{}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+// roleWarnings returns warnings about dynamic role configuration that is
+// accepted but likely to surprise the operator at issuance or revocation time.
+func (b *databaseBackend) roleWarnings(role *roleEntry) []string {
+	var warnings []string
+
+	if len(role.Statements.Revocation) == 0 {
+		warnings = append(warnings, "No revocation_statements were provided; the plugin's default revocation will be used, which may not remove all grants made by the creation_statements.")
+	}
+
+	if role.MaxTTL > 0 && role.DefaultTTL > role.MaxTTL {
+		warnings = append(warnings, fmt.Sprintf("default_ttl (%s) is greater than max_ttl (%s); issued credentials will be limited to max_ttl.", role.DefaultTTL, role.MaxTTL))
+	}
+
+	if sys := b.System(); sys != nil {
+		mountMaxTTL := sys.MaxLeaseTTL()
+		if mountMaxTTL > 0 && role.MaxTTL > mountMaxTTL {
+			warnings = append(warnings, fmt.Sprintf("max_ttl (%s) is greater than the mount's maximum lease TTL (%s); issued credentials will be limited to the mount's maximum.", role.MaxTTL, mountMaxTTL))
+		}
+		if mountMaxTTL > 0 && role.DefaultTTL > mountMaxTTL {
+			warnings = append(warnings, fmt.Sprintf("default_ttl (%s) is greater than the mount's maximum lease TTL (%s); issued credentials will be limited to the mount's maximum.", role.DefaultTTL, mountMaxTTL))
+		}
+	}
+
+	return warnings
 }
 
 func (b *databaseBackend) pathStaticRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -427,12 +1097,20 @@ func (b *databaseBackend) pathStaticRoleCreateUpdate(ctx context.Context, req *l
 	// can be used later by database plugins that distinguish between creating and
 	// updating roles, and may use seperate statements depending on the context.
 	createRole := (req.Operation == logical.CreateOperation)
+	current := 0
 	if role == nil {
 		role = &roleEntry{
 			StaticAccount: &staticAccount{},
 		}
 		createRole = true
+	} else {
+		current = entryVersion(role.Version)
+	}
+	version, errResp := checkAndSet(data, current)
+	if errResp != nil {
+		return errResp, nil
 	}
+	role.Version = version
 
 	// DB Attributes
 	if dbNameRaw, ok := data.GetOk("db_name"); ok {
@@ -445,15 +1123,17 @@ func (b *databaseBackend) pathStaticRoleCreateUpdate(ctx context.Context, req *l
 		return logical.ErrorResponse("database name is a required field"), nil
 	}
 
-	username := data.Get("username").(string)
-	if username == "" && createRole {
-		return logical.ErrorResponse("username is a required field to create a static account"), nil
+	// Updates may omit the username, in which case the stored one is kept
+	if usernameRaw, ok := data.GetOk("username"); ok {
+		username := usernameRaw.(string)
+		if role.StaticAccount.Username != "" && role.StaticAccount.Username != username {
+			return logical.ErrorResponse("cannot update static account username"), nil
+		}
+		role.StaticAccount.Username = username
 	}
-
-	if role.StaticAccount.Username != "" && role.StaticAccount.Username != username {
-		return logical.ErrorResponse("cannot update static account username"), nil
+	if role.StaticAccount.Username == "" {
+		return logical.ErrorResponse("username is a required field to create a static account"), nil
 	}
-	role.StaticAccount.Username = username
 
 	// If it's a Create operation, both username and rotation_period must be included
 	rotationPeriodSecondsRaw, ok := data.GetOk("rotation_period")
@@ -471,12 +1151,47 @@ func (b *databaseBackend) pathStaticRoleCreateUpdate(ctx context.Context, req *l
 		role.StaticAccount.RotationPeriod = time.Duration(rotationPeriodSeconds) * time.Second
 	}
 
+	if passwordHistoryRaw, ok := data.GetOk("password_history"); ok {
+		size := passwordHistoryRaw.(int)
+		if size < 0 {
+			return logical.ErrorResponse("password_history must not be negative"), nil
+		}
+		role.StaticAccount.PasswordHistorySize = size
+		if len(role.StaticAccount.PasswordHistory) > size {
+			role.StaticAccount.PasswordHistory = role.StaticAccount.PasswordHistory[:size]
+		}
+	}
+
+	if syncSinkRaw, ok := data.GetOk("sync_sink"); ok {
+		role.StaticAccount.SyncSink = syncSinkRaw.(string)
+		role.StaticAccount.LastSyncError = ""
+	}
+	if syncSecretNameRaw, ok := data.GetOk("sync_secret_name"); ok {
+		role.StaticAccount.SyncSecretName = syncSecretNameRaw.(string)
+	}
+	if role.StaticAccount.SyncSink != "" {
+		sink, err := b.secretSink(ctx, req.Storage, role.StaticAccount.SyncSink)
+		if err != nil {
+			return nil, err
+		}
+		if sink == nil {
+			return logical.ErrorResponse(fmt.Sprintf("secret sink %q does not exist", role.StaticAccount.SyncSink)), nil
+		}
+		if role.StaticAccount.SyncSecretName == "" {
+			return logical.ErrorResponse("sync_secret_name is required with sync_sink"), nil
+		}
+	}
+
 	if rotationStmtsRaw, ok := data.GetOk("rotation_statements"); ok {
 		role.Statements.Rotation = rotationStmtsRaw.([]string)
-	} else if req.Operation == logical.CreateOperation {
+	} else if createRole {
 		role.Statements.Rotation = data.Get("rotation_statements").([]string)
 	}
 
+	if err := b.validateRoleStatements(ctx, req.Storage, role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	// lvr represents the roles' LastVaultRotation
 	lvr := role.StaticAccount.LastVaultRotation
 
@@ -501,7 +1216,7 @@ func (b *databaseBackend) pathStaticRoleCreateUpdate(ctx context.Context, req *l
 		if err != nil {
 			return nil, err
 		}
-		if err := req.Storage.Put(ctx, entry); err != nil {
+		if err := b.putEntry(ctx, req.Storage, entry); err != nil {
 			return nil, err
 		}
 
@@ -521,12 +1236,133 @@ func (b *databaseBackend) pathStaticRoleCreateUpdate(ctx context.Context, req *l
 	return nil, nil
 }
 
+// validateRoleStatements checks the statements of a role against the plugin
+// used by the role's database connection. Roles may be written before the
+// connection they reference, in which case no validation is performed.
+func (b *databaseBackend) validateRoleStatements(ctx context.Context, s logical.Storage, role *roleEntry) error {
+	entry, err := s.Get(ctx, fmt.Sprintf("config/%s", role.DBName))
+	if err != nil {
+		return errwrap.Wrapf("failed to read connection configuration: {{err}}", err)
+	}
+	if entry == nil {
+		return nil
+	}
+
+	var config DatabaseConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return err
+	}
+
+	if role.Preset != "" {
+		if err := validatePresetPlugin(role.Preset, config.PluginName); err != nil {
+			return err
+		}
+	}
+	if role.UserSchema {
+		if err := validateUserSchema(config.PluginName); err != nil {
+			return err
+		}
+	}
+	if role.DeterministicUsernames {
+		if err := validateDeterministicUsernames(config.PluginName); err != nil {
+			return err
+		}
+	}
+	if role.VerifyCredentials {
+		if err := checkLoginSupported(role.DBName, &config); err != nil {
+			return fmt.Errorf("verify_credentials can't be used: %s", err)
+		}
+	}
+
+	if len(role.CreationStatementsNext) > 0 {
+		if err := validateStatements(config.PluginName, dbplugin.Statements{Creation: role.CreationStatementsNext}); err != nil {
+			return fmt.Errorf("creation_statements_next: %s", err)
+		}
+	}
+	return validateStatements(config.PluginName, role.Statements)
+}
+
 type roleEntry struct {
 	DBName        string              `json:"db_name"`
 	Statements    dbplugin.Statements `json:"statements"`
 	DefaultTTL    time.Duration       `json:"default_ttl"`
 	MaxTTL        time.Duration       `json:"max_ttl"`
 	StaticAccount *staticAccount      `json:"static_account" mapstructure:"static_account"`
+
+	// MaxLifetime is the longest a user of the role exists in the database,
+	// regardless of its lease. Zero disables the limit.
+	MaxLifetime time.Duration `json:"max_lifetime,omitempty"`
+
+	// TTLJitter is the percentage by which the TTL of issued credentials is
+	// randomly varied.
+	TTLJitter int `json:"ttl_jitter,omitempty"`
+
+	// MaxCredsPerMinute limits how many credentials are issued for the role
+	// each minute. Zero disables the limit.
+	MaxCredsPerMinute int `json:"max_creds_per_minute,omitempty"`
+
+	// MaxConcurrentUsers limits how many users with unexpired leases the
+	// role may have. Zero disables the quota.
+	MaxConcurrentUsers int `json:"max_concurrent_users,omitempty"`
+
+	// Preset is the name of the statementPresets entry the role's creation
+	// and revocation statements were expanded from, if any.
+	Preset string `json:"preset,omitempty"`
+
+	// CreationStatementsNext are creation statements staged to replace the
+	// role's own from CreationStatementsNextAt, or once they are activated
+	// if it is zero.
+	CreationStatementsNext   []string  `json:"creation_statements_next,omitempty"`
+	CreationStatementsNextAt time.Time `json:"creation_statements_next_at,omitempty"`
+
+	// UserSchema provisions a schema owned by each user of the role.
+	UserSchema bool `json:"user_schema,omitempty"`
+
+	// SkipRevocation leaves the users of the role in the database when their
+	// leases are revoked.
+	SkipRevocation bool `json:"skip_revocation,omitempty"`
+
+	// DeterministicUsernames derives the username of each user from the
+	// requesting entity, with entityUsername.
+	DeterministicUsernames bool `json:"deterministic_usernames,omitempty"`
+
+	// VerifyCredentials logs in as each new user before returning its
+	// credentials.
+	VerifyCredentials bool `json:"verify_credentials,omitempty"`
+
+	// CredentialTemplates render further fields of the credentials
+	// response, keyed by the name of the field.
+	CredentialTemplates map[string]string `json:"credential_templates,omitempty"`
+
+	// ResponseFormat is responseFormatNested if the credentials are returned
+	// under a "credential" object, and empty for the flat format.
+	ResponseFormat string `json:"response_format,omitempty"`
+
+	// AllowedEntityAliases and AllowedPolicies restrict the tokens that may
+	// request credentials for the role, in addition to the ACL policies of
+	// the mount's paths.
+	AllowedEntityAliases []string `json:"allowed_entity_aliases,omitempty"`
+	AllowedPolicies      []string `json:"allowed_policies,omitempty"`
+
+	// IssuanceWindows are the weekly windows, in IssuanceWindowTimezone,
+	// outside of which no credentials are issued for the role.
+	IssuanceWindows        []string `json:"issuance_windows,omitempty"`
+	IssuanceWindowTimezone string   `json:"issuance_window_timezone,omitempty"`
+
+	// RequireApproval makes credential requests wait for the approval of
+	// another caller for up to ApprovalWindow.
+	RequireApproval bool          `json:"require_approval,omitempty"`
+	ApprovalWindow  time.Duration `json:"approval_window,omitempty"`
+
+	// PasswordDelivery is passwordDeliveryDeferred if the passwords of the
+	// role's users are held back from the credential response, to be picked
+	// up within PasswordPickupWindow. It is empty for the default delivery.
+	PasswordDelivery     string        `json:"password_delivery,omitempty"`
+	PasswordPickupWindow time.Duration `json:"password_pickup_window,omitempty"`
+
+	// Version is incremented by every write of the role, for check-and-set
+	// writes.
+	Version int `json:"version,omitempty"`
 }
 
 type staticAccount struct {
@@ -549,6 +1385,30 @@ type staticAccount struct {
 	// RevokeUser is a boolean flag to indicate if Vault should revoke the
 	// database user when the role is deleted
 	RevokeUserOnDelete bool `json:"revoke_user_on_delete"`
+
+	// PasswordHistorySize is the number of previous passwords that rotations
+	// avoid reusing. Zero disables the history.
+	PasswordHistorySize int `json:"password_history_size"`
+
+	// PasswordHistory holds hashes of the most recent passwords Vault set on
+	// the account, newest first.
+	PasswordHistory passwordHistory `json:"password_history,omitempty"`
+
+	// RotationFailures counts consecutive failed rotations, with
+	// LastRotationError holding the most recent error.
+	RotationFailures  int    `json:"rotation_failures"`
+	LastRotationError string `json:"last_rotation_error,omitempty"`
+
+	// SyncSink names the secret sink that the credentials are written to,
+	// under SyncSecretName, after each rotation. LastSyncError holds the
+	// error of the most recent write if it failed.
+	SyncSink       string `json:"sync_sink,omitempty"`
+	SyncSecretName string `json:"sync_secret_name,omitempty"`
+	LastSyncError  string `json:"last_sync_error,omitempty"`
+
+	// LastRotationHookError holds the error of the rotation hook of the
+	// role's connection after the most recent rotation, if it failed.
+	LastRotationHookError string `json:"last_rotation_hook_error,omitempty"`
 }
 
 // NextRotationTime calculates the next rotation by adding the Rotation Period
@@ -565,9 +1425,9 @@ func (s *staticAccount) NextRotationTime() time.Time {
 // TTL is negative, zero is returned. Users should not trust passwords with a
 // Zero TTL, as they are likely in the process of being rotated and will quickly
 // be invalidated.
-func (s *staticAccount) PasswordTTL() time.Duration {
+func (s *staticAccount) PasswordTTL(now time.Time) time.Duration {
 	next := s.NextRotationTime()
-	ttl := next.Sub(time.Now()).Round(time.Second)
+	ttl := next.Sub(now).Round(time.Second)
 	if ttl < 0 {
 		ttl = time.Duration(0)
 	}
@@ -600,6 +1460,28 @@ and "}}" to be replaced.
 
   * "expiration" - The timestamp when this user will expire.
 
+Creation statements may also reference the metadata of the request issuing the
+credential, to tag the user for the database's administrators: "mount" is the
+path of this mount, "role" the name of the role and "request_id" the ID of the
+request in Vault's audit log. For example:
+
+	COMMENT ON ROLE "{{name}}" IS 'issued by {{mount}}{{role}} in request {{request_id}}';
+
+"request_ip" is the IP address of the client requesting the credential, so that
+databases with host-bound users can only be reached by the user from where it
+was requested, for example with MySQL:
+
+	CREATE USER '{{name}}'@'{{request_ip}}' IDENTIFIED BY '{{password}}';
+
+Revocation statements may reference "request_ip" too, which is kept with the
+lease, to drop the user by its name and host:
+
+	DROP USER '{{name}}'@'{{request_ip}}';
+
+The address is the one Vault sees, so clients behind proxies or NAT share the
+address of the proxy, unless the listener is configured to trust the proxy's
+X-Forwarded-For header. Requests are refused if the address is not known.
+
 Example of a decent creation_statements for a postgresql database plugin:
 
 	CREATE ROLE "{{name}}" WITH
@@ -608,6 +1490,102 @@ Example of a decent creation_statements for a postgresql database plugin:
 	  VALID UNTIL '{{expiration}}';
 	GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO "{{name}}";
 
+If the connection named by "db_name" exists, the statements are validated
+against its plugin when the role is written. Placeholders the plugin does not
+replace are rejected, and creation statements must reference both the
+generated username and password. Document databases such as MongoDB expect
+each statement to be a JSON document rather than a query:
+
+	{"db": "admin", "roles": [{"role": "readWrite"}]}
+
+Instead of writing the statements, a role can select a builtin "preset",
+which is expanded into vetted creation and revocation statements when the role
+is written:
+
+  * "postgres-readonly" and "postgres-readwrite" - PostgreSQL users that can
+    read, or read and write, the tables of the public schema.
+
+  * "mysql-readonly" and "mysql-readwrite" - MySQL users that can read, or
+    read and write, all databases.
+
+The expanded statements are returned when reading the role, along with the
+name of the preset. Writing "creation_statements" or "revocation_statements"
+later replaces the preset's statements and clears "preset".
+
+For schema migrations that change the grants users need, the new creation
+statements can be staged in "creation_statements_next" and validated like the
+role's own, without being used. From the RFC 3339 time in
+"creation_statements_next_at", every credential is issued with them, so no
+credential gets the grants of the old schema once the new one is live, nor
+the reverse before. Writing "activate_creation_statements_next" instead
+switches the role over with that write. Either way, the staged statements
+become the role's "creation_statements" when they take over, from the next
+write of the role if they took over on their own. Reading the role shows
+whether "creation_statements_next_active". The staged statements are tested
+with "dbs/<name>/test-role/<role>" by setting "next".
+
+The "skip_revocation" parameter is for roles whose users are de-provisioned by
+another system. Leases issued while it is set are revoked, and expire, without
+the plugin being called, so neither the "revocation_statements" nor the
+plugin's default revocation are run. The username of each skipped revocation
+is logged, and remains in the lease's internal data and the audit log.
+
+The "deterministic_usernames" parameter derives the username of each user from
+the requesting token's entity and the role, as "v-<role>-" followed by 16
+characters of their hash, instead of generating a new one, so that a workload
+reuses one database identity across leases and per-user settings, ownership and
+audit trails in the database persist. Requests from tokens without an entity
+are refused, and an entity can hold one unexpired lease of the role at a time;
+further requests fail with a 409 status until it is revoked. The creation
+statements must accept a user that already exists, and the revocation
+statements should disable the user, for example with "ALTER ROLE ... NOLOGIN",
+rather than drop it. It is supported by the PostgreSQL, MySQL and MSSQL
+plugins, and overrides the connection's "username_template".
+
+The "verify_credentials" parameter makes the backend log in as each new user,
+through a second connection with the connection's options, before returning
+its credentials, so that clients don't receive credentials that don't work yet,
+for example while Cassandra replicates a new user. Failed logins are retried
+for up to the connection's "replication_timeout" (default: 10s), after which
+the user is revoked and the request fails with a 503 status. The connection must pass its credentials in the "username"
+and "password" parameters, and each request takes the time of a new
+connection to the database.
+
+The "credential_templates" parameter adds fields to the credentials returned
+for the role, each rendered from a Go template, so that clients don't each
+assemble connection strings from the credentials. Templates can use
+.Username, .Password, .Role, .DBName, .Expiration and .Data, the other fields
+of the response such as a private key, as well as .ConnectionURL, the
+connection's "connection_url" with the user's credentials in place of the
+connection's, and .Connection, the connection details without the
+connection's credentials. For example:
+
+	credential_templates=jdbc='jdbc:postgresql://db:5432/app?user={{.Username}}&password={{urlquery .Password}}'
+	credential_templates=pgpass='db:5432:app:{{.Username}}:{{.Password}}'
+
+A template that fails to render fails the request, after which the user is
+revoked. "username", "password", "credential_file", "refresh_after" and
+"credential" can't be replaced.
+
+The "response_format" parameter set to "nested" returns the credentials under
+a "credential" object rather than as top-level fields, with a "type" of
+"password", "rsa" or "cert" depending on the connection's
+"credentials_producer", so that clients can handle the credentials of every
+role alike:
+
+	{"credential": {"type": "rsa", "username": "v-app-x1y2", "password": "...", "private_key": "..."}}
+
+The fields rendered from "credential_templates", and Kubernetes roles'
+"credential_file", stay top-level, and templates still see the credentials
+as .Data. The default "flat" format is unchanged for existing clients.
+
+The "user_schema" parameter gives each user a schema of its own as scratch
+space, named after and owned by the user. The schema is created after the
+"creation_statements" and dropped before the "revocation_statements", which
+must both be set. PostgreSQL drops the schema's contents with it; on MSSQL the
+schema must be empty for the revocation to succeed. Leases issued before
+"user_schema" was set are revoked without dropping a schema.
+
 The "revocation_statements" parameter customizes the statement string used to
 revoke a user. Example of a decent revocation_statements for a postgresql
 database plugin:
@@ -621,6 +1599,75 @@ The "renew_statements" parameter customizes the statement string used to renew a
 user.
 The "rollback_statements' parameter customizes the statement string used to
 rollback a change if needed.
+
+The "max_lifetime" parameter bounds how long each user of the role exists in
+the database, whatever happens to its lease. The leases of the role are issued
+and renewed with a max TTL of at most "max_lifetime", and their expiry is kept
+as "not_after" in the lease, so that changes to the role don't extend existing
+users. The periodic function also revokes users still in the database after
+"not_after", for example if a renewal misbehaved or the lease's revocation was
+lost, logging each one and counting it in the "database.max_lifetime.revocation"
+metric. It can't be combined with "skip_revocation".
+
+The "ttl_jitter" parameter varies the TTL of each credential by a random amount
+of up to the given percentage, in either direction, so that the credentials of a
+deployment are not all revoked at the same moment. The jittered TTL is still
+limited by "max_ttl". Renewals use the unjittered TTL.
+
+The "max_creds_per_minute" parameter limits how many credentials are issued
+for the role each minute, protecting databases that cannot create many users
+at once. Bursts of up to the limit are allowed, after which requests are
+rejected with a 429 status until the bucket refills. The limit is kept in
+memory by each Vault node.
+
+The "max_concurrent_users" parameter limits how many users of the role have
+unexpired leases, so that a runaway client cannot create an unbounded number of
+database users. Requests over the quota are rejected with a 429 status. Leases
+issued by versions of this backend without the quota are not counted.
+
+The "allowed_entity_aliases" and "allowed_policies" parameters restrict who may
+request credentials, as a second check after the ACL policies of the mount's
+paths, for databases where a broad policy such as one granting "creds/*" must
+not be enough. With "allowed_entity_aliases", the requesting token's entity
+must have an alias matching one of the entries, given as "<mount accessor or
+type>:<alias name>", such as "kubernetes:payments-*". With "allowed_policies",
+the token must have one of the policies, directly or through its entity or
+groups; the policies are looked up with the token configured at
+"token-lookup". Requests that aren't allowed are refused with a 403 status.
+
+The "issuance_windows" parameter limits when credentials are issued, for
+databases that must not receive new connections during batch jobs or backups.
+Each window is given as "<days> <HH:MM>-<HH:MM>", where the days are "*", a day
+such as "Mon", a range such as "Mon-Fri" or a comma separated list of those. A
+window whose end is before its start, such as "* 22:00-06:00", runs past
+midnight. Times are in "issuance_window_timezone", which defaults to UTC.
+Requests outside of every window are refused with a 403 status that says when
+the next window opens. Renewals of existing leases are not affected.
+
+The "require_approval" parameter makes credential requests wait for the
+approval of a second caller, for break-glass access to production databases.
+Reading "creds/<role>" returns an "approval_id" instead of credentials, which
+another caller approves at "approvals/<id>/approve" within "approval_window",
+after which the requester reads the credentials from "approvals/<id>/creds".
+See "path-help approvals/<id>" for details.
+
+The "password_delivery" parameter set to "deferred" keeps passwords out of the
+credential response, for clients that pass it through systems that only need
+the username. Reading "creds/<role>" creates the user and returns its
+"username" and a "password_id", and the requester picks the password up once,
+in a wrapped response, from "creds/<role>/password/<password_id>" within
+"password_pickup_window". See "path-help creds/<role>/password/<id>" for
+details.
+
+Updating an existing role only changes the parameters that are supplied; for
+example, writing only "default_ttl" leaves the role's statements untouched.
+Invalid parameters are reported together in a single error, each with the
+path of its field, such as "credential_templates.jdbc".
+
+Reads return the "version" of the role, which every write increments. Setting
+"cas" to it on a write only applies the write if the role is still at that
+version, and "cas" 0 only creates the role if it doesn't exist, so declarative
+tooling doesn't overwrite changes made since it last read the role.
 `
 
 const pathStaticRoleHelpDesc = `
@@ -661,4 +1708,19 @@ The "renew_statements" parameter customizes the statement string used to renew a
 user.
 The "rollback_statements' parameter customizes the statement string used to
 rollback a change if needed.
+
+Updating an existing role only changes the parameters that are supplied; for
+example, writing only "rotation_period" leaves the username and
+"rotation_statements" untouched. As for dynamic roles, reads return the
+"version" of the role and writes setting "cas" are only applied at that
+version.
+
+The "sync_sink" and "sync_secret_name" parameters write the credentials to an
+external secret store, configured at "sinks/", each time they are rotated. A
+failed write does not fail the rotation; the error is returned as
+"last_sync_error" when reading the role until a later write succeeds.
+
+If the connection has a "rotation_hook_url", the hook is run with the new
+credentials after each rotation. A failed hook is returned as
+"last_rotation_hook_error".
 `
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRoleCopy(b *databaseBackend) []*framework.Path {
+	return []*framework.Path{{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/copy$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role to copy.",
+			},
+			"target": {
+				Type:        framework.TypeString,
+				Description: "Name of the new role. No role or static role may already exist with this name.",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleCopyUpdate,
+				Summary:  "Copy a role's connection, statements and TTLs to a new role.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "The role was copied."}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleCopyHelpSyn,
+		HelpDescription: pathRoleCopyHelpDesc,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			ItemType: "Role",
+			Action:   "Copy",
+		},
+	}}
+}
+
+func (b *databaseBackend) pathRoleCopyUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	target := data.Get("target").(string)
+	if target == "" {
+		return logical.ErrorResponse("target is required"), nil
+	}
+
+	lock := locksutil.LockForKey(b.roleLocks, target)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Copy the stored role rather than the result of b.Role, so that virtual
+	// Kubernetes roles are not materialised with their annotation inlined.
+	entry, err := req.Storage.Get(ctx, databaseRolePath+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no role found with name %q", name)), nil
+	}
+
+	if err := checkNewRoleName(ctx, req.Storage, target); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entry.Key = databaseRolePath + target
+	if err := b.putEntry(ctx, req.Storage, entry); err != nil {
+		return nil, err
+	}
+	if err := forgetRoleRename(ctx, req.Storage, target); err != nil {
+		return nil, err
+	}
+
+	// The target didn't exist, so the copy is its creation
+	return b.recordChange(ctx, req, b.roleHistory(databaseRolePath), target, nil, nil), nil
+}
+
+const pathRoleCopyHelpSyn = `
+Copy a role to a new name.
+`
+
+const pathRoleCopyHelpDesc = `
+This path creates the role "target" with the same connection, statements and
+TTLs as an existing role. The existing role and its leases are unchanged. The
+target may not be the name of an existing role or static role.
+`
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRoleCredentials(b *databaseBackend) []*framework.Path {
+	fields := listFields()
+	fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Name of the role.",
+	}
+
+	return []*framework.Path{{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/credentials/?$",
+		Fields:  fields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathRoleCredentialsList,
+				Summary:  "List the users of a role whose leases have not been revoked.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: logical.ListResponseWithInfo([]string{"v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987"}, map[string]interface{}{
+							"v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987": map[string]interface{}{
+								"issue_time": "2019-11-13T17:26:27Z",
+								"expiration": "2019-11-13T18:26:27Z",
+								"expired":    false,
+							},
+						}),
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleCredentialsHelpSyn,
+		HelpDescription: pathRoleCredentialsHelpDesc,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			ItemType: "Credential",
+		},
+	}, {
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/credentials/(?P<username>.+)",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"username": {
+				Type:        framework.TypeString,
+				Description: "Username of the user.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleCredentialRead,
+				Summary:  "Read what a user of a role was created with.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"issue_time":      "2019-11-13T17:26:27Z",
+								"expiration":      "2019-11-13T18:26:27Z",
+								"expired":         false,
+								"db_name":         "postgres",
+								"role_version":    3,
+								"statements_hash": "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8",
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleCredentialHelpSyn,
+		HelpDescription: pathRoleCredentialHelpDesc,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			ItemType: "Credential",
+		},
+	}}
+}
+
+func (b *databaseBackend) pathRoleCredentialsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	usernames, err := req.Storage.List(ctx, databaseActiveUserPath+name+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	usernames, err = paginate(usernames, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	now := b.clock.Now()
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, username := range usernames {
+		user, err := getActiveUser(ctx, req.Storage, name, username)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.stale(now) {
+			continue
+		}
+
+		keys = append(keys, username)
+		keyInfo[username] = activeUserInfo(user, now)
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *databaseBackend) pathRoleCredentialRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	user, err := getActiveUser(ctx, req.Storage, data.Get("name").(string), data.Get("username").(string))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
+	return &logical.Response{Data: activeUserInfo(user, b.clock.Now())}, nil
+}
+
+func activeUserInfo(user *activeUser, now time.Time) map[string]interface{} {
+	info := user.issuanceMetadata()
+	info["issue_time"] = user.IssueTime.Format(time.RFC3339)
+	info["expiration"] = user.Expiration.Format(time.RFC3339)
+	info["expired"] = now.After(user.Expiration)
+	return info
+}
+
+const pathRoleCredentialsHelpSyn = `
+List the users of a role that currently hold credentials.
+`
+
+const pathRoleCredentialsHelpDesc = `
+This path lists the database users created for a role whose leases have not
+been revoked, with the time each was issued and when its lease expires. Users
+whose lease expired are listed with "expired" set until the lease's revocation
+removes them, as they may still exist in the database if revocation is failing.
+
+Users are recorded as they are issued and removed as their leases are revoked,
+including for Kubernetes roles, which are listed under their full k8s_ name.
+Users issued by versions of this backend without the index are not listed.
+
+Each user is listed with the connection it was created on, the version of the
+role and a hash of the role's statements when it was issued, as read from
+"roles/<name>/credentials/<username>".
+`
+
+const pathRoleCredentialHelpSyn = `
+Read what a user of a role was created with.
+`
+
+const pathRoleCredentialHelpDesc = `
+This path returns the connection a user of the role was created on in
+"db_name", the version of the role it was issued from in "role_version" and
+"statements_hash", a SHA-256 hash of the role's creation, revocation,
+rollback and renewal statements at the time, with the annotation of a
+Kubernetes role filled in. Comparing the hash to that of the role's current
+statements shows whether an old credential still carries the role's current
+privileges; the role's change history holds the statements of each version.
+
+Vault's lease lookup doesn't return the internal data of leases, where these
+are also recorded, so they are read from here.
+`
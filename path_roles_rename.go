@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxRenameHops bounds how many renames are followed when resolving the role
+// of a lease, guarding against cycles in stored rename records.
+const maxRenameHops = 16
+
+// roleNameRegex matches the role names accepted by the "roles/" path.
+var roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+func pathRoleRename(b *databaseBackend) []*framework.Path {
+	return []*framework.Path{{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/rename$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role to rename.",
+			},
+			"new_name": {
+				Type:        framework.TypeString,
+				Description: "New name of the role. No role or static role may already exist with this name.",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleRenameUpdate,
+				Summary:  "Rename a role, keeping outstanding leases renewable and revocable.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "The role was renamed."}},
+					http.StatusOK:        {{Description: "The role was renamed, with a warning that its connection doesn't allow its new name."}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleRenameHelpSyn,
+		HelpDescription: pathRoleRenameHelpDesc,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			ItemType: "Role",
+			Action:   "Rename",
+		},
+	}}
+}
+
+// roleRename records that a role was renamed, so that leases issued under the
+// old name can find the role they were issued against.
+type roleRename struct {
+	NewName string `json:"new_name"`
+}
+
+func (b *databaseBackend) pathRoleRenameUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	newName := data.Get("new_name").(string)
+	if newName == "" {
+		return logical.ErrorResponse("new_name is required"), nil
+	}
+	if newName == name {
+		return logical.ErrorResponse("new_name must differ from the current name"), nil
+	}
+
+	for _, lock := range locksutil.LocksForKeys(b.roleLocks, []string{name, newName}) {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	entry, err := req.Storage.Get(ctx, databaseRolePath+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no role found with name %q", name)), nil
+	}
+
+	if err := checkNewRoleName(ctx, req.Storage, newName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	history := b.roleHistory(databaseRolePath)
+	before, err := history.read(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the new role before anything is removed, so that a failure part
+	// way through leaves the role available under at least one name.
+	entry.Key = databaseRolePath + newName
+	if err := b.putEntry(ctx, req.Storage, entry); err != nil {
+		return nil, err
+	}
+
+	rename, err := logical.StorageEntryJSON(databaseRoleRenamePath+name, &roleRename{NewName: newName})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, rename); err != nil {
+		return nil, err
+	}
+	if err := forgetRoleRename(ctx, req.Storage, newName); err != nil {
+		return nil, err
+	}
+
+	if err := moveActiveUsers(ctx, req.Storage, name, newName); err != nil {
+		return nil, err
+	}
+
+	if err := b.deleteEntry(ctx, req.Storage, databaseRolePath+name); err != nil {
+		return nil, err
+	}
+	b.credLimiters.remove(name)
+
+	// The role keeps its connection, which may not allow its new name
+	var role roleEntry
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, err
+	}
+	var resp *logical.Response
+	if config, err := b.DatabaseConfig(ctx, req.Storage, role.DBName); err == nil && !b.roleAllowed(config, newName) {
+		resp = &logical.Response{}
+		resp.AddWarning(fmt.Sprintf("the allowed_roles of connection %q don't include %q, so no credentials can be issued for the role until it is added", role.DBName, newName))
+	}
+
+	// The rename creates the role under its new name and deletes the old one
+	resp = b.recordChange(ctx, req, history, newName, nil, resp)
+	return b.recordChange(ctx, req, history, name, before, resp), nil
+}
+
+// forgetRoleRename removes the record of a role renamed from name, once a
+// role is created with that name. Leases issued under the name then belong to
+// the new role, which leaseRole finds first.
+func forgetRoleRename(ctx context.Context, s logical.Storage, name string) error {
+	return s.Delete(ctx, databaseRoleRenamePath+name)
+}
+
+// forgetRenamesTo removes the records of the renames that lead to the role
+// called name, once it is deleted, as the leases of its previous names have
+// no role left to find.
+func forgetRenamesTo(ctx context.Context, s logical.Storage, name string) error {
+	oldNames, err := s.List(ctx, databaseRoleRenamePath)
+	if err != nil {
+		return err
+	}
+
+	renames := map[string]string{}
+	for _, oldName := range oldNames {
+		entry, err := s.Get(ctx, databaseRoleRenamePath+oldName)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var rename roleRename
+		if err := entry.DecodeJSON(&rename); err != nil {
+			return err
+		}
+		renames[oldName] = rename.NewName
+	}
+
+	// Follow the chain of renames back from the deleted role
+	ended := map[string]bool{name: true}
+	for found := true; found; {
+		found = false
+		for oldName, newName := range renames {
+			if !ended[newName] || ended[oldName] {
+				continue
+			}
+			if err := s.Delete(ctx, databaseRoleRenamePath+oldName); err != nil {
+				return err
+			}
+			ended[oldName] = true
+			found = true
+		}
+	}
+	return nil
+}
+
+// checkNewRoleName returns an error if name is not a valid role name, or is
+// already in use by a role or static role.
+func checkNewRoleName(ctx context.Context, s logical.Storage, name string) error {
+	if !roleNameRegex.MatchString(name) {
+		return fmt.Errorf("%q is not a valid role name", name)
+	}
+
+	for _, prefix := range []string{databaseRolePath, databaseStaticRolePath} {
+		existing, err := s.Get(ctx, prefix+name)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return fmt.Errorf("a role named %q already exists", name)
+		}
+	}
+
+	return nil
+}
+
+// leaseRole returns the dynamic role a lease was issued against, and its
+// current name. If no role exists with the name recorded in the lease, renames
+// of the role since the lease was issued are followed.
+func (b *databaseBackend) leaseRole(ctx context.Context, s logical.Storage, roleName string) (*roleEntry, string, error) {
+	for i := 0; i < maxRenameHops; i++ {
+		role, err := b.Role(ctx, s, roleName)
+		if err != nil || role != nil {
+			return role, roleName, err
+		}
+
+		// Leases of Kubernetes roles record the name of the virtual role, so
+		// follow renames of the concrete role it is based on.
+		prefix, concrete, suffix := "", roleName, ""
+		if strings.HasPrefix(roleName, "k8s_") {
+			subs := strings.SplitN(roleName, "_", 3)
+			if len(subs) < 3 {
+				return nil, "", nil
+			}
+			prefix, concrete, suffix = "k8s_", subs[1], "_"+subs[2]
+		}
+
+		entry, err := s.Get(ctx, databaseRoleRenamePath+concrete)
+		if err != nil {
+			return nil, "", err
+		}
+		if entry == nil {
+			return nil, "", nil
+		}
+
+		var rename roleRename
+		if err := entry.DecodeJSON(&rename); err != nil {
+			return nil, "", err
+		}
+		roleName = prefix + rename.NewName + suffix
+	}
+
+	return nil, "", fmt.Errorf("role %q was renamed more than %d times", roleName, maxRenameHops)
+}
+
+const pathRoleRenameHelpSyn = `
+Rename a role.
+`
+
+const pathRoleRenameHelpDesc = `
+This path renames a role to "new_name". Leases issued under the old name
+continue to renew and revoke using the renamed role, until a role is created
+with the old name or the renamed role is deleted. A role may not be renamed
+to the name of an existing role or static role. The response warns if the
+allowed_roles of the role's connection don't include the new name.
+`
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -376,6 +377,247 @@ func TestBackend_StaticRole_Updates(t *testing.T) {
 	}
 }
 
+func TestBackend_RoleRename(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	for _, name := range []string{"plugin-role-test", "taken"} {
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + name,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"db_name":     "plugin-test",
+				"default_ttl": "5m",
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+	}
+
+	rename := func(name, newName string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name + "/rename",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"new_name": newName,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := rename("plugin-role-test", "taken"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error renaming onto an existing role, got: %#v", resp)
+	}
+	if resp := rename("missing", "other"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error renaming a missing role, got: %#v", resp)
+	}
+	if resp := rename("plugin-role-test", "renamed"); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp := rename("renamed", "renamed-again"); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	role, err := b.Role(context.Background(), s, "plugin-role-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != nil {
+		t.Fatal("expected old role name to be removed")
+	}
+
+	role, err = b.Role(context.Background(), s, "renamed-again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role == nil || role.DefaultTTL != 5*time.Minute {
+		t.Fatalf("expected renamed role to keep its settings, got: %#v", role)
+	}
+
+	// Leases issued under either earlier name resolve to the renamed role
+	for _, name := range []string{"plugin-role-test", "renamed"} {
+		leaseRole, _, err := b.leaseRole(context.Background(), s, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(role, leaseRole); diff != nil {
+			t.Fatalf("lease role for %q: %v", name, diff)
+		}
+	}
+
+	// Renaming the role to a name its connection doesn't allow warns
+	resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"renamed*"},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+	if resp := rename("renamed-again", "moved"); resp == nil || resp.IsError() || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "allowed_roles") {
+		t.Fatalf("expected a warning about allowed_roles, got: %#v", resp)
+	}
+
+	// Creating a role under a previous name replaces its rename record, and
+	// deleting the role at the end of the chain removes the rest
+	resp, err = b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/plugin-role-test",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"db_name":     "plugin-test",
+			"default_ttl": "10m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+	renames, err := s.List(context.Background(), databaseRoleRenamePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(renames, []string{"renamed", "renamed-again"}); diff != nil {
+		t.Fatalf("unexpected rename records: %v", diff)
+	}
+
+	resp, err = b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/moved",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+	renames, err = s.List(context.Background(), databaseRoleRenamePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renames) != 0 {
+		t.Fatalf("expected the rename records to be removed, got %v", renames)
+	}
+	if role, _, err := b.leaseRole(context.Background(), s, "plugin-role-test"); err != nil || role == nil || role.DefaultTTL != 10*time.Minute {
+		t.Fatalf("expected the recreated role, got %#v, %v", role, err)
+	}
+}
+
+func TestBackend_RolePartialUpdate(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	write := func(op logical.Operation, data map[string]interface{}) {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      "roles/plugin-role-test",
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+	}
+
+	write(logical.CreateOperation, map[string]interface{}{
+		"db_name":               "plugin-test",
+		"creation_statements":   testRole,
+		"revocation_statements": defaultRevocationSQL,
+		"default_ttl":           "5m",
+		"max_ttl":               "10m",
+	})
+	created, err := b.Role(context.Background(), s, "plugin-role-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(logical.UpdateOperation, map[string]interface{}{
+		"default_ttl": "7m",
+	})
+
+	role, err := b.Role(context.Background(), s, "plugin-role-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.DefaultTTL != 7*time.Minute || role.MaxTTL != 10*time.Minute {
+		t.Fatalf("unexpected TTLs: default %s, max %s", role.DefaultTTL, role.MaxTTL)
+	}
+	if diff := deep.Equal(role.Statements, created.Statements); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestBackend_RoleCopy(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/staging-rw",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": testK8SRole,
+			"default_ttl":         "5m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	copyRole := func(name, target string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name + "/copy",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"target": target,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := copyRole("staging-rw", "prod-rw"); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp := copyRole("staging-rw", "prod-rw"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error copying onto an existing role, got: %#v", resp)
+	}
+	if resp := copyRole("staging-rw", "bad name"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error copying to an invalid name, got: %#v", resp)
+	}
+
+	source, err := b.Role(context.Background(), s, "staging-rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := b.Role(context.Background(), s, "prod-rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source == nil || copied == nil {
+		t.Fatalf("expected both roles to exist, got %#v and %#v", source, copied)
+	}
+	if diff := deep.Equal(source, copied); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
 func TestBackend_StaticRole_Role_name_check(t *testing.T) {
 	cluster, sys := getCluster(t)
 	defer cluster.Cleanup()
@@ -2,10 +2,10 @@ package database
 
 import (
 	"context"
-	"fmt"
-	"time"
+	"net/http"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/hashicorp/vault/sdk/queue"
 )
@@ -21,12 +21,23 @@ func pathRotateCredentials(b *databaseBackend) []*framework.Path {
 				},
 			},
 
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.UpdateOperation: b.pathRotateCredentialsUpdate(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRotateCredentialsUpdate(),
+					Summary:  "Rotate the root credentials of a connection.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The root credentials were rotated."}},
+					},
+				},
 			},
 
-			HelpSynopsis:    pathCredsCreateReadHelpSyn,
-			HelpDescription: pathCredsCreateReadHelpDesc,
+			HelpSynopsis:    pathRotateCredentialsUpdateHelpSyn,
+			HelpDescription: pathRotateCredentialsUpdateHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType: "Connection",
+				Action:   "Rotate Root",
+			},
 		},
 		&framework.Path{
 			Pattern: "rotate-role/" + framework.GenericNameRegex("name"),
@@ -37,12 +48,23 @@ func pathRotateCredentials(b *databaseBackend) []*framework.Path {
 				},
 			},
 
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.UpdateOperation: b.pathRotateRoleCredentialsUpdate(),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRotateRoleCredentialsUpdate(),
+					Summary:  "Rotate the password of a static role immediately.",
+					Responses: map[int][]framework.Response{
+						http.StatusNoContent: {{Description: "The rotation was attempted and the role was requeued."}},
+					},
+				},
 			},
 
-			HelpSynopsis:    pathCredsCreateReadHelpSyn,
-			HelpDescription: pathCredsCreateReadHelpDesc,
+			HelpSynopsis:    pathRotateRoleCredentialsUpdateHelpSyn,
+			HelpDescription: pathRotateRoleCredentialsUpdateHelpDesc,
+
+			DisplayAttrs: &framework.DisplayAttributes{
+				ItemType: "Static Role",
+				Action:   "Rotate",
+			},
 		},
 	}
 }
@@ -54,49 +76,14 @@ func (b *databaseBackend) pathRotateCredentialsUpdate() framework.OperationFunc
 			return logical.ErrorResponse(respErrEmptyName), nil
 		}
 
-		config, err := b.DatabaseConfig(ctx, req.Storage, name)
-		if err != nil {
-			return nil, err
-		}
-
-		db, err := b.GetConnection(ctx, req.Storage, name)
-		if err != nil {
-			return nil, err
-		}
-
-		// Take out the backend lock since we are swapping out the connection
-		b.Lock()
-		defer b.Unlock()
-
-		// Take the write lock on the instance
-		db.Lock()
-		defer db.Unlock()
-
-		connectionDetails, err := db.RotateRootCredentials(ctx, config.RootCredentialsRotateStatements)
-		if err != nil {
-			return nil, err
-		}
-
-		config.ConnectionDetails = connectionDetails
-		entry, err := logical.StorageEntryJSON(fmt.Sprintf("config/%s", name), config)
-		if err != nil {
+		if err := b.rotateRootCredentials(ctx, req.Storage, name); err != nil {
 			return nil, err
 		}
-		if err := req.Storage.Put(ctx, entry); err != nil {
-			return nil, err
-		}
-
-		// Close the plugin
-		db.closed = true
-		if err := db.Database.Close(); err != nil {
-			b.Logger().Error("error closing the database plugin connection", "err", err)
-		}
-		// Even on error, still remove the connection
-		delete(b.connections, name)
 
 		return nil, nil
 	}
 }
+
 func (b *databaseBackend) pathRotateRoleCredentialsUpdate() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		name := data.Get("name").(string)
@@ -104,6 +91,12 @@ func (b *databaseBackend) pathRotateRoleCredentialsUpdate() framework.OperationF
 			return logical.ErrorResponse("empty role name attribute given"), nil
 		}
 
+		// Hold the role's lock so that failures recorded on the role do not
+		// race with the rotation queue
+		lock := locksutil.LockForKey(b.roleLocks, name)
+		lock.Lock()
+		defer lock.Unlock()
+
 		role, err := b.StaticRole(ctx, req.Storage, name)
 		if err != nil {
 			return nil, err
@@ -129,7 +122,7 @@ func (b *databaseBackend) pathRotateRoleCredentialsUpdate() framework.OperationF
 			b.logger.Warn("unable to rotate credentials in rotate-role", "error", err)
 			// Update the priority to re-try this rotation and re-add the item to
 			// the queue
-			item.Priority = time.Now().Add(10 * time.Second).Unix()
+			item.Priority = b.recordStaticRotationFailure(ctx, req.Storage, name, role, err).Unix()
 
 			// Preserve the WALID if it was returned
 			if resp.WALID != "" {
@@ -154,6 +147,9 @@ Request to rotate the root credentials for a certain database connection.
 
 const pathRotateCredentialsUpdateHelpDesc = `
 This path attempts to rotate the root credentials for the given database. 
+
+Connections using the "dual" root_rotation_strategy set a new password on the
+secondary user and switch to it, rather than changing the password in use.
 `
 
 const pathRotateRoleCredentialsUpdateHelpSyn = `
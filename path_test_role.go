@@ -0,0 +1,224 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testRoleUserTTL is the expiration of the users created by tests of roles,
+// so that plugins supporting expirations remove the user if it can't be
+// revoked.
+const testRoleUserTTL = 5 * time.Minute
+
+// testRoleStep is the outcome of one step of a role test.
+type testRoleStep struct {
+	Step     string
+	Err      error
+	Duration time.Duration
+}
+
+func (s testRoleStep) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"step":        s.Step,
+		"success":     s.Err == nil,
+		"duration_ms": s.Duration.Milliseconds(),
+	}
+	if s.Err != nil {
+		data["error"] = s.Err.Error()
+	}
+	return data
+}
+
+func pathTestRole(b *databaseBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "dbs/" + framework.GenericNameRegex("name") + "/test-role/" + framework.GenericNameRegex("role") + "$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the database connection.",
+			},
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Name of the role to test, which must use the connection.",
+			},
+			"next": {
+				Type:        framework.TypeBool,
+				Description: "If true, test the role's creation_statements_next rather than the statements it issues credentials with.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTestRoleWrite,
+				Summary:  "Create, log in as and revoke a throwaway user of a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"success":  true,
+								"username": "v-root-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987",
+								"steps": []map[string]interface{}{
+									{"step": "create", "success": true, "duration_ms": 12},
+									{"step": "login", "success": true, "duration_ms": 30},
+									{"step": "revoke", "success": true, "duration_ms": 8},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathTestRoleHelpSyn,
+		HelpDescription: pathTestRoleHelpDesc,
+	}
+}
+
+func (b *databaseBackend) pathTestRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	roleName := data.Get("role").(string)
+
+	config, err := b.DatabaseConfig(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	role, err := b.Role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+	if role.DBName != name {
+		return logical.ErrorResponse(fmt.Sprintf("role %q uses connection %q, not %q", roleName, role.DBName, name)), nil
+	}
+
+	if data.Get("next").(bool) {
+		if len(role.CreationStatementsNext) == 0 {
+			return logical.ErrorResponse(fmt.Sprintf("role %q has no creation_statements_next", roleName)), nil
+		}
+		role.Statements.Creation = role.CreationStatementsNext
+	} else {
+		role.Statements.Creation = role.activeCreationStatements(b.clock.Now())
+	}
+
+	statements := role.Statements
+	var requestIP string
+	statements.Creation, requestIP, err = b.requestMetadataStatements(role, req, roleName)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	statements = withRequestIP(statements, requestIP)
+	if role.UserSchema {
+		statements, err = withUserSchema(config.PluginName, statements)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	db, err := b.GetConnection(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []testRoleStep
+	run := func(step string, f func() error) bool {
+		start := time.Now()
+		err := f()
+		steps = append(steps, testRoleStep{Step: step, Err: err, Duration: time.Since(start)})
+		return err == nil
+	}
+
+	var username, password string
+	created := run("create", func() error {
+		producer, err := newCredentialsProducer(config)
+		if err != nil {
+			return err
+		}
+		usernameConfig := dbplugin.UsernameConfig{
+			DisplayName: req.DisplayName,
+			RoleName:    roleName,
+		}
+		expiration := b.clock.Now().Add(testRoleUserTTL)
+		var creds *producedCredentials
+		if producer != nil {
+			if creds, err = b.produceReservedCredentials(ctx, req.Storage, name, producer, usernameConfig, expiration); err != nil {
+				return err
+			}
+		}
+		createCtx, _ := withPluginCredentialData(withProducedCredentials(ctx, creds))
+		db.RLock()
+		defer db.RUnlock()
+		username, password, err = db.CreateUser(createCtx, statements, usernameConfig, expiration)
+		if err != nil {
+			b.CloseIfShutdown(db, err)
+			return err
+		}
+		if creds != nil && creds.username != "" {
+			b.releaseUsername(ctx, req.Storage, name, creds.username)
+		}
+		return nil
+	})
+
+	if created {
+		run("login", func() error {
+			return b.loginAs(ctx, name, config, username, password)
+		})
+		// The user is revoked whether or not the login succeeded
+		run("revoke", func() error {
+			db.RLock()
+			defer db.RUnlock()
+			err := db.RevokeUser(ctx, statements, username)
+			if err != nil {
+				b.CloseIfShutdown(db, err)
+				b.Logger().Error("failed to revoke the user of a role test", "connection", name, "role", roleName, "username", username, "error", err)
+			}
+			return err
+		})
+	}
+
+	success := true
+	stepData := make([]map[string]interface{}, 0, len(steps))
+	for _, step := range steps {
+		success = success && step.Err == nil
+		stepData = append(stepData, step.data())
+	}
+	respData := map[string]interface{}{
+		"success": success,
+		"steps":   stepData,
+	}
+	if username != "" {
+		respData["username"] = username
+	}
+	return &logical.Response{Data: respData}, nil
+}
+
+const pathTestRoleHelpSyn = `
+Test a role end to end with a throwaway user.
+`
+
+const pathTestRoleHelpDesc = `
+Writing to "dbs/<name>/test-role/<role>" runs the role's creation statements on
+the connection called name, logs in as the user they created through a second
+connection with the generated credentials, and then runs the role's revocation
+statements, reporting the success, error and duration of each step. This checks
+the role's statements, the network path to the database and the permissions of
+the connection's user in one go, without issuing a lease.
+
+The user is revoked even if the login fails, and is created with an expiration
+of 5 minutes, so that plugins that support expirations remove it if its
+revocation fails. Logging in requires the connection to pass its credentials in
+the "username" and "password" parameters, as in
+"postgres://{{username}}:{{password}}@db:5432/app". The role's rate limits,
+quotas and other restrictions on issuance are not applied.
+
+Setting "next" tests the creation statements staged in the role's
+"creation_statements_next" instead, before they take over.
+`
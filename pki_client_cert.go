@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// clientCertRenewFraction is the fraction of a client certificate's lifetime
+// after which it is renewed.
+const clientCertRenewFraction = 2.0 / 3.0
+
+// pkiClientCertConfig configures the PKI mount that a connection's client
+// certificate is issued from. Plugins cannot call other mounts, so the
+// certificate is requested through the Vault API with its own token.
+type pkiClientCertConfig struct {
+	// Address is the Vault address, defaulting to VAULT_ADDR.
+	Address    string        `json:"address"`
+	Token      string        `json:"token"`
+	Mount      string        `json:"mount"`
+	Role       string        `json:"role"`
+	CommonName string        `json:"common_name"`
+	TTL        time.Duration `json:"ttl"`
+}
+
+// validate checks the settings required to issue a certificate are set.
+func (c pkiClientCertConfig) validate() error {
+	switch {
+	case c.Role == "":
+		return errors.New("pki_role must be set to use pki_mount")
+	case c.CommonName == "":
+		return errors.New("pki_common_name must be set to use pki_mount")
+	case c.Token == "":
+		return errors.New("pki_token must be set to use pki_mount")
+	}
+	return nil
+}
+
+// pkiClientCert is a client certificate issued for a connection.
+type pkiClientCert struct {
+	Certificate string    `json:"certificate"`
+	PrivateKey  string    `json:"private_key"`
+	IssuingCA   string    `json:"issuing_ca"`
+	Issued      time.Time `json:"issued"`
+	Expiration  time.Time `json:"expiration"`
+}
+
+// renewAt returns when the certificate should be replaced.
+func (c *pkiClientCert) renewAt() time.Time {
+	lifetime := c.Expiration.Sub(c.Issued)
+	return c.Issued.Add(time.Duration(float64(lifetime) * clientCertRenewFraction))
+}
+
+// issueClientCertificate requests a new client certificate from the PKI mount.
+func issueClientCertificate(ctx context.Context, config pkiClientCertConfig) (*pkiClientCert, error) {
+	apiConfig := api.DefaultConfig()
+	if config.Address != "" {
+		apiConfig.Address = config.Address
+	}
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(config.Token)
+
+	data := map[string]interface{}{
+		"common_name": config.CommonName,
+		"format":      "pem",
+	}
+	if config.TTL > 0 {
+		data["ttl"] = int64(config.TTL.Seconds())
+	}
+
+	path := fmt.Sprintf("%s/issue/%s", strings.Trim(config.Mount, "/"), config.Role)
+	secret, err := client.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no certificate was returned from %s", path)
+	}
+
+	cert := &pkiClientCert{
+		Issued: time.Now(),
+	}
+	cert.Certificate, _ = secret.Data["certificate"].(string)
+	cert.PrivateKey, _ = secret.Data["private_key"].(string)
+	cert.IssuingCA, _ = secret.Data["issuing_ca"].(string)
+	if cert.Certificate == "" || cert.PrivateKey == "" {
+		return nil, fmt.Errorf("the response from %s did not include a certificate and private key", path)
+	}
+
+	expiration, ok := secret.Data["expiration"].(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("the response from %s did not include the certificate's expiration", path)
+	}
+	seconds, err := expiration.Int64()
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to parse the certificate's expiration: {{err}}", err)
+	}
+	cert.Expiration = time.Unix(seconds, 0)
+
+	return cert, nil
+}
+
+// renewClientCertificates replaces the client certificates of connections
+// that are due for renewal. The connections are closed so that they are
+// reopened with the new certificate.
+func (b *databaseBackend) renewClientCertificates(ctx context.Context, req *logical.Request) error {
+	// Only the active node of the primary cluster, or a local mount, may write
+	// the renewed certificates.
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	keys, err := req.Storage.List(ctx, "config/")
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.Now()
+	for _, name := range keys {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		if err := b.renewClientCertificate(ctx, req.Storage, name, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renewClientCertificate replaces the client certificate of the named
+// connection if it is due for renewal.
+func (b *databaseBackend) renewClientCertificate(ctx context.Context, s logical.Storage, name string, now time.Time) error {
+	lock := locksutil.LockForKey(b.connectionLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.DatabaseConfig(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if config.PKI.Mount == "" || config.ClientCert == nil || now.Before(config.ClientCert.renewAt()) {
+		return nil
+	}
+
+	b.Logger().Info("renewing client certificate", "connection", name)
+	cert, err := issueClientCertificate(ctx, config.PKI)
+	if err != nil {
+		b.Logger().Error("client certificate renewal failed", "connection", name, "error", err)
+		return nil
+	}
+	config.ClientCert = cert
+
+	if err := b.updateDatabaseConfig(ctx, s, name, config); err != nil {
+		return err
+	}
+
+	return b.ClearConnection(name)
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pluginErrorKind classifies the errors returned by plugins by who can act on
+// them.
+type pluginErrorKind int
+
+const (
+	// pluginErrorInternal is a failure of the backend or the database that
+	// the client can do nothing about.
+	pluginErrorInternal pluginErrorKind = iota
+
+	// pluginErrorUser is caused by the request or by the statements of the
+	// role, and won't succeed until they are changed.
+	pluginErrorUser
+
+	// pluginErrorPermissionDenied is a refusal by the database to perform an
+	// operation.
+	pluginErrorPermissionDenied
+
+	// pluginErrorRetryable is a transient failure, such as a lost
+	// connection or a busy database, which may succeed if retried.
+	pluginErrorRetryable
+
+	// pluginErrorNotFound is a user error for a user that doesn't exist,
+	// such as one that was already dropped from the database.
+	pluginErrorNotFound
+)
+
+// Postgres SQLSTATE codes, and the classes of codes, classified by
+// classifyPluginError.
+var (
+	postgresUserErrorClasses = []pq.ErrorClass{"22", "23", "42"}
+	postgresRetryableClasses = []pq.ErrorClass{"08", "40", "53", "57"}
+)
+
+const (
+	postgresInsufficientPrivilege = pq.ErrorCode("42501")
+	postgresUndefinedObject       = pq.ErrorCode("42704")
+)
+
+// MySQL error numbers classified by classifyPluginError.
+var (
+	mysqlPermissionDenied = []uint16{1044, 1045, 1142, 1143, 1227}
+	mysqlUserErrors       = []uint16{1054, 1064, 1141, 1146, 1396}
+	mysqlRetryable        = []uint16{1040, 1205, 1213}
+
+	// mysqlMissingObject are the errors of dropping or revoking the grants
+	// of a user that doesn't exist, which missingUserCheck confirms. 1396 is
+	// also returned when creating a user that already exists.
+	mysqlMissingObject = []uint16{1141, 1396}
+)
+
+// mssqlMissingObject is the error of dropping a login or user that doesn't
+// exist, or that the connection can't see.
+const mssqlMissingObject = 15151
+
+// classifyPluginError classifies an error returned by a plugin. External
+// plugins signal the kind of an error with its gRPC status code, such as
+// codes.InvalidArgument for a user error. Errors from the drivers of builtin
+// plugins are classified by their SQLSTATE or error number, except that
+// their errors for missing users are only pluginErrorNotFound once
+// missingUserCheck has confirmed the user is gone.
+func classifyPluginError(err error) pluginErrorKind {
+	if err == nil {
+		return pluginErrorInternal
+	}
+
+	var notFound *userNotFoundError
+	if errors.As(err, &notFound) {
+		return pluginErrorNotFound
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.InvalidArgument, codes.AlreadyExists, codes.FailedPrecondition, codes.OutOfRange:
+			return pluginErrorUser
+		case codes.NotFound:
+			return pluginErrorNotFound
+		case codes.PermissionDenied, codes.Unauthenticated:
+			return pluginErrorPermissionDenied
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+			return pluginErrorRetryable
+		}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch class := pqErr.Code.Class(); {
+		case pqErr.Code == postgresInsufficientPrivilege:
+			return pluginErrorPermissionDenied
+		case containsErrorClass(postgresUserErrorClasses, class):
+			return pluginErrorUser
+		case containsErrorClass(postgresRetryableClasses, class):
+			return pluginErrorRetryable
+		}
+		return pluginErrorInternal
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch {
+		case containsErrorNumber(mysqlPermissionDenied, mysqlErr.Number):
+			return pluginErrorPermissionDenied
+		case containsErrorNumber(mysqlUserErrors, mysqlErr.Number):
+			return pluginErrorUser
+		case containsErrorNumber(mysqlRetryable, mysqlErr.Number):
+			return pluginErrorRetryable
+		}
+		return pluginErrorInternal
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		if mssqlErr.Number == mssqlMissingObject {
+			return pluginErrorUser
+		}
+		return pluginErrorInternal
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return pluginErrorRetryable
+	}
+
+	return pluginErrorInternal
+}
+
+// httpErrorCodes maps the statuses returned by the HTTP APIs of the builtin
+// plugins that provision users through a cloud provider to the gRPC codes
+// classified by classifyPluginError.
+var httpErrorCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusPreconditionFailed:  codes.Aborted,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusInternalServerError: codes.Unavailable,
+	http.StatusBadGateway:          codes.Unavailable,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.Unavailable,
+}
+
+// httpStatusError returns an error with the gRPC code of an HTTP status.
+func httpStatusError(statusCode int, msg string) error {
+	code, ok := httpErrorCodes[statusCode]
+	if !ok {
+		code = codes.Unknown
+	}
+	return status.Error(code, msg)
+}
+
+// pluginErrorResponse translates an error returned by a plugin into the
+// response and error of a request handler: user errors are returned to the
+// client as an error response, refusals by the database as
+// logical.ErrPermissionDenied, and transient failures with a 503 status so
+// that clients know to retry. Anything else is an internal error.
+func (b *databaseBackend) pluginErrorResponse(operation string, err error) (*logical.Response, error) {
+	switch classifyPluginError(err) {
+	case pluginErrorUser, pluginErrorNotFound:
+		return logical.ErrorResponse(fmt.Sprintf("failed to %s: %s", operation, pluginErrorMessage(err))), nil
+	case pluginErrorPermissionDenied:
+		// The plugin's reason can't be returned with the sentinel error, so
+		// it is logged for the operator.
+		b.Logger().Warn("the database denied permission", "operation", operation, "error", err)
+		return nil, logical.ErrPermissionDenied
+	case pluginErrorRetryable:
+		return nil, logical.CodedError(http.StatusServiceUnavailable, fmt.Sprintf("failed to %s, and the request may be retried: %s", operation, pluginErrorMessage(err)))
+	default:
+		return nil, err
+	}
+}
+
+// pluginErrorMessage returns the message of an error, without the gRPC status
+// code that errors from external plugins are prefixed with.
+func pluginErrorMessage(err error) string {
+	if s, ok := status.FromError(err); ok {
+		return s.Message()
+	}
+	return strings.TrimSpace(err.Error())
+}
+
+func containsErrorClass(classes []pq.ErrorClass, class pq.ErrorClass) bool {
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func containsErrorNumber(numbers []uint16, number uint16) bool {
+	for _, n := range numbers {
+		if n == number {
+			return true
+		}
+	}
+	return false
+}
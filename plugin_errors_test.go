@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyPluginError(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		kind pluginErrorKind
+	}{
+		"grpc invalid argument": {status.Error(codes.InvalidArgument, "bad statement"), pluginErrorUser},
+		"grpc permission":       {status.Error(codes.PermissionDenied, "denied"), pluginErrorPermissionDenied},
+		"grpc unavailable":      {status.Error(codes.Unavailable, "down"), pluginErrorRetryable},
+		"grpc unknown":          {status.Error(codes.Unknown, "boom"), pluginErrorInternal},
+		"postgres syntax":       {&pq.Error{Code: "42601"}, pluginErrorUser},
+		"postgres privilege":    {&pq.Error{Code: "42501"}, pluginErrorPermissionDenied},
+		"postgres wrapped":      {fmt.Errorf("creating user: %w", &pq.Error{Code: "40001"}), pluginErrorRetryable},
+		"postgres internal":     {&pq.Error{Code: "XX000"}, pluginErrorInternal},
+		"postgres missing role": {&pq.Error{Code: "42704"}, pluginErrorUser},
+		"mysql missing user":    {&mysql.MySQLError{Number: 1396}, pluginErrorUser},
+		"confirmed missing":     {&userNotFoundError{err: &pq.Error{Code: "42704"}}, pluginErrorNotFound},
+		"grpc not found":        {status.Error(codes.NotFound, "gone"), pluginErrorNotFound},
+		"mysql access":          {&mysql.MySQLError{Number: 1045}, pluginErrorPermissionDenied},
+		"mysql syntax":          {&mysql.MySQLError{Number: 1064}, pluginErrorUser},
+		"mysql deadlock":        {&mysql.MySQLError{Number: 1213}, pluginErrorRetryable},
+		"bad connection":        {driver.ErrBadConn, pluginErrorRetryable},
+		"other":                 {errors.New("boom"), pluginErrorInternal},
+	}
+	for name, tc := range cases {
+		if kind := classifyPluginError(tc.err); kind != tc.kind {
+			t.Errorf("%s: expected kind %d, got %d", name, tc.kind, kind)
+		}
+	}
+}
+
+func TestBackend_pluginErrorResponse(t *testing.T) {
+	b, _ := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	resp, err := b.pluginErrorResponse("create the user", status.Error(codes.InvalidArgument, "bad statement"))
+	if err != nil || resp == nil || !resp.IsError() || resp.Data["error"] != "failed to create the user: bad statement" {
+		t.Fatalf("expected an error response, got %#v, %v", resp, err)
+	}
+
+	resp, err = b.pluginErrorResponse("create the user", &pq.Error{Code: "42501", Message: "permission denied for schema app"})
+	if resp != nil || err != logical.ErrPermissionDenied {
+		t.Fatalf("expected permission denied, got %#v, %v", resp, err)
+	}
+
+	resp, err = b.pluginErrorResponse("revoke the user", driver.ErrBadConn)
+	coded, ok := err.(logical.HTTPCodedError)
+	if resp != nil || !ok || coded.Code() != 503 {
+		t.Fatalf("expected a retryable error, got %#v, %v", resp, err)
+	}
+
+	internal := errors.New("boom")
+	if resp, err := b.pluginErrorResponse("revoke the user", internal); resp != nil || err != internal {
+		t.Fatalf("expected the error to be returned, got %#v, %v", resp, err)
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gocql/gocql"
+	log "github.com/hashicorp/go-hclog"
+)
+
+// driverLogsOnce guards bridging the database drivers' logs, which are
+// process wide, into the logger of the first backend created.
+var driverLogsOnce sync.Once
+
+// bridgeDriverLogs sends the logs that the MySQL and Cassandra drivers write
+// to stderr through logger, so that they are structured like the rest of
+// Vault's logs.
+func bridgeDriverLogs(logger log.Logger) {
+	driverLogsOnce.Do(func() {
+		mysql.SetLogger(logger.Named("mysql-driver").StandardLogger(&log.StandardLoggerOptions{
+			ForceLevel: log.Error,
+		}))
+		gocql.Logger = logger.Named("cassandra-driver").StandardLogger(&log.StandardLoggerOptions{
+			InferLevels: true,
+		})
+	})
+}
+
+// pluginLogger returns the logger for a connection's plugin instance. The
+// plugin's logs are tagged with the connection's name, and filtered by the
+// connection's log_level if it is set.
+func (b *databaseBackend) pluginLogger(name string, config *DatabaseConfig) log.Logger {
+	logger := b.logger.With("connection", name)
+	if config.LogLevel == "" {
+		return logger
+	}
+	return &levelLogger{
+		Logger: logger,
+		level:  log.LevelFromString(config.LogLevel),
+	}
+}
+
+// levelLogger drops the messages below level. Messages are still filtered by
+// the level of the logger it wraps, so it can only make a logger quieter.
+type levelLogger struct {
+	log.Logger
+	level log.Level
+}
+
+func (l *levelLogger) Trace(msg string, args ...interface{}) {
+	if l.level <= log.Trace {
+		l.Logger.Trace(msg, args...)
+	}
+}
+
+func (l *levelLogger) Debug(msg string, args ...interface{}) {
+	if l.level <= log.Debug {
+		l.Logger.Debug(msg, args...)
+	}
+}
+
+func (l *levelLogger) Info(msg string, args ...interface{}) {
+	if l.level <= log.Info {
+		l.Logger.Info(msg, args...)
+	}
+}
+
+func (l *levelLogger) Warn(msg string, args ...interface{}) {
+	if l.level <= log.Warn {
+		l.Logger.Warn(msg, args...)
+	}
+}
+
+func (l *levelLogger) Error(msg string, args ...interface{}) {
+	if l.level <= log.Error {
+		l.Logger.Error(msg, args...)
+	}
+}
+
+func (l *levelLogger) IsTrace() bool { return l.level <= log.Trace && l.Logger.IsTrace() }
+func (l *levelLogger) IsDebug() bool { return l.level <= log.Debug && l.Logger.IsDebug() }
+func (l *levelLogger) IsInfo() bool  { return l.level <= log.Info && l.Logger.IsInfo() }
+func (l *levelLogger) IsWarn() bool  { return l.level <= log.Warn && l.Logger.IsWarn() }
+func (l *levelLogger) IsError() bool { return l.level <= log.Error && l.Logger.IsError() }
+
+func (l *levelLogger) With(args ...interface{}) log.Logger {
+	return &levelLogger{Logger: l.Logger.With(args...), level: l.level}
+}
+
+func (l *levelLogger) Named(name string) log.Logger {
+	return &levelLogger{Logger: l.Logger.Named(name), level: l.level}
+}
+
+func (l *levelLogger) ResetNamed(name string) log.Logger {
+	return &levelLogger{Logger: l.Logger.ResetNamed(name), level: l.level}
+}
+
+// SetLevel changes the level of this logger only, rather than of the logger
+// it wraps, which is shared with the rest of the backend.
+func (l *levelLogger) SetLevel(level log.Level) {
+	l.level = level
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+func TestLevelLogger(t *testing.T) {
+	var buf bytes.Buffer
+	base := log.New(&log.LoggerOptions{
+		Output: &buf,
+		Level:  log.Trace,
+	})
+
+	var logger log.Logger = &levelLogger{Logger: base, level: log.Warn}
+	logger = logger.Named("plugin").With("key", "value")
+	logger.SetLevel(log.Warn)
+	logger.Debug("dropped")
+	logger.Info("dropped")
+	logger.Warn("kept")
+	logger.Error("kept")
+
+	if logger.IsDebug() || !logger.IsWarn() {
+		t.Fatal("expected the logger to report the filtered level")
+	}
+	if !base.IsTrace() {
+		t.Fatal("expected the wrapped logger's level to be unchanged")
+	}
+	if out := buf.String(); strings.Contains(out, "dropped") || strings.Count(out, "kept") != 2 {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if !strings.Contains(buf.String(), "plugin: kept: key=value") {
+		t.Fatalf("expected the name and args to be kept, got: %s", buf.String())
+	}
+
+	// The wrapped logger's level still applies
+	buf.Reset()
+	base.SetLevel(log.Error)
+	logger = &levelLogger{Logger: base, level: log.Debug}
+	logger.Warn("dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+func TestBackend_pluginLogger(t *testing.T) {
+	b, _ := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	var buf bytes.Buffer
+	b.logger = log.New(&log.LoggerOptions{
+		Output: &buf,
+		Level:  log.Trace,
+	})
+
+	b.pluginLogger("plugin-test", &DatabaseConfig{}).Debug("message")
+	if !strings.Contains(buf.String(), "connection=plugin-test") {
+		t.Fatalf("expected the connection to be tagged, got: %s", buf.String())
+	}
+
+	buf.Reset()
+	logger := b.pluginLogger("plugin-test", &DatabaseConfig{LogLevel: "warn"})
+	logger.Debug("message")
+	if buf.Len() != 0 {
+		t.Fatalf("expected debug logs to be dropped, got: %s", buf.String())
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// prewarmConnectionsOption is the mount option listing the connections
+	// to initialize when the backend is set up, separated by commas.
+	prewarmConnectionsOption = "prewarm_connections"
+
+	// prewarmTimeoutOption is the mount option bounding how long the
+	// connections are initialized for.
+	prewarmTimeoutOption = "prewarm_timeout"
+)
+
+// defaultPrewarmTimeout bounds the initialization of the connections if
+// prewarm_timeout isn't set.
+const defaultPrewarmTimeout = 30 * time.Second
+
+// parsePrewarmOptions returns the connections the mount options ask to be
+// initialized at setup, and the time allowed to do so.
+func parsePrewarmOptions(options map[string]string) ([]string, time.Duration, error) {
+	var names []string
+	for _, name := range strings.Split(options[prewarmConnectionsOption], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	timeout := defaultPrewarmTimeout
+	if raw := options[prewarmTimeoutOption]; raw != "" {
+		var err error
+		if timeout, err = time.ParseDuration(raw); err != nil {
+			return nil, 0, fmt.Errorf("invalid %s: %s", prewarmTimeoutOption, err)
+		}
+		if timeout <= 0 {
+			return nil, 0, fmt.Errorf("%s must be positive", prewarmTimeoutOption)
+		}
+	}
+	return names, timeout, nil
+}
+
+// prewarmConnections starts and initializes the plugin instances of the
+// connections concurrently, so that the first credential requests after the
+// mount is set up, such as after an unseal, don't wait for them. Connections
+// that fail to initialize are logged and are initialized by their first
+// request instead. It waits for up to timeout; a connection still being
+// initialized then isn't canceled, as requests may be waiting for it.
+func (b *databaseBackend) prewarmConnections(ctx context.Context, s logical.Storage, names []string, timeout time.Duration) {
+	start := time.Now()
+	errs := make(chan error, len(names))
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := b.GetConnection(ctx, s, name); err != nil {
+				b.Logger().Warn("failed to pre-warm the connection", "connection", name, "error", err)
+				errs <- err
+			}
+		}(name)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		b.Logger().Info("pre-warmed connections", "connections", len(names)-len(errs), "failed", len(errs), "took", time.Since(start).String())
+	case <-time.After(timeout):
+		b.Logger().Warn("connections are still being pre-warmed", "timeout", timeout.String())
+	case <-ctx.Done():
+	}
+}
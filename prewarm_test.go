@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParsePrewarmOptions(t *testing.T) {
+	names, timeout, err := parsePrewarmOptions(map[string]string{
+		prewarmConnectionsOption: "ledger, billing,,",
+		prewarmTimeoutOption:     "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"ledger", "billing"}) || timeout != 5*time.Second {
+		t.Fatalf("unexpected options %q, %s", names, timeout)
+	}
+
+	if names, timeout, err := parsePrewarmOptions(nil); err != nil || names != nil || timeout != defaultPrewarmTimeout {
+		t.Fatalf("expected no connections to be pre-warmed by default, got %q, %s, %v", names, timeout, err)
+	}
+	for _, raw := range []string{"soon", "0s"} {
+		if _, _, err := parsePrewarmOptions(map[string]string{prewarmTimeoutOption: raw}); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestBackend_prewarmConnections(t *testing.T) {
+	databasePlugins["prewarm-test-database-plugin"] = func() (interface{}, error) {
+		return dbplugin.Database(&reloadTestDatabase{}), nil
+	}
+	defer delete(databasePlugins, "prewarm-test-database-plugin")
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	config.Config = map[string]string{prewarmConnectionsOption: "plugin-test,missing"}
+
+	entry, err := logical.StorageEntryJSON("config/plugin-test", &DatabaseConfig{
+		PluginName:        "prewarm-test-database-plugin",
+		ConnectionDetails: map[string]interface{}{"connection_url": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.StorageView.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := raw.(*databaseBackend)
+	defer b.Cleanup(context.Background())
+
+	for i := 0; i < 100; i++ {
+		b.RLock()
+		_, ok := b.connections["plugin-test"]
+		b.RUnlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected the connection to be initialized at setup")
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxyDialTimeout bounds how long connecting to a proxy may take.
+const proxyDialTimeout = 30 * time.Second
+
+// parseProxyURL validates a connection's proxy_url.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy_url: %s", err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, errors.New(`proxy_url must use the "socks5", "socks5h" or "http" scheme`)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy_url must include the proxy's host")
+	}
+	return u, nil
+}
+
+// redactProxyURL masks the password in a proxy URL, if there is one.
+func redactProxyURL(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.User == nil {
+		return proxyURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return proxyURL
+	}
+	// UserPassword would escape the mask, so it is added after formatting
+	u.User = url.User(u.User.Username())
+	return strings.Replace(u.String(), "@", ":*****@", 1)
+}
+
+// proxyDialer dials the database through a SOCKS5 or HTTP CONNECT proxy.
+type proxyDialer struct {
+	proxy.Dialer
+}
+
+func newProxyDialer(proxyURL string) (*proxyDialer, error) {
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		port := "1080"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	forward := &net.Dialer{Timeout: proxyDialTimeout}
+
+	if u.Scheme == "http" {
+		d := &httpConnectDialer{address: address, forward: forward}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			d.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+		}
+		return &proxyDialer{Dialer: d}, nil
+	}
+
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	// The SOCKS5 dialer passes hostnames to the proxy to resolve, so
+	// "socks5" and "socks5h" behave the same.
+	d, err := proxy.SOCKS5("tcp", address, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyDialer{Dialer: d}, nil
+}
+
+// Close is a no-op, as each forwarded connection has its own connection to the
+// proxy.
+func (d *proxyDialer) Close() error {
+	return nil
+}
+
+// httpConnectDialer dials through an HTTP proxy with the CONNECT method.
+type httpConnectDialer struct {
+	address       string
+	authorization string
+	forward       *net.Dialer
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.address)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.authorization != "" {
+		req.Header.Set("Proxy-Authorization", d.authorization)
+	}
+
+	conn.SetDeadline(time.Now().Add(proxyDialTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", address, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	// The database may already have sent data, such as the MySQL greeting,
+	// which was buffered while reading the response.
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
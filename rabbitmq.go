@@ -0,0 +1,360 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/mitchellh/mapstructure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	rabbitMQTypeName = "rabbitmq"
+
+	// rabbitMQRequestTimeout bounds each request to the management API.
+	rabbitMQRequestTimeout = 30 * time.Second
+)
+
+// rabbitMQStatement is the schema of the creation statement of a role using
+// the rabbitmq-database-plugin. vhosts maps each virtual host to the
+// permissions of the user on it, and vhost_topics maps each virtual host to
+// the permissions of the user on its topic exchanges. The permissions are
+// regular expressions of resource names, which may use {{name}} to refer to
+// the name of the user.
+type rabbitMQStatement struct {
+	Tags        string                                         `json:"tags"`
+	VHosts      map[string]rabbitMQPermissions                 `json:"vhosts"`
+	VHostTopics map[string]map[string]rabbitMQTopicPermissions `json:"vhost_topics"`
+}
+
+type rabbitMQPermissions struct {
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+type rabbitMQTopicPermissions struct {
+	Exchange string `json:"exchange,omitempty"`
+	Write    string `json:"write"`
+	Read     string `json:"read"`
+}
+
+// validateRabbitMQStatement checks the creation statement of a role using the
+// rabbitmq-database-plugin.
+func validateRabbitMQStatement(stmt string, requireRoles bool) error {
+	_, err := parseRabbitMQStatement(stmt)
+	return err
+}
+
+func parseRabbitMQStatement(stmt string) (*rabbitMQStatement, error) {
+	dec := json.NewDecoder(strings.NewReader(stmt))
+	dec.DisallowUnknownFields()
+
+	var parsed rabbitMQStatement
+	if err := dec.Decode(&parsed); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("statement looks like a query rather than JSON (%s)", err)
+		}
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+
+	if len(parsed.VHosts) == 0 && len(parsed.VHostTopics) == 0 {
+		return nil, errors.New(`"vhosts" or "vhost_topics" must contain at least one virtual host`)
+	}
+	for vhost := range parsed.VHosts {
+		if vhost == "" {
+			return nil, errors.New("virtual host names cannot be empty")
+		}
+	}
+	for vhost, exchanges := range parsed.VHostTopics {
+		if vhost == "" {
+			return nil, errors.New("virtual host names cannot be empty")
+		}
+		if len(exchanges) == 0 {
+			return nil, fmt.Errorf("vhost_topics[%q] must contain at least one exchange", vhost)
+		}
+	}
+
+	return &parsed, nil
+}
+
+// rabbitMQConfig is the connection configuration of the
+// rabbitmq-database-plugin, where connection_url is the address of the
+// management API.
+type rabbitMQConfig struct {
+	ConnectionURL string `mapstructure:"connection_url"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+}
+
+// rabbitMQ provisions the users of a RabbitMQ broker through its management
+// API, granting them the virtual host permissions of the role.
+type rabbitMQ struct {
+	sync.RWMutex
+	credsutil.CredentialsProducer
+
+	config    rabbitMQConfig
+	rawConfig map[string]interface{}
+	client    *http.Client
+}
+
+func newRabbitMQ() (interface{}, error) {
+	return &rabbitMQ{
+		CredentialsProducer: &credsutil.SQLCredentialsProducer{
+			DisplayNameLen: 20,
+			RoleNameLen:    20,
+			UsernameLen:    100,
+			Separator:      "-",
+		},
+		client: &http.Client{Timeout: rabbitMQRequestTimeout},
+	}, nil
+}
+
+func (r *rabbitMQ) Type() (string, error) {
+	return rabbitMQTypeName, nil
+}
+
+func (r *rabbitMQ) Init(ctx context.Context, conf map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	var config rabbitMQConfig
+	if err := mapstructure.WeakDecode(conf, &config); err != nil {
+		return nil, err
+	}
+	switch {
+	case config.ConnectionURL == "":
+		return nil, errors.New("connection_url cannot be empty")
+	case config.Username == "":
+		return nil, errors.New("username cannot be empty")
+	case config.Password == "":
+		return nil, errors.New("password cannot be empty")
+	}
+	if u, err := url.Parse(config.ConnectionURL); err != nil {
+		return nil, fmt.Errorf("invalid connection_url: %s", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("connection_url must be the http:// or https:// address of the management API")
+	}
+
+	r.Lock()
+	r.config, r.rawConfig = config, conf
+	r.Unlock()
+
+	if verifyConnection {
+		if err := r.request(ctx, http.MethodGet, "/whoami", nil, nil); err != nil {
+			return nil, fmt.Errorf("error verifying connection: %s", err)
+		}
+	}
+
+	return conf, nil
+}
+
+func (r *rabbitMQ) Initialize(ctx context.Context, conf map[string]interface{}, verifyConnection bool) error {
+	_, err := r.Init(ctx, conf, verifyConnection)
+	return err
+}
+
+func (r *rabbitMQ) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Creation) == 0 {
+		return "", "", dbutil.ErrEmptyCreationStatement
+	}
+
+	username, err := r.GenerateUsername(usernameConfig)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := r.GeneratePassword()
+	if err != nil {
+		return "", "", err
+	}
+
+	// The name is escaped for the JSON strings of the statement
+	name, _ := json.Marshal(username)
+	stmt, err := parseRabbitMQStatement(dbutil.QueryHelper(statements.Creation[0], map[string]string{
+		"name": string(name[1 : len(name)-1]),
+	}))
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user := map[string]string{"password": password, "tags": stmt.Tags}
+	if err := r.request(ctx, http.MethodPut, rabbitMQUserPath(username), user, nil); err != nil {
+		return "", "", err
+	}
+
+	if err := r.grantPermissions(ctx, username, stmt); err != nil {
+		// Don't leave a user behind that no lease will revoke
+		if deleteErr := r.RevokeUser(ctx, statements, username); deleteErr != nil {
+			err = status.Errorf(status.Code(err), "%s; the user %s could not be deleted: %s", status.Convert(err).Message(), username, deleteErr)
+		}
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+func (r *rabbitMQ) grantPermissions(ctx context.Context, username string, stmt *rabbitMQStatement) error {
+	for vhost, permissions := range stmt.VHosts {
+		path := "/permissions/" + url.PathEscape(vhost) + "/" + url.PathEscape(username)
+		if err := r.request(ctx, http.MethodPut, path, permissions, nil); err != nil {
+			return status.Errorf(status.Code(err), "failed to set the permissions on virtual host %q: %s", vhost, status.Convert(err).Message())
+		}
+	}
+	for vhost, exchanges := range stmt.VHostTopics {
+		path := "/topic-permissions/" + url.PathEscape(vhost) + "/" + url.PathEscape(username)
+		for exchange, permissions := range exchanges {
+			permissions.Exchange = exchange
+			if err := r.request(ctx, http.MethodPut, path, permissions, nil); err != nil {
+				return status.Errorf(status.Code(err), "failed to set the permissions on exchange %q of virtual host %q: %s", exchange, vhost, status.Convert(err).Message())
+			}
+		}
+	}
+	return nil
+}
+
+// RenewUser does nothing, as RabbitMQ users don't expire.
+func (r *rabbitMQ) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	return nil
+}
+
+// RevokeUser deletes the user, along with its permissions and connections.
+// Revocation statements are not used.
+func (r *rabbitMQ) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	return r.request(ctx, http.MethodDelete, rabbitMQUserPath(username), nil, nil)
+}
+
+func (r *rabbitMQ) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticUser dbplugin.StaticUserConfig) (string, string, error) {
+	if staticUser.Username == "" || staticUser.Password == "" {
+		return "", "", errors.New("must provide both username and password")
+	}
+	if err := r.setPassword(ctx, staticUser.Username, staticUser.Password); err != nil {
+		return "", "", err
+	}
+	return staticUser.Username, staticUser.Password, nil
+}
+
+// RotateRootCredentials changes the password of the user of the connection.
+// Rotation statements are not used.
+func (r *rabbitMQ) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	password, err := r.GeneratePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if err := r.setPasswordLocked(ctx, r.config, r.config.Username, password); err != nil {
+		return nil, err
+	}
+	r.config.Password = password
+	r.rawConfig["password"] = password
+	return r.rawConfig, nil
+}
+
+func (r *rabbitMQ) Close() error {
+	return nil
+}
+
+// setPassword changes the password of a user, keeping its tags, as the
+// management API replaces the whole user.
+func (r *rabbitMQ) setPassword(ctx context.Context, username, password string) error {
+	r.RLock()
+	config := r.config
+	r.RUnlock()
+	return r.setPasswordLocked(ctx, config, username, password)
+}
+
+func (r *rabbitMQ) setPasswordLocked(ctx context.Context, config rabbitMQConfig, username, password string) error {
+	var user struct {
+		Tags json.RawMessage `json:"tags"`
+	}
+	if err := r.send(ctx, config, http.MethodGet, rabbitMQUserPath(username), nil, &user); err != nil {
+		return err
+	}
+
+	// Since RabbitMQ 3.9, tags are returned as a list rather than a string
+	tags := string(user.Tags)
+	var list []string
+	if err := json.Unmarshal(user.Tags, &list); err == nil {
+		tags = strings.Join(list, ",")
+	} else if err := json.Unmarshal(user.Tags, &tags); err != nil {
+		tags = ""
+	}
+
+	return r.send(ctx, config, http.MethodPut, rabbitMQUserPath(username), map[string]string{"password": password, "tags": tags}, nil)
+}
+
+func rabbitMQUserPath(username string) string {
+	return "/users/" + url.PathEscape(username)
+}
+
+// request sends a request to the management API at path relative to /api,
+// decoding the response into out if it is set.
+func (r *rabbitMQ) request(ctx context.Context, method, path string, in, out interface{}) error {
+	r.RLock()
+	config := r.config
+	r.RUnlock()
+	return r.send(ctx, config, method, path, in, out)
+}
+
+func (r *rabbitMQ) send(ctx context.Context, config rabbitMQConfig, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(config.ConnectionURL, "/")+"/api"+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(config.Username, config.Password)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var apiErr struct {
+		Error  string `json:"error"`
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+	msg := fmt.Sprintf("rabbitmq management API returned %d", resp.StatusCode)
+	if apiErr.Error != "" {
+		msg = fmt.Sprintf("%s %s", msg, apiErr.Error)
+	}
+	if apiErr.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, apiErr.Reason)
+	}
+	return httpStatusError(resp.StatusCode, msg)
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type testRabbitMQUser struct {
+	password    string
+	tags        string
+	permissions map[string]rabbitMQPermissions
+	topics      map[string]rabbitMQTopicPermissions
+}
+
+// testRabbitMQServer serves the users of a broker over the management API,
+// with the administrator "admin".
+type testRabbitMQServer struct {
+	*httptest.Server
+
+	l     sync.Mutex
+	users map[string]*testRabbitMQUser
+}
+
+func newTestRabbitMQServer() *testRabbitMQServer {
+	server := &testRabbitMQServer{users: map[string]*testRabbitMQUser{
+		"admin": {password: "guest", tags: "administrator"},
+	}}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+func (s *testRabbitMQServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	username, password, _ := r.BasicAuth()
+	if admin := s.users["admin"]; username != "admin" || password != admin.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "not_authorised", "reason": "Login failed"}`))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/"), "/")
+	for i := range parts {
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Object Not Found", "reason": "Not Found"}`))
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "whoami":
+		w.Write([]byte(`{"name": "admin", "tags": ["administrator"]}`))
+
+	case len(parts) == 2 && parts[0] == "users":
+		user, ok := s.users[parts[1]]
+		switch r.Method {
+		case http.MethodGet:
+			if !ok {
+				notFound()
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": parts[1], "tags": strings.Split(user.tags, ",")})
+		case http.MethodPut:
+			var update struct {
+				Password string `json:"password"`
+				Tags     string `json:"tags"`
+			}
+			json.NewDecoder(r.Body).Decode(&update)
+			s.users[parts[1]] = &testRabbitMQUser{
+				password:    update.Password,
+				tags:        update.Tags,
+				permissions: map[string]rabbitMQPermissions{},
+				topics:      map[string]rabbitMQTopicPermissions{},
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if !ok {
+				notFound()
+				return
+			}
+			delete(s.users, parts[1])
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case len(parts) == 3 && r.Method == http.MethodPut && (parts[0] == "permissions" || parts[0] == "topic-permissions"):
+		user, ok := s.users[parts[2]]
+		if !ok || parts[1] != "/" {
+			notFound()
+			return
+		}
+		if parts[0] == "permissions" {
+			var permissions rabbitMQPermissions
+			json.NewDecoder(r.Body).Decode(&permissions)
+			user.permissions[parts[1]] = permissions
+		} else {
+			var permissions rabbitMQTopicPermissions
+			json.NewDecoder(r.Body).Decode(&permissions)
+			user.topics[parts[1]+" "+permissions.Exchange] = permissions
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		notFound()
+	}
+}
+
+func (s *testRabbitMQServer) user(username string) (testRabbitMQUser, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return testRabbitMQUser{}, false
+	}
+	return *user, true
+}
+
+func TestBackend_rabbitMQ(t *testing.T) {
+	server := newTestRabbitMQServer()
+	defer server.Close()
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "config/broker", Data: map[string]interface{}{
+		"plugin_name":    "rabbitmq-database-plugin",
+		"connection_url": server.URL,
+		"username":       "admin",
+		"password":       "guest",
+		"allowed_roles":  []string{"*"},
+	}})
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/broker"})
+	if _, ok := resp.Data["connection_details"].(map[string]interface{})["password"]; ok {
+		t.Fatal("expected the password not to be returned")
+	}
+
+	role := map[string]interface{}{
+		"db_name":             "broker",
+		"creation_statements": `{"tags": "management"}`,
+	}
+	resp, err := request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a role without virtual hosts, got err:%s resp:%#v", err, resp)
+	}
+	role["creation_statements"] = `{"tags": "management", "vhosts": {"/": {"configure": "^{{name}}\\.", "write": "^{{name}}\\.", "read": ".*"}}, "vhost_topics": {"/": {"amq.topic": {"write": "^events\\.", "read": ".*"}}}}`
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: role})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	username := resp.Data["username"].(string)
+	user, ok := server.user(username)
+	if !ok {
+		t.Fatalf("expected %q to be created", username)
+	}
+	if user.password != resp.Data["password"] || user.tags != "management" {
+		t.Fatalf("unexpected user %#v", user)
+	}
+	expected := rabbitMQPermissions{Configure: "^" + username + `\.`, Write: "^" + username + `\.`, Read: ".*"}
+	if user.permissions["/"] != expected {
+		t.Fatalf("expected permissions %#v, got %#v", expected, user.permissions)
+	}
+	if topic := user.topics["/ amq.topic"]; topic.Write != `^events\.` || topic.Read != ".*" {
+		t.Fatalf("unexpected topic permissions %#v", user.topics)
+	}
+
+	secret := resp.Secret
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	if _, ok := server.user(username); ok {
+		t.Fatalf("expected %q to be deleted", username)
+	}
+
+	// A user that was already deleted is revoked
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+
+	// A user that can't be granted its permissions is deleted
+	role["creation_statements"] = `{"vhosts": {"missing": {"configure": "", "write": "", "read": ".*"}}}`
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/missing", Data: role})
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/missing"})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected an error for a missing virtual host")
+	}
+	server.l.Lock()
+	users := len(server.users)
+	server.l.Unlock()
+	if users != 1 {
+		t.Fatalf("expected the user of the failed request to be deleted, got %d users", users)
+	}
+
+	mustRequest(&logical.Request{Operation: logical.UpdateOperation, Path: "rotate-root/broker"})
+	admin, _ := server.user("admin")
+	if admin.password == "guest" || admin.tags != "administrator" {
+		t.Fatalf("expected the admin password to be rotated and its tags kept, got %#v", admin)
+	}
+}
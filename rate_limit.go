@@ -0,0 +1,56 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// credLimiter is the token bucket limiting credential issuance for a role.
+type credLimiter struct {
+	perMinute int
+	limiter   *rate.Limiter
+}
+
+// credLimiters holds the token buckets of the roles that set
+// max_creds_per_minute. Buckets are kept per node and are not persisted, so
+// they refill on restart.
+type credLimiters struct {
+	l        sync.Mutex
+	limiters map[string]*credLimiter
+}
+
+// allow takes a token from the role's bucket at now, the time of the
+// backend's clock, returning false if it is empty. A bucket holds up to
+// perMinute tokens and refills at perMinute tokens a minute; a perMinute of
+// zero disables the limit.
+func (c *credLimiters) allow(role string, perMinute int, now time.Time) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if perMinute <= 0 {
+		delete(c.limiters, role)
+		return true
+	}
+
+	if c.limiters == nil {
+		c.limiters = make(map[string]*credLimiter)
+	}
+	limiter, ok := c.limiters[role]
+	if !ok || limiter.perMinute != perMinute {
+		limiter = &credLimiter{
+			perMinute: perMinute,
+			limiter:   rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute),
+		}
+		c.limiters[role] = limiter
+	}
+	return limiter.limiter.AllowN(now, 1)
+}
+
+// remove drops the bucket of a deleted or renamed role.
+func (c *credLimiters) remove(role string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	delete(c.limiters, role)
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeIssuingDatabase counts the users created and revoked through it.
+type fakeIssuingDatabase struct {
+	fakeStaticDatabase
+	created int
+	revoked []string
+}
+
+func (f *fakeIssuingDatabase) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	f.created++
+	return fmt.Sprintf("user-%d", f.created), "password", nil
+}
+
+func (f *fakeIssuingDatabase) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	f.revoked = append(f.revoked, username)
+	return nil
+}
+
+func TestCredLimiters(t *testing.T) {
+	var limiters credLimiters
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if !limiters.allow("app", 3, now) {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+	if limiters.allow("app", 3, now) {
+		t.Fatal("expected the bucket to be empty")
+	}
+	if !limiters.allow("other", 3, now) {
+		t.Fatal("expected roles to have separate buckets")
+	}
+
+	// The bucket refills by the given time rather than the wall clock
+	now = now.Add(20 * time.Second)
+	if !limiters.allow("app", 3, now) {
+		t.Fatal("expected a token to be refilled after 20 seconds")
+	}
+	if limiters.allow("app", 3, now) {
+		t.Fatal("expected only one token to be refilled")
+	}
+
+	// Changing the limit starts a new bucket
+	if !limiters.allow("app", 4, now) {
+		t.Fatal("expected a new bucket for the new limit")
+	}
+	for i := 0; i < 10; i++ {
+		if !limiters.allow("app", 0, now) {
+			t.Fatal("expected a zero limit not to limit issuance")
+		}
+	}
+}
+
+func TestBackend_maxCredsPerMinute(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	write := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		t.Helper()
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+
+	resp, err := write(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	fake := &fakeIssuingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":              "plugin-test",
+		"creation_statements":  `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"max_creds_per_minute": -1,
+	}
+	resp, err = write(logical.CreateOperation, "roles/app", role)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a negative limit, got err:%s resp:%#v", err, resp)
+	}
+	role["max_creds_per_minute"] = 2
+	resp, err = write(logical.CreateOperation, "roles/app", role)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	resp, err = write(logical.ReadOperation, "roles/app", nil)
+	if err != nil || resp.Data["max_creds_per_minute"] != 2 {
+		t.Fatalf("expected max_creds_per_minute to be returned, got err:%s resp:%#v", err, resp)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err = write(logical.ReadOperation, "creds/app", nil)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+	}
+	_, err = write(logical.ReadOperation, "creds/app", nil)
+	codedErr, ok := err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+	if fake.created != 2 {
+		t.Fatalf("expected 2 users to be created, got %d", fake.created)
+	}
+
+	// The bucket refills by the backend's clock
+	clock.advance(30 * time.Second)
+	resp, err = write(logical.ReadOperation, "creds/app", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("expected a token to be refilled, got err:%s resp:%#v", err, resp)
+	}
+
+	// Removing the limit applies immediately
+	resp, err = write(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"max_creds_per_minute": 0,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = write(logical.ReadOperation, "creds/app", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	// The virtual roles of service accounts share the role's bucket
+	entry, err := logical.StorageEntryJSON("serviceaccount/default/s-ledger", saCacheObject{Keyspace: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = write(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"max_creds_per_minute": 1,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = write(logical.ReadOperation, "creds/app", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	_, err = write(logical.ReadOperation, "creds/k8s_app_s-ledger_default", nil)
+	if codedErr, ok := err.(logical.HTTPCodedError); !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected the service account's request to be limited by the role, got %v", err)
+	}
+	if _, ok := b.credLimiters.limiters["k8s_app_s-ledger_default"]; ok {
+		t.Fatal("expected no bucket for the virtual role")
+	}
+
+	// Deleting the role drops its bucket
+	if _, err := write(logical.DeleteOperation, "roles/app", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.credLimiters.limiters) != 0 {
+		t.Fatalf("expected the role's bucket to be dropped, got %#v", b.credLimiters.limiters)
+	}
+}
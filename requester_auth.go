@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const tokenLookupPath = "token-lookup"
+
+// tokenLookupConfig configures how the policies of the tokens requesting
+// credentials are looked up, for roles with allowed_policies. Vault doesn't
+// pass the policies of the requesting token to secrets engines, so they are
+// looked up through the Vault API by the token's accessor, with a token that
+// may call auth/token/lookup-accessor.
+type tokenLookupConfig struct {
+	// Address is the Vault address, defaulting to VAULT_ADDR.
+	Address string `json:"address"`
+	Token   string `json:"token"`
+}
+
+func pathTokenLookup(b *databaseBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: tokenLookupPath + "$",
+		Fields: map[string]*framework.FieldSchema{
+			"vault_address": {
+				Type:        framework.TypeString,
+				Description: "Address of the Vault API. Defaults to the VAULT_ADDR environment variable of the plugin.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Vault Address",
+				},
+			},
+			"token": {
+				Type:        framework.TypeString,
+				Description: "Token with the update capability on auth/token/lookup-accessor.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Token",
+					Sensitive: true,
+				},
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTokenLookupWrite,
+				Summary:  "Configure the lookup of the policies of tokens requesting credentials.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "The configuration was saved."}},
+				},
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathTokenLookupRead,
+				Summary:  "Read the token lookup configuration. The token is not returned.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"vault_address": "https://vault.example.com:8200",
+							},
+						},
+					}},
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathTokenLookupDelete,
+				Summary:  "Delete the token lookup configuration.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "The configuration was deleted."}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathTokenLookupHelpSyn,
+		HelpDescription: pathTokenLookupHelpDesc,
+	}
+}
+
+func (b *databaseBackend) tokenLookupConfig(ctx context.Context, s logical.Storage) (*tokenLookupConfig, error) {
+	entry, err := s.Get(ctx, tokenLookupPath)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read token lookup configuration: {{err}}", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var config tokenLookupConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (b *databaseBackend) pathTokenLookupWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.tokenLookupConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &tokenLookupConfig{}
+	}
+	if addressRaw, ok := data.GetOk("vault_address"); ok {
+		config.Address = addressRaw.(string)
+	}
+	if tokenRaw, ok := data.GetOk("token"); ok {
+		config.Token = tokenRaw.(string)
+	}
+	if config.Token == "" {
+		return logical.ErrorResponse("token is required"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(tokenLookupPath, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, req.Storage.Put(ctx, entry)
+}
+
+func (b *databaseBackend) pathTokenLookupRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.tokenLookupConfig(ctx, req.Storage)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"vault_address": config.Address,
+		},
+	}, nil
+}
+
+func (b *databaseBackend) pathTokenLookupDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, tokenLookupPath)
+}
+
+// validateEntityAliases checks the entries of allowed_entity_aliases.
+func validateEntityAliases(aliases []string) error {
+	for _, alias := range aliases {
+		mount, name := splitEntityAlias(alias)
+		if mount == "" || name == "" {
+			return fmt.Errorf("allowed_entity_aliases entry %q must be of the form <mount accessor or type>:<alias name>", alias)
+		}
+	}
+	return nil
+}
+
+func splitEntityAlias(alias string) (string, string) {
+	i := strings.Index(alias, ":")
+	if i < 0 {
+		return "", ""
+	}
+	return alias[:i], alias[i+1:]
+}
+
+// authorizeRequester checks that the token requesting credentials for a role
+// is allowed by the role's allowed_entity_aliases and allowed_policies. They
+// add to the ACL policies of the mount's paths, for example so that a policy
+// granting creds/* doesn't grant the roles of the most sensitive databases.
+func (b *databaseBackend) authorizeRequester(ctx context.Context, req *logical.Request, name string, role *roleEntry) error {
+	if len(role.AllowedEntityAliases) > 0 {
+		allowed, err := b.entityAliasAllowed(req.EntityID, role.AllowedEntityAliases)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return logical.CodedError(http.StatusForbidden, fmt.Sprintf("the requesting entity is not allowed by the allowed_entity_aliases of role %q", name))
+		}
+	}
+
+	if len(role.AllowedPolicies) > 0 {
+		policies, err := b.requesterPolicies(ctx, req)
+		if err != nil {
+			return err
+		}
+		allowed := false
+		for _, policy := range policies {
+			if strutil.StrListContains(role.AllowedPolicies, policy) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return logical.CodedError(http.StatusForbidden, fmt.Sprintf("the requesting token has none of the allowed_policies of role %q", name))
+		}
+	}
+
+	return nil
+}
+
+// entityAliasAllowed returns whether the entity has an alias matching one of
+// the allowed aliases. Tokens without an entity, such as root tokens, are
+// never allowed.
+func (b *databaseBackend) entityAliasAllowed(entityID string, allowedAliases []string) (bool, error) {
+	if entityID == "" {
+		return false, nil
+	}
+	entity, err := b.System().EntityInfo(entityID)
+	if err != nil {
+		return false, errwrap.Wrapf("failed to look up the requesting entity: {{err}}", err)
+	}
+	if entity == nil || entity.Disabled {
+		return false, nil
+	}
+
+	for _, alias := range entity.Aliases {
+		for _, allowed := range allowedAliases {
+			mount, name := splitEntityAlias(allowed)
+			if mount != alias.MountAccessor && mount != alias.MountType {
+				continue
+			}
+			if strutil.StrListContainsGlob([]string{name}, alias.Name) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// requesterPolicies looks up the policies of the token of a request, including
+// the policies of its entity and groups.
+func (b *databaseBackend) requesterPolicies(ctx context.Context, req *logical.Request) ([]string, error) {
+	config, err := b.tokenLookupConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("roles with allowed_policies require token-lookup to be configured")
+	}
+	if req.ClientTokenAccessor == "" {
+		return nil, nil
+	}
+
+	apiConfig := api.DefaultConfig()
+	if config.Address != "" {
+		apiConfig.Address = config.Address
+	}
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(config.Token)
+
+	secret, err := client.Logical().Write("auth/token/lookup-accessor", map[string]interface{}{
+		"accessor": req.ClientTokenAccessor,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to look up the requesting token: {{err}}", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("failed to look up the requesting token: no token information was returned")
+	}
+
+	var policies []string
+	for _, key := range []string{"policies", "identity_policies"} {
+		raw, _ := secret.Data[key].([]interface{})
+		for _, policy := range raw {
+			if s, ok := policy.(string); ok {
+				policies = append(policies, s)
+			}
+		}
+	}
+	return policies, nil
+}
+
+const pathTokenLookupHelpSyn = `
+Configure the lookup of the policies of tokens requesting credentials.
+`
+
+const pathTokenLookupHelpDesc = `
+Vault doesn't pass the policies of the token making a request to secrets
+engines. Roles with "allowed_policies" look them up through the Vault API at
+"vault_address", by the accessor of the requesting token, using "token". The
+token needs the update capability on "auth/token/lookup-accessor". Requests for
+the credentials of a role with "allowed_policies" fail while this is not
+configured.
+`
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_requesterRestrictions(t *testing.T) {
+	lookups := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/auth/token/lookup-accessor" || r.Header.Get("X-Vault-Token") != "lookup-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		lookups++
+		policies := map[string][]string{
+			"accessor-ledger": {"default"},
+			"accessor-other":  {"default", "orders"},
+		}[body["accessor"]]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"policies":          policies,
+				"identity_policies": []string{"ledger-admins"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+	sys := b.System().(*logical.StaticSystemView)
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+	forbidden := func(req *logical.Request) {
+		t.Helper()
+		_, err := request(req)
+		if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusForbidden {
+			t.Fatalf("expected the request to be forbidden, got %v", err)
+		}
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":                "plugin-test",
+		"creation_statements":    `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"allowed_entity_aliases": "payments-api",
+	}
+	resp, _ := request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/payments", Data: role})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an alias without a mount to be rejected, got %#v", resp)
+	}
+	role["allowed_entity_aliases"] = "kubernetes:payments-*,auth_approle_1234:deploy"
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/payments", Data: role})
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "roles/payments"})
+	if len(resp.Data["allowed_entity_aliases"].([]string)) != 2 {
+		t.Fatalf("unexpected role %#v", resp.Data)
+	}
+
+	// Tokens without an entity are refused
+	creds := &logical.Request{Operation: logical.ReadOperation, Path: "creds/payments"}
+	forbidden(creds)
+
+	creds.EntityID = "entity-1"
+	sys.EntityVal = &logical.Entity{
+		ID: "entity-1",
+		Aliases: []*logical.Alias{
+			{MountType: "kubernetes", MountAccessor: "auth_kubernetes_5678", Name: "orders-api"},
+		},
+	}
+	forbidden(creds)
+	sys.EntityVal.Aliases[0].Name = "payments-api"
+	mustRequest(creds)
+	sys.EntityVal.Aliases = []*logical.Alias{{MountType: "approle", MountAccessor: "auth_approle_1234", Name: "deploy"}}
+	mustRequest(creds)
+	sys.EntityVal.Disabled = true
+	forbidden(creds)
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/ledger",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+			"allowed_policies":    "ledger-admins,ledger-readers",
+		},
+	})
+	creds = &logical.Request{Operation: logical.ReadOperation, Path: "creds/ledger", ClientTokenAccessor: "accessor-ledger"}
+	if _, err := request(creds); err == nil {
+		t.Fatal("expected allowed_policies to require token-lookup")
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token-lookup",
+		Data:      map[string]interface{}{"vault_address": srv.URL, "token": "lookup-token"},
+	})
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "token-lookup"})
+	if resp.Data["vault_address"] != srv.URL || resp.Data["token"] != nil {
+		t.Fatalf("expected the configuration without its token, got %#v", resp.Data)
+	}
+
+	// Identity policies count as the token's policies
+	mustRequest(creds)
+	creds.ClientTokenAccessor = "accessor-other"
+	mustRequest(creds)
+
+	role = map[string]interface{}{"allowed_policies": "ledger-readers"}
+	mustRequest(&logical.Request{Operation: logical.UpdateOperation, Path: "roles/ledger", Data: role})
+	forbidden(creds)
+	if lookups != 3 {
+		t.Fatalf("expected every request to look up its token, got %d lookups", lookups)
+	}
+}
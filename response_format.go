@@ -0,0 +1,42 @@
+package database
+
+const (
+	// responseFormatFlat returns the credentials as top-level fields of the
+	// response, such as "username" and "password", and responseFormatNested
+	// under a "credential" object that also gives their type.
+	responseFormatFlat   = "flat"
+	responseFormatNested = "nested"
+)
+
+// credentialTypes are the types of the credentials issued by each
+// credentials producer, as given by the nested response format. Producers
+// that aren't listed issue passwords.
+var credentialTypes = map[string]string{
+	"rsa-key": "rsa",
+	"cert":    "cert",
+}
+
+// credentialType returns the type of the credentials a connection issues.
+func credentialType(config *DatabaseConfig) string {
+	if t, ok := credentialTypes[credentialsProducerName(config)]; ok {
+		return t
+	}
+	return "password"
+}
+
+// nestCredential moves the credential fields of a flat response under
+// "credential", with the type of the credentials. The response's other
+// fields, such as those rendered from credential_templates, are left in
+// place.
+func nestCredential(respData map[string]interface{}, fields []string, credType string) {
+	credential := map[string]interface{}{
+		"type": credType,
+	}
+	for _, field := range fields {
+		if v, ok := respData[field]; ok {
+			credential[field] = v
+			delete(respData, field)
+		}
+	}
+	respData["credential"] = credential
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCredentialType(t *testing.T) {
+	for expected, config := range map[string]*DatabaseConfig{
+		"password": {},
+		"rsa":      {CredentialsProducer: "rsa-key"},
+		"cert":     {CredentialsProducer: "cert"},
+	} {
+		if credType := credentialType(config); credType != expected {
+			t.Fatalf("expected %q, got %q", expected, credType)
+		}
+	}
+	// Connections with a username_template use the template producer
+	if credType := credentialType(&DatabaseConfig{UsernameTemplate: "{{.RoleName}}"}); credType != "password" {
+		t.Fatalf("expected password, got %q", credType)
+	}
+}
+
+func TestBackend_nestedResponseFormat(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	if resp, _ := request(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":         "plugin-test",
+		"response_format": "xml",
+	}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid response_format to be rejected, got %#v", resp)
+	}
+	if resp, _ := request(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":              "plugin-test",
+		"credential_templates": map[string]interface{}{"credential": "{{.Password}}"},
+	}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the credential field to be reserved, got %#v", resp)
+	}
+
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":              "plugin-test",
+		"creation_statements":  `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"credential_templates": map[string]interface{}{"dsn": "{{.Data.username}}:{{.Data.password}}@db"},
+	})
+	resp := mustRequest(logical.ReadOperation, "creds/app", nil)
+	if resp.Data["username"] != "user-1" || resp.Data["password"] != "password" || resp.Data["credential"] != nil {
+		t.Fatalf("expected the flat format by default, got %#v", resp.Data)
+	}
+
+	mustRequest(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"response_format": "nested",
+	})
+	if resp := mustRequest(logical.ReadOperation, "roles/app", nil); resp.Data["response_format"] != "nested" {
+		t.Fatalf("expected the response_format to be returned, got %#v", resp.Data)
+	}
+	resp = mustRequest(logical.ReadOperation, "creds/app", nil)
+	credential, ok := resp.Data["credential"].(map[string]interface{})
+	if !ok || credential["type"] != "password" || credential["username"] != "user-2" || credential["password"] != "password" {
+		t.Fatalf("unexpected credential %#v", resp.Data)
+	}
+	if resp.Data["username"] != nil || resp.Data["password"] != nil || resp.Data["dsn"] != "user-2:password@db" {
+		t.Fatalf("expected only the rendered fields besides the credential, got %#v", resp.Data)
+	}
+	if resp.Secret.InternalData["username"] != "user-2" {
+		t.Fatalf("expected the lease to keep the username, got %#v", resp.Secret.InternalData)
+	}
+}
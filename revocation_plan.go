@@ -0,0 +1,238 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultRevocationPlanUsername is the username the revocation statements
+// of a role are rendered for if none is given.
+const defaultRevocationPlanUsername = "v-revocation-plan"
+
+// revocationCheckPlugins are the plugins whose revocation statements can be
+// checked against the database.
+var revocationCheckPlugins = map[string]bool{
+	"postgresql-database-plugin": true,
+}
+
+// revocationChecker runs revocation statements against a database without
+// keeping their effects.
+type revocationChecker interface {
+	// check runs the queries in a transaction that is rolled back, returning
+	// how many of them succeeded and the error of the first one that failed.
+	check(ctx context.Context, queries []string) (int, error)
+	Close() error
+}
+
+type sqlRevocationChecker struct {
+	db *sql.DB
+}
+
+// newRevocationChecker connects to the database of an open connection with
+// its own credentials. Tests replace it with a fake.
+var newRevocationChecker = func(files *connectionFiles, name string, config *DatabaseConfig, db *dbPluginInstance) (revocationChecker, error) {
+	if !revocationCheckPlugins[config.PluginName] {
+		return nil, fmt.Errorf("%s does not support checking revocation statements; it is supported by the PostgreSQL plugin", config.PluginName)
+	}
+	connURL, err := rootConnectionURL(files, name, config, db)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := sql.Open("postgres", connURL)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return &sqlRevocationChecker{db: sqlDB}, nil
+}
+
+func (c *sqlRevocationChecker) check(ctx context.Context, queries []string) (int, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for i, query := range queries {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return i, err
+		}
+	}
+	return len(queries), nil
+}
+
+func (c *sqlRevocationChecker) Close() error {
+	return c.db.Close()
+}
+
+// revocationQueries renders revocation statements into the queries the
+// plugins run for username.
+func revocationQueries(statements []string, username string) []string {
+	queries := []string{}
+	for _, stmt := range statements {
+		for _, query := range strutil.ParseArbitraryStringSlice(stmt, ";") {
+			if query = strings.TrimSpace(query); query != "" {
+				queries = append(queries, dbutil.QueryHelper(query, map[string]string{"name": username}))
+			}
+		}
+	}
+	return queries
+}
+
+func pathRevocationPlan(b *databaseBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/revocation-plan$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"username": {
+				Type:        framework.TypeString,
+				Default:     defaultRevocationPlanUsername,
+				Description: "Username to render the revocation statements for.",
+			},
+			"explain": {
+				Type: framework.TypeBool,
+				Description: `Run the statements against the database in a
+				transaction that is rolled back, reporting the first that
+				fails. Only supported by the PostgreSQL plugin.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRevocationPlanRead,
+				Summary:  "Render a role's revocation statements for a username, optionally checking them against the database.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"username":   "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987",
+								"db_name":    "postgres",
+								"statements": []string{`REVOKE ALL ON ALL TABLES IN SCHEMA public FROM "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987"`, `DROP ROLE IF EXISTS "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987"`},
+								"success":    true,
+								"checks": []map[string]interface{}{
+									{"statement": `REVOKE ALL ON ALL TABLES IN SCHEMA public FROM "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987"`, "success": true},
+									{"statement": `DROP ROLE IF EXISTS "v-token-readonly-8QVhEkVZ5zrTVRXCsWAo-1573665987"`, "success": true},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRevocationPlanHelpSyn,
+		HelpDescription: pathRevocationPlanHelpDesc,
+	}
+}
+
+func (b *databaseBackend) pathRevocationPlanRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	username := data.Get("username").(string)
+
+	role, err := b.Role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
+	}
+	config, err := b.DatabaseConfig(ctx, req.Storage, role.DBName)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	statements := role.Statements
+	if role.UserSchema {
+		if statements, err = withUserSchema(config.PluginName, statements); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	queries := revocationQueries(statements.Revocation, username)
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"username":   username,
+			"db_name":    role.DBName,
+			"statements": queries,
+		},
+	}
+	if role.SkipRevocation {
+		resp.Data["skip_revocation"] = true
+		resp.AddWarning("the role sets skip_revocation, so its users are not revoked when their leases are")
+	}
+	if len(queries) == 0 {
+		resp.Data["default_revocation"] = true
+		resp.AddWarning("the role has no revocation statements, so its users are revoked by the plugin's default revocation")
+	}
+	if !data.Get("explain").(bool) {
+		return resp, nil
+	}
+	if len(queries) == 0 {
+		return logical.ErrorResponse("the role has no revocation statements to check"), nil
+	}
+
+	db, err := b.GetConnection(ctx, req.Storage, role.DBName)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := newRevocationChecker(b.connectionFiles, role.DBName, config, db)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	defer checker.Close()
+
+	succeeded, checkErr := checker.check(ctx, queries)
+	checks := make([]map[string]interface{}, 0, len(queries))
+	for i, query := range queries {
+		if i > succeeded {
+			// The queries after the one that failed aren't run
+			break
+		}
+		check := map[string]interface{}{
+			"statement": query,
+			"success":   i < succeeded,
+		}
+		if i == succeeded && checkErr != nil {
+			check["error"] = checkErr.Error()
+		}
+		checks = append(checks, check)
+	}
+	resp.Data["success"] = checkErr == nil
+	resp.Data["checks"] = checks
+	return resp, nil
+}
+
+const pathRevocationPlanHelpSyn = `
+Preview the revocation statements of a role for a username.
+`
+
+const pathRevocationPlanHelpDesc = `
+This path renders the revocation statements of a role for "username", split
+into the queries the plugin runs, so that operators can check that revoking
+the role's users will work before relying on it. Kubernetes roles are
+rendered with the annotation of their service account, and roles with
+"user_schema" include the statement dropping the user's schema. Roles without
+revocation statements are revoked by the plugin's default revocation, which
+isn't rendered.
+
+With "explain", the queries are also run against the database on the role's
+connection, with the connection's credentials, in a transaction that is
+rolled back, reporting whether each succeeded up to the first that failed.
+PostgreSQL can't EXPLAIN statements such as DROP ROLE, so they are run rather
+than planned; nothing they do is kept. Only the PostgreSQL plugin is
+supported. A hypothetical username that doesn't exist makes statements
+without IF EXISTS fail, just as revoking a user that was already removed
+would.
+`
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeRevocationChecker fails the queries containing fail, recording the
+// queries it was given.
+type fakeRevocationChecker struct {
+	fail    string
+	queries *[]string
+}
+
+func (f *fakeRevocationChecker) check(ctx context.Context, queries []string) (int, error) {
+	*f.queries = queries
+	for i, query := range queries {
+		if f.fail != "" && strings.Contains(query, f.fail) {
+			return i, errors.New(`role "v-revocation-plan" does not exist`)
+		}
+	}
+	return len(queries), nil
+}
+
+func (f *fakeRevocationChecker) Close() error {
+	return nil
+}
+
+func TestBackend_revocationPlan(t *testing.T) {
+	var checked []string
+	fail := ""
+	defer func(f func(*connectionFiles, string, *DatabaseConfig, *dbPluginInstance) (revocationChecker, error)) {
+		newRevocationChecker = f
+	}(newRevocationChecker)
+	newRevocationChecker = func(files *connectionFiles, name string, config *DatabaseConfig, db *dbPluginInstance) (revocationChecker, error) {
+		return &fakeRevocationChecker{fail: fail, queries: &checked}, nil
+	}
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+	read := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "roles/app/revocation-plan",
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	request("config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request("roles/app", map[string]interface{}{
+		"db_name":               "plugin-test",
+		"creation_statements":   `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"revocation_statements": `REVOKE ALL ON DATABASE app FROM "{{name}}"; DROP ROLE "{{name}}";`,
+	})
+
+	expected := []string{`REVOKE ALL ON DATABASE app FROM "v-app-1"`, `DROP ROLE "v-app-1"`}
+	resp := read(map[string]interface{}{"username": "v-app-1"})
+	if resp.IsError() || !reflect.DeepEqual(resp.Data["statements"], expected) || resp.Data["checks"] != nil {
+		t.Fatalf("expected the statements to be rendered for the user, got %#v", resp)
+	}
+
+	resp = read(map[string]interface{}{"username": "v-app-1", "explain": true})
+	if resp.IsError() || resp.Data["success"] != true || !reflect.DeepEqual(checked, expected) {
+		t.Fatalf("expected the statements to be checked, got %#v", resp)
+	}
+
+	fail = "REVOKE"
+	resp = read(map[string]interface{}{"explain": true})
+	checks := resp.Data["checks"].([]map[string]interface{})
+	if resp.Data["success"] != false || len(checks) != 1 || checks[0]["success"] != false || checks[0]["error"] == nil {
+		t.Fatalf("expected the first statement to fail and the rest not to be run, got %#v", resp.Data)
+	}
+	if checked[1] != `DROP ROLE "v-revocation-plan"` {
+		t.Fatalf("expected the default username to be used, got %q", checked)
+	}
+
+	request("roles/app", map[string]interface{}{
+		"revocation_statements": "",
+	})
+	resp = read(nil)
+	if resp.IsError() || resp.Data["default_revocation"] != true || len(resp.Warnings) != 1 {
+		t.Fatalf("expected the plugin's default revocation to be reported, got %#v", resp)
+	}
+	if resp := read(map[string]interface{}{"explain": true}); !resp.IsError() {
+		t.Fatalf("expected the default revocation not to be checked, got %#v", resp)
+	}
+}
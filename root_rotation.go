@@ -0,0 +1,309 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// rootRotationStrategySingle rotates the password of the connection's
+	// configured user in place.
+	rootRotationStrategySingle = "single"
+
+	// rootRotationStrategyDual alternates between two admin users, so that
+	// the credentials in use before a rotation remain valid after it.
+	rootRotationStrategyDual = "dual"
+)
+
+// minRootRotationPeriod is the shortest root_rotation_period accepted. The
+// periodic function only runs about once a minute, so shorter periods would
+// not be honoured.
+const minRootRotationPeriod = time.Minute
+
+// rootRotationJitterFraction bounds the random delay added to each scheduled
+// root rotation, as a fraction of the rotation period, so that connections
+// configured together do not all rotate at the same moment.
+const rootRotationJitterFraction = 10
+
+// rootRotationUnsupported are the builtin plugins that can't rotate the root
+// credentials of their connections, so can't be given a root_rotation_period
+// or root_rotation_schedule.
+var rootRotationUnsupported = map[string]bool{
+	"mongodb-database-plugin":      true,
+	"hana-database-plugin":         true,
+	"mongodbatlas-database-plugin": true,
+	"dynamodb-database-plugin":     true,
+	"bigquery-database-plugin":     true,
+	"trino-database-plugin":        true,
+}
+
+// defaultSecondaryRotationStatements are the statements that set the password
+// of the secondary user of a dual root rotation, by plugin, where the
+// connection doesn't set secondary_rotation_statements. The PostgreSQL plugin
+// refuses to set credentials without statements, and the MySQL plugins run
+// only those they are given. A nil entry is a plugin that sets the password
+// itself.
+var defaultSecondaryRotationStatements = map[string][]string{
+	"postgresql-database-plugin":   {`ALTER ROLE "{{name}}" WITH PASSWORD '{{password}}';`},
+	"mysql-database-plugin":        {`ALTER USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';`},
+	"mysql-aurora-database-plugin": {`ALTER USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';`},
+	"mysql-rds-database-plugin":    {`ALTER USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';`},
+	"mysql-legacy-database-plugin": {`SET PASSWORD FOR '{{name}}'@'%' = PASSWORD('{{password}}');`},
+	"mariadb-database-plugin":      {`ALTER USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';`},
+	"mongodb-database-plugin":      nil,
+}
+
+// secondaryRotationStatements returns the statements that set the password of
+// the connection's secondary user, or an error if its plugin can't set it. The
+// builtin plugins without defaults don't implement setting credentials at
+// all, so their connections can't use the dual strategy; external plugins
+// must be given the statements.
+func (c *DatabaseConfig) secondaryRotationStatements() ([]string, error) {
+	defaults, ok := defaultSecondaryRotationStatements[c.PluginName]
+	_, builtin := databasePlugins[c.PluginName]
+	switch {
+	case builtin && c.pluginCommand() == "" && !ok:
+		return nil, fmt.Errorf("%s can't set the password of the secondary user, so it doesn't support the %q root_rotation_strategy", c.PluginName, rootRotationStrategyDual)
+	case len(c.SecondaryRotationStatements) > 0:
+		return c.SecondaryRotationStatements, nil
+	case !ok:
+		return nil, fmt.Errorf("secondary_rotation_statements are required for the %q root_rotation_strategy with %s", rootRotationStrategyDual, c.PluginName)
+	}
+	return defaults, nil
+}
+
+// nextRootRotation returns when a connection with the given period should next
+// have its root credentials rotated, or the zero time if rotation is not
+// scheduled.
+func nextRootRotation(now time.Time, period time.Duration) time.Time {
+	if period <= 0 {
+		return time.Time{}
+	}
+
+	var jitter time.Duration
+	if max := int64(period / rootRotationJitterFraction); max > 0 {
+		jitter = time.Duration(rand.Int63n(max))
+	}
+
+	return now.Add(period + jitter)
+}
+
+// rotateRootCredentials rotates the root credentials of the named connection
+// using its root_rotation_statements, stores the new connection details and
+// schedules the next rotation. The plugin instance is closed so that the next
+// request reconnects with the new credentials.
+func (b *databaseBackend) rotateRootCredentials(ctx context.Context, s logical.Storage, name string) error {
+	// The configuration is rewritten whole, so a write of the connection
+	// must not be stored in between
+	lock := locksutil.LockForKey(b.connectionLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.DatabaseConfig(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	db, err := b.GetConnection(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	// Take out the backend lock since we are swapping out the connection
+	b.Lock()
+
+	// Take the write lock on the instance
+	db.Lock()
+	defer db.Unlock()
+
+	var connectionDetails map[string]interface{}
+	switch config.RootRotationStrategy {
+	case rootRotationStrategyDual:
+		connectionDetails, err = rotateInactiveRootCredentials(ctx, db, config)
+	default:
+		connectionDetails, err = b.rotateUnusedRootCredentials(ctx, db, name, config)
+	}
+	// The database has the credentials of a rotation that couldn't avoid the
+	// password history, so they are stored along with the failure
+	var reuseErr error
+	if errors.Is(err, errRootPasswordReused) {
+		reuseErr, err = err, nil
+	}
+	if err != nil {
+		b.Unlock()
+		b.recordRootRotationFailure(ctx, s, name, config, err)
+		return err
+	}
+
+	if password, ok := connectionDetails["password"].(string); ok && config.RootPasswordHistorySize > 0 {
+		config.RootPasswordHistory, err = config.RootPasswordHistory.add(password, config.RootPasswordHistorySize)
+		if err != nil {
+			b.Unlock()
+			return err
+		}
+	}
+
+	username, _ := connectionDetails["username"].(string)
+	password, _ := connectionDetails["password"].(string)
+	config.ConnectionDetails = restoreConnectionDetails(connectionDetails, config.ConnectionDetails)
+	if reuseErr != nil {
+		b.recordRootRotationFailure(ctx, s, name, config, reuseErr)
+	} else {
+		config.NextRootRotation = config.nextScheduledRootRotation(b.clock.Now())
+		config.RootRotationFailures = 0
+		config.LastRootRotationError = ""
+		if err := b.updateDatabaseConfig(ctx, s, name, config); err != nil {
+			b.Unlock()
+			return err
+		}
+	}
+
+	// Even on error, still remove the connection, discarding any instance
+	// being created from the old credentials, so that the requests from now
+	// on reconnect with the new ones
+	b.clearConnection(name)
+	b.Unlock()
+
+	// Close the plugin and its tunnel. The instance's lock is held, so the
+	// calls in flight on it have finished.
+	if err := db.close(); err != nil {
+		b.Logger().Error("error closing the database plugin connection", "err", err)
+	}
+	db.retire()
+
+	// As with static roles, the database already has the new credentials, so
+	// a failed hook is recorded rather than failing the rotation. The hook may
+	// take up to rotationHookTimeout, so it runs without the backend's lock,
+	// which would hold up the requests for every other connection.
+	hookErr := ""
+	if err := b.runRotationHook(ctx, config, rotatedCredentials{Connection: name, Username: username, Password: password}); err != nil {
+		hookErr = err.Error()
+	}
+	if hookErr != config.LastRotationHookError {
+		config.LastRotationHookError = hookErr
+		if err := b.updateDatabaseConfig(ctx, s, name, config); err != nil {
+			return err
+		}
+	}
+
+	return reuseErr
+}
+
+// errRootPasswordReused is returned by rotateUnusedRootCredentials, along with
+// the credentials the database was left with, if every password the plugin
+// picked was in the password history.
+var errRootPasswordReused = fmt.Errorf("root credential rotation picked passwords from the password history %d times", maxPasswordAttempts)
+
+// rotateUnusedRootCredentials rotates the root credentials of a connection
+// using the single strategy, rotating again while the plugin picks a password
+// in the password history. The plugin generates the password itself, so
+// unlike generateUnusedPassword each attempt changes the password in the
+// database.
+func (b *databaseBackend) rotateUnusedRootCredentials(ctx context.Context, db *dbPluginInstance, name string, config *DatabaseConfig) (map[string]interface{}, error) {
+	connectionDetails, err := db.RotateRootCredentials(ctx, config.RootCredentialsRotateStatements)
+	for attempts := 1; err == nil; attempts++ {
+		password, _ := connectionDetails["password"].(string)
+		if !config.RootPasswordHistory.contains(password) {
+			return connectionDetails, nil
+		}
+		if attempts == maxPasswordAttempts {
+			return connectionDetails, errRootPasswordReused
+		}
+		b.Logger().Warn("root credential rotation picked a password from the history; rotating again", "connection", name)
+		connectionDetails, err = db.RotateRootCredentials(ctx, config.RootCredentialsRotateStatements)
+	}
+	return nil, err
+}
+
+// rotateInactiveRootCredentials sets a new password on the secondary user of a
+// connection using the dual strategy, and returns connection details that use
+// it. The user that was active becomes the secondary user, keeping its current
+// password until the next rotation.
+func rotateInactiveRootCredentials(ctx context.Context, db *dbPluginInstance, config *DatabaseConfig) (map[string]interface{}, error) {
+	activeUsername, _ := config.ConnectionDetails["username"].(string)
+	if activeUsername == "" || config.SecondaryUsername == "" {
+		return nil, errors.New(`both "username" and "secondary_username" are required for dual root rotation`)
+	}
+
+	password, err := generateUnusedPassword(ctx, db, config.RootPasswordHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	// root_rotation_statements are templated for the active user, so the
+	// secondary user is given its password by statements of its own
+	statements, err := config.secondaryRotationStatements()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := db.SetCredentials(ctx, dbplugin.Statements{Rotation: statements}, dbplugin.StaticUserConfig{
+		Username: config.SecondaryUsername,
+		Password: password,
+	}); err != nil {
+		return nil, errwrap.Wrapf("failed to set password of secondary user: {{err}}", err)
+	}
+
+	connectionDetails := make(map[string]interface{}, len(config.ConnectionDetails))
+	for k, v := range config.ConnectionDetails {
+		connectionDetails[k] = v
+	}
+	connectionDetails["username"] = config.SecondaryUsername
+	connectionDetails["password"] = password
+	config.SecondaryUsername = activeUsername
+
+	return connectionDetails, nil
+}
+
+// rotateScheduledRootCredentials rotates the root credentials of every
+// connection due for rotation by its root_rotation_period or
+// root_rotation_schedule. Failures are logged and
+// rescheduled according to the connection's retry policy.
+func (b *databaseBackend) rotateScheduledRootCredentials(ctx context.Context, req *logical.Request) error {
+	// Only the active node of the primary cluster, or a local mount, may write
+	// the rotated credentials.
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	keys, err := req.Storage.List(ctx, "config/")
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.Now()
+	for _, name := range keys {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		config, err := b.DatabaseConfig(ctx, req.Storage, name)
+		if err != nil {
+			return err
+		}
+		if !config.rootRotationScheduled() || config.NextRootRotation.IsZero() || now.Before(config.NextRootRotation) {
+			continue
+		}
+
+		b.Logger().Info("rotating root credentials on schedule", "connection", name)
+		if err := b.rotateRootCredentials(ctx, req.Storage, name); err != nil {
+			b.Logger().Error("scheduled root credential rotation failed", "connection", name, "error", err)
+		}
+	}
+
+	return nil
+}
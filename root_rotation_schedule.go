@@ -0,0 +1,172 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleSearch bounds the search for the next time of a schedule, so
+// that schedules naming dates that never come, such as February 30, end.
+const maxScheduleSearch = 5 * 366 * 24 * time.Hour
+
+// cronSchedule is a parsed root_rotation_schedule: a standard five field cron
+// expression of the minute, hour, day of the month, month and day of the
+// week, evaluated in UTC. Each field is a bitset of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny record a "*" day field. As in cron, a day matches
+	// either day field when both are restricted.
+	domAny, dowAny bool
+}
+
+// cronField is the range of values of a cron field.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of the month", 1, 31},
+	{"month", 1, 12},
+	{"day of the week", 0, 7},
+}
+
+// parseCronSchedule parses a cron expression. Fields are "*", values, ranges
+// such as "1-5" and lists of them, each optionally stepped with "/n". A day of
+// the week of 7 is Sunday, as is 0.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("schedule %q must have 5 fields: minute, hour, day of the month, month and day of the week", spec)
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q of the %s", part, f.name)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", f.name, part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s %q", f.name, part)
+				}
+			} else if step > 1 {
+				// As in cron, "5/15" is "5-max/15"
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s %q must be within %d-%d", f.name, part, f.min, f.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time of the schedule after t, or the zero time if
+// the schedule never comes.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(maxScheduleSearch)
+
+	for t.Before(end) {
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// rootRotationScheduled reports whether the connection's root credentials are
+// rotated by the periodic function.
+func (c *DatabaseConfig) rootRotationScheduled() bool {
+	return c.RootRotationPeriod > 0 || c.RootRotationSchedule != ""
+}
+
+// nextScheduledRootRotation returns when the connection's root credentials
+// are next due for rotation after now: the next time of its
+// root_rotation_schedule, which is not jittered since it names the times to
+// rotate at, or its root_rotation_period from now with jitter.
+func (c *DatabaseConfig) nextScheduledRootRotation(now time.Time) time.Time {
+	if c.RootRotationSchedule == "" {
+		return nextRootRotation(now, c.RootRotationPeriod)
+	}
+	schedule, err := parseCronSchedule(c.RootRotationSchedule)
+	if err != nil {
+		return time.Time{}
+	}
+	return schedule.next(now)
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	now := time.Date(2020, 1, 31, 12, 30, 0, 0, time.UTC) // a Friday
+
+	cases := map[string]struct {
+		spec     string
+		expected time.Time
+	}{
+		"every minute":   {"* * * * *", time.Date(2020, 1, 31, 12, 31, 0, 0, time.UTC)},
+		"daily":          {"0 3 * * *", time.Date(2020, 2, 1, 3, 0, 0, 0, time.UTC)},
+		"later today":    {"45 12 * * *", time.Date(2020, 1, 31, 12, 45, 0, 0, time.UTC)},
+		"step":           {"*/20 * * * *", time.Date(2020, 1, 31, 12, 40, 0, 0, time.UTC)},
+		"list and range": {"0 1,22-23 * * *", time.Date(2020, 1, 31, 22, 0, 0, 0, time.UTC)},
+		"sunday as 7":    {"0 3 * * 7", time.Date(2020, 2, 2, 3, 0, 0, 0, time.UTC)},
+		"weekdays":       {"0 3 * * 1-5", time.Date(2020, 2, 3, 3, 0, 0, 0, time.UTC)},
+		"monthly":        {"0 0 1 * *", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		"leap day":       {"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		"either day":     {"0 0 15 * 6", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		"quarterly":      {"0 0 1 */3 *", time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		"never":          {"0 0 30 2 *", time.Time{}},
+		"yearly":         {"0 0 1 1 *", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			schedule, err := parseCronSchedule(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next := schedule.next(now); !next.Equal(tc.expected) {
+				t.Fatalf("expected %s, got %s", tc.expected, next)
+			}
+		})
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCronSchedule(spec); err == nil {
+			t.Fatalf("expected an error for %q", spec)
+		}
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
-	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/hashicorp/vault/sdk/queue"
 )
@@ -79,7 +78,7 @@ func (b *databaseBackend) populateQueue(ctx context.Context, s logical.Storage)
 			} else {
 				log.Info("adjusting priority for Role")
 				item.Value = walEntry.walID
-				item.Priority = time.Now().Unix()
+				item.Priority = b.clock.Now().Unix()
 			}
 		}
 
@@ -162,7 +161,7 @@ func (b *databaseBackend) rotateCredential(ctx context.Context, s logical.Storag
 	role, err := b.StaticRole(ctx, s, item.Key)
 	if err != nil {
 		b.logger.Error("unable to load role", "role", item.Key, "error", err)
-		item.Priority = time.Now().Add(10 * time.Second).Unix()
+		item.Priority = b.clock.Now().Add(10 * time.Second).Unix()
 		if err := b.pushItem(item); err != nil {
 			b.logger.Error("unable to push item on to queue", "error", err)
 		}
@@ -175,7 +174,7 @@ func (b *databaseBackend) rotateCredential(ctx context.Context, s logical.Storag
 
 	// If "now" is less than the Item priority, then this item does not need to
 	// be rotated
-	if time.Now().Unix() < item.Priority {
+	if b.clock.Now().Unix() < item.Priority {
 		if err := b.pushItem(item); err != nil {
 			b.logger.Error("unable to push item on to queue", "error", err)
 		}
@@ -194,7 +193,7 @@ func (b *databaseBackend) rotateCredential(ctx context.Context, s logical.Storag
 		walEntry, err := b.findStaticWAL(ctx, s, walID)
 		if err != nil {
 			b.logger.Error("error finding static WAL", "error", err)
-			item.Priority = time.Now().Add(10 * time.Second).Unix()
+			item.Priority = b.clock.Now().Add(10 * time.Second).Unix()
 			if err := b.pushItem(item); err != nil {
 				b.logger.Error("unable to push item on to queue", "error", err)
 			}
@@ -210,7 +209,7 @@ func (b *databaseBackend) rotateCredential(ctx context.Context, s logical.Storag
 		b.logger.Error("unable to rotate credentials in periodic function", "error", err)
 		// Increment the priority enough so that the next call to this method
 		// likely will not attempt to rotate it, as a back-off of sorts
-		item.Priority = time.Now().Add(10 * time.Second).Unix()
+		item.Priority = b.recordStaticRotationFailure(ctx, s, item.Key, role, err).Unix()
 
 		// Preserve the WALID if it was returned
 		if resp != nil && resp.WALID != "" {
@@ -226,7 +225,7 @@ func (b *databaseBackend) rotateCredential(ctx context.Context, s logical.Storag
 
 	lvr := resp.RotationTime
 	if lvr.IsZero() {
-		lvr = time.Now()
+		lvr = b.clock.Now()
 	}
 
 	// Update priority and push updated Item to the queue
@@ -311,7 +310,7 @@ func (b *databaseBackend) setStaticAccount(ctx context.Context, s logical.Storag
 
 	// If role name isn't in the database's allowed roles, send back a
 	// permission denied.
-	if !strutil.StrListContains(dbConfig.AllowedRoles, "*") && !strutil.StrListContainsGlob(dbConfig.AllowedRoles, input.RoleName) {
+	if !b.roleAllowed(dbConfig, input.RoleName) {
 		return output, fmt.Errorf("%q is not an allowed role", input.RoleName)
 	}
 
@@ -330,7 +329,7 @@ func (b *databaseBackend) setStaticAccount(ctx context.Context, s logical.Storag
 	newPassword := input.Password
 	if newPassword == "" {
 		// Generate a new password
-		newPassword, err = db.GenerateCredentials(ctx)
+		newPassword, err = generateUnusedPassword(ctx, db, input.Role.StaticAccount.PasswordHistory)
 		if err != nil {
 			return output, err
 		}
@@ -367,16 +366,42 @@ func (b *databaseBackend) setStaticAccount(ctx context.Context, s logical.Storag
 
 	// Store updated role information
 	// lvr is the known LastVaultRotation
-	lvr := time.Now()
+	lvr := b.clock.Now()
 	input.Role.StaticAccount.LastVaultRotation = lvr
 	input.Role.StaticAccount.Password = password
+	input.Role.StaticAccount.RotationFailures = 0
+	input.Role.StaticAccount.LastRotationError = ""
 	output.RotationTime = lvr
 
+	input.Role.StaticAccount.PasswordHistory, err = input.Role.StaticAccount.PasswordHistory.add(password, input.Role.StaticAccount.PasswordHistorySize)
+	if err != nil {
+		return output, err
+	}
+
+	// The database already has the new password, so a failure to write it
+	// to the sink is recorded on the role rather than failing the rotation.
+	if err := b.syncStaticAccount(ctx, s, input.Role.StaticAccount); err != nil {
+		b.Logger().Error("failed to write rotated credentials to secret sink", "role", input.RoleName, "sink", input.Role.StaticAccount.SyncSink, "error", err)
+		input.Role.StaticAccount.LastSyncError = err.Error()
+	} else {
+		input.Role.StaticAccount.LastSyncError = ""
+	}
+	if err := b.runRotationHook(ctx, dbConfig, rotatedCredentials{
+		Connection: input.Role.DBName,
+		Role:       input.RoleName,
+		Username:   config.Username,
+		Password:   password,
+	}); err != nil {
+		input.Role.StaticAccount.LastRotationHookError = err.Error()
+	} else {
+		input.Role.StaticAccount.LastRotationHookError = ""
+	}
+
 	entry, err := logical.StorageEntryJSON(databaseStaticRolePath+input.RoleName, input.Role)
 	if err != nil {
 		return output, err
 	}
-	if err := s.Put(ctx, entry); err != nil {
+	if err := b.putEntry(ctx, s, entry); err != nil {
 		return output, err
 	}
 
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultRotationRetryBackoff is the delay before the first retry of a
+	// failed rotation, for connections that do not set rotation_retry_backoff.
+	defaultRotationRetryBackoff = 10 * time.Second
+
+	// maxRotationRetryBackoff caps the exponential backoff between retries.
+	maxRotationRetryBackoff = time.Hour
+)
+
+// rotationRetryBackoff returns how long to wait before retrying a rotation
+// that has failed the given number of consecutive times. The delay doubles
+// with each failure, up to maxRotationRetryBackoff.
+func (c *DatabaseConfig) rotationRetryBackoff(failures int) time.Duration {
+	backoff := c.RotationRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRotationRetryBackoff
+	}
+
+	for i := 1; i < failures && backoff < maxRotationRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRotationRetryBackoff {
+		backoff = maxRotationRetryBackoff
+	}
+	return backoff
+}
+
+// rotationRetriesExhausted reports whether a rotation that has failed the
+// given number of consecutive times has used up its retries. Connections
+// without rotation_max_retries retry indefinitely.
+func (c *DatabaseConfig) rotationRetriesExhausted(failures int) bool {
+	return c.RotationMaxRetries > 0 && failures >= c.RotationMaxRetries
+}
+
+// issuanceDisabled returns an error if credentials must not be issued because
+// a rotation has exhausted its retries and the connection is configured to
+// stop issuance when that happens.
+func (c *DatabaseConfig) issuanceDisabled(what string, failures int) error {
+	if !c.DisableIssuanceOnRotationFailure || !c.rotationRetriesExhausted(failures) {
+		return nil
+	}
+	return fmt.Errorf("issuance is disabled because rotation of %s has failed %d consecutive times", what, failures)
+}
+
+// recordStaticRotationFailure stores the failure of a static role's rotation
+// on the role, and returns when the rotation should next be attempted. The
+// caller must hold the role's lock.
+func (b *databaseBackend) recordStaticRotationFailure(ctx context.Context, s logical.Storage, name string, role *roleEntry, rotationErr error) time.Time {
+	role.StaticAccount.RotationFailures++
+	role.StaticAccount.LastRotationError = rotationErr.Error()
+	failures := role.StaticAccount.RotationFailures
+
+	entry, err := logical.StorageEntryJSON(databaseStaticRolePath+name, role)
+	if err == nil {
+		err = b.putEntry(ctx, s, entry)
+	}
+	if err != nil {
+		b.logger.Error("unable to record rotation failure", "role", name, "error", err)
+	}
+
+	config, err := b.DatabaseConfig(ctx, s, role.DBName)
+	if err != nil {
+		return b.clock.Now().Add(defaultRotationRetryBackoff)
+	}
+
+	if config.rotationRetriesExhausted(failures) {
+		b.logger.Error("static role rotation has exhausted its retries; retrying once per rotation period", "role", name, "failures", failures)
+		return b.clock.Now().Add(role.StaticAccount.RotationPeriod)
+	}
+	return b.clock.Now().Add(config.rotationRetryBackoff(failures))
+}
+
+// recordRootRotationFailure stores the failure of a connection's root
+// rotation on its configuration, rescheduling any scheduled rotation
+// according to the connection's retry policy. The caller must hold the
+// connection's lock.
+func (b *databaseBackend) recordRootRotationFailure(ctx context.Context, s logical.Storage, name string, config *DatabaseConfig, rotationErr error) {
+	config.RootRotationFailures++
+	config.LastRootRotationError = rotationErr.Error()
+
+	if config.rootRotationScheduled() {
+		if config.rotationRetriesExhausted(config.RootRotationFailures) {
+			b.logger.Error("root credential rotation has exhausted its retries; retrying at the next scheduled rotation", "connection", name, "failures", config.RootRotationFailures)
+			config.NextRootRotation = config.nextScheduledRootRotation(b.clock.Now())
+		} else {
+			config.NextRootRotation = b.clock.Now().Add(config.rotationRetryBackoff(config.RootRotationFailures))
+		}
+	}
+
+	if err := b.updateDatabaseConfig(ctx, s, name, config); err != nil {
+		b.logger.Error("unable to record root rotation failure", "connection", name, "error", err)
+	}
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDatabaseConfig_rotationRetryBackoff(t *testing.T) {
+	config := &DatabaseConfig{}
+	for failures, expected := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		4:  80 * time.Second,
+		20: maxRotationRetryBackoff,
+	} {
+		if backoff := config.rotationRetryBackoff(failures); backoff != expected {
+			t.Fatalf("failures %d: expected %s, got %s", failures, expected, backoff)
+		}
+	}
+
+	config.RotationRetryBackoff = time.Minute
+	if backoff := config.rotationRetryBackoff(3); backoff != 4*time.Minute {
+		t.Fatalf("expected 4m, got %s", backoff)
+	}
+}
+
+func TestDatabaseConfig_issuanceDisabled(t *testing.T) {
+	config := &DatabaseConfig{RotationMaxRetries: 3}
+	if config.rotationRetriesExhausted(2) || !config.rotationRetriesExhausted(3) {
+		t.Fatal("expected retries to be exhausted after 3 failures")
+	}
+	if err := config.issuanceDisabled("the root credentials", 3); err != nil {
+		t.Fatalf("expected issuance to be allowed without the safety switch, got %s", err)
+	}
+
+	config.DisableIssuanceOnRotationFailure = true
+	if err := config.issuanceDisabled("the root credentials", 2); err != nil {
+		t.Fatalf("expected issuance to be allowed before retries are exhausted, got %s", err)
+	}
+	if err := config.issuanceDisabled("the root credentials", 3); err == nil {
+		t.Fatal("expected issuance to be disabled")
+	}
+}
+
+func TestBackend_rotationFailurePolicy(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	write := func(path string, op logical.Operation, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := write("config/plugin-test", logical.CreateOperation, map[string]interface{}{
+		"connection_url":                       "sample_connection_url",
+		"plugin_name":                          "postgresql-database-plugin",
+		"verify_connection":                    false,
+		"allowed_roles":                        []string{"*"},
+		"disable_issuance_on_rotation_failure": true,
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error without rotation_max_retries, got: %#v", resp)
+	}
+
+	resp = write("config/plugin-test", logical.CreateOperation, map[string]interface{}{
+		"connection_url":                       "sample_connection_url",
+		"plugin_name":                          "postgresql-database-plugin",
+		"verify_connection":                    false,
+		"allowed_roles":                        []string{"*"},
+		"rotation_max_retries":                 2,
+		"disable_issuance_on_rotation_failure": true,
+	})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	resp = write("roles/plugin-role-test", logical.CreateOperation, map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": testRole,
+	})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+
+	config, err := b.DatabaseConfig(context.Background(), s, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		b.recordRootRotationFailure(context.Background(), s, "plugin-test", config, errors.New("connection refused"))
+	}
+
+	resp = write("config/plugin-test", logical.ReadOperation, nil)
+	if resp.Data["root_rotation_failures"] != 2 || resp.Data["last_root_rotation_error"] != "connection refused" {
+		t.Fatalf("expected the failure state on read, got: %#v", resp.Data)
+	}
+
+	// Recording the failures moves the connection to a new version
+	if resp.Data["version"] != 3 {
+		t.Fatalf("expected the failures to bump the version to 3, got: %#v", resp.Data["version"])
+	}
+	resp = write("config/plugin-test", logical.UpdateOperation, map[string]interface{}{
+		"verify_connection": false,
+		"cas":               1,
+	})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "did not match") {
+		t.Fatalf("expected a write at the old version to fail, got: %#v", resp)
+	}
+
+	resp = write("creds/plugin-role-test", logical.ReadOperation, nil)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "issuance is disabled") {
+		t.Fatalf("expected issuance to be disabled, got: %#v", resp)
+	}
+}
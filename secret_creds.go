@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/vault/sdk/database/dbplugin"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -14,8 +15,17 @@ const SecretCredsType = "creds"
 
 func secretCreds(b *databaseBackend) *framework.Secret {
 	return &framework.Secret{
-		Type:   SecretCredsType,
-		Fields: map[string]*framework.FieldSchema{},
+		Type: SecretCredsType,
+		Fields: map[string]*framework.FieldSchema{
+			"username": {
+				Type:        framework.TypeString,
+				Description: "Username of the generated database user.",
+			},
+			"password": {
+				Type:        framework.TypeString,
+				Description: "Password of the generated database user.",
+			},
+		},
 
 		Renew:  b.secretCredsRenew(),
 		Revoke: b.secretCredsRevoke(),
@@ -36,7 +46,7 @@ func (b *databaseBackend) secretCredsRenew() framework.OperationFunc {
 			return nil, fmt.Errorf("could not find role with name: %q", req.Secret.InternalData["role"])
 		}
 
-		role, err := b.Role(ctx, req.Storage, roleNameRaw.(string))
+		role, roleName, err := b.leaseRole(ctx, req.Storage, roleNameRaw.(string))
 		if err != nil {
 			return nil, err
 		}
@@ -53,25 +63,50 @@ func (b *databaseBackend) secretCredsRenew() framework.OperationFunc {
 		db.RLock()
 		defer db.RUnlock()
 
+		// Leases issued by a role with a max_lifetime aren't renewed past the
+		// lifetime, even if the role has changed since.
+		maxTTL := role.MaxTTL
+		notAfter, capped, err := leaseNotAfter(req.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if capped {
+			if !b.clock.Now().Before(notAfter) {
+				return logical.ErrorResponse(fmt.Sprintf("the credential reached its maximum lifetime at %s", notAfter.Format(time.RFC3339))), nil
+			}
+			maxTTL = capMaxTTL(maxTTL, notAfter.Sub(req.Secret.IssueTime))
+		}
+
 		// Make sure we increase the VALID UNTIL endpoint for this user.
-		ttl, _, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.DefaultTTL, 0, role.MaxTTL, 0, req.Secret.IssueTime)
+		ttl, _, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.DefaultTTL, 0, maxTTL, 0, req.Secret.IssueTime)
 		if err != nil {
 			return nil, err
 		}
 		if ttl > 0 {
-			expireTime := time.Now().Add(ttl)
+			expireTime := b.clock.Now().Add(ttl)
 			// Adding a small buffer since the TTL will be calculated again after this call
 			// to ensure the database credential does not expire before the lease
 			expireTime = expireTime.Add(5 * time.Second)
 			err := db.RenewUser(ctx, role.Statements, username, expireTime)
 			if err != nil {
 				b.CloseIfShutdown(db, err)
+				return b.pluginErrorResponse("renew the user", err)
+			}
+
+			user, err := getActiveUser(ctx, req.Storage, roleName, username)
+			if err != nil {
 				return nil, err
 			}
+			if user != nil {
+				user.Expiration = b.clock.Now().Add(ttl)
+				if err := putActiveUser(ctx, req.Storage, roleName, user); err != nil {
+					return nil, err
+				}
+			}
 		}
 		resp := &logical.Response{Secret: req.Secret}
 		resp.Secret.TTL = role.DefaultTTL
-		resp.Secret.MaxTTL = role.MaxTTL
+		resp.Secret.MaxTTL = maxTTL
 		return resp, nil
 	}
 }
@@ -95,7 +130,7 @@ func (b *databaseBackend) secretCredsRevoke() framework.OperationFunc {
 		var dbName string
 		var statements dbplugin.Statements
 
-		role, err := b.Role(ctx, req.Storage, roleNameRaw.(string))
+		role, roleName, err := b.leaseRole(ctx, req.Storage, roleNameRaw.(string))
 		if err != nil {
 			return nil, err
 		}
@@ -103,6 +138,7 @@ func (b *databaseBackend) secretCredsRevoke() framework.OperationFunc {
 			dbName = role.DBName
 			statements = role.Statements
 		} else {
+			roleName = roleNameRaw.(string)
 			if dbNameRaw, ok := req.Secret.InternalData["db_name"]; !ok {
 				return nil, fmt.Errorf("error during revoke: could not find role with name %q or embedded revocation db name data", req.Secret.InternalData["role"])
 			} else {
@@ -127,6 +163,32 @@ func (b *databaseBackend) secretCredsRevoke() framework.OperationFunc {
 			}
 		}
 
+		// The lease records whether the role skipped revocation when it was
+		// issued, so that changing the role doesn't affect existing users.
+		if skip, _ := req.Secret.InternalData["skip_revocation"].(bool); skip {
+			b.Logger().Info("skipping revocation of the user in the database", "role", roleName, "db_name", dbName, "username", username)
+			if err := deleteActiveUser(ctx, req.Storage, roleName, username); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+
+		// Users bound to the address they were requested from are revoked
+		// with the address kept in the lease.
+		requestIP, _ := req.Secret.InternalData["request_ip"].(string)
+		statements = withRequestIP(statements, requestIP)
+
+		if userSchema, _ := req.Secret.InternalData["user_schema"].(bool); userSchema {
+			config, err := b.DatabaseConfig(ctx, req.Storage, dbName)
+			if err != nil {
+				return nil, err
+			}
+			statements, err = withUserSchema(config.PluginName, statements)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		// Get our connection
 		db, err := b.GetConnection(ctx, req.Storage, dbName)
 		if err != nil {
@@ -138,6 +200,27 @@ func (b *databaseBackend) secretCredsRevoke() framework.OperationFunc {
 
 		if err := db.RevokeUser(ctx, statements, username); err != nil {
 			b.CloseIfShutdown(db, err)
+
+			// Revocations that fail are retried by Vault's expiration manager,
+			// so a user that is already gone is treated as revoked rather
+			// than retried until the lease is given up on.
+			switch classifyPluginError(err) {
+			case pluginErrorNotFound:
+				b.Logger().Info("the user was already removed from the database", "role", roleName, "db_name", dbName, "username", username, "error", err)
+			case pluginErrorRetryable:
+				b.Logger().Warn("revocation failed and will be retried", "role", roleName, "db_name", dbName, "username", username, "error", err)
+				return b.pluginErrorResponse("revoke the user", err)
+			default:
+				b.Logger().Error("revocation failed", "role", roleName, "db_name", dbName, "username", username, "error", err)
+				metrics.IncrCounterWithLabels([]string{"database", "revocation", "failure"}, 1, withMountLabels([]metrics.Label{
+					{Name: "db_name", Value: dbName},
+					{Name: "role", Value: roleName},
+				}, b.mountLabels))
+				return b.pluginErrorResponse("revoke the user", err)
+			}
+		}
+
+		if err := deleteActiveUser(ctx, req.Storage, roleName, username); err != nil {
 			return nil, err
 		}
 		return resp, nil
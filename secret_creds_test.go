@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+)
+
+func TestBackend_skipRevocation(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		req.Storage = s
+		resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	fake := &fakeIssuingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": testRole,
+			"skip_revocation":     true,
+		},
+	})
+
+	skipped := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"}).Secret
+	request(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/app",
+		Data:      map[string]interface{}{"skip_revocation": false},
+	})
+	revoked := request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"}).Secret
+
+	// Each lease is revoked as its role was configured when it was issued
+	request(&logical.Request{Operation: logical.RevokeOperation, Secret: skipped})
+	request(&logical.Request{Operation: logical.RevokeOperation, Secret: revoked})
+	if len(fake.revoked) != 1 || fake.revoked[0] != "user-2" {
+		t.Fatalf("expected only the second user to be revoked, got %v", fake.revoked)
+	}
+
+	users, err := activeUsers(context.Background(), s, "app", b.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("expected both users to be removed from the index, got %#v", users)
+	}
+}
+
+type failingRevocationDatabase struct {
+	fakeIssuingDatabase
+	revokeErr error
+}
+
+func (f *failingRevocationDatabase) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	return f.revokeErr
+}
+
+func TestBackend_revocationErrors(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	fake := &failingRevocationDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	mustRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"creation_statements": testRole,
+		},
+	})
+	secret := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"}).Secret
+
+	// A transient failure is returned for the revocation to be retried
+	fake.revokeErr = driver.ErrBadConn
+	_, err := request(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusServiceUnavailable {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+
+	// As is a permanent one
+	fake.revokeErr = &pq.Error{Code: "XX000", Message: "internal error"}
+	if _, err := request(&logical.Request{Operation: logical.RevokeOperation, Secret: secret}); err != fake.revokeErr {
+		t.Fatalf("expected the error to be returned, got %v", err)
+	}
+
+	// A missing object isn't taken for a missing user
+	fake.revokeErr = &pq.Error{Code: "42704", Message: `role "reporting" does not exist`}
+	if resp, _ := request(&logical.Request{Operation: logical.RevokeOperation, Secret: secret}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the revocation to fail, got %#v", resp)
+	}
+	users, err := activeUsers(context.Background(), s, "app", b.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("expected the user to stay in the index, got %#v", users)
+	}
+
+	// A user that is confirmed to be gone is revoked
+	fake.revokeErr = &userNotFoundError{err: &pq.Error{Code: "42704", Message: `role "user-1" does not exist`}}
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	users, err = activeUsers(context.Background(), s, "app", b.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("expected the user to be removed from the index, got %#v", users)
+	}
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	sinkTypeAWSSecretsManager = "aws_secrets_manager"
+	sinkTypeGCPSecretManager  = "gcp_secret_manager"
+	sinkTypeAzureKeyVault     = "azure_key_vault"
+)
+
+// secretSinkTimeout bounds how long writing a secret to a sink may take, so
+// that an unavailable store does not hold up rotations.
+const secretSinkTimeout = 30 * time.Second
+
+// secretSinkConfig configures an external secret store that the credentials of
+// static roles are written to when they are rotated, for applications that
+// cannot read them from Vault.
+type secretSinkConfig struct {
+	Type string `json:"type"`
+
+	// Endpoint overrides the API endpoint of the store, or for Azure Key
+	// Vault the Azure AD authority.
+	Endpoint string `json:"endpoint"`
+
+	// AWS Secrets Manager. The access key defaults to the credentials of the
+	// AWS SDK's default chain.
+	Region       string `json:"region"`
+	AccessKey    string `json:"access_key"`
+	SecretKey    string `json:"secret_key"`
+	SessionToken string `json:"session_token"`
+
+	// GCP Secret Manager. The credentials default to the application default
+	// credentials.
+	Project     string `json:"project"`
+	Credentials string `json:"credentials"`
+
+	// Azure Key Vault.
+	VaultURL     string `json:"vault_url"`
+	TenantID     string `json:"tenant_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func (c *secretSinkConfig) validate() error {
+	switch c.Type {
+	case sinkTypeAWSSecretsManager:
+		if c.Region == "" {
+			return errors.New("region must be set for aws_secrets_manager")
+		}
+		if (c.AccessKey == "") != (c.SecretKey == "") {
+			return errors.New("access_key and secret_key must be set together")
+		}
+	case sinkTypeGCPSecretManager:
+		if c.Project == "" {
+			return errors.New("project must be set for gcp_secret_manager")
+		}
+		if c.Credentials != "" {
+			if _, err := google.CredentialsFromJSON(context.Background(), []byte(c.Credentials)); err != nil {
+				return fmt.Errorf("failed to parse credentials: %s", err)
+			}
+		}
+	case sinkTypeAzureKeyVault:
+		if c.VaultURL == "" || c.TenantID == "" || c.ClientID == "" || c.ClientSecret == "" {
+			return errors.New("vault_url, tenant_id, client_id and client_secret must be set for azure_key_vault")
+		}
+	default:
+		return fmt.Errorf("unsupported sink type %q", c.Type)
+	}
+	return nil
+}
+
+// putSecret writes a new version of the named secret to the sink, creating
+// the secret if it does not exist yet.
+func (c *secretSinkConfig) putSecret(ctx context.Context, name, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, secretSinkTimeout)
+	defer cancel()
+
+	switch c.Type {
+	case sinkTypeAWSSecretsManager:
+		return c.putAWSSecret(ctx, name, value)
+	case sinkTypeGCPSecretManager:
+		return c.putGCPSecret(ctx, name, value)
+	case sinkTypeAzureKeyVault:
+		return c.putAzureSecret(ctx, name, value)
+	default:
+		return fmt.Errorf("unsupported sink type %q", c.Type)
+	}
+}
+
+// sinkError describes an unsuccessful response from a sink's API.
+type sinkError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *sinkError) Error() string {
+	return fmt.Sprintf("unexpected response %d: %s", e.StatusCode, e.Body)
+}
+
+// doSinkRequest sends a request and decodes a JSON response into out, if it is
+// not nil.
+func doSinkRequest(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &sinkError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (c *secretSinkConfig) putAWSSecret(ctx context.Context, name, value string) error {
+	err := c.awsRequest(ctx, "PutSecretValue", map[string]string{
+		"SecretId":     name,
+		"SecretString": value,
+	})
+	if e, ok := err.(*sinkError); ok && strings.Contains(e.Body, "ResourceNotFoundException") {
+		err = c.awsRequest(ctx, "CreateSecret", map[string]string{
+			"Name":         name,
+			"SecretString": value,
+		})
+	}
+	return err
+}
+
+func (c *secretSinkConfig) awsRequest(ctx context.Context, action string, input interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", c.Region)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager."+action)
+
+	creds, err := c.awsCredentials()
+	if err != nil {
+		return err
+	}
+	if _, err := v4.NewSigner(creds).Sign(req, bytes.NewReader(body), "secretsmanager", c.Region, time.Now()); err != nil {
+		return err
+	}
+
+	return doSinkRequest(http.DefaultClient, req, nil)
+}
+
+// awsCredentials returns the configured access key, or the credentials of the
+// AWS SDK's default chain: the environment, the shared credentials file and
+// the role of the instance or task the plugin runs on.
+func (c *secretSinkConfig) awsCredentials() (*credentials.Credentials, error) {
+	if c.AccessKey != "" {
+		return credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, c.SessionToken), nil
+	}
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(c.Region))
+	if err != nil {
+		return nil, err
+	}
+	return sess.Config.Credentials, nil
+}
+
+func (c *secretSinkConfig) putGCPSecret(ctx context.Context, name, value string) error {
+	const scope = "https://www.googleapis.com/auth/cloud-platform"
+
+	var creds *google.Credentials
+	var err error
+	if c.Credentials != "" {
+		creds, err = google.CredentialsFromJSON(ctx, []byte(c.Credentials), scope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, scope)
+	}
+	if err != nil {
+		return err
+	}
+	client := oauth2.NewClient(ctx, creds.TokenSource)
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretmanager.googleapis.com"
+	}
+	secretURL := fmt.Sprintf("%s/v1/projects/%s/secrets/%s", endpoint, url.PathEscape(c.Project), url.PathEscape(name))
+
+	addVersion := func() error {
+		body, err := json.Marshal(map[string]interface{}{
+			"payload": map[string]string{
+				"data": base64.StdEncoding.EncodeToString([]byte(value)),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, secretURL+":addVersion", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return doSinkRequest(client, req.WithContext(ctx), nil)
+	}
+
+	err = addVersion()
+	if e, ok := err.(*sinkError); ok && e.StatusCode == http.StatusNotFound {
+		createURL := fmt.Sprintf("%s/v1/projects/%s/secrets?secretId=%s", endpoint, url.PathEscape(c.Project), url.QueryEscape(name))
+		req, err := http.NewRequest(http.MethodPost, createURL, strings.NewReader(`{"replication":{"automatic":{}}}`))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := doSinkRequest(client, req.WithContext(ctx), nil); err != nil {
+			return err
+		}
+		return addVersion()
+	}
+	return err
+}
+
+func (c *secretSinkConfig) putAzureSecret(ctx context.Context, name, value string) error {
+	authority := c.Endpoint
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+	tokenConfig := &clientcredentials.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		TokenURL:     fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authority, "/"), url.PathEscape(c.TenantID)),
+		Scopes:       []string{"https://vault.azure.net/.default"},
+	}
+	client := tokenConfig.Client(ctx)
+
+	body, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		return err
+	}
+	// Setting a secret creates it, or adds a new version if it exists
+	secretURL := fmt.Sprintf("%s/secrets/%s?api-version=7.0", strings.TrimSuffix(c.VaultURL, "/"), url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodPut, secretURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doSinkRequest(client, req.WithContext(ctx), nil)
+}
+
+// syncStaticAccount writes the credentials of a static account to its secret
+// sink, if it has one.
+func (b *databaseBackend) syncStaticAccount(ctx context.Context, s logical.Storage, account *staticAccount) error {
+	if account.SyncSink == "" {
+		return nil
+	}
+
+	sink, err := b.secretSink(ctx, s, account.SyncSink)
+	if err != nil {
+		return err
+	}
+	if sink == nil {
+		return fmt.Errorf("secret sink %q does not exist", account.SyncSink)
+	}
+
+	value, err := json.Marshal(map[string]string{
+		"username": account.Username,
+		"password": account.Password,
+	})
+	if err != nil {
+		return err
+	}
+	return sink.putSecret(ctx, account.SyncSecretName, string(value))
+}
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSecretSink_awsCredentials(t *testing.T) {
+	for k, v := range map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDENV",
+		"AWS_SECRET_ACCESS_KEY": "env-secret",
+		"AWS_SESSION_TOKEN":     "env-token",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	config := &secretSinkConfig{Type: sinkTypeAWSSecretsManager, Region: "eu-west-1"}
+	creds, err := config.awsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "AKIDENV" || value.SecretAccessKey != "env-secret" || value.SessionToken != "env-token" {
+		t.Fatalf("expected the credentials of the environment, got %#v", value)
+	}
+
+	config.AccessKey, config.SecretKey, config.SessionToken = "AKID", "secret", "token"
+	if creds, err = config.awsCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err = creds.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "AKID" || value.SecretAccessKey != "secret" || value.SessionToken != "token" {
+		t.Fatalf("expected the configured credentials, got %#v", value)
+	}
+}
+
+func TestSecretSink_gcpSecretManager(t *testing.T) {
+	var added []string
+	created := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"gcp-token","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer gcp-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1/projects/my-project/secrets" && r.URL.Query().Get("secretId") == "app-db":
+			created = true
+		case r.URL.Path == "/v1/projects/my-project/secrets/app-db:addVersion":
+			if !created {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var body struct {
+				Payload struct {
+					Data string `json:"data"`
+				} `json:"payload"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			data, _ := base64.StdEncoding.DecodeString(body.Payload.Data)
+			added = append(added, string(data))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "my-project",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"client_email":   "vault@my-project.iam.gserviceaccount.com",
+		"token_uri":      srv.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &secretSinkConfig{
+		Type:        sinkTypeGCPSecretManager,
+		Endpoint:    srv.URL,
+		Project:     "my-project",
+		Credentials: string(credentials),
+	}
+	if err := sink.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"first", "second"} {
+		if err := sink.putSecret(context.Background(), "app-db", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(added, ",") != "first,second" {
+		t.Fatalf("expected both versions to be added, got %v", added)
+	}
+}
+
+func TestSecretSink_azureKeyVault(t *testing.T) {
+	var secrets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/my-tenant/oauth2/v2.0/token":
+			r.ParseForm()
+			if r.Form.Get("scope") != "https://vault.azure.net/.default" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"azure-token","token_type":"Bearer","expires_in":3600}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/secrets/app-db" && r.Header.Get("Authorization") == "Bearer azure-token":
+			body, _ := ioutil.ReadAll(r.Body)
+			secrets = append(secrets, string(body))
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	sink := &secretSinkConfig{
+		Type:         sinkTypeAzureKeyVault,
+		Endpoint:     srv.URL,
+		VaultURL:     srv.URL,
+		TenantID:     "my-tenant",
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}
+	if err := sink.putSecret(context.Background(), "app-db", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || secrets[0] != `{"value":"value"}` {
+		t.Fatalf("expected the secret to be set, got %v", secrets)
+	}
+}
+
+func TestBackend_staticRoleSecretSink(t *testing.T) {
+	var puts []map[string]string
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		// The first write creates the secret
+		if r.Header.Get("X-Amz-Target") == "secretsmanager.PutSecretValue" && len(puts) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+			return
+		}
+		body["action"] = r.Header.Get("X-Amz-Target")
+		puts = append(puts, body)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	write := func(path string, op logical.Operation, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := write("sinks/aws", logical.CreateOperation, map[string]interface{}{
+		"type":       sinkTypeAWSSecretsManager,
+		"region":     "eu-west-1",
+		"endpoint":   srv.URL,
+		"access_key": "AKID",
+		"secret_key": "secret",
+	})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	resp = write("sinks/aws", logical.ReadOperation, nil)
+	if resp.Data["access_key"] != "AKID" || resp.Data["secret_key"] != nil {
+		t.Fatalf("expected the sink without its secret key, got %#v", resp.Data)
+	}
+
+	resp = write("config/plugin-test", logical.CreateOperation, map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeStaticDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	role := map[string]interface{}{
+		"db_name":         "plugin-test",
+		"username":        "app",
+		"rotation_period": 3600,
+		"sync_sink":       "missing",
+	}
+	resp = write("static-roles/app", logical.CreateOperation, role)
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a missing sink, got %#v", resp)
+	}
+	role["sync_sink"] = "aws"
+	role["sync_secret_name"] = "legacy/app-db"
+	resp = write("static-roles/app", logical.CreateOperation, role)
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+
+	if len(puts) != 1 || puts[0]["action"] != "secretsmanager.CreateSecret" || puts[0]["Name"] != "legacy/app-db" ||
+		puts[0]["SecretString"] != `{"password":"generated","username":"app"}` {
+		t.Fatalf("expected the credentials to be written to the sink, got %#v", puts)
+	}
+
+	resp = write("sinks/aws", logical.DeleteOperation, nil)
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected a sink in use not to be deleted, got %#v", resp)
+	}
+
+	// A failed write is recorded without failing the rotation
+	failing = true
+	resp = write("rotate-role/app", logical.UpdateOperation, nil)
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	resp = write("static-roles/app", logical.ReadOperation, nil)
+	if resp.Data["rotation_failures"] != 0 || !strings.Contains(resp.Data["last_sync_error"].(string), "500") {
+		t.Fatalf("expected the sync error on the role, got %#v", resp.Data)
+	}
+
+	failing = false
+	resp = write("rotate-role/app", logical.UpdateOperation, nil)
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	resp = write("static-roles/app", logical.ReadOperation, nil)
+	if _, ok := resp.Data["last_sync_error"]; ok || len(puts) != 2 || puts[1]["action"] != "secretsmanager.PutSecretValue" {
+		t.Fatalf("expected the next rotation to be written to the sink, got %#v %#v", resp.Data, puts)
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// requestPlaceholders are the template variables the backend replaces in the
+// creation statements of a role with the metadata of the request issuing the
+// credential, before they are passed to the plugin.
+var requestPlaceholders = []string{"mount", "role", "request_id", "request_ip"}
+
+// sessionTag identifies a connection's sessions to the database server, for
+// example "vault:database/my-postgres".
+func sessionTag(name string, config *DatabaseConfig) string {
+	return "vault:" + config.MountPoint + name
+}
+
+// applySessionTag names the sessions of a connection with sessionTag, so that
+// they can be traced back to Vault by the database's administrators. A name
+// already set in the connection URL is kept.
+func applySessionTag(name string, config *DatabaseConfig, details map[string]interface{}) error {
+	if !config.TagSessions {
+		return nil
+	}
+
+	connURL, _ := details["connection_url"].(string)
+	tag := sessionTag(name, config)
+
+	switch config.PluginName {
+	case "postgresql-database-plugin":
+		if strings.Contains(connURL, "application_name") {
+			return nil
+		}
+		if strings.HasPrefix(connURL, "postgres://") || strings.HasPrefix(connURL, "postgresql://") {
+			details["connection_url"] = setURLQuery(connURL, map[string]string{"application_name": tag})
+		} else {
+			details["connection_url"] = connURL + fmt.Sprintf(" application_name='%s'", strings.Replace(tag, "'", `\'`, -1))
+		}
+
+	case "mssql-database-plugin":
+		if strings.Contains(strings.ToLower(connURL), "app name") {
+			return nil
+		}
+		if strings.HasPrefix(connURL, "sqlserver://") {
+			details["connection_url"] = setURLQuery(connURL, map[string]string{"app name": tag})
+		} else {
+			details["connection_url"] = strings.TrimSuffix(connURL, ";") + ";app name=" + strings.Replace(tag, ";", "", -1)
+		}
+
+	default:
+		return fmt.Errorf("%s does not support tag_sessions; reference {{mount}}, {{role}} or {{request_id}} in creation_statements instead", config.PluginName)
+	}
+
+	return nil
+}
+
+// requestMetadataStatements replaces the requestPlaceholders in the creation
+// statements of a role with the metadata of req, which is issuing a
+// credential for the role called name. If the creation or revocation
+// statements reference {{request_ip}}, the address is returned too, to be
+// kept with the lease for its revocation.
+func (b *databaseBackend) requestMetadataStatements(role *roleEntry, req *logical.Request, name string) ([]string, string, error) {
+	metadata := map[string]string{
+		"mount":      req.MountPoint,
+		"role":       name,
+		"request_id": req.ID,
+	}
+
+	var ip string
+	if b.statementTemplates.references(role.Statements.Creation, "request_ip") || b.statementTemplates.references(role.Statements.Revocation, "request_ip") {
+		var err error
+		if ip, err = requestIP(req); err != nil {
+			return nil, "", err
+		}
+		metadata["request_ip"] = ip
+	}
+
+	return b.statementTemplates.render(role.Statements.Creation, metadata), ip, nil
+}
+
+// withRequestIP replaces {{request_ip}} in the revocation statements with the
+// address kept in the lease of a user bound to it.
+func withRequestIP(statements dbplugin.Statements, requestIP string) dbplugin.Statements {
+	if requestIP == "" {
+		return statements
+	}
+	revocation := make([]string, 0, len(statements.Revocation))
+	for _, stmt := range statements.Revocation {
+		revocation = append(revocation, dbutil.QueryHelper(stmt, map[string]string{"request_ip": requestIP}))
+	}
+	statements.Revocation = revocation
+	return statements
+}
+
+// requestIP returns the address of the client that sent req, as seen by Vault,
+// which honors X-Forwarded-For only from the listener's trusted proxies. The
+// statements of roles that bind users to it can't be run without it, as the
+// user would otherwise be bound to the wrong host or to any host.
+func requestIP(req *logical.Request) (string, error) {
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		return "", errors.New("the role's statements reference {{request_ip}}, but the address of the client is unknown")
+	}
+	ip := net.ParseIP(req.Connection.RemoteAddr)
+	if ip == nil {
+		return "", fmt.Errorf("the role's statements reference {{request_ip}}, but the address of the client %q is not an IP address", req.Connection.RemoteAddr)
+	}
+	return ip.String(), nil
+}
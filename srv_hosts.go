@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultSRVRefreshInterval is how often SRV records are resolved again
+// unless srv_refresh_interval is set.
+const defaultSRVRefreshInterval = 5 * time.Minute
+
+// hasSRVHosts reports whether the hosts of a Cassandra connection include
+// SRV service names.
+func hasSRVHosts(config *DatabaseConfig) bool {
+	if config.PluginName != "cassandra-database-plugin" {
+		return false
+	}
+	hosts, _ := config.ConnectionDetails["hosts"].(string)
+	for _, host := range strings.Split(hosts, ",") {
+		if isSRVName(strings.TrimSpace(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvedSRVHosts returns the hosts that pluginConnectionDetails resolved
+// the SRV records of a connection to, or an empty string if it has none.
+func resolvedSRVHosts(config *DatabaseConfig, details map[string]interface{}) string {
+	if !hasSRVHosts(config) {
+		return ""
+	}
+	hosts, _ := details["hosts"].(string)
+	return hosts
+}
+
+func (config *DatabaseConfig) srvRefreshInterval() time.Duration {
+	if config.SRVRefreshInterval > 0 {
+		return config.SRVRefreshInterval
+	}
+	return defaultSRVRefreshInterval
+}
+
+// refreshSRVHosts resolves the SRV records of the open connections that are
+// due to be refreshed, closing those whose contact points have changed so
+// that the next request reconnects to the new ones. Connections are local to
+// each node, so every node refreshes its own.
+func (b *databaseBackend) refreshSRVHosts(ctx context.Context, req *logical.Request) error {
+	now := b.clock.Now()
+
+	type resolved struct {
+		db *dbPluginInstance
+		at time.Time
+	}
+	b.RLock()
+	var connections []resolved
+	for _, db := range b.connections {
+		if db.srvHosts != "" {
+			connections = append(connections, resolved{db: db, at: db.srvResolvedAt})
+		}
+	}
+	b.RUnlock()
+
+	for _, c := range connections {
+		db := c.db
+		config, err := b.DatabaseConfig(ctx, req.Storage, db.name)
+		if err != nil {
+			return err
+		}
+		if !hasSRVHosts(config) || now.Sub(c.at) < config.srvRefreshInterval() {
+			continue
+		}
+
+		details := map[string]interface{}{"hosts": config.ConnectionDetails["hosts"]}
+		if err := normalizeCassandraHosts(details); err != nil {
+			// Keep the contact points the connection already has
+			b.Logger().Error("failed to resolve the SRV records of the connection", "connection", db.name, "error", err)
+			continue
+		}
+
+		var old *dbPluginInstance
+		b.Lock()
+		if current, ok := b.connections[db.name]; ok && current.id == db.id {
+			if hosts := details["hosts"].(string); hosts != db.srvHosts {
+				b.Logger().Info("the SRV records of the connection changed, reconnecting", "connection", db.name, "previous", db.srvHosts, "hosts", hosts)
+				old = b.clearConnection(db.name)
+			} else {
+				db.srvResolvedAt = now
+			}
+		}
+		b.Unlock()
+		if old != nil {
+			old.Close()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_refreshSRVHosts(t *testing.T) {
+	var l sync.Mutex
+	records := []*net.SRV{{Target: "cassandra-0.example.com.", Port: 9042}}
+	defer func(previous func(string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = previous
+	}(lookupSRV)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		l.Lock()
+		defer l.Unlock()
+		return name, records, nil
+	}
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, "config/cassandra", map[string]interface{}{
+		"plugin_name":          "cassandra-database-plugin",
+		"hosts":                "_cql._tcp.cassandra.example.com",
+		"username":             "cassandra",
+		"password":             "cassandra",
+		"srv_refresh_interval": "10m",
+		"verify_connection":    false,
+	})
+	resp := request(logical.ReadOperation, "config/cassandra", nil)
+	if resp.Data["resolved_hosts"] != "cassandra-0.example.com:9042" || resp.Data["srv_refresh_interval"] != int64(600) {
+		t.Fatalf("unexpected connection %#v", resp.Data)
+	}
+	if hosts := resp.Data["connection_details"].(map[string]interface{})["hosts"]; hosts != "_cql._tcp.cassandra.example.com" {
+		t.Fatalf("expected the SRV name to be stored, got %q", hosts)
+	}
+
+	connection := func() *dbPluginInstance {
+		b.RLock()
+		defer b.RUnlock()
+		return b.connections["cassandra"]
+	}
+	refresh := func() {
+		t.Helper()
+		if err := b.refreshSRVHosts(context.Background(), &logical.Request{Storage: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The records are only resolved again once the interval has passed
+	db := connection()
+	l.Lock()
+	records = []*net.SRV{{Target: "cassandra-1.example.com.", Port: 9042}}
+	l.Unlock()
+	clock.advance(5 * time.Minute)
+	refresh()
+	if connection() != db {
+		t.Fatal("expected the connection to be kept before the refresh interval")
+	}
+
+	clock.advance(5 * time.Minute)
+	refresh()
+	if connection() != nil {
+		t.Fatal("expected the connection to be closed once its contact points changed")
+	}
+
+	// Unchanged records keep the connection
+	request(logical.CreateOperation, "config/cassandra", map[string]interface{}{
+		"plugin_name":       "cassandra-database-plugin",
+		"hosts":             "_cql._tcp.cassandra.example.com",
+		"username":          "cassandra",
+		"password":          "cassandra",
+		"verify_connection": false,
+	})
+	db = connection()
+	if db.srvHosts != "cassandra-1.example.com:9042" {
+		t.Fatalf("expected the new contact points, got %q", db.srvHosts)
+	}
+	clock.advance(10 * time.Minute)
+	refresh()
+	if connection() != db || !db.srvResolvedAt.Equal(clock.Now()) {
+		t.Fatal("expected the connection to be kept with unchanged contact points")
+	}
+}
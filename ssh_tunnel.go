@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshDialTimeout bounds how long connecting to an SSH bastion may take.
+const sshDialTimeout = 30 * time.Second
+
+// sshTunnelConfig configures an SSH bastion that connections to the database
+// are forwarded through.
+type sshTunnelConfig struct {
+	// Host is the address of the bastion, with the port defaulting to 22.
+	Host     string `json:"host"`
+	Username string `json:"username"`
+
+	// PrivateKey authenticates to the bastion, along with Certificate if it
+	// is set, for example one signed by Vault's SSH secrets engine.
+	PrivateKey  string `json:"private_key"`
+	Certificate string `json:"certificate"`
+
+	// HostKey is the public key the bastion must present, in authorized_keys
+	// format.
+	HostKey string `json:"host_key"`
+}
+
+// address returns the address to dial the bastion on.
+func (c sshTunnelConfig) address() string {
+	if _, _, err := net.SplitHostPort(c.Host); err == nil {
+		return c.Host
+	}
+	return net.JoinHostPort(c.Host, "22")
+}
+
+// clientConfig validates the tunnel configuration and returns the SSH client
+// configuration for the bastion.
+func (c sshTunnelConfig) clientConfig() (*ssh.ClientConfig, error) {
+	if c.Username == "" {
+		return nil, errors.New("ssh_username must be set to use ssh_host")
+	}
+	if c.PrivateKey == "" {
+		return nil, errors.New("ssh_private_key must be set to use ssh_host")
+	}
+	if c.HostKey == "" {
+		return nil, errors.New("ssh_host_key must be set to use ssh_host")
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(c.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh_private_key: %s", err)
+	}
+	if c.Certificate != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.Certificate))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh_certificate: %s", err)
+		}
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			return nil, errors.New("ssh_certificate is a public key rather than a certificate")
+		}
+		signer, err = ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return nil, fmt.Errorf("ssh_certificate does not match ssh_private_key: %s", err)
+		}
+	}
+
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh_host_key: %s", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// sshDialer dials the database through an SSH bastion. The connection to the
+// bastion is opened on first use, and reopened if it is lost.
+type sshDialer struct {
+	address string
+	config  *ssh.ClientConfig
+
+	l      sync.Mutex
+	client *ssh.Client
+	closed bool
+}
+
+func newSSHDialer(config sshTunnelConfig) (*sshDialer, error) {
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &sshDialer{
+		address: config.address(),
+		config:  clientConfig,
+	}, nil
+}
+
+func (d *sshDialer) Dial(network, address string) (net.Conn, error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	if d.closed {
+		return nil, errors.New("the SSH tunnel is closed")
+	}
+
+	if d.client != nil {
+		conn, err := d.client.Dial(network, address)
+		if err == nil {
+			return conn, nil
+		}
+		// The connection to the bastion may have been lost, so reconnect
+		// and try again.
+		d.client.Close()
+		d.client = nil
+	}
+
+	client, err := ssh.Dial("tcp", d.address, d.config)
+	if err != nil {
+		return nil, err
+	}
+	d.client = client
+
+	return client.Dial(network, address)
+}
+
+// Close closes the connection to the bastion. Forwarded connections which are
+// still open are closed along with it.
+func (d *sshDialer) Close() error {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.closed = true
+	if d.client != nil {
+		err := d.client.Close()
+		d.client = nil
+		return err
+	}
+	return nil
+}
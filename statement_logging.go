@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+const redactedValue = "[redacted]"
+
+// sensitivePlaceholders are the template variables whose values are never
+// logged, as they hold live credentials.
+var sensitivePlaceholders = []string{passwordPlaceholder}
+
+// statementExpirationFormat is the format the SQL plugins use to replace the
+// {{expiration}} template variable.
+const statementExpirationFormat = "2006-01-02 15:04:05-0700"
+
+// statementLogger wraps a connection's plugin instance to log the statements
+// it executes at debug level. Sensitive template variables, and the password
+// itself wherever it appears, are replaced with a placeholder, so that
+// troubleshooting doesn't leak live credentials into the logs.
+type statementLogger struct {
+	dbplugin.Database
+
+	logger  log.Logger
+	dialect statementDialect
+}
+
+func newStatementLogger(db dbplugin.Database, logger log.Logger, pluginName string) *statementLogger {
+	dialect, ok := statementDialects[pluginName]
+	if !ok {
+		dialect = sqlDialect
+	}
+	return &statementLogger{
+		Database: db,
+		logger:   logger.Named("statements"),
+		dialect:  dialect,
+	}
+}
+
+func (s *statementLogger) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	username, password, err := s.Database.CreateUser(ctx, statements, usernameConfig, expiration)
+	s.log("create user", statements.Creation, username, password, expiration, err)
+	return username, password, err
+}
+
+func (s *statementLogger) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	err := s.Database.RenewUser(ctx, statements, username, expiration)
+	s.log("renew user", statements.Renewal, username, "", expiration, err)
+	return err
+}
+
+func (s *statementLogger) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	err := s.Database.RevokeUser(ctx, statements, username)
+	s.log("revoke user", statements.Revocation, username, "", time.Time{}, err)
+	return err
+}
+
+func (s *statementLogger) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticConfig dbplugin.StaticUserConfig) (string, string, error) {
+	username, password, err := s.Database.SetCredentials(ctx, statements, staticConfig)
+	s.log("set credentials", statements.Rotation, staticConfig.Username, staticConfig.Password, time.Time{}, err)
+	return username, password, err
+}
+
+func (s *statementLogger) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	config, err := s.Database.RotateRootCredentials(ctx, statements)
+	var username, password string
+	if config != nil {
+		username, _ = config["username"].(string)
+		password, _ = config["password"].(string)
+	}
+	s.log("rotate root credentials", statements, username, password, time.Time{}, err)
+	return config, err
+}
+
+func (s *statementLogger) log(operation string, statements []string, username, password string, expiration time.Time, err error) {
+	if len(statements) == 0 || !s.logger.IsDebug() {
+		return
+	}
+
+	rendered := make([]string, 0, len(statements))
+	for _, stmt := range statements {
+		rendered = append(rendered, s.render(stmt, username, password, expiration))
+	}
+
+	if err != nil {
+		s.logger.Debug("executed statements", "operation", operation, "statements", rendered, "error", err)
+		return
+	}
+	s.logger.Debug("executed statements", "operation", operation, "statements", rendered)
+}
+
+// render fills in the template variables of a statement as the plugin would,
+// redacting sensitive ones. Variables whose value isn't known, such as the
+// username of a failed creation, are left as they are.
+func (s *statementLogger) render(stmt, username, password string, expiration time.Time) string {
+	values := map[string]string{}
+	if username != "" {
+		values[s.dialect.usernamePlaceholder] = username
+		values["username"] = username
+	}
+	if !expiration.IsZero() {
+		values["expiration"] = expiration.Format(statementExpirationFormat)
+	}
+
+	stmt = placeholderRegex.ReplaceAllStringFunc(stmt, func(match string) string {
+		name := placeholderRegex.FindStringSubmatch(match)[1]
+		if strutil.StrListContains(sensitivePlaceholders, name) {
+			return redactedValue
+		}
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+
+	if password != "" {
+		stmt = strings.Replace(stmt, password, redactedValue, -1)
+	}
+	return stmt
+}
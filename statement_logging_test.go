@@ -0,0 +1,63 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+func TestStatementLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&log.LoggerOptions{
+		Output: &buf,
+		Level:  log.Debug,
+	})
+	db := newStatementLogger(&fakeIssuingDatabase{}, logger, "postgresql-database-plugin")
+
+	expiration := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, _, err := db.CreateUser(context.Background(), dbplugin.Statements{
+		Creation: []string{
+			`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';`,
+			`COMMENT ON ROLE "{{name}}" IS 'password';`,
+		},
+	}, dbplugin.UsernameConfig{}, expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "'password'") || strings.Count(out, redactedValue) != 2 {
+		t.Fatalf("expected the password to be redacted, got: %s", out)
+	}
+	if !strings.Contains(out, `CREATE ROLE "user-1"`) || !strings.Contains(out, "2020-01-01 00:00:00+0000") {
+		t.Fatalf("expected the other template variables to be rendered, got: %s", out)
+	}
+
+	buf.Reset()
+	_, _, err = db.SetCredentials(context.Background(), dbplugin.Statements{
+		Rotation: []string{`ALTER USER "{{name}}" WITH PASSWORD '{{password}}';`},
+	}, dbplugin.StaticUserConfig{Username: "app", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); strings.Contains(out, "secret") || !strings.Contains(out, `ALTER USER "app"`) {
+		t.Fatalf("expected the password to be redacted, got: %s", out)
+	}
+
+	// Statements are only logged at debug level
+	buf.Reset()
+	logger.SetLevel(log.Info)
+	if err := db.RevokeUser(context.Background(), dbplugin.Statements{
+		Revocation: []string{`DROP ROLE "{{name}}";`},
+	}, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+var (
+	postgresPlugins = []string{"postgresql-database-plugin"}
+	mysqlPlugins    = []string{"mysql-database-plugin", "mysql-aurora-database-plugin", "mysql-rds-database-plugin", "mysql-legacy-database-plugin", "mariadb-database-plugin"}
+)
+
+// statementPreset is a vetted set of statements that a role can select with
+// "preset" instead of writing its own.
+type statementPreset struct {
+	// plugins are the plugins the statements are written for.
+	plugins []string
+
+	creation   []string
+	revocation []string
+}
+
+// statementPresets are the presets roles may select, by name. Presets are
+// expanded into the role's statements when it is written, so changing a
+// preset only affects roles written afterwards.
+var statementPresets = map[string]statementPreset{
+	"postgres-readonly": {
+		plugins: postgresPlugins,
+		creation: []string{
+			`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';`,
+			`GRANT USAGE ON SCHEMA public TO "{{name}}";`,
+			`GRANT SELECT ON ALL TABLES IN SCHEMA public TO "{{name}}";`,
+		},
+		revocation: []string{
+			`REVOKE ALL PRIVILEGES ON ALL TABLES IN SCHEMA public FROM "{{name}}";`,
+			`REVOKE USAGE ON SCHEMA public FROM "{{name}}";`,
+			`DROP ROLE IF EXISTS "{{name}}";`,
+		},
+	},
+	"postgres-readwrite": {
+		plugins: postgresPlugins,
+		creation: []string{
+			`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';`,
+			`GRANT USAGE ON SCHEMA public TO "{{name}}";`,
+			`GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO "{{name}}";`,
+			`GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO "{{name}}";`,
+		},
+		revocation: []string{
+			`REVOKE ALL PRIVILEGES ON ALL TABLES IN SCHEMA public FROM "{{name}}";`,
+			`REVOKE ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public FROM "{{name}}";`,
+			`REVOKE USAGE ON SCHEMA public FROM "{{name}}";`,
+			`DROP ROLE IF EXISTS "{{name}}";`,
+		},
+	},
+	"mysql-readonly": {
+		plugins: mysqlPlugins,
+		creation: []string{
+			`CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';`,
+			`GRANT SELECT ON *.* TO '{{name}}'@'%';`,
+		},
+		revocation: []string{
+			`REVOKE ALL PRIVILEGES, GRANT OPTION FROM '{{name}}'@'%';`,
+			`DROP USER '{{name}}'@'%';`,
+		},
+	},
+	"mysql-readwrite": {
+		plugins: mysqlPlugins,
+		creation: []string{
+			`CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';`,
+			`GRANT SELECT, INSERT, UPDATE, DELETE ON *.* TO '{{name}}'@'%';`,
+		},
+		revocation: []string{
+			`REVOKE ALL PRIVILEGES, GRANT OPTION FROM '{{name}}'@'%';`,
+			`DROP USER '{{name}}'@'%';`,
+		},
+	},
+}
+
+// statementPresetNames returns the names of the presets in order.
+func statementPresetNames() []string {
+	names := make([]string, 0, len(statementPresets))
+	for name := range statementPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func presetAllowedValues() []interface{} {
+	names := statementPresetNames()
+	values := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		values = append(values, name)
+	}
+	return values
+}
+
+// lookupStatementPreset returns the preset called name, with an error listing
+// the available presets if there is none.
+func lookupStatementPreset(name string) (statementPreset, error) {
+	preset, ok := statementPresets[name]
+	if !ok {
+		return statementPreset{}, fmt.Errorf("unknown preset %q; available presets are %s", name, strings.Join(statementPresetNames(), ", "))
+	}
+	return preset, nil
+}
+
+// validatePresetPlugin checks that the preset called name was written for the
+// given plugin.
+func validatePresetPlugin(name, pluginName string) error {
+	preset, err := lookupStatementPreset(name)
+	if err != nil {
+		return err
+	}
+	if !strutil.StrListContains(preset.plugins, pluginName) {
+		return fmt.Errorf("preset %q cannot be used with %s; it is written for %s", name, pluginName, strings.Join(preset.plugins, ", "))
+	}
+	return nil
+}
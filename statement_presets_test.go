@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestStatementPresets_valid(t *testing.T) {
+	for name, preset := range statementPresets {
+		for _, pluginName := range preset.plugins {
+			err := validateStatements(pluginName, dbplugin.Statements{
+				Creation:   preset.creation,
+				Revocation: preset.revocation,
+			})
+			if err != nil {
+				t.Fatalf("preset %s is not valid for %s: %s", name, pluginName, err)
+			}
+		}
+	}
+}
+
+func TestBackend_rolePreset(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+
+	resp, err := request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/plugin-test",
+		Data: map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     []string{"*"},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	for preset, errors := range map[string]bool{
+		"unknown":        true,
+		"mysql-readonly": true,
+	} {
+		resp, err = request(&logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/app",
+			Data:      map[string]interface{}{"db_name": "plugin-test", "preset": preset},
+		})
+		if err != nil || resp == nil || resp.IsError() != errors {
+			t.Fatalf("preset %s: err:%s resp:%#v", preset, err, resp)
+		}
+	}
+
+	resp, err = request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data: map[string]interface{}{
+			"db_name":             "plugin-test",
+			"preset":              "postgres-readonly",
+			"creation_statements": testRole,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error combining a preset with statements, got err:%s resp:%#v", err, resp)
+	}
+
+	resp, err = request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data:      map[string]interface{}{"db_name": "plugin-test", "preset": "postgres-readonly"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "roles/app"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	expected := statementPresets["postgres-readonly"]
+	if resp.Data["preset"] != "postgres-readonly" ||
+		!reflect.DeepEqual(resp.Data["creation_statements"], expected.creation) ||
+		!reflect.DeepEqual(resp.Data["revocation_statements"], expected.revocation) {
+		t.Fatalf("expected the preset to be expanded, got %#v", resp.Data)
+	}
+
+	// Writing the statements replaces the preset
+	resp, err = request(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/app",
+		Data:      map[string]interface{}{"creation_statements": testRole},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "roles/app"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	if _, ok := resp.Data["preset"]; ok {
+		t.Fatalf("expected the preset to be cleared, got %#v", resp.Data)
+	}
+	if !reflect.DeepEqual(resp.Data["revocation_statements"], expected.revocation) {
+		t.Fatalf("expected the preset's revocation statements to be kept, got %#v", resp.Data)
+	}
+}
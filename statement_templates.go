@@ -0,0 +1,132 @@
+package database
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxStatementTemplates bounds the parsed statements kept in memory. The
+// cache is emptied when it is full, as well as whenever a role is written.
+const maxStatementTemplates = 4096
+
+// statementSegment is a literal part of a statement, or a placeholder.
+type statementSegment struct {
+	text        string
+	placeholder bool
+}
+
+// statementTemplate is a statement split into its literal text and its
+// {{placeholders}}, so that the placeholders of a role's statements are
+// found once rather than on every credential request.
+type statementTemplate struct {
+	segments     []statementSegment
+	placeholders map[string]bool
+}
+
+func parseStatementTemplate(stmt string) *statementTemplate {
+	t := &statementTemplate{placeholders: make(map[string]bool)}
+	literal := func(text string) {
+		if text == "" {
+			return
+		}
+		if n := len(t.segments); n > 0 && !t.segments[n-1].placeholder {
+			t.segments[n-1].text += text
+			return
+		}
+		t.segments = append(t.segments, statementSegment{text: text})
+	}
+
+	rest := stmt
+	for rest != "" {
+		end := strings.Index(rest, "}}")
+		if end == -1 {
+			literal(rest)
+			break
+		}
+		open := strings.LastIndex(rest[:end], "{{")
+		if open == -1 {
+			literal(rest[:end+2])
+			rest = rest[end+2:]
+			continue
+		}
+		literal(rest[:open])
+		name := rest[open+2 : end]
+		t.segments = append(t.segments, statementSegment{text: name, placeholder: true})
+		t.placeholders[name] = true
+		rest = rest[end+2:]
+	}
+	return t
+}
+
+// render fills in the placeholders that have values, leaving the others for
+// the plugin, as dbutil.QueryHelper does. Values are not searched for
+// placeholders themselves.
+func (t *statementTemplate) render(values map[string]string) string {
+	var b strings.Builder
+	for _, segment := range t.segments {
+		if !segment.placeholder {
+			b.WriteString(segment.text)
+			continue
+		}
+		if v, ok := values[segment.text]; ok {
+			b.WriteString(v)
+			continue
+		}
+		b.WriteString("{{" + segment.text + "}}")
+	}
+	return b.String()
+}
+
+// statementTemplateCache keeps the parsed statements of the roles used by
+// credential requests, keyed by the text of the statement. Only the
+// statements of roles are parsed into it, never statements that have had
+// credentials filled in.
+type statementTemplateCache struct {
+	l         sync.RWMutex
+	templates map[string]*statementTemplate
+}
+
+func (c *statementTemplateCache) get(stmt string) *statementTemplate {
+	c.l.RLock()
+	t, ok := c.templates[stmt]
+	c.l.RUnlock()
+	if ok {
+		return t
+	}
+
+	t = parseStatementTemplate(stmt)
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.templates == nil || len(c.templates) >= maxStatementTemplates {
+		c.templates = make(map[string]*statementTemplate)
+	}
+	c.templates[stmt] = t
+	return t
+}
+
+// render fills values into each of the statements.
+func (c *statementTemplateCache) render(statements []string, values map[string]string) []string {
+	rendered := make([]string, 0, len(statements))
+	for _, stmt := range statements {
+		rendered = append(rendered, c.get(stmt).render(values))
+	}
+	return rendered
+}
+
+// references returns whether any of the statements has the placeholder.
+func (c *statementTemplateCache) references(statements []string, placeholder string) bool {
+	for _, stmt := range statements {
+		if c.get(stmt).placeholders[placeholder] {
+			return true
+		}
+	}
+	return false
+}
+
+// purge removes every parsed statement from the cache.
+func (c *statementTemplateCache) purge() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.templates = nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestStatementTemplate(t *testing.T) {
+	values := map[string]string{"mount": "database/", "role": "app", "annotation": "ledger"}
+	for _, stmt := range []string{
+		"",
+		"SELECT 1;",
+		testK8SRole,
+		`CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}'; COMMENT ON ROLE "{{name}}" IS '{{mount}} {{role}}';`,
+		"{{ role }} {{role}}{{role}} {{{{role}} }}role}} {{",
+		"GRANT {{annotation}} TO {{}} {{missing}};",
+	} {
+		expected := dbutil.QueryHelper(stmt, values)
+		if rendered := parseStatementTemplate(stmt).render(values); rendered != expected {
+			t.Fatalf("expected %q to render as %q, got %q", stmt, expected, rendered)
+		}
+	}
+
+	tmpl := parseStatementTemplate(`GRANT "{{name}}" TO {{request_ip}};`)
+	if !tmpl.placeholders["request_ip"] || tmpl.placeholders["password"] {
+		t.Fatalf("unexpected placeholders %v", tmpl.placeholders)
+	}
+
+	// Values are filled in once, without filling in their own placeholders
+	if rendered := parseStatementTemplate("{{role}} {{mount}}").render(map[string]string{"role": "{{mount}}", "mount": "m"}); rendered != "{{mount}} m" {
+		t.Fatalf("unexpected rendering %q", rendered)
+	}
+}
+
+func TestBackend_statementTemplatesPurgedOnRoleWrite(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	fake := &recordingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	request(logical.CreateOperation, "roles/app", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" PASSWORD '{{password}}'; COMMENT ON ROLE "{{name}}" IS '{{role}}';`,
+	})
+
+	request(logical.ReadOperation, "creds/app", nil)
+	if len(b.statementTemplates.templates) != 1 || fake.creation[0] != `CREATE ROLE "{{name}}" PASSWORD '{{password}}'; COMMENT ON ROLE "{{name}}" IS 'app';` {
+		t.Fatalf("expected the statement to be parsed and cached, got %q", fake.creation)
+	}
+
+	request(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"creation_statements": `CREATE ROLE "{{name}}" PASSWORD '{{password}}'; COMMENT ON ROLE "{{name}}" IS 'v2 {{role}}';`,
+	})
+	if len(b.statementTemplates.templates) != 0 {
+		t.Fatalf("expected the role write to purge the parsed statements, got %d", len(b.statementTemplates.templates))
+	}
+	request(logical.ReadOperation, "creds/app", nil)
+	if fake.creation[0] != `CREATE ROLE "{{name}}" PASSWORD '{{password}}'; COMMENT ON ROLE "{{name}}" IS 'v2 app';` {
+		t.Fatalf("expected the updated statement, got %q", fake.creation)
+	}
+}
@@ -0,0 +1,367 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// statementFormat describes how a database plugin expects the statements of a
+// role to be written.
+type statementFormat int
+
+const (
+	// statementFormatText is used by plugins that execute their statements
+	// verbatim as a query language, such as SQL or CQL.
+	statementFormatText statementFormat = iota
+
+	// statementFormatJSON is used by document databases, which expect each
+	// statement to be a JSON payload describing the user to create or revoke.
+	statementFormatJSON
+)
+
+// statementDialect describes the statements accepted by a database plugin.
+type statementDialect struct {
+	format statementFormat
+
+	// maxCreation and maxRevocation bound the number of statements the plugin
+	// will read. Zero means unbounded.
+	maxCreation   int
+	maxRevocation int
+
+	// example is included in error messages to show the expected shape of a
+	// statement.
+	example string
+
+	// validateJSON decodes a JSON statement, defaulting to
+	// validateJSONStatement.
+	validateJSON func(stmt string, requireRoles bool) error
+
+	// validateText checks a text creation statement for syntax that the
+	// database rejects. Text statements are otherwise passed through
+	// unchecked.
+	validateText func(stmt string) error
+
+	// usernamePlaceholder is the template variable the plugin replaces with
+	// the generated username. Text statements must reference it, along with
+	// passwordPlaceholder, when creating a user.
+	usernamePlaceholder string
+
+	// placeholders is the full set of template variables the plugin replaces
+	// in role statements.
+	placeholders []string
+
+	// rootRotation reports whether the plugin can rotate the root credential
+	// of a connection using root_rotation_statements.
+	rootRotation bool
+
+	// passwordless reports whether the plugin authenticates the users it
+	// creates without a password, so text creation statements need not
+	// reference {{password}}.
+	passwordless bool
+}
+
+const passwordPlaceholder = "password"
+
+// rootRotationPlaceholders are the template variables replaced in the
+// root_rotation_statements of a connection, regardless of the plugin's
+// role placeholders.
+var rootRotationPlaceholders = []string{"username", passwordPlaceholder}
+
+// annotationPlaceholder is interpolated into the creation statements of a
+// role by getKubernetesRoleEntry before they are passed to the plugin.
+const annotationPlaceholder = "annotation"
+
+var placeholderRegex = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// jsonStatement is the schema of a JSON statement understood by the document
+// database plugins.
+type jsonStatement struct {
+	DB    string `json:"db"`
+	Roles []struct {
+		Role string `json:"role"`
+		DB   string `json:"db"`
+	} `json:"roles"`
+}
+
+var (
+	sqlDialect = statementDialect{
+		format:              statementFormatText,
+		example:             `CREATE USER "{{name}}" WITH PASSWORD '{{password}}';`,
+		usernamePlaceholder: "name",
+		placeholders:        []string{"name", "password", "expiration"},
+		rootRotation:        true,
+	}
+
+	hanaDialect = statementDialect{
+		format:              statementFormatText,
+		example:             sqlDialect.example,
+		usernamePlaceholder: sqlDialect.usernamePlaceholder,
+		placeholders:        sqlDialect.placeholders,
+	}
+
+	cqlDialect = statementDialect{
+		format:              statementFormatText,
+		example:             `CREATE USER '{{username}}' WITH PASSWORD '{{password}}' NOSUPERUSER;`,
+		usernamePlaceholder: "username",
+		placeholders:        []string{"username", "password"},
+		rootRotation:        true,
+	}
+
+	mongoDBDialect = statementDialect{
+		format:        statementFormatJSON,
+		maxCreation:   1,
+		maxRevocation: 1,
+		example:       `{"db": "admin", "roles": [{"role": "readWrite"}]}`,
+	}
+
+	mongoDBAtlasDialect = statementDialect{
+		format:       statementFormatJSON,
+		maxCreation:  1,
+		example:      `{"roles": [{"role": "readWrite", "db": "app"}], "scopes": [{"name": "Cluster0", "type": "CLUSTER"}]}`,
+		validateJSON: validateAtlasStatement,
+	}
+
+	dynamoDBDialect = statementDialect{
+		format:       statementFormatJSON,
+		maxCreation:  1,
+		example:      `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": ["dynamodb:GetItem"], "Resource": "arn:aws:dynamodb:us-east-1:123456789012:table/app"}]}`,
+		placeholders: []string{"name"},
+		validateJSON: validateDynamoDBPolicy,
+	}
+
+	bigQueryDialect = statementDialect{
+		format:       statementFormatJSON,
+		maxCreation:  1,
+		example:      `{"datasets": [{"dataset": "analytics", "role": "READER"}]}`,
+		validateJSON: validateBigQueryStatement,
+	}
+
+	rabbitMQDialect = statementDialect{
+		format:       statementFormatJSON,
+		maxCreation:  1,
+		example:      `{"tags": "management", "vhosts": {"/": {"configure": "^{{name}}\\.", "write": "^{{name}}\\.", "read": ".*"}}}`,
+		placeholders: []string{"name"},
+		validateJSON: validateRabbitMQStatement,
+	}
+
+	vitessDialect = statementDialect{
+		format:              statementFormatText,
+		example:             `CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}'; GRANT SELECT ON commerce.* TO '{{name}}'@'%';`,
+		usernamePlaceholder: sqlDialect.usernamePlaceholder,
+		placeholders:        sqlDialect.placeholders,
+		rootRotation:        true,
+		validateText:        validateVitessStatement,
+	}
+
+	trinoDialect = statementDialect{
+		format:              statementFormatText,
+		example:             `GRANT SELECT ON SCHEMA hive.sales TO USER "{{name}}";`,
+		usernamePlaceholder: "name",
+		placeholders:        []string{"name"},
+		passwordless:        true,
+	}
+)
+
+// statementDialects maps the builtin plugins in databasePlugins to the
+// statements they accept. Plugins without an entry are not validated.
+var statementDialects = map[string]statementDialect{
+	"mysql-database-plugin":        sqlDialect,
+	"mysql-aurora-database-plugin": sqlDialect,
+	"mysql-rds-database-plugin":    sqlDialect,
+	"mysql-legacy-database-plugin": sqlDialect,
+	"mariadb-database-plugin":      sqlDialect,
+	"vitess-database-plugin":       vitessDialect,
+	"postgresql-database-plugin":   sqlDialect,
+	"mssql-database-plugin":        sqlDialect,
+	"hana-database-plugin":         hanaDialect,
+	"cassandra-database-plugin":    cqlDialect,
+	"influxdb-database-plugin":     cqlDialect,
+	"mongodb-database-plugin":      mongoDBDialect,
+	"mongodbatlas-database-plugin": mongoDBAtlasDialect,
+	"dynamodb-database-plugin":     dynamoDBDialect,
+	"bigquery-database-plugin":     bigQueryDialect,
+	"rabbitmq-database-plugin":     rabbitMQDialect,
+	"trino-database-plugin":        trinoDialect,
+}
+
+// validateStatements checks the statements of a role against the dialect of
+// the given plugin, returning an error suitable for returning to the user if
+// the statements would be rejected by the plugin at issuance time.
+func validateStatements(pluginName string, statements dbplugin.Statements) error {
+	dialect, ok := statementDialects[pluginName]
+	if !ok {
+		return nil
+	}
+
+	if dialect.maxCreation > 0 && len(statements.Creation) > dialect.maxCreation {
+		return fmt.Errorf("%s accepts at most %d creation statement(s), got %d", pluginName, dialect.maxCreation, len(statements.Creation))
+	}
+	if dialect.maxRevocation > 0 && len(statements.Revocation) > dialect.maxRevocation {
+		return fmt.Errorf("%s accepts at most %d revocation statement(s), got %d", pluginName, dialect.maxRevocation, len(statements.Revocation))
+	}
+
+	if dialect.format == statementFormatJSON {
+		validateJSON := dialect.validateJSON
+		if validateJSON == nil {
+			validateJSON = validateJSONStatement
+		}
+		for i, stmt := range statements.Creation {
+			if err := validateJSON(stmt, true); err != nil {
+				return fmt.Errorf("creation_statements[%d] is not a valid %s statement: %s; expected JSON such as %s", i, pluginName, err, dialect.example)
+			}
+		}
+		for i, stmt := range statements.Revocation {
+			if err := validateJSON(stmt, false); err != nil {
+				return fmt.Errorf("revocation_statements[%d] is not a valid %s statement: %s; expected JSON such as %s", i, pluginName, err, dialect.example)
+			}
+		}
+	}
+	if dialect.validateText != nil {
+		for i, stmt := range statements.Creation {
+			if err := dialect.validateText(stmt); err != nil {
+				return fmt.Errorf("creation_statements[%d] is not supported by %s: %s", i, pluginName, err)
+			}
+		}
+	}
+
+	return dialect.validatePlaceholders(pluginName, statements)
+}
+
+// validateRootRotationStatements checks the root_rotation_statements of a
+// connection, which every plugin templates with the root username and the
+// newly generated password.
+func validateRootRotationStatements(pluginName string, statements []string) error {
+	dialect, ok := statementDialects[pluginName]
+	if !ok || len(statements) == 0 {
+		return nil
+	}
+
+	if !dialect.rootRotation {
+		return fmt.Errorf("%s does not support root credential rotation, so root_rotation_statements cannot be used", pluginName)
+	}
+
+	for i, stmt := range statements {
+		for _, match := range placeholderRegex.FindAllStringSubmatch(stmt, -1) {
+			if !strutil.StrListContains(rootRotationPlaceholders, match[1]) {
+				return fmt.Errorf("root_rotation_statements[%d] uses unsupported placeholder %q; root rotation supports %s", i, match[0], formatPlaceholders(rootRotationPlaceholders))
+			}
+			if match[0] != "{{"+match[1]+"}}" {
+				return fmt.Errorf("root_rotation_statements[%d] placeholder %q must not contain whitespace", i, match[0])
+			}
+		}
+	}
+
+	if !referencesPlaceholder(statements, passwordPlaceholder) {
+		return fmt.Errorf("root_rotation_statements must reference {{%s}}", passwordPlaceholder)
+	}
+
+	return nil
+}
+
+// validateJSONStatement decodes a single JSON statement, rejecting unknown
+// keys so that typos are caught before a credential is requested.
+func validateJSONStatement(stmt string, requireRoles bool) error {
+	dec := json.NewDecoder(bytes.NewReader([]byte(stmt)))
+	dec.DisallowUnknownFields()
+
+	var parsed jsonStatement
+	if err := dec.Decode(&parsed); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return fmt.Errorf("statement looks like a query rather than JSON (%s)", err)
+		}
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON object")
+	}
+
+	if requireRoles && len(parsed.Roles) == 0 {
+		return fmt.Errorf(`"roles" must contain at least one role`)
+	}
+	for i, role := range parsed.Roles {
+		if role.Role == "" {
+			return fmt.Errorf(`roles[%d] is missing "role"`, i)
+		}
+	}
+
+	return nil
+}
+
+// validatePlaceholders rejects statements using template variables that the
+// plugin will not replace, and text creation or rotation statements that do
+// not reference the generated credentials.
+func (d statementDialect) validatePlaceholders(pluginName string, statements dbplugin.Statements) error {
+	kinds := []struct {
+		field      string
+		statements []string
+		extra      []string
+	}{
+		{"creation_statements", statements.Creation, append(append([]string{annotationPlaceholder}, requestPlaceholders...), producerPlaceholders...)},
+		{"revocation_statements", statements.Revocation, []string{"request_ip"}},
+		{"rollback_statements", statements.Rollback, nil},
+		{"renew_statements", statements.Renewal, nil},
+		{"rotation_statements", statements.Rotation, nil},
+	}
+
+	for _, kind := range kinds {
+		allowed := append(append([]string{}, d.placeholders...), kind.extra...)
+		for i, stmt := range kind.statements {
+			for _, match := range placeholderRegex.FindAllStringSubmatch(stmt, -1) {
+				if !strutil.StrListContains(allowed, match[1]) {
+					return fmt.Errorf("%s[%d] uses unsupported placeholder %q; %s supports %s", kind.field, i, match[0], pluginName, formatPlaceholders(allowed))
+				}
+				if match[0] != "{{"+match[1]+"}}" {
+					return fmt.Errorf("%s[%d] placeholder %q must not contain whitespace", kind.field, i, match[0])
+				}
+			}
+		}
+	}
+
+	if d.format != statementFormatText {
+		return nil
+	}
+
+	if len(statements.Creation) > 0 {
+		if !referencesPlaceholder(statements.Creation, d.usernamePlaceholder) {
+			return fmt.Errorf("creation_statements must reference {{%s}}; for example %s", d.usernamePlaceholder, d.example)
+		}
+		// Users authenticating with the key pair of the rsa-key credentials
+		// producer need no password.
+		if !d.passwordless && !referencesPlaceholder(statements.Creation, passwordPlaceholder) && !referencesPlaceholder(statements.Creation, "public_key") {
+			return fmt.Errorf("creation_statements must reference {{%s}}; for example %s", passwordPlaceholder, d.example)
+		}
+	}
+	if len(statements.Rotation) > 0 && !referencesPlaceholder(statements.Rotation, passwordPlaceholder) {
+		return fmt.Errorf("rotation_statements must reference {{%s}}", passwordPlaceholder)
+	}
+
+	return nil
+}
+
+// referencesPlaceholder reports whether any of the statements contain the
+// given template variable.
+func referencesPlaceholder(statements []string, placeholder string) bool {
+	for _, stmt := range statements {
+		if strings.Contains(stmt, "{{"+placeholder+"}}") {
+			return true
+		}
+	}
+	return false
+}
+
+func formatPlaceholders(placeholders []string) string {
+	if len(placeholders) == 0 {
+		return "no placeholders"
+	}
+	formatted := make([]string, 0, len(placeholders))
+	for _, p := range placeholders {
+		formatted = append(formatted, "{{"+p+"}}")
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+func TestValidateStatements_JSON(t *testing.T) {
+	cases := map[string]struct {
+		statements  dbplugin.Statements
+		errContains string
+	}{
+		"valid": {
+			statements: dbplugin.Statements{
+				Creation:   []string{`{"db": "admin", "roles": [{"role": "readWrite"}, {"role": "read", "db": "{{annotation}}"}]}`},
+				Revocation: []string{`{"db": "admin"}`},
+			},
+		},
+		"sql statement": {
+			statements: dbplugin.Statements{
+				Creation: []string{`CREATE ROLE "{{name}}" WITH PASSWORD '{{password}}';`},
+			},
+			errContains: "looks like a query rather than JSON",
+		},
+		"unknown key": {
+			statements: dbplugin.Statements{
+				Creation: []string{`{"database": "admin", "roles": [{"role": "readWrite"}]}`},
+			},
+			errContains: `unknown field "database"`,
+		},
+		"missing roles": {
+			statements: dbplugin.Statements{
+				Creation: []string{`{"db": "admin"}`},
+			},
+			errContains: `"roles" must contain at least one role`,
+		},
+		"too many creation statements": {
+			statements: dbplugin.Statements{
+				Creation: []string{`{"roles": [{"role": "read"}]}`, `{"roles": [{"role": "read"}]}`},
+			},
+			errContains: "at most 1 creation statement(s)",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := validateStatements("mongodb-database-plugin", tc.statements)
+			switch {
+			case tc.errContains == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tc.errContains != "" && err == nil:
+				t.Fatalf("expected error containing %q", tc.errContains)
+			case tc.errContains != "" && !strings.Contains(err.Error(), tc.errContains):
+				t.Fatalf("expected error containing %q, got %q", tc.errContains, err)
+			}
+		})
+	}
+
+}
+
+func TestValidateStatements_Placeholders(t *testing.T) {
+	cases := map[string]struct {
+		pluginName  string
+		statements  dbplugin.Statements
+		errContains string
+	}{
+		"postgres valid": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation:   []string{testRole},
+				Revocation: []string{defaultRevocationSQL},
+			},
+		},
+		"postgres k8s annotation": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{testK8SRole},
+			},
+		},
+		"cassandra valid": {
+			pluginName: "cassandra-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{
+					`CREATE USER '{{username}}' WITH PASSWORD '{{password}}' NOSUPERUSER;`,
+					`GRANT ALL PERMISSIONS ON KEYSPACE "{{annotation}}" TO {{username}};`,
+				},
+			},
+		},
+		"postgres request metadata": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{
+					testRole,
+					`COMMENT ON ROLE "{{name}}" IS '{{mount}}{{role}} {{request_id}}';`,
+				},
+			},
+		},
+		"request metadata in revocation": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation:   []string{testRole},
+				Revocation: []string{`DROP ROLE "{{name}}"; -- {{request_id}}`},
+			},
+			errContains: `unsupported placeholder "{{request_id}}"`,
+		},
+		"cassandra uses name": {
+			pluginName: "cassandra-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{`CREATE USER '{{name}}' WITH PASSWORD '{{password}}' NOSUPERUSER;`},
+			},
+			errContains: `unsupported placeholder "{{name}}"`,
+		},
+		"missing password": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{`CREATE ROLE "{{name}}";`},
+			},
+			errContains: "must reference {{password}}",
+		},
+		"missing name": {
+			pluginName: "mysql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{`CREATE USER 'static' IDENTIFIED BY '{{password}}';`},
+			},
+			errContains: "must reference {{name}}",
+		},
+		"annotation in revocation": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Revocation: []string{`REVOKE ALL ON SCHEMA {{annotation}} FROM {{name}};`},
+			},
+			errContains: `revocation_statements[0] uses unsupported placeholder "{{annotation}}"`,
+		},
+		"whitespace": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{`CREATE ROLE "{{ name }}" WITH PASSWORD '{{password}}';`},
+			},
+			errContains: "must not contain whitespace",
+		},
+		"rotation missing password": {
+			pluginName: "postgresql-database-plugin",
+			statements: dbplugin.Statements{
+				Rotation: []string{`ALTER USER "{{name}}" VALID UNTIL 'infinity';`},
+			},
+			errContains: "rotation_statements must reference {{password}}",
+		},
+		"unknown plugin": {
+			pluginName: "custom-database-plugin",
+			statements: dbplugin.Statements{
+				Creation: []string{`{{anything}}`},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := validateStatements(tc.pluginName, tc.statements)
+			switch {
+			case tc.errContains == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tc.errContains != "" && err == nil:
+				t.Fatalf("expected error containing %q", tc.errContains)
+			case tc.errContains != "" && !strings.Contains(err.Error(), tc.errContains):
+				t.Fatalf("expected error containing %q, got %q", tc.errContains, err)
+			}
+		})
+	}
+}
+
+func TestValidateRootRotationStatements(t *testing.T) {
+	cases := map[string]struct {
+		pluginName  string
+		statements  []string
+		errContains string
+	}{
+		"postgres valid": {
+			pluginName: "postgresql-database-plugin",
+			statements: []string{`ALTER USER "{{username}}" WITH PASSWORD '{{password}}';`},
+		},
+		"cassandra valid": {
+			pluginName: "cassandra-database-plugin",
+			statements: []string{`ALTER USER '{{username}}' WITH PASSWORD '{{password}}';`},
+		},
+		"default statements": {
+			pluginName: "mongodb-database-plugin",
+		},
+		"role placeholder": {
+			pluginName:  "postgresql-database-plugin",
+			statements:  []string{`ALTER USER "{{name}}" WITH PASSWORD '{{password}}';`},
+			errContains: `unsupported placeholder "{{name}}"`,
+		},
+		"missing password": {
+			pluginName:  "mysql-database-plugin",
+			statements:  []string{`SET PASSWORD FOR '{{username}}' = 'static';`},
+			errContains: "must reference {{password}}",
+		},
+		"unsupported plugin": {
+			pluginName:  "hana-database-plugin",
+			statements:  []string{`ALTER USER {{username}} PASSWORD "{{password}}";`},
+			errContains: "does not support root credential rotation",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := validateRootRotationStatements(tc.pluginName, tc.statements)
+			switch {
+			case tc.errContains == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tc.errContains != "" && err == nil:
+				t.Fatalf("expected error containing %q", tc.errContains)
+			case tc.errContains != "" && !strings.Contains(err.Error(), tc.errContains):
+				t.Fatalf("expected error containing %q, got %q", tc.errContains, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// cachedStoragePrefixes are the storage prefixes of the entries read on every
+// credential request, which are kept in memory by the storageCache.
+var cachedStoragePrefixes = []string{
+	"config/",
+	databaseRolePath,
+	databaseStaticRolePath,
+}
+
+// storageCache keeps the storage entries of connections and roles in memory,
+// so that issuing credentials doesn't read them from storage every time.
+// Entries are removed when the backend writes or deletes them, and when Vault
+// invalidates them after they were written by another node. Only entries that
+// exist are cached, so the cache is no larger than storage.
+type storageCache struct {
+	l       sync.RWMutex
+	entries map[string]*logical.StorageEntry
+
+	// generation is incremented by every invalidation, so that an entry read
+	// from storage concurrently with a write isn't cached after the write
+	// invalidated it.
+	generation uint64
+}
+
+func isCachedStorageKey(key string) bool {
+	for _, prefix := range cachedStoragePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the storage entry at key, reading it from storage if it isn't
+// cached.
+func (c *storageCache) get(ctx context.Context, s logical.Storage, key string) (*logical.StorageEntry, error) {
+	if !isCachedStorageKey(key) {
+		return s.Get(ctx, key)
+	}
+
+	c.l.RLock()
+	entry, ok := c.entries[key]
+	generation := c.generation
+	c.l.RUnlock()
+	if ok {
+		return entry, nil
+	}
+
+	entry, err := s.Get(ctx, key)
+	if err != nil || entry == nil {
+		return entry, err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.generation == generation {
+		if c.entries == nil {
+			c.entries = make(map[string]*logical.StorageEntry)
+		}
+		c.entries[key] = entry
+	}
+	return entry, nil
+}
+
+// invalidate removes the entry at key from the cache.
+func (c *storageCache) invalidate(key string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.generation++
+	delete(c.entries, key)
+}
+
+// purge removes every entry from the cache.
+func (c *storageCache) purge() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.generation++
+	c.entries = nil
+}
+
+// isRoleStorageKey returns whether key is the entry of a role, whose
+// statements are parsed by the statementTemplateCache.
+func isRoleStorageKey(key string) bool {
+	return strings.HasPrefix(key, databaseRolePath) || strings.HasPrefix(key, databaseStaticRolePath)
+}
+
+// putEntry writes an entry to storage, removing it from the caches.
+func (b *databaseBackend) putEntry(ctx context.Context, s logical.Storage, entry *logical.StorageEntry) error {
+	defer b.invalidateEntry(entry.Key)
+	return s.Put(ctx, entry)
+}
+
+// deleteEntry deletes an entry from storage, removing it from the caches.
+func (b *databaseBackend) deleteEntry(ctx context.Context, s logical.Storage, key string) error {
+	defer b.invalidateEntry(key)
+	return s.Delete(ctx, key)
+}
+
+func (b *databaseBackend) invalidateEntry(key string) {
+	b.storageCache.invalidate(key)
+	if isRoleStorageKey(key) {
+		b.statementTemplates.purge()
+	}
+}
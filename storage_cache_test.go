@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// countingStorage counts the reads of each storage key.
+type countingStorage struct {
+	logical.Storage
+
+	l    sync.Mutex
+	gets map[string]int
+}
+
+func (c *countingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	c.l.Lock()
+	if c.gets == nil {
+		c.gets = make(map[string]int)
+	}
+	c.gets[key]++
+	c.l.Unlock()
+	return c.Storage.Get(ctx, key)
+}
+
+func (c *countingStorage) count(key string) int {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.gets[key]
+}
+
+func TestBackend_storageCache(t *testing.T) {
+	b, config := getBackend(t)
+	defer b.Cleanup(context.Background())
+	s := &countingStorage{Storage: config}
+
+	write := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	write(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+	})
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: &fakeIssuingDatabase{},
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	write(logical.CreateOperation, "roles/app", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+		"default_ttl":         60,
+	})
+
+	write(logical.ReadOperation, "creds/app", nil)
+	configReads, roleReads := s.count("config/plugin-test"), s.count("role/app")
+
+	resp := write(logical.ReadOperation, "creds/app", nil)
+	if s.count("config/plugin-test") != configReads || s.count("role/app") != roleReads {
+		t.Fatal("expected the connection and role to be read from the cache")
+	}
+	if resp.Secret.TTL.Seconds() != 60 {
+		t.Fatalf("expected a TTL of 60s, got %s", resp.Secret.TTL)
+	}
+
+	// Writing the role replaces the cached entry
+	write(logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"default_ttl": 120,
+	})
+	resp = write(logical.ReadOperation, "creds/app", nil)
+	if resp.Secret.TTL.Seconds() != 120 {
+		t.Fatalf("expected the updated role to be used, got a TTL of %s", resp.Secret.TTL)
+	}
+
+	// A write by another node is picked up once Vault invalidates the key
+	role, err := b.Role(context.Background(), s, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.DefaultTTL = 180 * time.Second
+	entry, err := logical.StorageEntryJSON("role/app", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = write(logical.ReadOperation, "creds/app", nil)
+	if resp.Secret.TTL.Seconds() != 120 {
+		t.Fatalf("expected the cached role to be used until it is invalidated, got a TTL of %s", resp.Secret.TTL)
+	}
+	b.Invalidate(context.Background(), "role/app")
+	resp = write(logical.ReadOperation, "creds/app", nil)
+	if resp.Secret.TTL.Seconds() != 180 {
+		t.Fatalf("expected the invalidated role to be read from storage, got a TTL of %s", resp.Secret.TTL)
+	}
+
+	// Deleting the role removes it from the cache
+	write(logical.DeleteOperation, "roles/app", nil)
+	if role, err := b.Role(context.Background(), s, "app"); err != nil || role != nil {
+		t.Fatalf("expected the deleted role not to be found, got err:%v role:%#v", err, role)
+	}
+}
@@ -0,0 +1,387 @@
+package database
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/mitchellh/mapstructure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	trinoTypeName = "trino"
+
+	// defaultTrinoIssuer is the issuer of the tokens signed by the
+	// trino-database-plugin unless jwt_issuer is set.
+	defaultTrinoIssuer = "vault"
+
+	// trinoRequestTimeout bounds each request to the coordinator.
+	trinoRequestTimeout = 30 * time.Second
+)
+
+// trinoGrantRegex matches the GRANT statements that defaultTrinoRevocation
+// can reverse, capturing what was granted and to whom.
+var trinoGrantRegex = regexp.MustCompile(`(?is)^\s*GRANT\s+(.+?)\s+TO\s+(.+?)(\s+WITH\s+(GRANT|ADMIN)\s+OPTION)?\s*$`)
+
+// trinoConfig is the connection configuration of the trino-database-plugin,
+// where connection_url is the address of the coordinator.
+type trinoConfig struct {
+	ConnectionURL string `mapstructure:"connection_url"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+	SigningKey    string `mapstructure:"jwt_signing_key"`
+	KeyID         string `mapstructure:"jwt_key_id"`
+	Issuer        string `mapstructure:"jwt_issuer"`
+	Audience      string `mapstructure:"jwt_audience"`
+}
+
+// trino issues credentials for a Trino or Presto cluster. Trino doesn't store
+// the passwords of its users, so each credential is a JSON Web Token for the
+// generated user, signed with a key that the coordinator trusts for JWT
+// authentication and expiring with the lease. The creation statements grant
+// the user access to catalogs and schemas, and are run by the user of the
+// connection through the REST API of the coordinator.
+type trino struct {
+	sync.RWMutex
+	credsutil.CredentialsProducer
+
+	config trinoConfig
+	signer jose.Signer
+	client *http.Client
+}
+
+func newTrino() (interface{}, error) {
+	return &trino{
+		CredentialsProducer: &credsutil.SQLCredentialsProducer{
+			DisplayNameLen: 20,
+			RoleNameLen:    20,
+			UsernameLen:    100,
+			Separator:      "_",
+		},
+		client: &http.Client{Timeout: trinoRequestTimeout},
+	}, nil
+}
+
+func (t *trino) Type() (string, error) {
+	return trinoTypeName, nil
+}
+
+func (t *trino) Init(ctx context.Context, conf map[string]interface{}, verifyConnection bool) (map[string]interface{}, error) {
+	var config trinoConfig
+	if err := mapstructure.WeakDecode(conf, &config); err != nil {
+		return nil, err
+	}
+	switch {
+	case config.ConnectionURL == "":
+		return nil, errors.New("connection_url cannot be empty")
+	case config.Username == "":
+		return nil, errors.New("username cannot be empty")
+	case config.SigningKey == "":
+		return nil, errors.New("jwt_signing_key cannot be empty")
+	}
+	if u, err := url.Parse(config.ConnectionURL); err != nil {
+		return nil, fmt.Errorf("invalid connection_url: %s", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("connection_url must be the http:// or https:// address of the coordinator")
+	}
+	if config.Issuer == "" {
+		config.Issuer = defaultTrinoIssuer
+	}
+
+	signer, err := newTrinoSigner(config)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Lock()
+	t.config, t.signer = config, signer
+	t.Unlock()
+
+	if verifyConnection {
+		if err := t.execute(ctx, "SELECT 1"); err != nil {
+			return nil, fmt.Errorf("error verifying connection: %s", err)
+		}
+	}
+
+	return conf, nil
+}
+
+func (t *trino) Initialize(ctx context.Context, conf map[string]interface{}, verifyConnection bool) error {
+	_, err := t.Init(ctx, conf, verifyConnection)
+	return err
+}
+
+// newTrinoSigner parses the PEM encoded RSA or ECDSA private key of the
+// connection.
+func newTrinoSigner(config trinoConfig) (jose.Signer, error) {
+	block, _ := pem.Decode([]byte(config.SigningKey))
+	if block == nil {
+		return nil, errors.New("jwt_signing_key must be a PEM encoded private key")
+	}
+
+	var key crypto.Signer
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("jwt_signing_key must be an RSA or ECDSA private key")
+		}
+		key = signer
+	} else if parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = parsed
+	} else if parsed, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		key = parsed
+	} else {
+		return nil, errors.New("jwt_signing_key must be an RSA or ECDSA private key")
+	}
+
+	var algorithm jose.SignatureAlgorithm
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			algorithm = jose.ES256
+		case elliptic.P384():
+			algorithm = jose.ES384
+		case elliptic.P521():
+			algorithm = jose.ES512
+		default:
+			return nil, errors.New("jwt_signing_key uses an unsupported elliptic curve")
+		}
+	default:
+		return nil, errors.New("jwt_signing_key must be an RSA or ECDSA private key")
+	}
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if config.KeyID != "" {
+		opts = opts.WithHeader("kid", config.KeyID)
+	}
+	return jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: key}, opts)
+}
+
+// CreateUser returns a token for the new user as the password.
+func (t *trino) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Creation) == 0 {
+		return "", "", dbutil.ErrEmptyCreationStatement
+	}
+
+	username, err := t.GenerateUsername(usernameConfig)
+	if err != nil {
+		return "", "", err
+	}
+
+	t.RLock()
+	config, signer := t.config, t.signer
+	t.RUnlock()
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Subject:   username,
+		Issuer:    config.Issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(expiration),
+	}
+	if config.Audience != "" {
+		claims.Audience = jwt.Audience{config.Audience}
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := t.executeStatements(ctx, statements.Creation, username, false); err != nil {
+		// Don't leave grants behind that no lease will revoke
+		if revokeErr := t.RevokeUser(ctx, statements, username); revokeErr != nil {
+			err = status.Errorf(status.Code(err), "%s; the grants of %s could not be revoked: %s", status.Convert(err).Message(), username, revokeErr)
+		}
+		return "", "", err
+	}
+	return username, token, nil
+}
+
+// RenewUser fails, as the token of a user can't be extended past the
+// expiration it was signed with.
+func (t *trino) RenewUser(ctx context.Context, statements dbplugin.Statements, username string, expiration time.Time) error {
+	return status.Error(codes.FailedPrecondition, "trino tokens cannot be renewed past their original expiration")
+}
+
+// RevokeUser runs the revocation statements, or otherwise reverses the GRANT
+// statements among the creation statements. Revoking only stops the user
+// from accessing what it was granted, and the token remains valid until it
+// expires. Statements on catalogs or schemas that no longer exist are
+// skipped, as there is nothing left to revoke.
+func (t *trino) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	revocation := statements.Revocation
+	if len(revocation) == 0 {
+		revocation = defaultTrinoRevocation(statements.Creation)
+	}
+	return t.executeStatements(ctx, revocation, username, true)
+}
+
+// defaultTrinoRevocation returns a REVOKE statement for each GRANT
+// statement of the creation statements, in reverse order.
+func defaultTrinoRevocation(creation []string) []string {
+	var grants []string
+	for _, stmt := range creation {
+		grants = append(grants, strutil.ParseArbitraryStringSlice(stmt, ";")...)
+	}
+
+	var revocation []string
+	for i := len(grants) - 1; i >= 0; i-- {
+		if match := trinoGrantRegex.FindStringSubmatch(grants[i]); match != nil {
+			revocation = append(revocation, fmt.Sprintf("REVOKE %s FROM %s", match[1], match[2]))
+		}
+	}
+	return revocation
+}
+
+func (t *trino) SetCredentials(ctx context.Context, statements dbplugin.Statements, staticUser dbplugin.StaticUserConfig) (string, string, error) {
+	return "", "", errors.New("static roles are not supported by the trino-database-plugin")
+}
+
+func (t *trino) RotateRootCredentials(ctx context.Context, statements []string) (map[string]interface{}, error) {
+	return nil, errors.New("root credential rotation is not supported by the trino-database-plugin; rotate the password with the authenticator of the coordinator instead")
+}
+
+func (t *trino) Close() error {
+	return nil
+}
+
+func (t *trino) executeStatements(ctx context.Context, statements []string, username string, skipNotFound bool) error {
+	for _, stmt := range statements {
+		for _, query := range strutil.ParseArbitraryStringSlice(stmt, ";") {
+			query = strings.TrimSpace(query)
+			if query == "" {
+				continue
+			}
+			err := t.execute(ctx, dbutil.QueryHelper(query, map[string]string{"name": username}))
+			if err != nil && !(skipNotFound && status.Code(err) == codes.NotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// trinoResults are the results of a statement returned by the coordinator,
+// which the client follows through nextUri until the statement finishes.
+type trinoResults struct {
+	NextURI string      `json:"nextUri"`
+	Error   *trinoError `json:"error"`
+}
+
+type trinoError struct {
+	Message   string `json:"message"`
+	ErrorName string `json:"errorName"`
+	ErrorType string `json:"errorType"`
+}
+
+// err returns the error of a failed statement with the gRPC code of its
+// type.
+func (e *trinoError) err() error {
+	code := codes.Unknown
+	switch {
+	case e.ErrorName == "PERMISSION_DENIED":
+		code = codes.PermissionDenied
+	case e.ErrorType == "USER_ERROR" && strings.HasSuffix(e.ErrorName, "_NOT_FOUND"):
+		code = codes.NotFound
+	case e.ErrorType == "USER_ERROR" && strings.HasSuffix(e.ErrorName, "_ALREADY_EXISTS"):
+		code = codes.AlreadyExists
+	case e.ErrorType == "USER_ERROR":
+		code = codes.InvalidArgument
+	case e.ErrorType == "INSUFFICIENT_RESOURCES":
+		code = codes.ResourceExhausted
+	case e.ErrorType == "EXTERNAL":
+		code = codes.Unavailable
+	}
+	return status.Error(code, fmt.Sprintf("trino returned %s: %s", e.ErrorName, e.Message))
+}
+
+// execute runs a statement as the user of the connection, following its
+// results until it finishes. The coordinator holds each request for nextUri
+// until there are new results, so they are followed without waiting.
+func (t *trino) execute(ctx context.Context, query string) error {
+	t.RLock()
+	config := t.config
+	t.RUnlock()
+
+	method, endpoint, body := http.MethodPost, strings.TrimSuffix(config.ConnectionURL, "/")+"/v1/statement", query
+	for {
+		results, err := t.send(ctx, config, method, endpoint, body)
+		if err != nil {
+			return err
+		}
+		if results.Error != nil {
+			return results.Error.err()
+		}
+		if results.NextURI == "" {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		method, endpoint, body = http.MethodGet, results.NextURI, ""
+	}
+}
+
+func (t *trino) send(ctx context.Context, config trinoConfig, method, endpoint, body string) (*trinoResults, error) {
+	req, err := http.NewRequest(method, endpoint, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	// Presto reads the X-Presto-* headers, as Trino does when configured with
+	// protocol.v1.alternate-header-name
+	for _, prefix := range []string{"X-Trino-", "X-Presto-"} {
+		req.Header.Set(prefix+"User", config.Username)
+		req.Header.Set(prefix+"Source", "vault")
+	}
+	if config.Password != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, httpStatusError(resp.StatusCode, fmt.Sprintf("trino returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg))))
+	}
+
+	var results trinoResults
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// testTrinoServer runs statements over the REST API of a coordinator,
+// recording the statements run by "admin". Each statement is queued once
+// before its results are returned, and statements on the catalog "missing"
+// fail.
+type testTrinoServer struct {
+	*httptest.Server
+
+	l          sync.Mutex
+	statements []string
+}
+
+func newTestTrinoServer() *testTrinoServer {
+	server := &testTrinoServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+func (s *testTrinoServer) serve(w http.ResponseWriter, r *http.Request) {
+	if username, password, _ := r.BasicAuth(); r.Header.Get("X-Trino-User") != "admin" || username != "admin" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/statement":
+		body, _ := ioutil.ReadAll(r.Body)
+		s.statements = append(s.statements, string(body))
+		json.NewEncoder(w).Encode(map[string]string{"nextUri": fmt.Sprintf("%s/v1/statement/queued/%d", s.URL, len(s.statements)-1)})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/statement/queued/"):
+		var i int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/v1/statement/queued/"), "%d", &i)
+		if strings.Contains(s.statements[i], "missing.") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{
+				"message":   "line 1:1: Catalog 'missing' does not exist",
+				"errorName": "CATALOG_NOT_FOUND",
+				"errorType": "USER_ERROR",
+			}})
+			return
+		}
+		w.Write([]byte(`{"stats": {"state": "FINISHED"}}`))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *testTrinoServer) ran() []string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	statements := s.statements
+	s.statements = nil
+	return statements
+}
+
+func TestBackend_trino(t *testing.T) {
+	server := newTestTrinoServer()
+	defer server.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+	mustRequest := func(req *logical.Request) *logical.Response {
+		t.Helper()
+		resp, err := request(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "config/trino", Data: map[string]interface{}{
+		"plugin_name":     "trino-database-plugin",
+		"connection_url":  server.URL,
+		"username":        "admin",
+		"password":        "secret",
+		"jwt_signing_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		"jwt_audience":    "trino",
+		"allowed_roles":   []string{"*"},
+	}})
+	resp := mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "config/trino"})
+	if _, ok := resp.Data["connection_details"].(map[string]interface{})["jwt_signing_key"]; ok {
+		t.Fatal("expected the signing key not to be returned")
+	}
+	if ran := server.ran(); len(ran) != 1 || ran[0] != "SELECT 1" {
+		t.Fatalf("expected the connection to be verified, got %q", ran)
+	}
+
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/analyst", Data: map[string]interface{}{
+		"db_name":             "trino",
+		"creation_statements": `GRANT SELECT ON SCHEMA hive.sales TO USER "{{name}}"; GRANT SELECT ON TABLE hive.web.clicks TO USER "{{name}}" WITH GRANT OPTION;`,
+		"default_ttl":         "1h",
+	}})
+
+	resp = mustRequest(&logical.Request{Operation: logical.ReadOperation, Path: "creds/analyst"})
+	username := resp.Data["username"].(string)
+	expected := []string{
+		`GRANT SELECT ON SCHEMA hive.sales TO USER "` + username + `"`,
+		`GRANT SELECT ON TABLE hive.web.clicks TO USER "` + username + `" WITH GRANT OPTION`,
+	}
+	if ran := server.ran(); strings.Join(ran, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected %q, got %q", expected, ran)
+	}
+
+	token, err := jwt.ParseSigned(resp.Data["password"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims jwt.Claims
+	if err := token.Claims(&key.PublicKey, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if err := claims.Validate(jwt.Expected{Subject: username, Issuer: "vault", Audience: jwt.Audience{"trino"}, Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(claims.Expiry.Time()); ttl > time.Hour+time.Minute || ttl < 59*time.Minute {
+		t.Fatalf("expected the token to expire with the lease, got %s", ttl)
+	}
+
+	mustRequest(&logical.Request{Operation: logical.RevokeOperation, Secret: resp.Secret})
+	expected = []string{
+		`REVOKE SELECT ON TABLE hive.web.clicks FROM USER "` + username + `"`,
+		`REVOKE SELECT ON SCHEMA hive.sales FROM USER "` + username + `"`,
+	}
+	if ran := server.ran(); strings.Join(ran, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected %q, got %q", expected, ran)
+	}
+
+	// Grants that fail are rolled back
+	mustRequest(&logical.Request{Operation: logical.CreateOperation, Path: "roles/broken", Data: map[string]interface{}{
+		"db_name":             "trino",
+		"creation_statements": `GRANT SELECT ON SCHEMA hive.sales TO USER "{{name}}"; GRANT SELECT ON SCHEMA missing.sales TO USER "{{name}}";`,
+	}})
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/broken"})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected an error for a missing catalog")
+	}
+	if ran := server.ran(); len(ran) != 4 || !strings.HasPrefix(ran[3], "REVOKE SELECT ON SCHEMA hive.sales FROM USER ") {
+		t.Fatalf("expected the grants to be revoked, got %q", ran)
+	}
+}
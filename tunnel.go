@@ -0,0 +1,270 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+	log "github.com/hashicorp/go-hclog"
+)
+
+// tunnelDialer dials the database for a tunnel, through an SSH bastion, a
+// proxy or a registered dialer.
+type tunnelDialer interface {
+	Dial(network, address string) (net.Conn, error)
+	Close() error
+}
+
+// tunnel forwards connections from a local listener to the database through a
+// tunnelDialer. Plugins are pointed at the listener, which lets every driver
+// reach the database without supporting the bastion, proxy or dialer itself.
+type tunnel struct {
+	listener net.Listener
+	dialer   tunnelDialer
+	remote   string
+	logger   log.Logger
+
+	// failover, if set, picks the remote address between the connection's
+	// endpoints in place of remote.
+	failover *endpointFailover
+}
+
+// openTunnel starts a tunnel for the connection if it has an SSH bastion, a
+// proxy, a dialer or fallback endpoints configured, pointing the connection
+// URL in details at it. It returns nil if the connection uses none of them.
+// The metrics of its failover carry mountLabels.
+func openTunnel(name string, config *DatabaseConfig, details map[string]interface{}, logger log.Logger, mountLabels []metrics.Label) (*tunnel, error) {
+	var dialer tunnelDialer
+	var err error
+	switch {
+	case config.SSHTunnel.Host != "":
+		dialer, err = newSSHDialer(config.SSHTunnel)
+	case config.ProxyURL != "":
+		dialer, err = newProxyDialer(config.ProxyURL)
+	case config.Dialer != "":
+		dialer, err = newRegisteredDialer(config.Dialer)
+	case len(config.FallbackEndpoints) > 0:
+		dialer = directDialer{}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	connURL, _ := details["connection_url"].(string)
+	remote, err := tunnelTarget(config.PluginName, connURL)
+	if err != nil {
+		dialer.Close()
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		dialer.Close()
+		return nil, fmt.Errorf("failed to listen for the tunnel: %s", err)
+	}
+
+	tunnelURL, err := setTunnelAddress(config.PluginName, connURL, listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		dialer.Close()
+		return nil, err
+	}
+	details["connection_url"] = tunnelURL
+
+	t := &tunnel{
+		listener: listener,
+		dialer:   dialer,
+		remote:   remote,
+		logger:   logger,
+	}
+	if len(config.FallbackEndpoints) > 0 {
+		endpoints := append([]string{remote}, config.FallbackEndpoints...)
+		t.failover = newEndpointFailover(name, endpoints, dialer, config.HealthCheckInterval, logger)
+		t.failover.mountLabels = mountLabels
+		go t.failover.run()
+	}
+	go t.serve()
+
+	return t, nil
+}
+
+func (t *tunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			// The listener was closed
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *tunnel) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := t.dialer.Dial("tcp", t.target())
+	if err != nil && t.failover != nil {
+		// Fail over without waiting for the next health check
+		t.failover.check()
+		remote, err = t.dialer.Dial("tcp", t.target())
+	}
+	if err != nil {
+		t.logger.Error("failed to connect to the database through the tunnel", "error", err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// target returns the address connections are forwarded to.
+func (t *tunnel) target() string {
+	if t.failover != nil {
+		return t.failover.current()
+	}
+	return t.remote
+}
+
+// Close stops the tunnel.
+func (t *tunnel) Close() error {
+	if t.failover != nil {
+		t.failover.stop()
+	}
+	err := t.listener.Close()
+	if dialErr := t.dialer.Close(); err == nil {
+		err = dialErr
+	}
+	return err
+}
+
+// tunnelTarget returns the database address in a connection URL, which the
+// tunnel forwards connections to.
+func tunnelTarget(pluginName, connURL string) (string, error) {
+	switch pluginName {
+	case "mysql-database-plugin", "mysql-aurora-database-plugin", "mysql-rds-database-plugin", "mysql-legacy-database-plugin", "mariadb-database-plugin", "vitess-database-plugin":
+		slash := strings.LastIndex(connURL, "/")
+		if slash < 0 {
+			return "", errors.New("connection_url is not a valid MySQL DSN: missing the '/' before the database name")
+		}
+		protocol := connURL[strings.LastIndex(connURL[:slash], "@")+1 : slash]
+		switch {
+		case protocol == "":
+			return "127.0.0.1:3306", nil
+		case strings.HasPrefix(protocol, "tcp(") && strings.HasSuffix(protocol, ")"):
+			return withDefaultPort(protocol[len("tcp("):len(protocol)-1], "3306"), nil
+		default:
+			return "", errors.New("connection_url must use the tcp protocol to connect through a tunnel")
+		}
+
+	case "postgresql-database-plugin":
+		if isURL(connURL, "postgres", "postgresql") {
+			host, _ := urlHost(connURL)
+			if host == "" {
+				host = "localhost"
+			}
+			return withDefaultPort(host, "5432"), nil
+		}
+		host, port := "localhost", "5432"
+		for _, field := range strings.Fields(connURL) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch v := strings.Trim(kv[1], "'"); kv[0] {
+			case "host":
+				host = v
+			case "port":
+				port = v
+			}
+		}
+		if strings.HasPrefix(host, "/") {
+			return "", errors.New("connection_url must connect over TCP to connect through a tunnel")
+		}
+		return net.JoinHostPort(host, port), nil
+
+	case "mssql-database-plugin":
+		if !isURL(connURL, "sqlserver") {
+			return "", errors.New("connection_url must be a sqlserver:// URL to connect through a tunnel")
+		}
+		host, _ := urlHost(connURL)
+		return withDefaultPort(host, "1433"), nil
+
+	default:
+		return "", fmt.Errorf("%s does not support ssh_host, proxy_url, dialer or fallback_endpoints", pluginName)
+	}
+}
+
+// setTunnelAddress replaces the database address in a connection URL with the
+// local address of a tunnel.
+func setTunnelAddress(pluginName, connURL, address string) (string, error) {
+	switch pluginName {
+	case "mysql-database-plugin", "mysql-aurora-database-plugin", "mysql-rds-database-plugin", "mysql-legacy-database-plugin", "mariadb-database-plugin", "vitess-database-plugin":
+		return setMySQLAddress(connURL, "tcp", address)
+
+	case "postgresql-database-plugin":
+		if isURL(connURL, "postgres", "postgresql") {
+			return setURLHost(connURL, address), nil
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", err
+		}
+		// Later keywords take precedence in key/value connection strings
+		return connURL + fmt.Sprintf(" host='%s' port='%s'", host, port), nil
+
+	case "mssql-database-plugin":
+		return setURLHost(connURL, address), nil
+
+	default:
+		return "", fmt.Errorf("%s does not support ssh_host, proxy_url, dialer or fallback_endpoints", pluginName)
+	}
+}
+
+func isURL(rawURL string, schemes ...string) bool {
+	for _, scheme := range schemes {
+		if strings.HasPrefix(rawURL, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// urlHost returns the host of a URL and its offset. It is found by hand as
+// templated credentials are not valid URL syntax.
+func urlHost(rawURL string) (string, int) {
+	start := strings.Index(rawURL, "://") + len("://")
+	end := len(rawURL)
+	if i := strings.IndexAny(rawURL[start:], "/?"); i >= 0 {
+		end = start + i
+	}
+	start += strings.LastIndex(rawURL[start:end], "@") + 1
+	return rawURL[start:end], start
+}
+
+// setURLHost replaces the host of a URL, leaving any credentials, path and
+// query untouched.
+func setURLHost(rawURL, host string) string {
+	old, start := urlHost(rawURL)
+	return rawURL[:start] + host + rawURL[start+len(old):]
+}
+
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, port)
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+)
+
+// userSchemaStatements create and drop a schema owned by a dynamic user, for
+// roles with user_schema set.
+type userSchemaStatements struct {
+	creation   string
+	revocation string
+}
+
+// userSchemaPlugins maps the plugins supporting user_schema to the statements
+// that provision the schema.
+var userSchemaPlugins = map[string]userSchemaStatements{
+	"postgresql-database-plugin": {
+		creation:   `CREATE SCHEMA "{{name}}" AUTHORIZATION "{{name}}";`,
+		revocation: `DROP SCHEMA IF EXISTS "{{name}}" CASCADE;`,
+	},
+	"mssql-database-plugin": {
+		creation:   `CREATE SCHEMA [{{name}}] AUTHORIZATION [{{name}}];`,
+		revocation: `DROP SCHEMA IF EXISTS [{{name}}];`,
+	},
+}
+
+func validateUserSchema(pluginName string) error {
+	if _, ok := userSchemaPlugins[pluginName]; !ok {
+		return fmt.Errorf("%s does not support user_schema; it is supported by the PostgreSQL and MSSQL plugins", pluginName)
+	}
+	return nil
+}
+
+// withUserSchema returns statements with the schema of the user created after
+// the role's creation statements, and dropped before its revocation
+// statements, so that the schema's owner exists for as long as it does.
+func withUserSchema(pluginName string, statements dbplugin.Statements) (dbplugin.Statements, error) {
+	if err := validateUserSchema(pluginName); err != nil {
+		return statements, err
+	}
+	schema := userSchemaPlugins[pluginName]
+
+	statements.Creation = append(append([]string{}, statements.Creation...), schema.creation)
+	statements.Revocation = append([]string{schema.revocation}, statements.Revocation...)
+	return statements, nil
+}
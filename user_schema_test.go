@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_userSchema(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	request := func(req *logical.Request) (*logical.Response, error) {
+		t.Helper()
+		req.Storage = s
+		return b.HandleRequest(namespace.RootContext(nil), req)
+	}
+
+	for name, plugin := range map[string]string{
+		"plugin-test": "postgresql-database-plugin",
+		"mysql":       "mysql-database-plugin",
+	} {
+		resp, err := request(&logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config/" + name,
+			Data: map[string]interface{}{
+				"connection_url":    "sample_connection_url",
+				"plugin_name":       plugin,
+				"verify_connection": false,
+				"allowed_roles":     []string{"*"},
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v", err, resp)
+		}
+	}
+	fake := &recordingDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"db_name": "mysql", "preset": "mysql-readonly", "user_schema": true},
+		{"db_name": "plugin-test", "creation_statements": testRole, "user_schema": true},
+	} {
+		resp, err := request(&logical.Request{Operation: logical.CreateOperation, Path: "roles/app", Data: data})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, got err:%s resp:%#v", data, err, resp)
+		}
+	}
+
+	resp, err := request(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/app",
+		Data:      map[string]interface{}{"db_name": "plugin-test", "preset": "postgres-readwrite", "user_schema": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	resp, err = request(&logical.Request{Operation: logical.ReadOperation, Path: "creds/app"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	preset := statementPresets["postgres-readwrite"]
+	schema := userSchemaPlugins["postgresql-database-plugin"]
+	if !reflect.DeepEqual(fake.creation, append(append([]string{}, preset.creation...), schema.creation)) {
+		t.Fatalf("expected the schema to be created after the user, got %#v", fake.creation)
+	}
+
+	// Leases record the schema, so it is dropped even if the role changes
+	secret := resp.Secret
+	resp, err = request(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/app",
+		Data:      map[string]interface{}{"user_schema": false},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	resp, err = request(&logical.Request{Operation: logical.RevokeOperation, Secret: secret})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+	if !reflect.DeepEqual(fake.revocation, append([]string{schema.revocation}, preset.revocation...)) {
+		t.Fatalf("expected the schema to be dropped before the user, got %#v", fake.revocation)
+	}
+}
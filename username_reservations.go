@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const usernameReservationPath = "username-reservation/"
+
+// usernameReservationTTL is how long a generated username stays reserved if
+// its user may not have been created, long enough that a CreateUser call
+// that timed out has finished on the database.
+const usernameReservationTTL = 5 * time.Minute
+
+// maxUsernameReservationAttempts is how many usernames are generated for a
+// user before giving up, if they are all reserved.
+const maxUsernameReservationAttempts = 3
+
+// usernameReservation is kept for a username generated by the backend from
+// before its user is created until it is created, so that no other request
+// creates a user with the same name on the database at the same time.
+type usernameReservation struct {
+	Role       string    `json:"role"`
+	ReservedAt time.Time `json:"reserved_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// usernameReservationKey is the key of a generated username of a connection.
+// Usernames are escaped, as templates may generate slashes.
+func usernameReservationKey(dbName, username string) string {
+	return usernameReservationPath + dbName + "/" + url.PathEscape(username)
+}
+
+// reserveUsername reserves a generated username of a connection, returning
+// false if it is already reserved.
+func (b *databaseBackend) reserveUsername(ctx context.Context, s logical.Storage, dbName, username, role string) (bool, error) {
+	key := usernameReservationKey(dbName, username)
+	lock := locksutil.LockForKey(b.connectionLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := b.clock.Now()
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if entry != nil {
+		var existing usernameReservation
+		if err := entry.DecodeJSON(&existing); err != nil {
+			return false, err
+		}
+		if now.Before(existing.ExpiresAt) {
+			return false, nil
+		}
+	}
+
+	entry, err = logical.StorageEntryJSON(key, &usernameReservation{
+		Role:       role,
+		ReservedAt: now,
+		ExpiresAt:  now.Add(usernameReservationTTL),
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, s.Put(ctx, entry)
+}
+
+// releaseUsername removes the reservation of a username whose user was
+// created. The users of failed or timed out requests keep their reservation
+// until it expires, as they may still be created.
+func (b *databaseBackend) releaseUsername(ctx context.Context, s logical.Storage, dbName, username string) {
+	if err := s.Delete(ctx, usernameReservationKey(dbName, username)); err != nil {
+		b.Logger().Warn("failed to release username reservation", "connection", dbName, "username", username, "error", err)
+	}
+}
+
+// produceReservedCredentials produces the credentials of a new user with a
+// producer, reserving the username if the producer generated one and
+// generating another if it was already reserved.
+func (b *databaseBackend) produceReservedCredentials(ctx context.Context, s logical.Storage, dbName string, producer credentialsProducer, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (*producedCredentials, error) {
+	for i := 0; i < maxUsernameReservationAttempts; i++ {
+		creds, err := producer.produce(ctx, usernameConfig, expiration)
+		if err != nil {
+			return nil, err
+		}
+		if creds.username == "" {
+			return creds, nil
+		}
+		reserved, err := b.reserveUsername(ctx, s, dbName, creds.username, usernameConfig.RoleName)
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return creds, nil
+		}
+		b.Logger().Warn("generated username is already reserved", "connection", dbName, "role", usernameConfig.RoleName, "username", creds.username)
+	}
+	return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("the last %d usernames generated for role %q were already reserved by other requests; make username_template more random", maxUsernameReservationAttempts, usernameConfig.RoleName))
+}
+
+// removeExpiredUsernameReservations removes the reservations of usernames
+// whose user was never confirmed as created.
+func (b *databaseBackend) removeExpiredUsernameReservations(ctx context.Context, req *logical.Request) error {
+	if sys := b.System(); sys != nil {
+		replicationState := sys.ReplicationState()
+		if (!sys.LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+			replicationState.HasState(consts.ReplicationDRSecondary) ||
+			replicationState.HasState(consts.ReplicationPerformanceStandby) {
+			return nil
+		}
+	}
+
+	dbNames, err := req.Storage.List(ctx, usernameReservationPath)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	now := b.clock.Now()
+	for _, dbName := range dbNames {
+		prefix := usernameReservationPath + dbName
+		if !strings.HasSuffix(prefix, "/") {
+			continue
+		}
+		keys, err := req.Storage.List(ctx, prefix)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		for _, key := range keys {
+			entry, err := req.Storage.Get(ctx, prefix+key)
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			if entry == nil {
+				continue
+			}
+			var reservation usernameReservation
+			if err := entry.DecodeJSON(&reservation); err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			if now.Before(reservation.ExpiresAt) {
+				continue
+			}
+			if err := req.Storage.Delete(ctx, prefix+key); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+	return result.ErrorOrNil()
+}
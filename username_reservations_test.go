@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// timingOutDatabase fails to create users while timeouts is set, as if
+// CreateUser timed out.
+type timingOutDatabase struct {
+	fakeIssuingDatabase
+	timeouts bool
+}
+
+func (f *timingOutDatabase) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	if f.timeouts {
+		return "", "", errors.New("context deadline exceeded")
+	}
+	return f.fakeIssuingDatabase.CreateUser(ctx, statements, usernameConfig, expiration)
+}
+
+func TestBackend_usernameReservations(t *testing.T) {
+	b, s := getBackend(t)
+	defer b.Cleanup(context.Background())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b.clock = clock
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+	mustConflict := func() {
+		t.Helper()
+		resp, err := request(logical.ReadOperation, "creds/app", nil)
+		if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusConflict {
+			t.Fatalf("expected a 409 error, got err:%v resp:%#v", err, resp)
+		}
+	}
+
+	// The template generates the same username every time
+	mustRequest(logical.CreateOperation, "config/plugin-test", map[string]interface{}{
+		"connection_url":    "sample_connection_url",
+		"plugin_name":       "postgresql-database-plugin",
+		"verify_connection": false,
+		"allowed_roles":     []string{"*"},
+		"username_template": "v-{{.RoleName}}",
+	})
+	fake := &timingOutDatabase{}
+	b.connections["plugin-test"] = &dbPluginInstance{
+		Database: fake,
+		name:     "plugin-test",
+		id:       "fake",
+	}
+	mustRequest(logical.CreateOperation, "roles/app", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+	})
+
+	// Usernames are released once their user is created
+	mustRequest(logical.ReadOperation, "creds/app", nil)
+	if keys, err := s.List(namespace.RootContext(nil), usernameReservationPath+"plugin-test/"); err != nil || len(keys) != 0 {
+		t.Fatalf("expected the reservation to be released, got %v, %v", keys, err)
+	}
+
+	// A request that timed out keeps its username reserved
+	fake.timeouts = true
+	if resp, err := request(logical.ReadOperation, "creds/app", nil); err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("expected the creation to fail, got %#v", resp)
+	}
+	fake.timeouts = false
+	mustConflict()
+	if fake.created != 1 {
+		t.Fatalf("expected no user to be created with a reserved username, got %d", fake.created)
+	}
+
+	ctx := namespace.RootContext(nil)
+	clock.advance(usernameReservationTTL - time.Second)
+	if err := b.removeExpiredUsernameReservations(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	mustConflict()
+
+	clock.advance(time.Second)
+	if err := b.removeExpiredUsernameReservations(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := s.List(ctx, usernameReservationPath+"plugin-test/"); err != nil || len(keys) != 0 {
+		t.Fatalf("expected the expired reservation to be removed, got %v, %v", keys, err)
+	}
+	mustRequest(logical.ReadOperation, "creds/app", nil)
+}
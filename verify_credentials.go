@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultReplicationTimeout bounds how long the credentials of a role with
+// verify_credentials are retried, for databases such as Cassandra that
+// replicate new users asynchronously, unless the connection sets
+// replication_timeout.
+const defaultReplicationTimeout = 10 * time.Second
+
+// maxReplicationWait bounds the replication_delay and replication_timeout of
+// a connection together, so that requests for credentials don't outlive
+// Vault's request timeout.
+const maxReplicationWait = time.Minute
+
+func (config *DatabaseConfig) replicationTimeout() time.Duration {
+	if config.ReplicationTimeout > 0 {
+		return config.ReplicationTimeout
+	}
+	return defaultReplicationTimeout
+}
+
+// validateReplicationWait checks the replication_delay and
+// replication_timeout of a connection.
+func validateReplicationWait(config *DatabaseConfig) error {
+	if config.ReplicationDelay < 0 || config.ReplicationTimeout < 0 {
+		return errors.New("replication_delay and replication_timeout must not be negative")
+	}
+	if wait := config.ReplicationDelay + config.replicationTimeout(); wait > maxReplicationWait {
+		return fmt.Errorf("replication_delay and replication_timeout must not add up to more than %s, got %s", maxReplicationWait, wait)
+	}
+	return nil
+}
+
+// waitForReplication waits for the replication_delay of a connection after a
+// user was created, before it is logged in as or returned.
+func waitForReplication(ctx context.Context, config *DatabaseConfig) error {
+	if config.ReplicationDelay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(config.ReplicationDelay):
+		return nil
+	}
+}
+
+// checkLoginSupported returns an error if the credentials of a connection's
+// users can't be logged in with, as the connection doesn't pass its own
+// credentials in the username and password parameters.
+func checkLoginSupported(name string, config *DatabaseConfig) error {
+	connURL, _ := config.ConnectionDetails["connection_url"].(string)
+	if _, ok := config.ConnectionDetails["username"]; !ok && !strings.Contains(connURL, "{{username}}") {
+		return fmt.Errorf("connection %q doesn't set its username through the username parameter or {{username}} in its connection_url, so its users can't be logged in as", name)
+	}
+	return nil
+}
+
+// loginAs opens a second connection to the database with the given
+// credentials in place of the connection's own, through the same plugin,
+// tunnel and TLS options.
+func (b *databaseBackend) loginAs(ctx context.Context, name string, config *DatabaseConfig, username, password string) error {
+	if err := checkLoginSupported(name, config); err != nil {
+		return err
+	}
+
+	loginConfig := *config
+	loginConfig.ConnectionDetails = make(map[string]interface{}, len(config.ConnectionDetails))
+	for k, v := range config.ConnectionDetails {
+		loginConfig.ConnectionDetails[k] = v
+	}
+	loginConfig.ConnectionDetails["username"] = username
+	loginConfig.ConnectionDetails["password"] = password
+
+	conn, err := b.newConnection(ctx, name, &loginConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// verifyCredentials logs in as a newly created user, retrying with a backoff
+// until the connection's replication timeout while the user may not have
+// been replicated to every node of the database.
+func (b *databaseBackend) verifyCredentials(ctx context.Context, name string, config *DatabaseConfig, username, password string) error {
+	timeout := config.replicationTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := 100 * time.Millisecond
+	for {
+		err := b.loginAs(ctx, name, config, username, password)
+		if err == nil {
+			return nil
+		}
+		if checkLoginSupported(name, config) != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to log in as the new user within %s: %s", timeout, err)
+		case <-time.After(backoff):
+		}
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/plugins/database/mysql"
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// vitessUsernameLen is the longest username accepted by the MySQL 5.7
+	// and 8.0 servers that Vitess runs.
+	vitessUsernameLen = 32
+
+	// vitessRevocationStatement is the default revocation statement of the
+	// vitess-database-plugin.
+	vitessRevocationStatement = `DROP USER IF EXISTS '{{name}}'@'%';`
+)
+
+var (
+	vitessGrantRegex = regexp.MustCompile(`(?is)^GRANT\s+(.+?)(?:\s+ON\s+(?:TABLE\s+)?(\S+))?\s+TO\s+.+$`)
+	vitessRoleRegex  = regexp.MustCompile(`(?i)^(CREATE\s+ROLE|DROP\s+ROLE|SET\s+(DEFAULT\s+)?ROLE)\b`)
+	vitessSpaceRegex = regexp.MustCompile(`\s+`)
+
+	// vitessGlobalPrivileges are the privileges that only apply to the whole
+	// server, which Vitess doesn't give to application users since it manages
+	// the servers of each keyspace itself.
+	vitessGlobalPrivileges = []string{
+		"SUPER", "FILE", "PROCESS", "RELOAD", "SHUTDOWN", "CREATE USER",
+		"CREATE TABLESPACE", "REPLICATION CLIENT", "REPLICATION SLAVE",
+		"SYSTEM_VARIABLES_ADMIN", "CONNECTION_ADMIN",
+	}
+)
+
+// validateVitessStatement rejects the statements of a role that Vitess
+// doesn't support. Users are granted privileges on the tables of a keyspace,
+// so grants must name one, and can't include the global privileges, grant
+// options or roles that Vitess doesn't pass through to the servers of the
+// keyspace.
+func validateVitessStatement(stmt string) error {
+	for _, query := range strutil.ParseArbitraryStringSlice(stmt, ";") {
+		query = vitessSpaceRegex.ReplaceAllString(strings.TrimSpace(query), " ")
+		if query == "" {
+			continue
+		}
+
+		if vitessRoleRegex.MatchString(query) {
+			return fmt.Errorf("vitess does not support roles; grant privileges to the user directly: %s", query)
+		}
+
+		match := vitessGrantRegex.FindStringSubmatch(query)
+		if match == nil {
+			continue
+		}
+		privileges, target := strings.ToUpper(match[1]), match[2]
+		switch {
+		case target == "":
+			return fmt.Errorf("vitess does not support granting roles; grant privileges ON a keyspace instead: %s", query)
+		case strings.HasPrefix(privileges, "PROXY"):
+			return fmt.Errorf("vitess does not support proxy users: %s", query)
+		case target == "*" || strings.HasPrefix(target, "*."):
+			return fmt.Errorf("vitess users are scoped to a keyspace, so grants must be ON keyspace.* or keyspace.table rather than %s: %s", target, query)
+		case strings.Contains(strings.ToUpper(query), "WITH GRANT OPTION"):
+			return fmt.Errorf("vitess does not support WITH GRANT OPTION: %s", query)
+		}
+		for _, privilege := range strings.Split(privileges, ",") {
+			privilege = strings.TrimSpace(privilege)
+			if strutil.StrListContains(vitessGlobalPrivileges, privilege) {
+				return fmt.Errorf("vitess does not support the %s privilege: %s", privilege, query)
+			}
+		}
+	}
+	return nil
+}
+
+// vitess is the MySQL plugin for the MySQL protocol of a Vitess cluster, as
+// served by vtgate or PlanetScale. It rejects the creation statements that
+// Vitess doesn't support before running them, and drops the user instead of
+// revoking its grants by default.
+type vitess struct {
+	dbplugin.Database
+}
+
+func newVitess() (interface{}, error) {
+	raw, err := newMySQL(mysql.MetadataLen, mysql.MetadataLen, vitessUsernameLen)()
+	if err != nil {
+		return nil, err
+	}
+	db, ok := raw.(dbplugin.Database)
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %T", raw)
+	}
+	return &vitess{Database: db}, nil
+}
+
+func (v *vitess) CreateUser(ctx context.Context, statements dbplugin.Statements, usernameConfig dbplugin.UsernameConfig, expiration time.Time) (string, string, error) {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	for _, stmt := range statements.Creation {
+		if err := validateVitessStatement(stmt); err != nil {
+			return "", "", status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return v.Database.CreateUser(ctx, statements, usernameConfig, expiration)
+}
+
+func (v *vitess) RevokeUser(ctx context.Context, statements dbplugin.Statements, username string) error {
+	statements = dbutil.StatementCompatibilityHelper(statements)
+	if len(statements.Revocation) == 0 {
+		statements.Revocation = []string{vitessRevocationStatement}
+	}
+	return v.Database.RevokeUser(ctx, statements, username)
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateVitessStatement(t *testing.T) {
+	tests := map[string]string{
+		`CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}'; GRANT SELECT, INSERT ON commerce.* TO '{{name}}'@'%';`: "",
+		`GRANT SELECT ON TABLE customer.orders TO '{{name}}'@'%';`:                                                       "",
+		`GRANT ALL PRIVILEGES ON commerce.* TO '{{name}}'@'%';`:                                                          "",
+		`GRANT SELECT ON *.* TO '{{name}}'@'%';`:                                                                         "scoped to a keyspace",
+		`GRANT SELECT ON * TO '{{name}}'@'%';`:                                                                           "scoped to a keyspace",
+		"GRANT SELECT, SUPER\n\tON commerce.* TO '{{name}}'@'%';":                                                        "the SUPER privilege",
+		`grant replication  client on commerce.* to '{{name}}'@'%';`:                                                     "the REPLICATION CLIENT privilege",
+		`GRANT SELECT ON commerce.* TO '{{name}}'@'%' WITH GRANT OPTION;`:                                                "WITH GRANT OPTION",
+		`GRANT PROXY ON 'admin'@'%' TO '{{name}}'@'%';`:                                                                  "proxy users",
+		`CREATE ROLE 'reader'; GRANT SELECT ON commerce.* TO 'reader';`:                                                  "does not support roles",
+		`GRANT 'reader' TO '{{name}}'@'%';`:                                                                              "granting roles",
+	}
+
+	for stmt, expected := range tests {
+		err := validateVitessStatement(stmt)
+		switch {
+		case expected == "" && err != nil:
+			t.Errorf("expected %q to be valid, got %s", stmt, err)
+		case expected != "" && (err == nil || !strings.Contains(err.Error(), expected)):
+			t.Errorf("expected %q to be rejected with %q, got %v", stmt, expected, err)
+		}
+	}
+
+	err := validateStatements("vitess-database-plugin", dbplugin.Statements{
+		Creation: []string{`CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}'; GRANT SUPER ON *.* TO '{{name}}'@'%';`},
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "creation_statements[0] is not supported by vitess-database-plugin") {
+		t.Fatalf("expected the role to be rejected, got %v", err)
+	}
+}
+
+func TestVitess_statements(t *testing.T) {
+	raw, err := databasePlugins["vitess-database-plugin"]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw.(*vitess); !ok {
+		t.Fatalf("expected the vitess plugin, got %T", raw)
+	}
+
+	recorder := &recordingDatabase{}
+	db := &vitess{Database: recorder}
+
+	statements := dbplugin.Statements{CreationStatements: `CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}'; GRANT SELECT ON *.* TO '{{name}}'@'%';`}
+	_, _, err = db.CreateUser(context.Background(), statements, dbplugin.UsernameConfig{}, time.Now().Add(time.Hour))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an invalid argument error, got %v", err)
+	}
+	if recorder.creation != nil {
+		t.Fatalf("expected the statements not to be run, got %q", recorder.creation)
+	}
+
+	if err := db.RevokeUser(context.Background(), dbplugin.Statements{}, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorder.revocation, []string{vitessRevocationStatement}) {
+		t.Fatalf("expected the default revocation statement, got %q", recorder.revocation)
+	}
+}
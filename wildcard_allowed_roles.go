@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// denyWildcardAllowedRolesOption is the mount option that rejects
+// connections allowing every role with allowed_roles "*".
+const denyWildcardAllowedRolesOption = "deny_wildcard_allowed_roles"
+
+// parseDenyWildcardAllowedRolesOption returns whether the mount options deny
+// wildcard allowed_roles.
+func parseDenyWildcardAllowedRolesOption(options map[string]string) (bool, error) {
+	raw := options[denyWildcardAllowedRolesOption]
+	if raw == "" {
+		return false, nil
+	}
+	deny, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s", denyWildcardAllowedRolesOption, err)
+	}
+	return deny, nil
+}
+
+// checkAllowedRoles returns an error suitable for the user if the mount
+// denies wildcard allowed_roles and the connection's contain "*".
+func (b *databaseBackend) checkAllowedRoles(config *DatabaseConfig) error {
+	if b.denyWildcardAllowedRoles && strutil.StrListContains(config.AllowedRoles, "*") {
+		return fmt.Errorf(`this mount sets %s, so allowed_roles must list the connection's roles rather than "*"`, denyWildcardAllowedRolesOption)
+	}
+	return nil
+}
+
+// roleAllowed returns whether the connection's allowed_roles allow the role
+// called name. A "*" allows every role, unless the mount denies wildcard
+// allowed_roles, in which case it allows none.
+func (b *databaseBackend) roleAllowed(config *DatabaseConfig, name string) bool {
+	for _, allowed := range config.AllowedRoles {
+		if allowed == "*" {
+			if b.denyWildcardAllowedRoles {
+				continue
+			}
+			return true
+		}
+		if strutil.GlobbedStringsMatch(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditWildcardAllowedRoles returns the connections stored with allowed_roles
+// "*", logging each, for mounts that deny them after the connections were
+// written. Their roles can't issue credentials until allowed_roles lists
+// them.
+func (b *databaseBackend) auditWildcardAllowedRoles(ctx context.Context, s logical.Storage) ([]string, error) {
+	names, err := s.List(ctx, "config/")
+	if err != nil {
+		return nil, err
+	}
+
+	var wildcard []string
+	for _, name := range names {
+		config, err := b.DatabaseConfig(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if strutil.StrListContains(config.AllowedRoles, "*") {
+			b.Logger().Warn(`connection allows every role with allowed_roles "*", which the mount denies; its roles can't issue credentials until allowed_roles lists them`, "connection", name)
+			wildcard = append(wildcard, name)
+		}
+	}
+	return wildcard, nil
+}
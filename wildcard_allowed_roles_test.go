@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseDenyWildcardAllowedRolesOption(t *testing.T) {
+	for options, expected := range map[string]bool{"": false, "false": false, "true": true, "1": true} {
+		deny, err := parseDenyWildcardAllowedRolesOption(map[string]string{denyWildcardAllowedRolesOption: options})
+		if err != nil || deny != expected {
+			t.Fatalf("expected %t for %q, got %t, %v", expected, options, deny, err)
+		}
+	}
+	if _, err := parseDenyWildcardAllowedRolesOption(map[string]string{denyWildcardAllowedRolesOption: "sometimes"}); err == nil {
+		t.Fatal("expected an invalid option to be rejected")
+	}
+}
+
+func TestBackend_denyWildcardAllowedRoles(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	s := config.StorageView
+
+	backend := func(options map[string]string) *databaseBackend {
+		t.Helper()
+		config.Config = options
+		b, err := Factory(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.(*databaseBackend)
+	}
+	request := func(b *databaseBackend, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+	}
+	mustRequest := func(b *databaseBackend, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := request(b, op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+	connection := func(b *databaseBackend, allowedRoles ...string) (*logical.Response, error) {
+		return request(b, logical.UpdateOperation, "config/plugin-test", map[string]interface{}{
+			"connection_url":    "sample_connection_url",
+			"plugin_name":       "postgresql-database-plugin",
+			"verify_connection": false,
+			"allowed_roles":     allowedRoles,
+		})
+	}
+	useFake := func(b *databaseBackend) {
+		b.Lock()
+		b.connections["plugin-test"] = &dbPluginInstance{
+			Database: &fakeIssuingDatabase{},
+			name:     "plugin-test",
+			id:       "fake",
+		}
+		b.Unlock()
+	}
+
+	// Connections written before the mount denied wildcards
+	b := backend(nil)
+	if _, err := connection(b, "*"); err != nil {
+		t.Fatal(err)
+	}
+	mustRequest(b, logical.UpdateOperation, "roles/app", map[string]interface{}{
+		"db_name":             "plugin-test",
+		"creation_statements": `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}';`,
+	})
+	useFake(b)
+	mustRequest(b, logical.ReadOperation, "creds/app", nil)
+	b.Cleanup(context.Background())
+
+	b = backend(map[string]string{denyWildcardAllowedRolesOption: "true"})
+	defer b.Cleanup(context.Background())
+	if wildcard, err := b.auditWildcardAllowedRoles(context.Background(), s); err != nil || len(wildcard) != 1 || wildcard[0] != "plugin-test" {
+		t.Fatalf("expected the connection to be audited, got %q, %v", wildcard, err)
+	}
+	if resp := mustRequest(b, logical.ReadOperation, "config/plugin-test", nil); len(resp.Warnings) == 0 {
+		t.Fatalf("expected the connection's allowed_roles to be warned about, got %#v", resp)
+	}
+	useFake(b)
+	if _, err := request(b, logical.ReadOperation, "creds/app", nil); err == nil {
+		t.Fatal(`expected "*" to allow no roles`)
+	}
+
+	if resp, _ := connection(b, "other", "*"); resp == nil || !resp.IsError() {
+		t.Fatalf(`expected "*" to be rejected, got %#v`, resp)
+	}
+	if resp, err := connection(b, "ap*"); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("expected globs to be allowed, got %#v, %v", resp, err)
+	}
+	useFake(b)
+	mustRequest(b, logical.ReadOperation, "creds/app", nil)
+}